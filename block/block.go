@@ -5,6 +5,8 @@
 package block
 
 import (
+	"io"
+
 	"github.com/dacapoday/smol"
 	"github.com/dacapoday/smol/internal/heap"
 )
@@ -13,6 +15,9 @@ type File = smol.File
 type BlockID = smol.BlockID
 type HeapCheckpoint = heap.Checkpoint
 
+// Format is the on-disk format of a heap file: a version and feature bits.
+type Format = heap.Format
+
 type HeapOption interface {
 	MagicCode() [4]byte
 	ReadOnly() bool
@@ -21,3 +26,14 @@ type HeapOption interface {
 }
 
 var _ smol.Block[HeapCheckpoint] = (*Heap[File])(nil)
+
+// Inspect reads the format, commit number and block size of the newest
+// checkpoint of a heap file without opening it.
+func Inspect[R io.ReaderAt](file R, magic [4]byte) (format Format, ckp uint32, blockSize int, err error) {
+	meta, err := heap.Inspect(file, magic)
+	if err != nil {
+		return
+	}
+	format = Format{Version: meta.Version, Required: meta.Required, Optional: meta.Optional}
+	return format, meta.Ckp, int(meta.BlockSize), nil
+}
@@ -42,6 +42,63 @@ func (block *Heap[F]) Rollback() error {
 	return block.heap.Rollback()
 }
 
+// Refresh loads the newest checkpoint committed by another process.
+// It returns a nil ckpt if the file has not changed.
+func (block *Heap[F]) Refresh() (entry []byte, ckpt HeapCheckpoint, err error) {
+	meta, ckpt, err := block.heap.Refresh()
+	if meta != nil {
+		entry = meta.Entry
+	}
+	return
+}
+
+// Latest returns the commit number of the newest checkpoint in the file.
+func (block *Heap[F]) Latest() (ckp uint32, err error) {
+	return block.heap.Latest()
+}
+
+// Oldest returns the commit number of the oldest checkpoint still acquired.
+func (block *Heap[F]) Oldest() uint32 {
+	return block.heap.Oldest()
+}
+
+// Hold keeps blocks recycled after checkpoint ckp from being reused.
+func (block *Heap[F]) Hold(ckp uint32) {
+	block.heap.Hold(ckp)
+}
+
+// Format returns the format the next commit writes.
+func (block *Heap[F]) Format() Format {
+	return block.heap.Format()
+}
+
+// SetFormat sets the format the next commit writes.
+// Rollback restores the committed format.
+func (block *Heap[F]) SetFormat(format Format) error {
+	return block.heap.SetFormat(format)
+}
+
+// ReadAt reads the raw, still encoded content of a block.
+func (block *Heap[F]) ReadAt(buffer []byte, blockID BlockID) (int, error) {
+	return block.heap.ReadAt(buffer, blockID)
+}
+
+// WriteAt writes raw, already encoded content to a block.
+func (block *Heap[F]) WriteAt(buffer []byte, blockID BlockID) (int, error) {
+	return block.heap.WriteAt(buffer, blockID)
+}
+
+// Extend appends a block to the file and returns its BlockID,
+// or a BlockID < 2 on error.
+func (block *Heap[F]) Extend() BlockID {
+	return block.heap.Extend()
+}
+
+// BlockSize returns the size of a block including codec overhead.
+func (block *Heap[F]) BlockSize() int {
+	return block.heap.BlockSize()
+}
+
 func (block *Heap[F]) PageSize() int {
 	return block.heap.PageSize()
 }
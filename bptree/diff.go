@@ -0,0 +1,315 @@
+// Copyright 2025 dacapoday
+// SPDX-License-Identifier: Apache-2.0
+
+package bptree
+
+import (
+	"bytes"
+
+	"github.com/dacapoday/smol/overflow"
+)
+
+// Diff iterates the differences between two snapshots of a B+ tree in key
+// order: keys added, deleted, or whose value changed. Subtrees referenced by
+// the same BlockID on both sides are skipped without being read when both
+// sides reach them at the same key boundary, so the cost mostly follows the
+// size of the change rather than the size of the tree. Where a changed page
+// on one side ends past the start of a shared subtree, that subtree is read
+// to align the two sides.
+//
+// Both roots must be stored in the same block storage.
+type Diff[B ReadOnly] struct {
+	block         B
+	old, new      diffSide
+	err           error
+	key           []byte
+	oldVal        []byte
+	newVal        []byte
+	buf           [3][]byte // key, old, new overflow buffers
+	keyInlineSize int
+	valInlineSize int
+}
+
+// diffSide is a stack of pages from the root down; each frame's index is
+// the next item to visit. Branch items are visited as references to their
+// subtree until expanded.
+type diffSide struct {
+	frames []diffFrame
+}
+
+type diffFrame struct {
+	page   Page
+	index  uint16
+	loaded bool // page is a buffer from AllocateBuffer
+}
+
+// Load initializes the diff from oldRoot to newRoot.
+// Positions the diff before the first difference.
+func (diff *Diff[B]) Load(block B, oldRoot, newRoot Page, keyInlineSize, valInlineSize int) {
+	diff.block = block
+	diff.keyInlineSize = keyInlineSize
+	diff.valInlineSize = valInlineSize
+	diff.old.frames = append(diff.old.frames[:0], diffFrame{page: oldRoot})
+	diff.new.frames = append(diff.new.frames[:0], diffFrame{page: newRoot})
+	diff.err = null
+	diff.key, diff.oldVal, diff.newVal = nil, nil, nil
+}
+
+// Close releases resources and resets the diff.
+func (diff *Diff[B]) Close() {
+	diff.old.close(diff.block)
+	diff.new.close(diff.block)
+	diff.err = nil
+	diff.key, diff.oldVal, diff.newVal = nil, nil, nil
+	diff.buf = [3][]byte{}
+
+	var nilBlock B
+	diff.block = nilBlock
+}
+
+// Error returns any error encountered during iteration.
+func (diff *Diff[B]) Error() error {
+	if diff.err == nil {
+		return ErrClosed
+	}
+	if diff.err == null || diff.err == exhausted {
+		return nil
+	}
+	return diff.err
+}
+
+// Key returns the key of the current difference.
+//
+// Warning: Returned slice is valid only until next method call.
+func (diff *Diff[B]) Key() []byte {
+	return diff.key
+}
+
+// Old returns the value before the change, or nil if the key was added.
+//
+// Warning: Returned slice is valid only until next method call.
+func (diff *Diff[B]) Old() []byte {
+	return diff.oldVal
+}
+
+// New returns the value after the change, or nil if the key was deleted.
+//
+// Warning: Returned slice is valid only until next method call.
+func (diff *Diff[B]) New() []byte {
+	return diff.newVal
+}
+
+// Next advances to the next difference.
+func (diff *Diff[B]) Next() bool {
+	if diff.err != null {
+		return false
+	}
+	diff.key, diff.oldVal, diff.newVal = nil, nil, nil
+
+	for {
+		diff.old.pop(diff.block)
+		diff.new.pop(diff.block)
+
+		old, new := diff.old.top(), diff.new.top()
+		switch {
+		case old == nil && new == nil:
+			diff.err = exhausted
+			return false
+		case old == nil:
+			if new.page.IsLeaf() {
+				return diff.added(new)
+			}
+			if !diff.expand(&diff.new) {
+				return false
+			}
+			continue
+		case new == nil:
+			if old.page.IsLeaf() {
+				return diff.deleted(old)
+			}
+			if !diff.expand(&diff.old) {
+				return false
+			}
+			continue
+		}
+
+		oldLeaf, newLeaf := old.page.IsLeaf(), new.page.IsLeaf()
+		if !oldLeaf && !newLeaf {
+			if old.page.BranchID(old.index) == new.page.BranchID(new.index) {
+				old.index++
+				new.index++
+				continue
+			}
+			// expand the subtree reaching further, it may contain the other;
+			// separators are only a hint here, so inline heads are enough
+			cmp := bytes.Compare(old.page.BranchKey(old.index), new.page.BranchKey(new.index))
+			if cmp >= 0 && !diff.expand(&diff.old) {
+				return false
+			}
+			if cmp <= 0 && !diff.expand(&diff.new) {
+				return false
+			}
+			continue
+		}
+		if !oldLeaf {
+			if !diff.expand(&diff.old) {
+				return false
+			}
+			continue
+		}
+		if !newLeaf {
+			if !diff.expand(&diff.new) {
+				return false
+			}
+			continue
+		}
+
+		cmp, ok := diff.compareKeys(old.page.LeafKey(old.index), new.page.LeafKey(new.index))
+		if !ok {
+			return false
+		}
+		if cmp < 0 {
+			return diff.deleted(old)
+		}
+		if cmp > 0 {
+			return diff.added(new)
+		}
+
+		oldVal, newVal := old.page.LeafVal(old.index), new.page.LeafVal(new.index)
+		key := new.page.LeafKey(new.index)
+		old.index++
+		new.index++
+		if bytes.Equal(oldVal, newVal) {
+			continue
+		}
+		if diff.oldVal = diff.fullVal(key, oldVal, 1); diff.err != null {
+			return false
+		}
+		if diff.newVal = diff.fullVal(key, newVal, 2); diff.err != null {
+			return false
+		}
+		if bytes.Equal(diff.oldVal, diff.newVal) {
+			diff.oldVal, diff.newVal = nil, nil
+			continue
+		}
+		diff.key = diff.fullKey(key)
+		return diff.err == null
+	}
+}
+
+func (diff *Diff[B]) added(frame *diffFrame) bool {
+	diff.key = diff.fullKey(frame.page.LeafKey(frame.index))
+	diff.newVal = diff.fullVal(frame.page.LeafKey(frame.index), frame.page.LeafVal(frame.index), 2)
+	frame.index++
+	return diff.err == null
+}
+
+func (diff *Diff[B]) deleted(frame *diffFrame) bool {
+	diff.key = diff.fullKey(frame.page.LeafKey(frame.index))
+	diff.oldVal = diff.fullVal(frame.page.LeafKey(frame.index), frame.page.LeafVal(frame.index), 1)
+	frame.index++
+	return diff.err == null
+}
+
+// expand replaces the subtree reference at the top of side with its page.
+func (diff *Diff[B]) expand(side *diffSide) bool {
+	frame := side.top()
+	blockID := frame.page.BranchID(frame.index)
+	frame.index++
+
+	buffer := diff.block.AllocateBuffer()
+	if err := readBlock("bptree.Diff", diff.block, blockID, buffer, nil); err != nil {
+		diff.block.RecycleBuffer(buffer)
+		diff.err = err
+		return false
+	}
+	side.frames = append(side.frames, diffFrame{page: buffer, loaded: true})
+	return true
+}
+
+// compareKeys compares two leaf key slots, reading overflow keys in full.
+func (diff *Diff[B]) compareKeys(oldKey, newKey []byte) (cmp int, ok bool) {
+	keyInlineSize := diff.keyInlineSize
+	if len(oldKey) <= keyInlineSize && len(newKey) <= keyInlineSize {
+		return bytes.Compare(oldKey, newKey), true
+	}
+	if bytes.Equal(oldKey, newKey) {
+		return 0, true
+	}
+	if oldKey = diff.fullKey(oldKey); diff.err != null {
+		return
+	}
+	if len(newKey) <= keyInlineSize {
+		return bytes.Compare(oldKey, newKey), true
+	}
+
+	head, overflowSize, overflowID := Overflow(newKey, keyInlineSize)
+	cmp, err := overflow.Compare(diff.block, oldKey, head, overflowSize, overflowID)
+	if err != nil {
+		diff.err = errKey("bptree.Diff", newKey, keyInlineSize, err)
+		return
+	}
+	return cmp, true
+}
+
+// fullKey returns the key of a leaf key slot.
+func (diff *Diff[B]) fullKey(key []byte) []byte {
+	if len(key) <= diff.keyInlineSize {
+		return key
+	}
+	head, overflowSize, overflowID := Overflow(key, diff.keyInlineSize)
+	inlineKey := key
+	key, err := overflow.Read(diff.block, diff.buf[0], head, overflowSize, overflowID)
+	if err != nil {
+		diff.err = errKey("bptree.Diff", inlineKey, diff.keyInlineSize, err)
+		return nil
+	}
+	diff.buf[0] = key
+	return key
+}
+
+// fullVal returns the value of a leaf value slot, read into buf[i],
+// for the leaf key slot key.
+func (diff *Diff[B]) fullVal(key, val []byte, i int) []byte {
+	if len(val) <= diff.valInlineSize {
+		return val
+	}
+	head, overflowSize, overflowID := Overflow(val, diff.valInlineSize)
+	val, err := overflow.Read(diff.block, diff.buf[i], head, overflowSize, overflowID)
+	if err != nil {
+		diff.err = errKey("bptree.Diff", key, diff.keyInlineSize, err)
+		return nil
+	}
+	diff.buf[i] = val
+	return val
+}
+
+func (side *diffSide) top() *diffFrame {
+	if len(side.frames) == 0 {
+		return nil
+	}
+	return &side.frames[len(side.frames)-1]
+}
+
+// pop drops exhausted frames.
+func (side *diffSide) pop(block interface{ RecycleBuffer([]byte) }) {
+	for len(side.frames) != 0 {
+		frame := side.top()
+		if frame.index < frame.page.Count() {
+			break
+		}
+		if frame.loaded {
+			block.RecycleBuffer(frame.page)
+		}
+		side.frames = side.frames[:len(side.frames)-1]
+	}
+}
+
+func (side *diffSide) close(block interface{ RecycleBuffer([]byte) }) {
+	for _, frame := range side.frames {
+		if frame.loaded {
+			block.RecycleBuffer(frame.page)
+		}
+	}
+	side.frames = side.frames[:0]
+}
@@ -0,0 +1,133 @@
+// Copyright 2025 dacapoday
+// SPDX-License-Identifier: Apache-2.0
+
+package bptree
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/dacapoday/smol/block"
+	"github.com/dacapoday/smol/mem"
+)
+
+// countingBlock counts block reads.
+type countingBlock struct {
+	*block.Heap[*mem.File]
+	reads int
+}
+
+func (b *countingBlock) ReadBlock(blockID BlockID, buffer []byte, reader func([]byte)) error {
+	b.reads++
+	return b.Heap.ReadBlock(blockID, buffer, reader)
+}
+
+type diffOption struct{ option }
+
+func (o diffOption) BlockSize() int { return 1024 }
+
+func TestDiff(t *testing.T) {
+	var f mem.File
+	var b block.Heap[*mem.File]
+	_, ckpt, err := b.Load(&f, diffOption{})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer ckpt.Release()
+	defer b.Close()
+
+	klen, vlen := InlineSize(b.PageSize(), 5, 1<<20, 1<<20)
+	big := bytes.Repeat([]byte("overflow"), 100)
+
+	oldData := map[string][]byte{}
+	for i := range 5000 {
+		oldData[fmt.Sprintf("key%05d", i)] = fmt.Appendf(nil, "val%05d", i)
+	}
+	oldData["key00500"] = big
+	oldData["key01500"] = big
+
+	write := func(root Page, data map[string][]byte) Page {
+		_, root, err := WriteSortedChanges(&b, root, klen, vlen, 0, func(yield func([]byte, []byte) bool) {
+			for _, k := range slices.Sorted(maps.Keys(data)) {
+				if !yield([]byte(k), data[k]) {
+					return
+				}
+			}
+		})
+		if err != nil {
+			t.Fatalf("WriteSortedChanges failed: %v", err)
+		}
+		return root
+	}
+	oldRoot := write(nil, oldData)
+
+	changes := map[string][]byte{
+		"key00010":  []byte("changed"),
+		"key00011":  nil,
+		"key00500":  append(bytes.Clone(big), '!'),
+		"key01500":  bytes.Clone(big), // rewritten, equal
+		"key01999a": []byte("added"),
+		"key00000":  fmt.Appendf(nil, "val%05d", 0), // rewritten, equal
+	}
+	newRoot := write(oldRoot, changes)
+
+	want := []string{
+		"key00010 val00010 -> changed",
+		"key00011 val00011 -> <nil>",
+		"key00500 " + string(big) + " -> " + string(big) + "!",
+		"key01999a <nil> -> added",
+	}
+
+	counter := &countingBlock{Heap: &b}
+	var diff Diff[*countingBlock]
+	diff.Load(counter, oldRoot, newRoot, klen, vlen)
+	defer diff.Close()
+
+	show := func(v []byte) string {
+		if v == nil {
+			return "<nil>"
+		}
+		return string(v)
+	}
+	var got []string
+	for diff.Next() {
+		got = append(got, fmt.Sprintf("%s %s -> %s", diff.Key(), show(diff.Old()), show(diff.New())))
+	}
+	if err := diff.Error(); err != nil {
+		t.Fatalf("Diff error: %v", err)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("diff mismatch:\ngot  %q\nwant %q", got, want)
+	}
+
+	var reader Reader[*countingBlock]
+	reader.Load(counter, newRoot, klen, vlen, 0)
+	reads := counter.reads
+	counter.reads = 0
+	for reader.SeekFirst(); reader.Valid(); reader.Next() {
+	}
+	reader.Close()
+	if reads*4 > counter.reads {
+		t.Errorf("diff read %d blocks, full scan %d", reads, counter.reads)
+	}
+
+	diff.Load(counter, newRoot, newRoot, klen, vlen)
+	if diff.Next() {
+		t.Errorf("diff of identical roots yields %q", diff.Key())
+	}
+
+	diff.Load(counter, nil, oldRoot, klen, vlen)
+	n := 0
+	for diff.Next() {
+		if diff.Old() != nil || diff.New() == nil {
+			t.Fatalf("diff from empty: %q old=%v", diff.Key(), diff.Old())
+		}
+		n++
+	}
+	if n != len(oldData) {
+		t.Errorf("diff from empty yields %d keys, want %d", n, len(oldData))
+	}
+}
@@ -4,6 +4,7 @@
 package bptree
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 
@@ -13,16 +14,52 @@ import (
 var (
 	ErrClosed         = smol.ErrClosed
 	ErrAllocateFailed = smol.ErrAllocateFailed
+	ErrBadChecksum    = smol.ErrBadChecksum
+	ErrBadOverflow    = smol.ErrBadOverflow
+)
+
+type (
+	BlockError = smol.BlockError
+	KeyError   = smol.KeyError
 )
 
 var null = errors.New("")
 var exhausted = errors.New("exhausted")
 
-func errAllocateFailed[B ReadWrite](b B) error {
+// errAllocateFailed wraps ErrAllocateFailed, and the error of the block
+// if any, in a KeyError with the first key of the page to be written.
+func errAllocateFailed[B ReadWrite](op string, b B, inlineKey []byte, keyInlineSize int) error {
+	err := ErrAllocateFailed
 	if block, ok := any(b).(interface{ Error() error }); ok {
-		if err := block.Error(); err != nil {
-			return fmt.Errorf("%w: %w", ErrAllocateFailed, err)
+		if blockErr := block.Error(); blockErr != nil {
+			err = fmt.Errorf("%w: %w", ErrAllocateFailed, blockErr)
 		}
 	}
-	return ErrAllocateFailed
+	return errKey(op, inlineKey, keyInlineSize, err)
+}
+
+// readBlock is block.ReadBlock, returning its error in a BlockError.
+func readBlock[B ReadOnly](op string, block B, blockID BlockID, buffer []byte, reader func(block []byte)) error {
+	if err := block.ReadBlock(blockID, buffer, reader); err != nil {
+		return &BlockError{Op: op, BlockID: blockID, Err: err}
+	}
+	return nil
+}
+
+// loadBlock is block.LoadBlock, returning its error in a BlockError.
+func loadBlock[B ReadOnly](op string, block B, blockID BlockID) ([]byte, error) {
+	buffer, err := block.LoadBlock(blockID)
+	if err != nil {
+		err = &BlockError{Op: op, BlockID: blockID, Err: err}
+	}
+	return buffer, err
+}
+
+// errKey wraps err, from the overflow of a key or its value, in a KeyError
+// with the key as stored inline: its head if the key overflowed itself.
+func errKey(op string, inlineKey []byte, keyInlineSize int, err error) error {
+	if len(inlineKey) > keyInlineSize {
+		inlineKey, _, _ = Overflow(inlineKey, keyInlineSize)
+	}
+	return &KeyError{Op: op, Key: bytes.Clone(inlineKey), Err: err}
 }
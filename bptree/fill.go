@@ -0,0 +1,47 @@
+// Copyright 2025 dacapoday
+// SPDX-License-Identifier: Apache-2.0
+
+package bptree
+
+// Fill controls how full WriteSortedChanges packs the pages it rewrites.
+// Fields are percentages of the page size, clamped to their valid range.
+// The zero value packs pages full and evens out the last two pages of a split.
+type Fill struct {
+	// Split is the fill of pages split off when changes overflow a page,
+	// leaving room for later inserts and updates. 50 to 100; zero means 100.
+	// Pages that still fit are never split.
+	Split int
+
+	// Append is the fill of pages split off at the right edge of the tree,
+	// where monotonically increasing keys land. The last page is not evened
+	// out there, since later appends fill it. 50 to 100; zero applies Split.
+	Append int
+
+	// Merge is the minimum fill of a rewritten page. A page below it takes
+	// in its right neighbour, or its left one at the end of the branch page,
+	// and the two are rebalanced if they do not fit in one page.
+	// Up to 50; zero never merges.
+	Merge int
+}
+
+func (fill Fill) split(limit int) int {
+	return percent(limit, fill.Split, 50, 100, 100)
+}
+
+func (fill Fill) append(limit int) int {
+	if fill.Append == 0 {
+		return 0
+	}
+	return percent(limit, fill.Append, 50, 100, 100)
+}
+
+func (fill Fill) merge(limit int) int {
+	return percent(limit, fill.Merge, 0, 50, 0)
+}
+
+func percent(limit, p, lo, hi, zero int) int {
+	if p == 0 {
+		p = zero
+	}
+	return limit * min(max(p, lo), hi) / 100
+}
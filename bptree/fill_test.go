@@ -0,0 +1,204 @@
+// Copyright 2025 dacapoday
+// SPDX-License-Identifier: Apache-2.0
+
+package bptree
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/dacapoday/smol/block"
+	"github.com/dacapoday/smol/mem"
+)
+
+// fillTree is a tree written batch by batch with a fill policy,
+// mirrored by a map.
+type fillTree struct {
+	t     *testing.T
+	b     block.Heap[*mem.File]
+	f     mem.File
+	fill  Fill
+	klen  int
+	vlen  int
+	high  uint8
+	root  Page
+	model map[string][]byte
+}
+
+func newFillTree(t *testing.T, fill Fill) *fillTree {
+	tree := &fillTree{t: t, fill: fill, model: map[string][]byte{}}
+	_, ckpt, err := tree.b.Load(&tree.f, diffOption{})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	t.Cleanup(func() {
+		ckpt.Release()
+		tree.b.Close()
+	})
+	tree.klen, tree.vlen = InlineSize(tree.b.PageSize(), 5, 1<<20, 1<<20)
+	return tree
+}
+
+func (tree *fillTree) write(changes map[string][]byte) {
+	var err error
+	tree.high, tree.root, err = WriteSortedChangesOptions(&tree.b, tree.root, tree.klen, tree.vlen, tree.high, Options{Fill: tree.fill}, func(yield func([]byte, []byte) bool) {
+		for _, k := range slices.Sorted(maps.Keys(changes)) {
+			if !yield([]byte(k), changes[k]) {
+				return
+			}
+		}
+	})
+	if err != nil {
+		tree.t.Fatalf("WriteSortedChangesOptions failed: %v", err)
+	}
+	for k, v := range changes {
+		if v == nil {
+			delete(tree.model, k)
+		} else {
+			tree.model[k] = v
+		}
+	}
+}
+
+// leaves counts the leaf pages and verifies the items against the model.
+func (tree *fillTree) leaves() (leaves int) {
+	t := tree.t
+	var count func(page Page)
+	count = func(page Page) {
+		if page.IsLeaf() {
+			leaves++
+			return
+		}
+		buffer := tree.b.AllocateBuffer()
+		defer tree.b.RecycleBuffer(buffer)
+		for i := range page.Count() {
+			if err := tree.b.ReadBlock(page.BranchID(i), buffer, nil); err != nil {
+				t.Fatalf("ReadBlock failed: %v", err)
+			}
+			count(Page(buffer))
+		}
+	}
+	count(tree.root)
+
+	var reader Reader[*block.Heap[*mem.File]]
+	reader.Load(&tree.b, tree.root, tree.klen, tree.vlen, tree.high)
+	defer reader.Close()
+	keys := slices.Sorted(maps.Keys(tree.model))
+	i := 0
+	for reader.SeekFirst(); reader.Valid(); reader.Next() {
+		if i >= len(keys) {
+			t.Fatalf("extra key %q", reader.Key())
+		}
+		if string(reader.Key()) != keys[i] || !bytes.Equal(reader.Val(), tree.model[keys[i]]) {
+			t.Fatalf("item %d: got %q=%q, want %q=%q", i, reader.Key(), reader.Val(), keys[i], tree.model[keys[i]])
+		}
+		i++
+	}
+	if err := reader.Error(); err != nil {
+		t.Fatalf("Reader error: %v", err)
+	}
+	if i != len(keys) {
+		t.Fatalf("iterated %d keys, want %d", i, len(keys))
+	}
+	return
+}
+
+// TestFillAppend tests that appends leave full pages behind.
+func TestFillAppend(t *testing.T) {
+	balanced := newFillTree(t, Fill{})
+	packed := newFillTree(t, Fill{Append: 100})
+	for batch := range 300 {
+		changes := map[string][]byte{}
+		for i := range 20 {
+			changes[fmt.Sprintf("event:%08d", batch*20+i)] = []byte("payload")
+		}
+		balanced.write(changes)
+		packed.write(changes)
+	}
+
+	b, p := balanced.leaves(), packed.leaves()
+	t.Logf("leaf pages: balanced=%d packed=%d", b, p)
+	if p*10 > b*8 {
+		t.Fatalf("Append fill wrote %d leaf pages, want well below %d", p, b)
+	}
+
+	t.Log("✓ Appends pack pages full")
+}
+
+// TestFillSplit tests that a lower split fill leaves room in split pages.
+func TestFillSplit(t *testing.T) {
+	full := newFillTree(t, Fill{})
+	roomy := newFillTree(t, Fill{Split: 60})
+	keys := rand.Perm(6000)
+	for batch := range 30 {
+		changes := map[string][]byte{}
+		for _, k := range keys[batch*200 : batch*200+200] {
+			changes[fmt.Sprintf("user:%08d", k)] = []byte("profile")
+		}
+		full.write(changes)
+		roomy.write(changes)
+	}
+
+	f, r := full.leaves(), roomy.leaves()
+	t.Logf("leaf pages: full=%d roomy=%d", f, r)
+	if r <= f {
+		t.Fatalf("Split fill wrote %d leaf pages, want more than %d", r, f)
+	}
+
+	t.Log("✓ Split pages leave room")
+}
+
+// TestFillSplitLargeItem tests splitting pages whose items are larger
+// than the split fill.
+func TestFillSplitLargeItem(t *testing.T) {
+	for _, fill := range []Fill{{Split: 60}, {Append: 60}} {
+		tree := newFillTree(t, fill)
+		for batch := range 4 {
+			changes := map[string][]byte{}
+			for i := range 20 {
+				changes[fmt.Sprintf("key:%04d", i*4+batch)] = bytes.Repeat([]byte{'v'}, tree.vlen)
+			}
+			tree.write(changes)
+		}
+		tree.leaves()
+	}
+
+	t.Log("✓ Large items split with a fill")
+}
+
+// TestFillMerge tests that pages left underfull by deletes are merged.
+func TestFillMerge(t *testing.T) {
+	kept := newFillTree(t, Fill{})
+	merged := newFillTree(t, Fill{Merge: 40})
+	changes := map[string][]byte{}
+	for i := range 8000 {
+		changes[fmt.Sprintf("session:%08d", i)] = []byte("token")
+	}
+	kept.write(changes)
+	merged.write(changes)
+	before := kept.leaves()
+
+	// delete most keys of scattered ranges, each within a page or two
+	for batch := range 4 {
+		changes = map[string][]byte{}
+		for i := range 8000 {
+			if (i/150)%4 == batch && i%30 != 0 {
+				changes[fmt.Sprintf("session:%08d", i)] = nil
+			}
+		}
+		kept.write(changes)
+		merged.write(changes)
+	}
+
+	k, m := kept.leaves(), merged.leaves()
+	t.Logf("leaf pages: before=%d kept=%d merged=%d", before, k, m)
+	if m*3 > k*2 {
+		t.Fatalf("Merge fill left %d leaf pages, want well below %d", m, k)
+	}
+
+	t.Log("✓ Underfull pages merged")
+}
@@ -13,7 +13,7 @@ func High[B ReadOnly](block B, root Page) (high uint8, err error) {
 			buffer = block.AllocateBuffer()
 			defer block.RecycleBuffer(buffer)
 		}
-		if err = block.ReadBlock(root.BranchID(0), buffer, nil); err != nil {
+		if err = readBlock("bptree.High", block, root.BranchID(0), buffer, nil); err != nil {
 			return
 		}
 		root = Page(buffer)
@@ -33,14 +33,15 @@ func (reader *Reader[B]) Key() (key []byte) {
 	if reader.err != null {
 		return
 	}
-	key = reader.page.LeafKey(reader.index)
+	key = reader.leafKey()
 	keyInlineSize := int(reader.keyInlineSize)
 	if len(key) > keyInlineSize {
 		head, overflowSize, overflowID := Overflow(key, keyInlineSize)
 		var err error
+		inlineKey := key
 		key, err = overflow.Read(reader.block, nil, head, overflowSize, overflowID)
 		if err != nil {
-			reader.err = err
+			reader.err = errKey("bptree.Reader.Key", inlineKey, keyInlineSize, err)
 		}
 	}
 	return
@@ -64,7 +65,7 @@ func (reader *Reader[B]) Val() (val []byte) {
 		var err error
 		val, err = overflow.Read(reader.block, reader.val, head, overflowSize, overflowID)
 		if err != nil {
-			reader.err = err
+			reader.err = errKey("bptree.Reader.Val", reader.leafKey(), int(reader.keyInlineSize), err)
 			return
 		}
 		reader.val = val
@@ -96,7 +97,7 @@ func (reader *Reader[B]) Next() bool {
 	var blockID BlockID
 	if h == 0 {
 		blockID = reader.root.BranchID(reader.level[0].Index)
-	} else if err := reader.block.ReadBlock(reader.level[h].BlockID, reader.page, func(block []byte) {
+	} else if err := readBlock("bptree.Reader.Next", reader.block, reader.level[h].BlockID, reader.page, func(block []byte) {
 		blockID = Page(block).BranchID(reader.level[h].Index)
 	}); err != nil {
 		reader.err = err
@@ -111,12 +112,12 @@ func (reader *Reader[B]) Next() bool {
 		blockID = page.BranchID(0)
 	}
 	for h++; h < high; h++ {
-		if err := reader.block.ReadBlock(blockID, reader.page, seekFirst); err != nil {
+		if err := readBlock("bptree.Reader.Next", reader.block, blockID, reader.page, seekFirst); err != nil {
 			reader.err = err
 			return false
 		}
 	}
-	if err := reader.block.ReadBlock(blockID, reader.page, nil); err != nil {
+	if err := readBlock("bptree.Reader.Next", reader.block, blockID, reader.page, nil); err != nil {
 		reader.err = err
 		return false
 	}
@@ -152,7 +153,7 @@ func (reader *Reader[B]) Prev() bool {
 	var blockID BlockID
 	if h == 0 {
 		blockID = reader.root.BranchID(reader.level[0].Index)
-	} else if err := reader.block.ReadBlock(reader.level[h].BlockID, reader.page, func(block []byte) {
+	} else if err := readBlock("bptree.Reader.Prev", reader.block, reader.level[h].BlockID, reader.page, func(block []byte) {
 		blockID = Page(block).BranchID(reader.level[h].Index)
 	}); err != nil {
 		reader.err = err
@@ -168,12 +169,12 @@ func (reader *Reader[B]) Prev() bool {
 		blockID = page.BranchID(index)
 	}
 	for h++; h < high; h++ {
-		if err := reader.block.ReadBlock(blockID, reader.page, seekLast); err != nil {
+		if err := readBlock("bptree.Reader.Prev", reader.block, blockID, reader.page, seekLast); err != nil {
 			reader.err = err
 			return false
 		}
 	}
-	if err := reader.block.ReadBlock(blockID, reader.page, nil); err != nil {
+	if err := readBlock("bptree.Reader.Prev", reader.block, blockID, reader.page, nil); err != nil {
 		reader.err = err
 		return false
 	}
@@ -224,12 +225,12 @@ func (reader *Reader[B]) SeekFirst() bool {
 		blockID = page.BranchID(0)
 	}
 	for ; h < high; h++ {
-		reader.err = reader.block.ReadBlock(blockID, reader.page, seekFirst)
+		reader.err = readBlock("bptree.Reader.SeekFirst", reader.block, blockID, reader.page, seekFirst)
 		if reader.err != nil {
 			return false
 		}
 	}
-	reader.err = reader.block.ReadBlock(blockID, reader.page, nil)
+	reader.err = readBlock("bptree.Reader.SeekFirst", reader.block, blockID, reader.page, nil)
 	if reader.err != nil {
 		return false
 	}
@@ -283,12 +284,12 @@ func (reader *Reader[B]) SeekLast() bool {
 		blockID = page.BranchID(index)
 	}
 	for ; h < high; h++ {
-		reader.err = reader.block.ReadBlock(blockID, reader.page, seekLast)
+		reader.err = readBlock("bptree.Reader.SeekLast", reader.block, blockID, reader.page, seekLast)
 		if reader.err != nil {
 			return false
 		}
 	}
-	reader.err = reader.block.ReadBlock(blockID, reader.page, nil)
+	reader.err = readBlock("bptree.Reader.SeekLast", reader.block, blockID, reader.page, nil)
 	if reader.err != nil {
 		return false
 	}
@@ -312,13 +313,59 @@ func (reader *Reader[B]) Seek(key []byte) bool {
 		key:           key,
 		keyInlineSize: int(reader.keyInlineSize),
 	}
+	if !reader.descend(&cursor) {
+		return false
+	}
+	if reader.index == reader.count {
+		// key lies between the last key of the leaf and its separator
+		reader.index--
+		return reader.Next()
+	}
+	return true
+}
+
+// SeekLE positions at the last key <= the given key.
+// Unlike Seek followed by Prev, it steps to another leaf only when key
+// lies before the first key of the leaf that would hold it.
+func (reader *Reader[B]) SeekLE(key []byte) bool {
+	cursor := cursor[B]{
+		Reader:        reader,
+		key:           key,
+		keyInlineSize: int(reader.keyInlineSize),
+	}
+	if !reader.descend(&cursor) {
+		if reader.err == exhausted {
+			// key is greater than every separator of the root
+			return reader.SeekLast()
+		}
+		return false
+	}
+	if reader.index < reader.count && reader.Equal(key) {
+		return true
+	}
+	if reader.err != null {
+		return false
+	}
+	if reader.index > 0 {
+		reader.index--
+		return true
+	}
+	// key lies between the separator of the previous leaf and the first key of this one
+	return reader.Prev()
+}
+
+// descend positions the reader at the first key >= cursor.key in the leaf
+// that would hold it, past the last key of the leaf if cursor.key lies
+// between that key and the separator of the leaf. It returns false with
+// the reader exhausted if cursor.key is greater than every separator.
+func (reader *Reader[B]) descend(cursor *cursor[B]) bool {
 	high := len(reader.level)
 	if high == 0 {
 		if reader.level == nil {
 			if reader.err == nil {
 				return false
 			}
-			return reader.seek(key)
+			return reader.seek(cursor)
 		}
 		page := reader.page
 		count := page.Count()
@@ -366,7 +413,7 @@ func (reader *Reader[B]) Seek(key []byte) bool {
 		blockID = page.BranchID(index)
 	}
 	for ; h < high; h++ {
-		reader.err = reader.block.ReadBlock(blockID, reader.page, seek)
+		reader.err = readBlock("bptree.Reader.Seek", reader.block, blockID, reader.page, seek)
 		if reader.err != nil {
 			return false
 		}
@@ -375,13 +422,13 @@ func (reader *Reader[B]) Seek(key []byte) bool {
 			return false
 		}
 	}
-	reader.err = reader.block.ReadBlock(blockID, reader.page, nil)
+	reader.err = readBlock("bptree.Reader.Seek", reader.block, blockID, reader.page, nil)
 	if reader.err != nil {
 		return false
 	}
 	page = reader.page
 	count = page.Count()
-	index = cursor.searchLeaf(count-1, page)
+	index = cursor.searchLeaf(count, page)
 	if cursor.err != nil {
 		reader.err = cursor.err
 		return false
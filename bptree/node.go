@@ -9,32 +9,51 @@ func writeNodes[
 	Items items[V],
 	Item branchItem | leafItem,
 	ItemPtr itemPtr[V, Items, Item],
-](block B, page *branchPage, head *node[V, Items, Item, ItemPtr]) (err error) {
-	writer := nodeWriter[B, V, Items, Item, ItemPtr]{block: block, page: page}
+](block B, keyInlineSize int, opts Options, edge bool, page *branchPage, head *node[V, Items, Item, ItemPtr]) (err error) {
+	writer := nodeWriter[B, V, Items, Item, ItemPtr]{block: block, keyInlineSize: keyInlineSize, short: opts.ShortSeparators, page: page}
 	writer.buffer = block.AllocateBuffer()
 	defer block.RecycleBuffer(writer.buffer)
+	defer writer.recycle()
 
 	limit := block.PageSize() - HeadSize
 	halfLimit := limit / 2
+	splitLimit := opts.Fill.split(limit)
+	appendLimit := opts.Fill.append(limit)
+	mergeLimit := opts.Fill.merge(limit)
 
 	var node, rest, next nodes[V, Items, Item, ItemPtr]
 	var nodeSize, restSize int
-	var noRest bool
+	var noRest, merged bool
 
 	node.node = head
 	node.item = &head.page.head
+	node.sizer.compact = opts.PrefixLeaves
 	// node.offset = 0
 	// node.count = 0
 	for {
-		nodeSize, rest, noRest = node.split(limit)
+		// at the right edge of the tree pages are left behind for good
+		target, balance := splitLimit, true
+		if appendLimit != 0 && edge && writer.atEnd(node) {
+			target, balance = appendLimit, false
+		}
+
+		nodeSize, rest, noRest = node.pack(limit, target)
 		if noRest {
 			if node.count == 0 {
 				writer.append(node.prev)
 			} else {
-				// if restSize < halfLimit {
-				// 	// TODO: try merge neighbor
-				// }
-				err = writer.write(node.prev, HeadSize+nodeSize, node.items)
+				if nodeSize < mergeLimit {
+					merged, err = writer.merge(&node, rest)
+					if err != nil {
+						return
+					}
+					if merged {
+						node.count = 0
+						node.sizer = node.sizer.reset()
+						continue
+					}
+				}
+				err = writer.write(node.prev, HeadSize+nodeSize, node.sizer.compress(), node.items)
 			}
 			if rest.node == nil {
 				return
@@ -46,17 +65,17 @@ func writeNodes[
 			continue
 		}
 
-		restSize, next, noRest = rest.split(limit)
+		restSize, next, noRest = rest.pack(limit, target)
 		if noRest {
-			if restSize < halfLimit {
+			if balance && restSize < halfLimit {
 				// TODO: try merge next
-				nodeSize, rest, restSize = node.balance(limit, nodeSize, restSize, rest.count)
+				nodeSize, rest, restSize = node.balance(limit, nodeSize, rest, restSize)
 			}
-			err = writer.write(node.prev, HeadSize+nodeSize, node.items)
+			err = writer.write(node.prev, HeadSize+nodeSize, node.sizer.compress(), node.items)
 			if err != nil {
 				return
 			}
-			err = writer.write(seg{}, HeadSize+restSize, rest.items)
+			err = writer.write(seg{}, HeadSize+restSize, rest.sizer.compress(), rest.items)
 			if next.node == nil {
 				return
 			}
@@ -67,7 +86,7 @@ func writeNodes[
 			continue
 		}
 
-		err = writer.write(node.prev, HeadSize+nodeSize, node.items)
+		err = writer.write(node.prev, HeadSize+nodeSize, node.sizer.compress(), node.items)
 		if err != nil {
 			return
 		}
@@ -75,24 +94,24 @@ func writeNodes[
 			node = rest
 			rest = next
 			nodeSize = restSize
-			restSize, next, noRest = rest.split(limit)
+			restSize, next, noRest = rest.pack(limit, target)
 			if noRest {
-				if restSize < halfLimit {
+				if balance && restSize < halfLimit {
 					// TODO: try merge next
-					nodeSize, rest, restSize = node.balance(limit, nodeSize, restSize, rest.count)
+					nodeSize, rest, restSize = node.balance(limit, nodeSize, rest, restSize)
 				}
-				err = writer.write(seg{}, HeadSize+nodeSize, node.items)
+				err = writer.write(seg{}, HeadSize+nodeSize, node.sizer.compress(), node.items)
 				if err != nil {
 					return
 				}
-				err = writer.write(seg{}, HeadSize+restSize, rest.items)
+				err = writer.write(seg{}, HeadSize+restSize, rest.sizer.compress(), rest.items)
 				if err != nil {
 					return
 				}
 				break
 			}
 
-			err = writer.write(seg{}, HeadSize+nodeSize, node.items)
+			err = writer.write(seg{}, HeadSize+nodeSize, node.sizer.compress(), node.items)
 			if err != nil {
 				return
 			}
@@ -112,12 +131,98 @@ type nodeWriter[
 	Item branchItem | leafItem,
 	ItemPtr itemPtr[V, Items, Item],
 ] struct {
-	block B
+	block         B
+	keyInlineSize int
+	short         bool // shorten separators
 
 	page *branchPage
 	tail *branchItem
 
 	buffer []byte
+	loaded [][]byte // sibling pages taken in by merge
+}
+
+func (writer *nodeWriter[B, V, Items, Item, ItemPtr]) recycle() {
+	for _, buffer := range writer.loaded {
+		writer.block.RecycleBuffer(buffer)
+	}
+}
+
+// keep returns key as a string that outlives the loaded sibling pages.
+func (writer *nodeWriter[B, V, Items, Item, ItemPtr]) keep(key []byte) string {
+	if writer.loaded == nil {
+		return b2s(key)
+	}
+	return string(key)
+}
+
+// atEnd reports whether the run of node reaches the end of the branch page.
+func (writer *nodeWriter[B, V, Items, Item, ItemPtr]) atEnd(node nodes[V, Items, Item, ItemPtr]) bool {
+	if writer.page.tail.beg != writer.page.tail.end {
+		return false
+	}
+	for n := node.next; n != nil; n = n.next {
+		if n.prev.beg != n.prev.end {
+			return false
+		}
+	}
+	return true
+}
+
+// merge takes the page right after the run into the run, or the page
+// right before it when the run ends the branch page. It reports false when
+// the run has no untouched neighbour.
+func (writer *nodeWriter[B, V, Items, Item, ItemPtr]) merge(run *nodes[V, Items, Item, ItemPtr], rest nodes[V, Items, Item, ItemPtr]) (merged bool, err error) {
+	var index uint16
+	switch {
+	case rest.node != nil:
+		index = rest.prev.beg
+	case writer.page.tail.beg != writer.page.tail.end:
+		index = writer.page.tail.beg
+	case run.prev.beg != run.prev.end:
+		index = run.prev.end - 1
+	default:
+		return
+	}
+
+	blockID := writer.page.BranchID(index)
+	buffer, err := loadBlock("bptree.WriteSortedChanges", writer.block, blockID)
+	if err != nil {
+		return
+	}
+	writer.loaded = append(writer.loaded, buffer)
+	writer.block.RecycleBlock(blockID)
+
+	sibling := new(node[V, Items, Item, ItemPtr])
+	sibling.Page = buffer
+	sibling.page.tail.end = sibling.Count()
+
+	switch {
+	case rest.node != nil:
+		rest.prev.beg++
+		last := run.node
+		for last.next != rest.node {
+			last = last.next
+		}
+		last.next = sibling
+		sibling.next = rest.node
+	case writer.page.tail.beg != writer.page.tail.end:
+		writer.page.tail.beg++
+		last := run.node
+		for last.next != nil {
+			last = last.next
+		}
+		last.next = sibling
+	default:
+		sibling.prev = seg{run.prev.beg, index}
+		sibling.next = run.node
+		run.prev = seg{}
+		run.node = sibling
+		run.item = &sibling.page.head
+		run.offset = 0
+	}
+	merged = true
+	return
 }
 
 func (writer *nodeWriter[B, V, Items, Item, ItemPtr]) append(prev seg) {
@@ -125,23 +230,29 @@ func (writer *nodeWriter[B, V, Items, Item, ItemPtr]) append(prev seg) {
 	item.prev = prev
 }
 
-func (writer *nodeWriter[B, V, Items, Item, ItemPtr]) write(prev seg, size int, items Items) (err error) {
+func (writer *nodeWriter[B, V, Items, Item, ItemPtr]) write(prev seg, size, prefix int, items Items) (err error) {
+	first, key := items.encode(writer.buffer[:size], prefix)
+
 	blockID := writer.block.AllocateBlock()
 	if blockID < 2 {
-		err = errAllocateFailed(writer.block)
+		err = errAllocateFailed("bptree.WriteSortedChanges", writer.block, first, writer.keyInlineSize)
 		return
 	}
 
-	key := items.encode(writer.buffer[:size])
-
 	err = writer.block.WriteBlock(blockID, writer.buffer)
 	if err != nil {
 		return
 	}
 
+	// the previous page written right before this one needs to separate
+	// only up to the first key here
+	if writer.short && prev.beg == prev.end && writer.tail != nil && writer.tail.has() {
+		writer.tail.key = writer.keep(items.separator(s2b(writer.tail.key), first, writer.keyInlineSize))
+	}
+
 	item := writer.extend()
 	item.prev = prev
-	item.key = b2s(key)
+	item.key = writer.keep(key)
 	item.id = blockID
 	return
 }
@@ -166,10 +277,11 @@ type nodes[
 	item   ItemPtr
 	offset uint16
 	count  uint16
+	sizer  sizer
 }
 
 func (node nodes[V, Items, Item, ItemPtr]) items(yield func([]byte, V) bool) {
-	for key, val := range node.item.pageItems(node.Page, node.tail, node.offset) {
+	for key, val := range node.item.pageItems(&node.source, node.tail, node.offset) {
 		if !yield(key, val) {
 			return
 		}
@@ -190,14 +302,15 @@ func (node nodes[V, Items, Item, ItemPtr]) items(yield func([]byte, V) bool) {
 func (node *nodes[V, Items, Item, ItemPtr]) split(limit int) (nodeSize int, rest nodes[V, Items, Item, ItemPtr], noRest bool) {
 	// node.count = 0
 	rest.node = node.node
-	page := rest.Page
+	rest.sizer = node.sizer.reset()
+	page := &rest.source
 	tail := rest.page.tail
 	rest.item = node.item
 	rest.offset = node.offset
 	var pageSize int
 	var pageCount uint16
 	for {
-		pageSize, pageCount, noRest, rest.offset, rest.item = rest.item.split(limit, page, tail, rest.offset)
+		pageSize, pageCount, noRest, rest.offset, rest.item = rest.item.split(limit, &node.sizer, page, tail, rest.offset)
 		nodeSize += pageSize
 		node.count += pageCount
 		if !noRest {
@@ -216,7 +329,7 @@ func (node *nodes[V, Items, Item, ItemPtr]) split(limit int) (nodeSize int, rest
 			return
 		}
 		rest.node = rest.next
-		page = rest.Page
+		page = &rest.source
 		tail = rest.page.tail
 		rest.item = &rest.page.head
 		rest.offset = 0
@@ -224,9 +337,30 @@ func (node *nodes[V, Items, Item, ItemPtr]) split(limit int) (nodeSize int, rest
 	}
 }
 
-func (node *nodes[V, Items, Item, ItemPtr]) balance(limit, size, nextSize int, nextCount uint16) (nodeSize int, rest nodes[V, Items, Item, ItemPtr], restSize int) {
+// pack splits off a page of at most limit items. When the items overflow the
+// page they are split at target instead, to leave room in the pages, unless
+// the first item alone is larger than target.
+func (node *nodes[V, Items, Item, ItemPtr]) pack(limit, target int) (nodeSize int, rest nodes[V, Items, Item, ItemPtr], noRest bool) {
+	nodeSize, rest, noRest = node.split(limit)
+	if noRest || target >= limit {
+		return
+	}
+	packed := *node
+	node.count = 0
+	node.sizer = node.sizer.reset()
+	targetSize, targetRest, targetNoRest := node.split(target)
+	if node.count == 0 {
+		*node = packed
+		return
+	}
+	return targetSize, targetRest, targetNoRest
+}
+
+func (node *nodes[V, Items, Item, ItemPtr]) balance(limit, size int, next nodes[V, Items, Item, ItemPtr], nextSize int) (nodeSize int, rest nodes[V, Items, Item, ItemPtr], restSize int) {
+	s := node.sizer.reset()
 	rest.node = node.node
-	page := rest.Page
+	rest.sizer = s
+	page := &rest.source
 	tail := rest.page.tail
 	rest.item = node.item
 	rest.offset = node.offset
@@ -235,14 +369,14 @@ func (node *nodes[V, Items, Item, ItemPtr]) balance(limit, size, nextSize int, n
 	var noRest bool
 	threshold := size - (limit - nextSize)
 	for {
-		pageSize, pageCount, noRest, rest.offset, rest.item = rest.item.split(threshold, page, tail, rest.offset)
+		pageSize, pageCount, noRest, rest.offset, rest.item = rest.item.split(threshold, &s, page, tail, rest.offset)
 		nodeSize += pageSize
 		count += pageCount
 		if !noRest {
 			break
 		}
 		rest.node = rest.next
-		page = rest.Page
+		page = &rest.source
 		tail = rest.page.tail
 		rest.item = &rest.page.head
 		rest.offset = 0
@@ -250,26 +384,58 @@ func (node *nodes[V, Items, Item, ItemPtr]) balance(limit, size, nextSize int, n
 	}
 	half := (node.count-count)/2 + 1
 	for {
-		pageSize, pageCount, noRest, rest.offset, rest.item = rest.item.take(half, page, tail, rest.offset)
+		pageSize, pageCount, noRest, rest.offset, rest.item = rest.item.take(half, &s, page, tail, rest.offset)
 		nodeSize += pageSize
 		count += pageCount
 		if !noRest {
 			break
 		}
 		rest.node = rest.next
-		page = rest.Page
+		page = &rest.source
 		tail = rest.page.tail
 		rest.item = &rest.page.head
 		rest.offset = 0
 		half -= pageCount
 	}
 
-	rest.count = nextCount + (node.count - count)
-	restSize = nextSize + (size - nodeSize)
+	// with prefix compression the size of an item depends on its neighbours,
+	// so the rest is measured anew and the balance is undone if it overflows
+	rest.count = next.count + (node.count - count)
+	if restSize = rest.measure(); restSize > limit {
+		return size, next, nextSize
+	}
 	node.count = count
+	node.sizer = s
 	return
 }
 
+// measure resets the sizer and returns the size of the node's items.
+func (node *nodes[V, Items, Item, ItemPtr]) measure() (size int) {
+	node.sizer = node.sizer.reset()
+	cur := node.node
+	page := &cur.source
+	tail := cur.page.tail
+	item := node.item
+	offset := node.offset
+	n := node.count
+	var pageSize int
+	var pageCount uint16
+	var noRest bool
+	for {
+		pageSize, pageCount, noRest, offset, item = item.take(n, &node.sizer, page, tail, offset)
+		size += pageSize
+		n -= pageCount
+		if !noRest || n == 0 {
+			return
+		}
+		cur = cur.next
+		page = &cur.source
+		tail = cur.page.tail
+		item = &cur.page.head
+		offset = 0
+	}
+}
+
 type list[
 	V BlockID | []byte,
 	Items items[V],
@@ -346,13 +512,42 @@ type page[
 	Item branchItem | leafItem,
 	ItemPtr itemPtr[V, Items, Item],
 ] struct {
-	Page
+	source
 	head Item
 	tail seg
 }
 
+// source is a page the writer takes items from. The keys of a
+// prefix-compressed leaf page are assembled once, on first use, and shared
+// by every pass over its items.
+type source struct {
+	Page
+	keys [][]byte
+}
+
+// leafItems is Page.LeafItems without assembling the keys again.
+func (page *source) leafItems(beg, end uint16) LeafItems {
+	if !page.HasPrefix() {
+		return page.LeafItems(beg, end)
+	}
+	if page.keys == nil {
+		count := page.Count()
+		page.keys = make([][]byte, 0, count)
+		for key := range page.LeafItems(0, count) {
+			page.keys = append(page.keys, key)
+		}
+	}
+	return func(yield func([]byte, []byte) bool) {
+		for i := beg; i < end; i++ {
+			if !yield(page.keys[i], page.LeafVal(i)) {
+				return
+			}
+		}
+	}
+}
+
 func (page *page[V, Items, Item, ItemPtr]) items(yield func([]byte, V) bool) {
-	for key, val := range ItemPtr(&page.head).pageItems(page.Page, page.tail, 0) {
+	for key, val := range ItemPtr(&page.head).pageItems(&page.source, page.tail, 0) {
 		if !yield(key, val) {
 			return
 		}
@@ -365,9 +560,9 @@ type itemPtr[
 	Item branchItem | leafItem,
 ] interface {
 	*Item
-	pageItems(page Page, tail seg, offset uint16) Items
-	split(limit int, page Page, tail seg, offset uint16) (size int, count uint16, noRest bool, restOffset uint16, restItem *Item)
-	take(n uint16, page Page, tail seg, offset uint16) (size int, count uint16, noRest bool, restOffset uint16, restItem *Item)
+	pageItems(page *source, tail seg, offset uint16) Items
+	split(limit int, s *sizer, page *source, tail seg, offset uint16) (size int, count uint16, noRest bool, restOffset uint16, restItem *Item)
+	take(n uint16, s *sizer, page *source, tail seg, offset uint16) (size int, count uint16, noRest bool, restOffset uint16, restItem *Item)
 }
 
 type leafItem struct {
@@ -387,10 +582,10 @@ func (item *leafItem) extend() *leafItem {
 	return item.next
 }
 
-func (item *leafItem) pageItems(page Page, tail seg, offset uint16) LeafItems {
+func (item *leafItem) pageItems(page *source, tail seg, offset uint16) LeafItems {
 	return func(yield func([]byte, []byte) bool) {
 		if item == nil {
-			for key, val := range page.LeafItems(tail.beg+offset, tail.end) {
+			for key, val := range page.leafItems(tail.beg+offset, tail.end) {
 				if !yield(key, val) {
 					return
 				}
@@ -398,7 +593,7 @@ func (item *leafItem) pageItems(page Page, tail seg, offset uint16) LeafItems {
 			return
 		}
 
-		for key, val := range page.LeafItems(item.prev.beg+offset, item.prev.end) {
+		for key, val := range page.leafItems(item.prev.beg+offset, item.prev.end) {
 			if !yield(key, val) {
 				return
 			}
@@ -409,7 +604,7 @@ func (item *leafItem) pageItems(page Page, tail seg, offset uint16) LeafItems {
 			}
 		}
 		for item = item.next; item != nil; item = item.next {
-			for key, val := range page.LeafItems(item.prev.beg, item.prev.end) {
+			for key, val := range page.leafItems(item.prev.beg, item.prev.end) {
 				if !yield(key, val) {
 					return
 				}
@@ -420,7 +615,7 @@ func (item *leafItem) pageItems(page Page, tail seg, offset uint16) LeafItems {
 				}
 			}
 		}
-		for key, val := range page.LeafItems(tail.beg, tail.end) {
+		for key, val := range page.leafItems(tail.beg, tail.end) {
 			if !yield(key, val) {
 				return
 			}
@@ -428,15 +623,16 @@ func (item *leafItem) pageItems(page Page, tail seg, offset uint16) LeafItems {
 	}
 }
 
-func (item *leafItem) split(limit int, page Page, tail seg, offset uint16) (size int, count uint16, noRest bool, restOffset uint16, restItem *leafItem) {
+func (item *leafItem) split(limit int, s *sizer, page *source, tail seg, offset uint16) (size int, count uint16, noRest bool, restOffset uint16, restItem *leafItem) {
 	restOffset = offset
 	if item == nil {
-		for itemSize := range page.LeafItems(tail.beg+offset, tail.end).ItemSize {
-			size += itemSize
-			if size > limit {
-				size -= itemSize
+		for key, val := range page.leafItems(tail.beg+offset, tail.end) {
+			next, itemSize := s.leaf(key, val)
+			if size+itemSize > limit {
 				return
 			}
+			*s = next
+			size += itemSize
 			count++
 			restOffset++
 		}
@@ -444,54 +640,57 @@ func (item *leafItem) split(limit int, page Page, tail seg, offset uint16) (size
 		return
 	}
 	restItem = item
-	for itemSize := range page.LeafItems(item.prev.beg+offset, item.prev.end).ItemSize {
-		size += itemSize
-		if size > limit {
-			size -= itemSize
+	for key, val := range page.leafItems(item.prev.beg+offset, item.prev.end) {
+		next, itemSize := s.leaf(key, val)
+		if size+itemSize > limit {
 			return
 		}
+		*s = next
+		size += itemSize
 		count++
 		restOffset++
 	}
 	if item.has() {
-		itemSize := leafItemSize(len(item.key), len(item.val))
-		size += itemSize
-		if size > limit {
-			size -= itemSize
+		next, itemSize := s.leaf(s2b(item.key), s2b(item.val))
+		if size+itemSize > limit {
 			return
 		}
+		*s = next
+		size += itemSize
 		count++
 	}
 	for item = item.next; item != nil; item = item.next {
 		restOffset = 0
 		restItem = item
-		for itemSize := range page.LeafItems(item.prev.beg, item.prev.end).ItemSize {
-			size += itemSize
-			if size > limit {
-				size -= itemSize
+		for key, val := range page.leafItems(item.prev.beg, item.prev.end) {
+			next, itemSize := s.leaf(key, val)
+			if size+itemSize > limit {
 				return
 			}
+			*s = next
+			size += itemSize
 			count++
 			restOffset++
 		}
 		if item.has() {
-			itemSize := leafItemSize(len(item.key), len(item.val))
-			size += itemSize
-			if size > limit {
-				size -= itemSize
+			next, itemSize := s.leaf(s2b(item.key), s2b(item.val))
+			if size+itemSize > limit {
 				return
 			}
+			*s = next
+			size += itemSize
 			count++
 		}
 	}
 	restOffset = 0
 	restItem = nil
-	for itemSize := range page.LeafItems(tail.beg, tail.end).ItemSize {
-		size += itemSize
-		if size > limit {
-			size -= itemSize
+	for key, val := range page.leafItems(tail.beg, tail.end) {
+		next, itemSize := s.leaf(key, val)
+		if size+itemSize > limit {
 			return
 		}
+		*s = next
+		size += itemSize
 		count++
 		restOffset++
 	}
@@ -499,13 +698,15 @@ func (item *leafItem) split(limit int, page Page, tail seg, offset uint16) (size
 	return
 }
 
-func (item *leafItem) take(n uint16, page Page, tail seg, offset uint16) (size int, count uint16, noRest bool, restOffset uint16, restItem *leafItem) {
+func (item *leafItem) take(n uint16, s *sizer, page *source, tail seg, offset uint16) (size int, count uint16, noRest bool, restOffset uint16, restItem *leafItem) {
 	restOffset = offset
 	if item == nil {
-		for itemSize := range page.LeafItems(tail.beg+offset, tail.end).ItemSize {
+		for key, val := range page.leafItems(tail.beg+offset, tail.end) {
 			if count >= n {
 				return
 			}
+			next, itemSize := s.leaf(key, val)
+			*s = next
 			size += itemSize
 			count++
 			restOffset++
@@ -514,10 +715,12 @@ func (item *leafItem) take(n uint16, page Page, tail seg, offset uint16) (size i
 		return
 	}
 	restItem = item
-	for itemSize := range page.LeafItems(item.prev.beg+offset, item.prev.end).ItemSize {
+	for key, val := range page.leafItems(item.prev.beg+offset, item.prev.end) {
 		if count >= n {
 			return
 		}
+		next, itemSize := s.leaf(key, val)
+		*s = next
 		size += itemSize
 		count++
 		restOffset++
@@ -526,17 +729,20 @@ func (item *leafItem) take(n uint16, page Page, tail seg, offset uint16) (size i
 		if count >= n {
 			return
 		}
-		itemSize := leafItemSize(len(item.key), len(item.val))
+		next, itemSize := s.leaf(s2b(item.key), s2b(item.val))
+		*s = next
 		size += itemSize
 		count++
 	}
 	for item = item.next; item != nil; item = item.next {
 		restOffset = 0
 		restItem = item
-		for itemSize := range page.LeafItems(item.prev.beg, item.prev.end).ItemSize {
+		for key, val := range page.leafItems(item.prev.beg, item.prev.end) {
 			if count >= n {
 				return
 			}
+			next, itemSize := s.leaf(key, val)
+			*s = next
 			size += itemSize
 			count++
 			restOffset++
@@ -545,17 +751,20 @@ func (item *leafItem) take(n uint16, page Page, tail seg, offset uint16) (size i
 			if count >= n {
 				return
 			}
-			itemSize := leafItemSize(len(item.key), len(item.val))
+			next, itemSize := s.leaf(s2b(item.key), s2b(item.val))
+			*s = next
 			size += itemSize
 			count++
 		}
 	}
 	restOffset = 0
 	restItem = nil
-	for itemSize := range page.LeafItems(tail.beg, tail.end).ItemSize {
+	for key, val := range page.leafItems(tail.beg, tail.end) {
 		if count >= n {
 			return
 		}
+		next, itemSize := s.leaf(key, val)
+		*s = next
 		size += itemSize
 		count++
 		restOffset++
@@ -580,7 +789,7 @@ func (item *branchItem) extend() *branchItem {
 	return item.next
 }
 
-func (item *branchItem) pageItems(page Page, tail seg, offset uint16) BranchItems {
+func (item *branchItem) pageItems(page *source, tail seg, offset uint16) BranchItems {
 	return func(yield func([]byte, BlockID) bool) {
 		if item == nil {
 			for key, id := range page.BranchItems(tail.beg+offset, tail.end) {
@@ -621,15 +830,16 @@ func (item *branchItem) pageItems(page Page, tail seg, offset uint16) BranchItem
 	}
 }
 
-func (item *branchItem) split(limit int, page Page, tail seg, offset uint16) (size int, count uint16, noRest bool, restOffset uint16, restItem *branchItem) {
+func (item *branchItem) split(limit int, s *sizer, page *source, tail seg, offset uint16) (size int, count uint16, noRest bool, restOffset uint16, restItem *branchItem) {
 	restOffset = offset
 	if item == nil {
-		for itemSize := range page.BranchItems(tail.beg+offset, tail.end).ItemSize {
-			size += itemSize
-			if size > limit {
-				size -= itemSize
+		for key := range page.BranchItems(tail.beg+offset, tail.end) {
+			next, itemSize := s.branch(key)
+			if size+itemSize > limit {
 				return
 			}
+			*s = next
+			size += itemSize
 			count++
 			restOffset++
 		}
@@ -637,54 +847,57 @@ func (item *branchItem) split(limit int, page Page, tail seg, offset uint16) (si
 		return
 	}
 	restItem = item
-	for itemSize := range page.BranchItems(item.prev.beg+offset, item.prev.end).ItemSize {
-		size += itemSize
-		if size > limit {
-			size -= itemSize
+	for key := range page.BranchItems(item.prev.beg+offset, item.prev.end) {
+		next, itemSize := s.branch(key)
+		if size+itemSize > limit {
 			return
 		}
+		*s = next
+		size += itemSize
 		count++
 		restOffset++
 	}
 	if item.has() {
-		itemSize := branchItemSize(len(item.key))
-		size += itemSize
-		if size > limit {
-			size -= itemSize
+		next, itemSize := s.branch(s2b(item.key))
+		if size+itemSize > limit {
 			return
 		}
+		*s = next
+		size += itemSize
 		count++
 	}
 	for item = item.next; item != nil; item = item.next {
 		restOffset = 0
 		restItem = item
-		for itemSize := range page.BranchItems(item.prev.beg, item.prev.end).ItemSize {
-			size += itemSize
-			if size > limit {
-				size -= itemSize
+		for key := range page.BranchItems(item.prev.beg, item.prev.end) {
+			next, itemSize := s.branch(key)
+			if size+itemSize > limit {
 				return
 			}
+			*s = next
+			size += itemSize
 			count++
 			restOffset++
 		}
 		if item.has() {
-			itemSize := branchItemSize(len(item.key))
-			size += itemSize
-			if size > limit {
-				size -= itemSize
+			next, itemSize := s.branch(s2b(item.key))
+			if size+itemSize > limit {
 				return
 			}
+			*s = next
+			size += itemSize
 			count++
 		}
 	}
 	restOffset = 0
 	restItem = nil
-	for itemSize := range page.BranchItems(tail.beg, tail.end).ItemSize {
-		size += itemSize
-		if size > limit {
-			size -= itemSize
+	for key := range page.BranchItems(tail.beg, tail.end) {
+		next, itemSize := s.branch(key)
+		if size+itemSize > limit {
 			return
 		}
+		*s = next
+		size += itemSize
 		count++
 		restOffset++
 	}
@@ -692,13 +905,15 @@ func (item *branchItem) split(limit int, page Page, tail seg, offset uint16) (si
 	return
 }
 
-func (item *branchItem) take(n uint16, page Page, tail seg, offset uint16) (size int, count uint16, noRest bool, restOffset uint16, restItem *branchItem) {
+func (item *branchItem) take(n uint16, s *sizer, page *source, tail seg, offset uint16) (size int, count uint16, noRest bool, restOffset uint16, restItem *branchItem) {
 	restOffset = offset
 	if item == nil {
-		for itemSize := range page.BranchItems(tail.beg+offset, tail.end).ItemSize {
+		for key := range page.BranchItems(tail.beg+offset, tail.end) {
 			if count >= n {
 				return
 			}
+			next, itemSize := s.branch(key)
+			*s = next
 			size += itemSize
 			count++
 			restOffset++
@@ -707,10 +922,12 @@ func (item *branchItem) take(n uint16, page Page, tail seg, offset uint16) (size
 		return
 	}
 	restItem = item
-	for itemSize := range page.BranchItems(item.prev.beg+offset, item.prev.end).ItemSize {
+	for key := range page.BranchItems(item.prev.beg+offset, item.prev.end) {
 		if count >= n {
 			return
 		}
+		next, itemSize := s.branch(key)
+		*s = next
 		size += itemSize
 		count++
 		restOffset++
@@ -719,17 +936,20 @@ func (item *branchItem) take(n uint16, page Page, tail seg, offset uint16) (size
 		if count >= n {
 			return
 		}
-		itemSize := branchItemSize(len(item.key))
+		next, itemSize := s.branch(s2b(item.key))
+		*s = next
 		size += itemSize
 		count++
 	}
 	for item = item.next; item != nil; item = item.next {
 		restOffset = 0
 		restItem = item
-		for itemSize := range page.BranchItems(item.prev.beg, item.prev.end).ItemSize {
+		for key := range page.BranchItems(item.prev.beg, item.prev.end) {
 			if count >= n {
 				return
 			}
+			next, itemSize := s.branch(key)
+			*s = next
 			size += itemSize
 			count++
 			restOffset++
@@ -738,17 +958,20 @@ func (item *branchItem) take(n uint16, page Page, tail seg, offset uint16) (size
 			if count >= n {
 				return
 			}
-			itemSize := branchItemSize(len(item.key))
+			next, itemSize := s.branch(s2b(item.key))
+			*s = next
 			size += itemSize
 			count++
 		}
 	}
 	restOffset = 0
 	restItem = nil
-	for itemSize := range page.BranchItems(tail.beg, tail.end).ItemSize {
+	for key := range page.BranchItems(tail.beg, tail.end) {
 		if count >= n {
 			return
 		}
+		next, itemSize := s.branch(key)
+		*s = next
 		size += itemSize
 		count++
 		restOffset++
@@ -4,6 +4,7 @@
 package bptree
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"unsafe"
@@ -18,9 +19,11 @@ type Page []byte
 // EmptyRootLeafPage is nil or empty []byte
 // LeafPage is (Count>0 and IsLeaf){byte[0:2]:Head, byte[2:4]:Size, byte[4:4+Count*2]:offset, byte[4+Count*2:n]:LeafItem}
 // LeafItem is {uvarint,Key,Val}, uvarint is key's length size
+// PrefixLeafPage is (Count>0 and IsLeaf and HasPrefix){byte[0:2]:Head, byte[2:4]:Size, byte[4:6+Count*2]:offset, byte[6+Count*2:n]:{Prefix,LeafItem...}}
+// PrefixLeafPage stores the key prefix shared by all items once in slot 0; its LeafItem keys are suffixes
 // BranchPage is (Count>0 and not IsLeaf){byte[0:2]:Head, byte[2:4]:Size, byte[4:4+Count*2]:offset, byte[4+Count*2:n]:BranchItem}
 // BranchItem is {BlockID,Key}
-// Head is MSB{bit0:reserved, bit1:IsLeaf, bit[2:]:Count}LSB
+// Head is MSB{bit0:HasPrefix, bit1:IsBranch, bit[2:]:Count}LSB
 
 const HeadSize = 4 // Head + Size
 
@@ -48,6 +51,14 @@ func (page Page) IsLeaf() bool {
 	return page[1]&0x40 == 0
 }
 
+// HasPrefix reports whether the page is a prefix-compressed leaf page.
+func (page Page) HasPrefix() bool {
+	if len(page) < HeadSize {
+		return false
+	}
+	return page[1]&0x80 != 0
+}
+
 func (page Page) item(index uint16) []byte {
 	offset := 2*index + HeadSize
 	beg := binary.LittleEndian.Uint16(page[offset:]) + HeadSize
@@ -55,10 +66,28 @@ func (page Page) item(index uint16) []byte {
 	return page[beg:end]
 }
 
-// LeafKey returns the key at the given index in a leaf page.
+func (page Page) leafItem(index uint16) []byte {
+	if page.HasPrefix() {
+		index++
+	}
+	return page.item(index)
+}
+
+// LeafPrefix returns the key prefix shared by all items of a prefix-compressed
+// leaf page, or nil for a plain leaf page.
 // Only call this method on leaf pages (when IsLeaf returns true).
-func (page Page) LeafKey(index uint16) []byte {
-	item := page.item(index)
+func (page Page) LeafPrefix() []byte {
+	if !page.HasPrefix() {
+		return nil
+	}
+	return page.item(0)
+}
+
+// LeafSuffix returns the key bytes stored in the item at the given index,
+// which exclude LeafPrefix on a prefix-compressed leaf page.
+// Only call this method on leaf pages (when IsLeaf returns true).
+func (page Page) LeafSuffix(index uint16) []byte {
+	item := page.leafItem(index)
 	klen, klen_size := binary.Uvarint(item)
 	if klen_size <= 0 {
 		return nil
@@ -66,10 +95,47 @@ func (page Page) LeafKey(index uint16) []byte {
 	return item[klen_size : uint64(klen_size)+klen]
 }
 
+// LeafKey returns the key at the given index in a leaf page.
+// On a prefix-compressed leaf page the key is assembled in a new slice;
+// use AppendLeafKey to reuse a buffer instead.
+// Only call this method on leaf pages (when IsLeaf returns true).
+func (page Page) LeafKey(index uint16) []byte {
+	if page.HasPrefix() {
+		prefix, suffix := page.LeafPrefix(), page.LeafSuffix(index)
+		return append(append(make([]byte, 0, len(prefix)+len(suffix)), prefix...), suffix...)
+	}
+	return page.LeafSuffix(index)
+}
+
+// AppendLeafKey appends the key at the given index in a leaf page to dst
+// and returns the extended buffer.
+// Only call this method on leaf pages (when IsLeaf returns true).
+func (page Page) AppendLeafKey(dst []byte, index uint16) []byte {
+	return append(append(dst, page.LeafPrefix()...), page.LeafSuffix(index)...)
+}
+
+func (page Page) leafKeySize(index uint16) int {
+	return len(page.LeafPrefix()) + len(page.LeafSuffix(index))
+}
+
+// compareLeafKey compares key with the key at the given index in a leaf page
+// without assembling it.
+func compareLeafKey(key []byte, page Page, index uint16) int {
+	prefix := page.LeafPrefix()
+	n := min(len(key), len(prefix))
+	if cmp := bytes.Compare(key[:n], prefix[:n]); cmp != 0 {
+		return cmp
+	}
+	if len(key) < len(prefix) {
+		return -1
+	}
+	return bytes.Compare(key[n:], page.LeafSuffix(index))
+}
+
 // LeafVal returns the value at the given index in a leaf page.
 // Only call this method on leaf pages (when IsLeaf returns true).
 func (page Page) LeafVal(index uint16) []byte {
-	item := page.item(index)
+	item := page.leafItem(index)
 	klen, klen_size := binary.Uvarint(item)
 	if klen_size <= 0 {
 		return nil
@@ -78,11 +144,31 @@ func (page Page) LeafVal(index uint16) []byte {
 }
 
 // LeafItems returns an iterator for key-value pairs in the range [beg, end) of a leaf page.
+// Keys of a prefix-compressed leaf page are assembled in freshly allocated
+// memory, so every yielded key stays valid after the iteration.
 // Only call this method on leaf pages (when IsLeaf returns true).
 func (page Page) LeafItems(beg, end uint16) LeafItems {
 	return func(yield func([]byte, []byte) bool) {
+		if !page.HasPrefix() {
+			for i := beg; i < end; i++ {
+				if !yield(page.LeafSuffix(i), page.LeafVal(i)) {
+					return
+				}
+			}
+			return
+		}
+
+		prefix := page.LeafPrefix()
+		size := 0
 		for i := beg; i < end; i++ {
-			if !yield(page.LeafKey(i), page.LeafVal(i)) {
+			size += len(prefix) + len(page.LeafSuffix(i))
+		}
+		arena := make([]byte, 0, size)
+		for i := beg; i < end; i++ {
+			suffix := page.LeafSuffix(i)
+			off := len(arena)
+			arena = append(append(arena, prefix...), suffix...)
+			if !yield(arena[off:len(arena):len(arena)], page.LeafVal(i)) {
 				return
 			}
 		}
@@ -94,7 +180,10 @@ func leafItemSize(klen, vlen int) int {
 	return 2 + sizeUvarint(klen) + klen + vlen
 }
 
-func encodeLeafPage(buffer []byte, items LeafItems) (key []byte) {
+// encodeLeafPage encodes items into buffer and returns the first and last keys.
+// A positive prefix, which must not exceed the common prefix of all item keys,
+// selects the prefix-compressed variant.
+func encodeLeafPage(buffer []byte, items LeafItems, prefix int) (first, key []byte) {
 	// if len(buffer) > 65536 {
 	// 	panic(errors.New("buffer too large"))
 	// }
@@ -108,10 +197,24 @@ func encodeLeafPage(buffer []byte, items LeafItems) (key []byte) {
 
 	body := buffer[HeadSize:]
 	var offset, klen int
+	var head uint16
+	if prefix > 0 {
+		offset = 2
+		head = 0x8000
+	}
 	var item, val []byte
 	for key, val = range items {
+		if offset == 0 || prefix > 0 && offset == 2 {
+			first = key
+		}
+		if prefix > 0 && offset == 2 {
+			beg -= prefix
+			binary.LittleEndian.PutUint16(body, uint16(beg))
+			copy(body[beg:end], key[:prefix])
+			end = beg
+		}
 		offset += 2
-		klen = len(key)
+		klen = len(key) - prefix
 		beg -= sizeUvarint(klen) + klen + len(val)
 
 		if offset > beg {
@@ -122,18 +225,111 @@ func encodeLeafPage(buffer []byte, items LeafItems) (key []byte) {
 
 		item = body[beg:end]
 		item = item[binary.PutUvarint(item, uint64(klen)):]
-		copy(item, key)
+		copy(item, key[prefix:])
 		copy(item[klen:], val)
 		end = beg
 	}
 
+	if prefix > 0 {
+		offset -= 2
+	}
 	if offset == 0 {
 		panic(errors.New("empty leaf page"))
 	}
-	binary.LittleEndian.PutUint16(buffer, uint16(offset/2)) // count
+	binary.LittleEndian.PutUint16(buffer, uint16(offset/2)|head) // count
+	return
+}
+
+// sizer accumulates the size of a page item by item. For leaf pages of a
+// compact sizer it tracks the prefix shared by all keys seen so far, so the
+// size accounts for prefix compression whenever that is smaller than the
+// plain variant.
+type sizer struct {
+	first   []byte
+	prefix  int
+	count   int
+	plain   int
+	compact bool
+}
+
+// reset returns an empty sizer of the same kind.
+func (s sizer) reset() sizer {
+	return sizer{compact: s.compact}
+}
+
+// leaf returns the sizer with key and val appended, and the growth of the page size.
+func (s sizer) leaf(key, val []byte) (next sizer, size int) {
+	next = s
+	if next.compact {
+		if next.count == 0 {
+			next.first = key
+			next.prefix = len(key)
+		} else {
+			next.prefix = commonPrefix(next.first[:next.prefix], key)
+		}
+	}
+	next.count++
+	next.plain += leafItemSize(len(key), len(val))
+	size = next.size() - s.size()
+	return
+}
+
+// branch returns the sizer with a branch item appended, and the growth of the page size.
+func (s sizer) branch(key []byte) (next sizer, size int) {
+	next = s
+	next.count++
+	size = branchItemSize(len(key))
+	next.plain += size
+	return
+}
+
+// compress returns the prefix length to encode a leaf page with, or 0 for
+// the plain variant.
+func (s sizer) compress() int {
+	if (s.count-1)*s.prefix > 2 {
+		return s.prefix
+	}
+	return 0
+}
+
+// size returns an upper bound of the page size without HeadSize.
+func (s sizer) size() int {
+	if prefix := s.compress(); prefix > 0 {
+		// prefix slot, minus the prefix stored in every item
+		return s.plain + 2 + prefix - s.count*prefix
+	}
+	return s.plain
+}
+
+func commonPrefix(a, b []byte) (n int) {
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
 	return
 }
 
+// separator returns the shortest key s with last <= s < first, to be used as
+// the branch key of the page ending with last when the next page begins with
+// first. Both are leaf key representations, whose first keyInlineSize bytes
+// are the key itself; last is returned when no inline separator is found.
+func separator(last, first []byte, keyInlineSize int) []byte {
+	n := min(len(last), len(first), keyInlineSize)
+	c := commonPrefix(last[:n], first[:n])
+	if c == n || c+1 >= len(last) {
+		return last
+	}
+	if c+1 < len(first) {
+		return first[:c+1]
+	}
+	if last[c]+1 < first[c] {
+		sep := make([]byte, c+1)
+		copy(sep, last[:c])
+		sep[c] = last[c] + 1
+		return sep
+	}
+	return last
+}
+
 // BranchKey returns the key at the given index in a branch page.
 // Only call this method on branch pages (when IsLeaf returns false).
 func (page Page) BranchKey(index uint16) []byte {
@@ -163,7 +359,7 @@ func branchItemSize(klen int) int {
 	return 2 + 4 + klen
 }
 
-func encodeBranchPage(buffer []byte, items BranchItems) (key []byte) {
+func encodeBranchPage(buffer []byte, items BranchItems) (first, key []byte) {
 	// if len(buffer) > 65536 {
 	// 	panic(errors.New("buffer too large"))
 	// }
@@ -180,6 +376,9 @@ func encodeBranchPage(buffer []byte, items BranchItems) (key []byte) {
 	var item []byte
 	var blockID BlockID
 	for key, blockID = range items {
+		if offset == 0 {
+			first = key
+		}
 		offset += 2
 		beg -= 4 + len(key)
 
@@ -204,8 +403,9 @@ func encodeBranchPage(buffer []byte, items BranchItems) (key []byte) {
 
 type items[V BlockID | []byte] interface {
 	~func(func([]byte, V) bool)
-	ItemSize(func(int) bool)
-	encode([]byte) []byte
+	grow(s sizer, key []byte, val V) (sizer, int)
+	encode(buffer []byte, prefix int) (first, last []byte)
+	separator(last, first []byte, keyInlineSize int) []byte
 }
 
 // LeafItems is an iter for leaf page key-value pairs.
@@ -219,8 +419,16 @@ func (items LeafItems) ItemSize(yield func(int) bool) {
 	}
 }
 
-func (items LeafItems) encode(buffer []byte) (key []byte) {
-	return encodeLeafPage(buffer, items)
+func (items LeafItems) grow(s sizer, key []byte, val []byte) (sizer, int) {
+	return s.leaf(key, val)
+}
+
+func (items LeafItems) encode(buffer []byte, prefix int) (first, last []byte) {
+	return encodeLeafPage(buffer, items, prefix)
+}
+
+func (items LeafItems) separator(last, first []byte, keyInlineSize int) []byte {
+	return separator(last, first, keyInlineSize)
 }
 
 // BranchItems is an iter for branch page key-blockID pairs.
@@ -234,10 +442,20 @@ func (items BranchItems) ItemSize(yield func(int) bool) {
 	}
 }
 
-func (items BranchItems) encode(buffer []byte) (key []byte) {
+func (items BranchItems) grow(s sizer, key []byte, _ BlockID) (sizer, int) {
+	return s.branch(key)
+}
+
+func (items BranchItems) encode(buffer []byte, _ int) (first, last []byte) {
 	return encodeBranchPage(buffer, items)
 }
 
+// separator keeps branch keys as they are: a branch page only knows upper
+// bounds of its children, not the first key of the next one.
+func (items BranchItems) separator(last, _ []byte, _ int) []byte {
+	return last
+}
+
 func search(n uint16, f func(uint16) int) uint16 {
 	var i, j uint16 = 0, n
 	for i < j {
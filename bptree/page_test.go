@@ -5,9 +5,16 @@ package bptree
 
 import (
 	"bytes"
+	"fmt"
+	"maps"
 	"math"
 	"math/rand/v2"
+	"runtime"
+	"slices"
 	"testing"
+
+	"github.com/dacapoday/smol/block"
+	"github.com/dacapoday/smol/mem"
 )
 
 func TestLeafPageRoundTrip(t *testing.T) {
@@ -62,7 +69,7 @@ func TestLeafPageRoundTrip(t *testing.T) {
 	})
 
 	buffer := make([]byte, pageSize)
-	encodeLeafPage(buffer, items)
+	encodeLeafPage(buffer, items, 0)
 	page := Page(buffer)
 
 	if !page.IsLeaf() {
@@ -87,6 +94,241 @@ func TestLeafPageRoundTrip(t *testing.T) {
 	}
 }
 
+// TestPrefixLeafPageRoundTrip tests encoding and decoding a prefix-compressed leaf page.
+func TestPrefixLeafPageRoundTrip(t *testing.T) {
+	pageSize := 512 + rand.IntN(64*1024-511)
+
+	var keys, vals [][]byte
+	s := sizer{compact: true}
+	size := HeadSize
+	for i := 0; ; i++ {
+		key := fmt.Appendf(nil, "tenant:%04d:order:%06d", rand.IntN(2), i)
+		val := make([]byte, rand.IntN(32))
+		for j := range val {
+			val[j] = byte(rand.IntN(256))
+		}
+		next, itemSize := s.leaf(key, val)
+		if size+itemSize > pageSize {
+			break
+		}
+		s = next
+		size += itemSize
+		keys = append(keys, key)
+		vals = append(vals, val)
+	}
+	slices.SortFunc(keys, bytes.Compare)
+	prefix := s.compress()
+	t.Logf("pageSize=%d, itemCount=%d, size=%d, prefix=%d", pageSize, len(keys), size, prefix)
+	if prefix != len("tenant:000") {
+		t.Fatalf("compress() = %d, want %d", prefix, len("tenant:000"))
+	}
+
+	items := LeafItems(func(yield func([]byte, []byte) bool) {
+		for i := range keys {
+			if !yield(keys[i], vals[i]) {
+				return
+			}
+		}
+	})
+
+	buffer := make([]byte, size)
+	encodeLeafPage(buffer, items, prefix)
+	page := Page(buffer)
+
+	if !page.IsLeaf() || !page.HasPrefix() {
+		t.Fatal("page should be a prefix-compressed leaf page")
+	}
+	if page.Count() != uint16(len(keys)) {
+		t.Fatalf("expected %d items, got %d", len(keys), page.Count())
+	}
+	if !bytes.Equal(page.LeafPrefix(), keys[0][:prefix]) {
+		t.Fatalf("LeafPrefix() = %q, want %q", page.LeafPrefix(), keys[0][:prefix])
+	}
+
+	i := 0
+	for key, val := range page.LeafItems(0, page.Count()) {
+		if !bytes.Equal(key, keys[i]) || !bytes.Equal(val, vals[i]) {
+			t.Fatalf("item %d: got %q=%x, want %q=%x", i, key, val, keys[i], vals[i])
+		}
+		index := uint16(i)
+		if got := page.LeafKey(index); !bytes.Equal(got, keys[i]) {
+			t.Fatalf("LeafKey(%d) = %q, want %q", i, got, keys[i])
+		}
+		if got := page.AppendLeafKey([]byte("x"), index); !bytes.Equal(got[1:], keys[i]) {
+			t.Fatalf("AppendLeafKey(%d) = %q, want x%q", i, got, keys[i])
+		}
+		if got := page.leafKeySize(index); got != len(keys[i]) {
+			t.Fatalf("leafKeySize(%d) = %d, want %d", i, got, len(keys[i]))
+		}
+		for _, probe := range [][]byte{keys[i], keys[i][:prefix-1], keys[i][:prefix], append(bytes.Clone(keys[i]), 0), []byte("u")} {
+			if got, want := compareLeafKey(probe, page, index), bytes.Compare(probe, keys[i]); got != want {
+				t.Fatalf("compareLeafKey(%q, %d) = %d, want %d", probe, i, got, want)
+			}
+		}
+		i++
+	}
+	if i != len(keys) {
+		t.Fatalf("LeafItems yields %d items, want %d", i, len(keys))
+	}
+
+	// the writer assembles the keys of a page once, for all passes over it
+	src := source{Page: page}
+	i = 1
+	for key := range src.leafItems(1, page.Count()) {
+		if !bytes.Equal(key, keys[i]) {
+			t.Fatalf("leafItems item %d: got %q, want %q", i, key, keys[i])
+		}
+		i++
+	}
+	allocated := func(items func() LeafItems) uint64 {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		for range 10 {
+			for range items() {
+			}
+		}
+		runtime.ReadMemStats(&after)
+		return after.TotalAlloc - before.TotalAlloc
+	}
+	reused := allocated(func() LeafItems { return src.leafItems(0, page.Count()) })
+	fresh := allocated(func() LeafItems { return page.LeafItems(0, page.Count()) })
+	if reused*2 > fresh {
+		t.Fatalf("passes over reused keys allocate %d bytes, over fresh keys %d", reused, fresh)
+	}
+
+	var plain sizer
+	for i := range keys {
+		plain, _ = plain.leaf(keys[i], vals[i])
+	}
+	if plain.plain <= size-HeadSize {
+		t.Fatalf("plain size %d not larger than prefix-compressed size %d", plain.plain, size-HeadSize)
+	}
+
+	t.Log("✓ Prefix-compressed leaf page round trip")
+}
+
+// TestPrefixLeafWrite tests trees of prefix-compressed leaf pages through
+// inserts, updates and deletes, including keys that overflow, and that
+// pages are rewritten plain without Options.PrefixLeaves.
+func TestPrefixLeafWrite(t *testing.T) {
+	var f mem.File
+	var b block.Heap[*mem.File]
+	_, ckpt, err := b.Load(&f, diffOption{})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer ckpt.Release()
+	defer b.Close()
+
+	klen, vlen := InlineSize(b.PageSize(), 5, 1<<20, 1<<20)
+	long := bytes.Repeat([]byte("x"), 3*klen)
+
+	model := map[string][]byte{}
+	var high uint8
+	var root Page
+	write := func(opts Options, changes map[string][]byte) {
+		high, root, err = WriteSortedChangesOptions(&b, root, klen, vlen, high, opts, func(yield func([]byte, []byte) bool) {
+			for _, k := range slices.Sorted(maps.Keys(changes)) {
+				if !yield([]byte(k), changes[k]) {
+					return
+				}
+			}
+		})
+		if err != nil {
+			t.Fatalf("WriteSortedChangesOptions failed: %v", err)
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(model, k)
+			} else {
+				model[k] = v
+			}
+		}
+	}
+
+	changes := map[string][]byte{}
+	for i := range 6000 {
+		key := fmt.Sprintf("tenant:%04d:order:%06d", i/500, i)
+		if i%1000 == 7 {
+			key += string(long)
+		}
+		changes[key] = fmt.Appendf(nil, "v%d", i)
+	}
+	write(Options{PrefixLeaves: true}, changes)
+
+	changes = map[string][]byte{}
+	for k := range model {
+		switch n := len(changes); {
+		case n%5 == 0:
+			changes[k] = nil
+		case n%7 == 0:
+			changes[k] = []byte("updated")
+		default:
+			changes[k+"a"] = []byte("added")
+		}
+	}
+	write(Options{PrefixLeaves: true}, changes)
+
+	count := func() (leaves, prefixed int) {
+		err := Walk(&b, root, klen, vlen, func(_ BlockID, block []byte) error {
+			if page := Page(block); page.Count() > 0 && page.IsLeaf() {
+				leaves++
+				if page.HasPrefix() {
+					prefixed++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Walk failed: %v", err)
+		}
+		return
+	}
+	leaves, prefixed := count()
+	t.Logf("%d of %d leaf pages prefix-compressed, high=%d", prefixed, leaves, high)
+	if prefixed == 0 {
+		t.Fatal("no prefix-compressed leaf pages written")
+	}
+
+	var reader Reader[*block.Heap[*mem.File]]
+	reader.Load(&b, root, klen, vlen, high)
+	defer reader.Close()
+	keys := slices.Sorted(maps.Keys(model))
+	i := 0
+	for reader.SeekFirst(); reader.Valid(); reader.Next() {
+		if i >= len(keys) {
+			t.Fatalf("extra key %q", reader.Key())
+		}
+		if string(reader.Key()) != keys[i] || !bytes.Equal(reader.Val(), model[keys[i]]) {
+			t.Fatalf("item %d: got %q=%q, want %q=%q", i, reader.Key(), reader.Val(), keys[i], model[keys[i]])
+		}
+		i++
+	}
+	if err := reader.Error(); err != nil {
+		t.Fatalf("Reader error: %v", err)
+	}
+	if i != len(keys) {
+		t.Fatalf("iterated %d keys, want %d", i, len(keys))
+	}
+	for j := 0; j < len(keys); j += 97 {
+		key := []byte(keys[j])
+		if !reader.Seek(key) || !reader.Equal(key) {
+			t.Fatalf("Seek(%q) lands on %q", key, reader.Key())
+		}
+	}
+
+	changes = map[string][]byte{}
+	for k := range model {
+		changes[k] = []byte("plain")
+	}
+	write(Options{}, changes)
+	if leaves, prefixed := count(); prefixed != 0 {
+		t.Fatalf("%d of %d leaf pages prefix-compressed after a plain rewrite", prefixed, leaves)
+	}
+
+	t.Log("✓ Prefix-compressed leaf pages written, updated and read")
+}
+
 func TestBranchPageRoundTrip(t *testing.T) {
 	pageSize := 512 + rand.IntN(64*1024-511)
 	maxKeyOverflow := math.MaxInt64
@@ -4,8 +4,6 @@
 package bptree
 
 import (
-	"bytes"
-
 	"github.com/dacapoday/smol/overflow"
 )
 
@@ -16,6 +14,7 @@ type Reader[B ReadOnly] struct {
 	err           error
 	level         Level
 	page          Page   // buf
+	key           []byte // buf
 	val           []byte // buf
 	count         uint16
 	index         uint16
@@ -27,6 +26,16 @@ func (reader *Reader[B]) Block() B {
 	return reader.block
 }
 
+// Root returns the root page the reader was loaded with.
+func (reader *Reader[B]) Root() Page {
+	return reader.root
+}
+
+// KeyInlineSize returns the inline key size the reader was loaded with.
+func (reader *Reader[B]) KeyInlineSize() int {
+	return int(reader.keyInlineSize)
+}
+
 // Load initializes the reader with block and root page.
 // Positions reader before the first entry.
 func (reader *Reader[B]) Load(block B, root Page, keyInlineSize, valInlineSize int, high uint8) {
@@ -64,6 +73,7 @@ func (dst *Reader[B]) LoadFrom(src *Reader[B]) {
 	dst.err = src.err
 	dst.count = src.count
 	dst.index = src.index
+	dst.key = nil
 	dst.val = nil
 	if dst.err == nil {
 		dst.level = nil
@@ -85,7 +95,7 @@ func (dst *Reader[B]) LoadFrom(src *Reader[B]) {
 	if dst.err != null {
 		return
 	}
-	if err := dst.block.ReadBlock(dst.level[0].BlockID, dst.page, nil); err != nil {
+	if err := readBlock("bptree.Reader.LoadFrom", dst.block, dst.level[0].BlockID, dst.page, nil); err != nil {
 		dst.err = err
 	}
 }
@@ -102,6 +112,7 @@ func (reader *Reader[B]) Close() {
 	reader.level = nil
 	reader.root = nil
 	reader.page = nil
+	reader.key = nil
 	reader.val = nil
 	reader.count = 0
 	reader.index = 0
@@ -118,6 +129,25 @@ func (reader *Reader[B]) Level() Level {
 	return append(Level(nil), reader.level...)
 }
 
+// Leaf returns the block ID of the leaf page at the cursor, zero for a root
+// leaf, with the index of the cursor in it and its number of items.
+func (reader *Reader[B]) Leaf() (blockID BlockID, index, count uint16) {
+	if len(reader.level) != 0 {
+		blockID = reader.level[0].BlockID
+	}
+	return blockID, reader.index, reader.count
+}
+
+// NextLeaf advances to the first item of the next leaf page, skipping the
+// rest of the current one without reading its items.
+func (reader *Reader[B]) NextLeaf() bool {
+	if reader.err != null {
+		return false
+	}
+	reader.index = reader.count - 1
+	return reader.Next()
+}
+
 func (reader *Reader[B]) next() bool {
 	reader.index++
 	if reader.index < reader.count {
@@ -127,6 +157,16 @@ func (reader *Reader[B]) next() bool {
 	return false
 }
 
+// leafKey returns the inline key at the cursor, assembled in the key buffer
+// on a prefix-compressed leaf page.
+func (reader *Reader[B]) leafKey() []byte {
+	if !reader.page.HasPrefix() {
+		return reader.page.LeafKey(reader.index)
+	}
+	reader.key = reader.page.AppendLeafKey(reader.key[:0], reader.index)
+	return reader.key
+}
+
 func (reader *Reader[B]) prev() bool {
 	if reader.index == 0 {
 		return false
@@ -140,16 +180,16 @@ func (reader *Reader[B]) Equal(key []byte) bool {
 		return false
 	}
 
-	currentKey := reader.page.LeafKey(reader.index)
 	keyInlineSize := int(reader.keyInlineSize)
-	if len(currentKey) <= keyInlineSize {
-		return bytes.Equal(key, currentKey)
+	if reader.page.leafKeySize(reader.index) <= keyInlineSize {
+		return compareLeafKey(key, reader.page, reader.index) == 0
 	}
+	currentKey := reader.leafKey()
 
 	head, overflowSize, overflowID := Overflow(currentKey, keyInlineSize)
 	cmp, err := overflow.Compare(reader.block, key, head, overflowSize, overflowID)
 	if err != nil {
-		reader.err = err
+		reader.err = errKey("bptree.Reader.Equal", currentKey, keyInlineSize, err)
 		return false
 	}
 
@@ -171,7 +211,7 @@ func (reader *Reader[B]) InlineKey() (key []byte) {
 	if reader.err != null {
 		return
 	}
-	key = reader.page.LeafKey(reader.index)
+	key = reader.leafKey()
 	return
 }
 
@@ -198,14 +238,14 @@ func (reader *Reader[B]) KeyCopy(buf []byte) (key []byte) {
 	if reader.err != null {
 		return
 	}
-	k := reader.page.LeafKey(reader.index)
+	k := reader.leafKey()
 	keyInlineSize := int(reader.keyInlineSize)
 	if len(k) > keyInlineSize {
 		head, overflowSize, overflowID := Overflow(k, keyInlineSize)
 		var err error
 		key, err = overflow.Read(reader.block, buf, head, overflowSize, overflowID)
 		if err != nil {
-			reader.err = err
+			reader.err = errKey("bptree.Reader.KeyCopy", k, keyInlineSize, err)
 		}
 		return
 	}
@@ -230,7 +270,7 @@ func (reader *Reader[B]) ValCopy(buf []byte) (val []byte) {
 		var err error
 		val, err = overflow.Read(reader.block, buf, head, overflowSize, overflowID)
 		if err != nil {
-			reader.err = err
+			reader.err = errKey("bptree.Reader.ValCopy", reader.leafKey(), int(reader.keyInlineSize), err)
 		}
 		return
 	}
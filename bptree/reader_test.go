@@ -5,6 +5,8 @@ package bptree
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/dacapoday/smol/block"
@@ -45,7 +47,7 @@ func TestReaderBasic(t *testing.T) {
 	leafItems := newMockLeafItems(itemCount, keyLen, valLen)
 
 	// Write root using writeRoot
-	high, rootPage, err := writeRoot(blk, 0, leafItems)
+	high, rootPage, err := writeRoot(blk, maxKeyInlineSize(blk.PageSize()), Options{}, 0, leafItems)
 	if err != nil {
 		t.Fatalf("writeRoot failed: %v", err)
 	}
@@ -130,3 +132,221 @@ func TestReaderEmptyTree(t *testing.T) {
 		t.Fatal("Reader should not be valid on empty tree")
 	}
 }
+
+// TestReaderKeyError tests that a corrupt overflow value is reported
+// with its key and block, and still matches ErrBadChecksum.
+func TestReaderKeyError(t *testing.T) {
+	tree := newFillTree(t, Fill{})
+	tree.write(map[string][]byte{
+		"big":   bytes.Repeat([]byte("v"), 3*tree.b.PageSize()),
+		"small": []byte("v"),
+	})
+
+	var reader Reader[*block.Heap[*mem.File]]
+	reader.Load(&tree.b, tree.root, tree.klen, tree.vlen, tree.high)
+	defer reader.Close()
+	if !reader.Seek([]byte("big")) {
+		t.Fatalf("Seek failed: %v", reader.Error())
+	}
+	_, _, blockID := Overflow(reader.InlineVal(), tree.vlen)
+	if _, err := tree.f.WriteAt([]byte{0xff, 0xff}, int64(blockID)*int64(tree.b.BlockSize())+8); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+
+	if val := reader.Val(); val != nil {
+		t.Fatalf("Val = %d bytes from a corrupt block", len(val))
+	}
+	err := reader.Error()
+	var keyErr *KeyError
+	if !errors.As(err, &keyErr) || string(keyErr.Key) != "big" {
+		t.Fatalf("Error() = %v, want a KeyError for \"big\"", err)
+	}
+	var blockErr *BlockError
+	if !errors.As(err, &blockErr) || blockErr.BlockID != blockID {
+		t.Fatalf("Error() = %v, want a BlockError for block %d", err, blockID)
+	}
+	if !errors.Is(err, ErrBadChecksum) {
+		t.Fatalf("Error() = %v, want ErrBadChecksum", err)
+	}
+	t.Logf("✓ %v", err)
+}
+
+// TestReaderSeekLE tests SeekLE against the keys of a multi-level tree,
+// probing keys equal to, between, before and after them, on readers of
+// known and unknown height.
+func TestReaderSeekLE(t *testing.T) {
+	var f mem.File
+	var b block.Heap[*mem.File]
+	blk := &b
+	_, ckpt, err := blk.Load(&f, option{})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer ckpt.Release()
+	defer blk.Close()
+
+	// keys are the even numbers, so odd probes fall between them
+	key := func(i int) []byte { return fmt.Appendf(nil, "%08d", i) }
+	for _, count := range []int{5, 3000} {
+		items := LeafItems(func(yield func([]byte, []byte) bool) {
+			for i := range count {
+				if !yield(key(2*i), []byte("v")) {
+					return
+				}
+			}
+		})
+		high, rootPage, err := writeRoot(blk, maxKeyInlineSize(blk.PageSize()), Options{}, 0, items)
+		if err != nil {
+			t.Fatalf("writeRoot failed: %v", err)
+		}
+		checkSeekLE(t, blk, rootPage, high, count, key)
+	}
+}
+
+func checkSeekLE(t *testing.T, blk *block.Heap[*mem.File], rootPage Page, high uint8, count int, key func(int) []byte) {
+	t.Helper()
+	for _, h := range []uint8{high, 0} {
+		var reader Reader[*block.Heap[*mem.File]]
+		reader.Load(blk, rootPage, 1000, 1000, h)
+		for p := -1; p <= 2*count; p++ {
+			probes := [][]byte{key(p), append(key(p), 'x')}
+			if p < 0 {
+				probes = [][]byte{{}, []byte("0")}
+			}
+			for _, probe := range probes {
+				want := min(p, 2*count-2) &^ 1
+				ok := reader.SeekLE(probe)
+				if p < 0 {
+					if ok || reader.Error() != nil {
+						t.Fatalf("SeekLE(%q) = %q, %v", probe, reader.Key(), reader.Error())
+					}
+					continue
+				}
+				if !ok || !bytes.Equal(reader.Key(), key(want)) {
+					t.Fatalf("high %d: SeekLE(%q) = %q, %v, want %q", h, probe, reader.Key(), reader.Error(), key(want))
+				}
+			}
+		}
+		// the position is that of a Seek: iteration goes on from it
+		if !reader.SeekLE(key(5)) || !reader.Next() || !bytes.Equal(reader.Key(), key(6)) {
+			t.Fatalf("Next after SeekLE = %q", reader.Key())
+		}
+		if !reader.SeekLE(key(5)) || !reader.Prev() || !bytes.Equal(reader.Key(), key(2)) {
+			t.Fatalf("Prev after SeekLE = %q", reader.Key())
+		}
+		reader.Close()
+	}
+}
+
+// TestReaderNextLeaf tests that NextLeaf visits each leaf once, its counts
+// adding up to the number of items, and that Seek lands in the leaf Leaf
+// reports.
+func TestReaderNextLeaf(t *testing.T) {
+	var f mem.File
+	var b block.Heap[*mem.File]
+	blk := &b
+	_, ckpt, err := blk.Load(&f, option{})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer ckpt.Release()
+	defer blk.Close()
+
+	const count = 3000
+	high, rootPage, err := writeRoot(blk, maxKeyInlineSize(blk.PageSize()), Options{}, 0, newMockLeafItems(count, 16, 8))
+	if err != nil {
+		t.Fatalf("writeRoot failed: %v", err)
+	}
+	var reader Reader[*block.Heap[*mem.File]]
+	reader.Load(blk, rootPage, 1000, 1000, high)
+	defer reader.Close()
+
+	leaves := map[BlockID]int{}
+	items := 0
+	for ok := reader.SeekFirst(); ok; ok = reader.NextLeaf() {
+		blockID, index, n := reader.Leaf()
+		if _, seen := leaves[blockID]; seen || index != 0 {
+			t.Fatalf("leaf %d visited again at %d", blockID, index)
+		}
+		leaves[blockID] = items
+		items += int(n)
+	}
+	if err := reader.Error(); err != nil || items != count || len(leaves) < 2 {
+		t.Fatalf("%d items in %d leaves, %v", items, len(leaves), err)
+	}
+
+	key := fmt.Sprintf("%016d", 1234)
+	if !reader.Seek([]byte(key)) {
+		t.Fatalf("Seek failed: %v", reader.Error())
+	}
+	if blockID, index, _ := reader.Leaf(); leaves[blockID]+int(index) != 1234 {
+		t.Fatalf("Seek lands at %d in leaf %d", index, blockID)
+	}
+	t.Logf("✓ %d items in %d leaves", items, len(leaves))
+}
+
+// TestReaderBlockError tests that a corrupt leaf is reported with the
+// operation and block that read it, and still matches ErrBadChecksum.
+func TestReaderBlockError(t *testing.T) {
+	tree := newFillTree(t, Fill{})
+	changes := map[string][]byte{}
+	for i := range 2000 {
+		changes[fmt.Sprintf("key%05d", i)] = []byte("val")
+	}
+	tree.write(changes)
+
+	var reader Reader[*block.Heap[*mem.File]]
+	reader.Load(&tree.b, tree.root, tree.klen, tree.vlen, tree.high)
+	defer reader.Close()
+	if tree.high == 0 || !reader.Seek([]byte("key01000")) {
+		t.Fatalf("Seek failed at height %d: %v", tree.high, reader.Error())
+	}
+	blockID, _, _ := reader.Leaf()
+	if _, err := tree.f.WriteAt([]byte{0xff, 0xff}, int64(blockID)*int64(tree.b.BlockSize())+8); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+
+	reader.Load(&tree.b, tree.root, tree.klen, tree.vlen, tree.high)
+	if reader.Seek([]byte("key01000")) {
+		t.Fatal("Seek succeeded on a corrupt leaf")
+	}
+	err := reader.Error()
+	var blockErr *BlockError
+	if !errors.As(err, &blockErr) || blockErr.Op != "bptree.Reader.Seek" || blockErr.BlockID != blockID {
+		t.Fatalf("Error() = %v, want a bptree.Reader.Seek BlockError for block %d", err, blockID)
+	}
+	if !errors.Is(err, ErrBadChecksum) {
+		t.Fatalf("Error() = %v, want ErrBadChecksum", err)
+	}
+	t.Logf("✓ %v", err)
+}
+
+// noSpace is a block store failing every allocation.
+type noSpace struct {
+	*block.Heap[*mem.File]
+}
+
+func (noSpace) AllocateBlock() BlockID {
+	return 0
+}
+
+// TestAllocateError tests that a failed allocation is reported with the
+// first key of the page to be written, and matches ErrAllocateFailed.
+func TestAllocateError(t *testing.T) {
+	tree := newFillTree(t, Fill{})
+	_, _, err := WriteSortedChanges(noSpace{&tree.b}, nil, tree.klen, tree.vlen, 0, func(yield func([]byte, []byte) bool) {
+		for i := range 2000 {
+			if !yield(fmt.Appendf(nil, "key%05d", i), []byte("val")) {
+				return
+			}
+		}
+	})
+	var keyErr *KeyError
+	if !errors.As(err, &keyErr) || string(keyErr.Key) != "key00000" {
+		t.Fatalf("WriteSortedChanges = %v, want a KeyError for key00000", err)
+	}
+	if !errors.Is(err, ErrAllocateFailed) {
+		t.Fatalf("WriteSortedChanges = %v, want ErrAllocateFailed", err)
+	}
+	t.Logf("✓ %v", err)
+}
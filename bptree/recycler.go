@@ -4,22 +4,38 @@
 package bptree
 
 import (
+	"context"
+
 	"github.com/dacapoday/smol/overflow"
 )
 
 // Recycle releases all blocks used by the B+ tree.
 func Recycle[B ReadWrite](block B, root Page, keyInlineSize, valInlineSize int) (err error) {
+	return RecycleContext(context.Background(), block, root, keyInlineSize, valInlineSize)
+}
+
+// RecycleContext is Recycle, stopping with the context's error once ctx
+// is done. Blocks already released stay released: the caller must roll
+// back the block storage, as block.Heap.Rollback does.
+func RecycleContext[B ReadWrite](ctx context.Context, block B, root Page, keyInlineSize, valInlineSize int) (err error) {
 	task := new(task)
-	recycle(block, task, root, keyInlineSize, valInlineSize)
-	return task.wait()
+	recycle(ctx, block, task, root, keyInlineSize, valInlineSize)
+	err = task.wait()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+	}
+	return
 }
 
-func recycle[B ReadWrite](block B, task *task, page Page, keyInlineSize, valInlineSize int) {
+func recycle[B ReadWrite](ctx context.Context, block B, task *task, page Page, keyInlineSize, valInlineSize int) {
+	if ctx.Err() != nil {
+		return
+	}
 	count := page.Count()
 	if page.IsLeaf() {
 		for i := range count {
-			if key := page.LeafKey(i); len(key) > keyInlineSize {
-				overflowID := overflowID(key)
+			if page.leafKeySize(i) > keyInlineSize {
+				overflowID := overflowID(page.LeafKey(i))
 				task.run(func() error {
 					return overflow.Recycle(block, overflowID)
 				})
@@ -35,17 +51,17 @@ func recycle[B ReadWrite](block B, task *task, page Page, keyInlineSize, valInli
 		for i := range count {
 			blockID := page.BranchID(i)
 			task.run(func() error {
-				return recycleBlock(block, task, blockID, keyInlineSize, valInlineSize)
+				return recycleBlock(ctx, block, task, blockID, keyInlineSize, valInlineSize)
 			})
 			block.RecycleBlock(blockID)
 		}
 	}
 }
 
-func recycleBlock[B ReadWrite](block B, task *task, blockID BlockID, keyInlineSize, valInlineSize int) (err error) {
+func recycleBlock[B ReadWrite](ctx context.Context, block B, task *task, blockID BlockID, keyInlineSize, valInlineSize int) (err error) {
 	buffer := block.AllocateBuffer()
-	err = block.ReadBlock(blockID, buffer, func(buffer []byte) {
-		recycle(block, task, Page(buffer), keyInlineSize, valInlineSize)
+	err = readBlock("bptree.Recycle", block, blockID, buffer, func(buffer []byte) {
+		recycle(ctx, block, task, Page(buffer), keyInlineSize, valInlineSize)
 	})
 	block.RecycleBuffer(buffer)
 	return
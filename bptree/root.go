@@ -3,10 +3,13 @@
 
 package bptree
 
-import "iter"
+import (
+	"bytes"
+	"iter"
+)
 
-func writeRoot[B ReadWrite, V BlockID | []byte, Items items[V]](block B, h uint8, items Items) (high uint8, root Page, err error) {
-	root, branch, err := writeRootPage(block, items)
+func writeRoot[B ReadWrite, V BlockID | []byte, Items items[V]](block B, keyInlineSize int, opts Options, h uint8, items Items) (high uint8, root Page, err error) {
+	root, branch, err := writeRootPage(block, keyInlineSize, opts, items)
 	if err != nil {
 		return
 	}
@@ -14,7 +17,7 @@ func writeRoot[B ReadWrite, V BlockID | []byte, Items items[V]](block B, h uint8
 		return
 	}
 	for high = h; branch != nil; high++ {
-		root, branch, err = writeRootPage(block, branch)
+		root, branch, err = writeRootPage(block, keyInlineSize, opts, branch)
 		if err != nil {
 			return
 		}
@@ -22,27 +25,60 @@ func writeRoot[B ReadWrite, V BlockID | []byte, Items items[V]](block B, h uint8
 	return
 }
 
-func writeRootPage[B ReadWrite, V BlockID | []byte, Items items[V]](block B, items Items) (root Page, branch BranchItems, err error) {
-	next, stop := layout(items, block.PageSize())
+// Push writes root to a block of its own and returns a root page holding
+// a single branch item over it, so a known height grows by one. It bounds
+// the size of a root page kept inline next to other data.
+func Push[B ReadWrite](block B, root Page, keyInlineSize int) (newRoot Page, err error) {
+	count := root.Count()
+	if count == 0 {
+		return root, nil
+	}
+	var key []byte
+	if root.IsLeaf() {
+		key = root.AppendLeafKey(nil, count-1)
+	} else {
+		key = bytes.Clone(root.BranchKey(count - 1))
+	}
+
+	blockID := block.AllocateBlock()
+	if blockID < 2 {
+		err = errAllocateFailed("bptree.Push", block, key, keyInlineSize)
+		return
+	}
+	buffer := block.AllocateBuffer()
+	defer block.RecycleBuffer(buffer)
+	clear(buffer[copy(buffer, root[:root.Size()]):])
+	if err = block.WriteBlock(blockID, buffer); err != nil {
+		return
+	}
+
+	newRoot, _, err = writeRootPage(block, keyInlineSize, Options{}, BranchItems(func(yield func([]byte, BlockID) bool) {
+		yield(key, blockID)
+	}))
+	return
+}
+
+func writeRootPage[B ReadWrite, V BlockID | []byte, Items items[V]](block B, keyInlineSize int, opts Options, items Items) (root Page, branch BranchItems, err error) {
+	next, stop := layout(items, block.PageSize(), opts.PrefixLeaves)
 	defer stop()
-	size, items, last := next()
+	size, prefix, items, last := next()
 	if size <= HeadSize {
 		return
 	}
 	if last {
 		root = make([]byte, size)
-		items.encode(root)
+		items.encode(root, prefix)
 		return
 	}
 
 	buffer := block.AllocateBuffer()
 	defer block.RecycleBuffer(buffer)
 
-	key := items.encode(buffer[:size])
+	first, key := items.encode(buffer[:size], prefix)
 
 	blockID := block.AllocateBlock()
 	if blockID < 2 {
-		err = errAllocateFailed(block)
+		err = errAllocateFailed("bptree.WriteSortedChanges", block, first, keyInlineSize)
 		return
 	}
 
@@ -56,12 +92,12 @@ func writeRootPage[B ReadWrite, V BlockID | []byte, Items items[V]](block B, ite
 	item.key = b2s(key)
 	item.id = blockID
 	for {
-		size, items, last = next()
-		key = items.encode(buffer[:size])
+		size, prefix, items, last = next()
+		first, key := items.encode(buffer[:size], prefix)
 
 		blockID = block.AllocateBlock()
 		if blockID < 2 {
-			err = errAllocateFailed(block)
+			err = errAllocateFailed("bptree.WriteSortedChanges", block, first, keyInlineSize)
 			return
 		}
 
@@ -70,6 +106,9 @@ func writeRootPage[B ReadWrite, V BlockID | []byte, Items items[V]](block B, ite
 			return
 		}
 
+		if opts.ShortSeparators {
+			item.key = b2s(items.separator(s2b(item.key), first, keyInlineSize))
+		}
 		item = item.extend()
 		item.key = b2s(key)
 		item.id = blockID
@@ -80,33 +119,45 @@ func writeRootPage[B ReadWrite, V BlockID | []byte, Items items[V]](block B, ite
 	}
 }
 
-func layout[V BlockID | []byte, Items items[V]](items Items, pageSize int) (func() (int, Items, bool), func()) {
-	next, stopPager := pager(items.ItemSize, pageSize)
+// layout splits items into pages, returning for each page its size,
+// the prefix length to encode it with, its items and whether it is the last.
+// Leaf pages are prefix-compressed only if prefix is set.
+func layout[V BlockID | []byte, Items items[V]](items Items, pageSize int, prefix bool) (func() (int, int, Items, bool), func()) {
+	next, stopPager := pager(items, pageSize, prefix)
 	chunk, stopGroup := group(items)
-	return func() (int, Items, bool) {
-		size, count, last := next()
-		return size, chunk(count), last
+	return func() (int, int, Items, bool) {
+		size, prefix, count, last := next()
+		return size, prefix, chunk(count), last
 	}, func() { stopPager(); stopGroup() }
 }
 
-func pager(items func(yield func(int) bool), pageSize int) (func() (int, uint16, bool), func()) {
-	next, stop := iter.Pull(items)
-	var itemSize int
-	return func() (size int, count uint16, last bool) {
-		if itemSize != 0 {
+func pager[V BlockID | []byte, Items items[V]](items Items, pageSize int, compact bool) (func() (int, int, uint16, bool), func()) {
+	next, stop := iter.Pull2(iter.Seq2[[]byte, V](items))
+	var key []byte
+	var val V
+	var pending bool
+	return func() (size int, prefix int, count uint16, last bool) {
+		s := sizer{compact: compact}
+		size = HeadSize
+		if pending {
+			var itemSize int
+			s, itemSize = items.grow(s, key, val)
+			size += itemSize
 			count++
 		}
-		size = HeadSize + itemSize
-		var ok bool
 		for {
-			itemSize, ok = next()
-			if !ok {
+			key, val, pending = next()
+			if !pending {
 				last = true
+				prefix = s.compress()
 				return
 			}
+			grown, itemSize := items.grow(s, key, val)
 			if size+itemSize > pageSize {
+				prefix = s.compress()
 				return
 			}
+			s = grown
 			size += itemSize
 			count++
 		}
@@ -10,10 +10,10 @@ import (
 
 func TestLayout_LeafItems_Empty(t *testing.T) {
 	items := newMockLeafItems(0, 5, 10)
-	next, stop := layout(items, 1024)
+	next, stop := layout(items, 1024, false)
 	defer stop()
 
-	size, resultItems, last := next()
+	size, _, resultItems, last := next()
 
 	// Empty items should return single empty result with HeadSize
 	if !last {
@@ -59,7 +59,7 @@ func TestLayout_LeafItems_Various(t *testing.T) {
 			}
 
 			// Apply layout
-			next, stop := layout(items, tc.pageSize)
+			next, stop := layout(items, tc.pageSize, true)
 			defer stop()
 
 			// Collect all items from all pages in order
@@ -67,7 +67,7 @@ func TestLayout_LeafItems_Various(t *testing.T) {
 			pageNum := 0
 
 			for {
-				size, resultItems, last := next()
+				size, prefix, resultItems, last := next()
 				pageNum++
 
 				// Verify page size doesn't exceed limit
@@ -78,11 +78,22 @@ func TestLayout_LeafItems_Various(t *testing.T) {
 				// Verify page size calculation
 				expectedSize := HeadSize
 				pageItemCount := 0
+				var firstKey []byte
 				for key, val := range resultItems {
 					expectedSize += leafItemSize(len(key), len(val))
+					if pageItemCount == 0 {
+						firstKey = key
+					}
+					if !bytes.HasPrefix(key, firstKey[:prefix]) {
+						t.Errorf("Page %d: key %q lacks prefix %q", pageNum, key, firstKey[:prefix])
+					}
 					pageItemCount++
 					allResultKVs = append(allResultKVs, struct{ key, val []byte }{key, val})
 				}
+				if prefix > 0 {
+					// the prefix is stored once in its own slot instead of in every item
+					expectedSize += 2 + prefix - pageItemCount*prefix
+				}
 
 				if size != expectedSize {
 					t.Errorf("Page %d: expected size=%d, got: %d", pageNum, expectedSize, size)
@@ -136,10 +147,10 @@ func TestLayout_LeafItems_Various(t *testing.T) {
 
 func TestLayout_BranchItems_Empty(t *testing.T) {
 	items := newMockBranchItems(0, 5, 100)
-	next, stop := layout(items, 1024)
+	next, stop := layout(items, 1024, false)
 	defer stop()
 
-	size, resultItems, last := next()
+	size, _, resultItems, last := next()
 
 	// Empty items should return single empty result with HeadSize
 	if !last {
@@ -191,7 +202,7 @@ func TestLayout_BranchItems_Various(t *testing.T) {
 			}
 
 			// Apply layout
-			next, stop := layout(items, tc.pageSize)
+			next, stop := layout(items, tc.pageSize, false)
 			defer stop()
 
 			// Collect all items from all pages in order
@@ -202,7 +213,7 @@ func TestLayout_BranchItems_Various(t *testing.T) {
 			pageNum := 0
 
 			for {
-				size, resultItems, last := next()
+				size, _, resultItems, last := next()
 				pageNum++
 
 				// Verify page size doesn't exceed limit
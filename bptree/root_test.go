@@ -4,6 +4,7 @@
 package bptree
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/dacapoday/smol/block"
@@ -26,7 +27,7 @@ func TestWriteRootPageSinglePage(t *testing.T) {
 	items := newMockLeafItems(5, 8, 16)
 
 	// Call writeRootPage
-	root, branch, err := writeRootPage(&b, items)
+	root, branch, err := writeRootPage(&b, maxKeyInlineSize(b.PageSize()), Options{}, items)
 	if err != nil {
 		t.Fatalf("writeRootPage failed: %v", err)
 	}
@@ -67,7 +68,7 @@ func TestWriteRootPageMultiplePages(t *testing.T) {
 	items := newMockLeafItems(20, 16, 36) // assume page size is 512
 
 	// Call writeRootPage
-	root, branch, err := writeRootPage(&b, items)
+	root, branch, err := writeRootPage(&b, maxKeyInlineSize(b.PageSize()), Options{}, items)
 	if err != nil {
 		t.Fatalf("writeRootPage failed: %v", err)
 	}
@@ -107,7 +108,7 @@ func TestWriteRootPageEmpty(t *testing.T) {
 	items := newMockLeafItems(0, 8, 16)
 
 	// Call writeRootPage
-	root, branch, err := writeRootPage(&b, items)
+	root, branch, err := writeRootPage(&b, maxKeyInlineSize(b.PageSize()), Options{}, items)
 	if err != nil {
 		t.Fatalf("writeRootPage failed: %v", err)
 	}
@@ -120,3 +121,42 @@ func TestWriteRootPageEmpty(t *testing.T) {
 		t.Error("Expected nil branch for empty items")
 	}
 }
+
+// TestPush tests that a pushed-down root reads and writes like the original.
+func TestPush(t *testing.T) {
+	for _, count := range []int{10, 3000} {
+		tree := newFillTree(t, Fill{})
+		changes := map[string][]byte{}
+		for i := range count {
+			changes[fmt.Sprintf("key:%06d", i*2)] = []byte("value")
+		}
+		tree.write(changes)
+		leaves := tree.leaves()
+
+		root, err := Push(&tree.b, tree.root, tree.klen)
+		if err != nil {
+			t.Fatalf("Push failed: %v", err)
+		}
+		if root.IsLeaf() || root.Count() != 1 || len(root) >= len(tree.root) && count > 10 {
+			t.Fatalf("Push returned a %d-byte root of %d items", len(root), root.Count())
+		}
+		tree.root = root
+		tree.high++
+		if got := tree.leaves(); got != leaves {
+			t.Fatalf("pushed tree has %d leaf pages, want %d", got, leaves)
+		}
+
+		changes = map[string][]byte{}
+		for i := range count {
+			changes[fmt.Sprintf("key:%06d", i*2+1)] = []byte("inserted")
+			if i%3 == 0 {
+				changes[fmt.Sprintf("key:%06d", i*2)] = nil
+			}
+		}
+		changes["key:999999"] = []byte("last")
+		tree.write(changes)
+		tree.leaves()
+	}
+
+	t.Log("✓ Pushed roots read and write")
+}
@@ -37,7 +37,7 @@ func (reader *Reader[B]) seekFirst() bool {
 	}
 	page = reader.page
 	for blockID > 1 {
-		reader.err = reader.block.ReadBlock(blockID, page, seekFirst)
+		reader.err = readBlock("bptree.Reader.SeekFirst", reader.block, blockID, page, seekFirst)
 		if reader.err != nil {
 			return false
 		}
@@ -79,7 +79,7 @@ func (reader *Reader[B]) seekLast() bool {
 	}
 	page = reader.page
 	for blockID > 1 {
-		reader.err = reader.block.ReadBlock(blockID, page, seekLast)
+		reader.err = readBlock("bptree.Reader.SeekLast", reader.block, blockID, page, seekLast)
 		if reader.err != nil {
 			return false
 		}
@@ -89,12 +89,9 @@ func (reader *Reader[B]) seekLast() bool {
 	return true
 }
 
-func (reader *Reader[B]) seek(key []byte) bool {
-	cursor := cursor[B]{
-		Reader:        reader,
-		key:           key,
-		keyInlineSize: int(reader.keyInlineSize),
-	}
+// seek is descend on the first use of a reader of unknown height,
+// building its level path on the way down.
+func (reader *Reader[B]) seek(cursor *cursor[B]) bool {
 	page := reader.root
 	count := page.Count()
 	index := cursor.searchBranch(count, page)
@@ -118,7 +115,7 @@ func (reader *Reader[B]) seek(key []byte) bool {
 			if &page[0] != &reader.page[0] {
 				copy(reader.page, page)
 			}
-			index := cursor.searchLeaf(count-1, page)
+			index := cursor.searchLeaf(count, page)
 			reader.count = count
 			reader.index = index
 			reader.level[0].BlockID = blockID
@@ -135,7 +132,7 @@ func (reader *Reader[B]) seek(key []byte) bool {
 	}
 	page = reader.page
 	for blockID > 1 {
-		reader.err = reader.block.ReadBlock(blockID, page, seek)
+		reader.err = readBlock("bptree.Reader.Seek", reader.block, blockID, page, seek)
 		if reader.err != nil {
 			return false
 		}
@@ -166,11 +163,10 @@ func (cursor *cursor[B]) searchLeaf(count uint16, page Page) (index uint16) {
 }
 
 func (cursor *cursor[B]) leaf(i uint16) int {
-	inlineKey := cursor.page.LeafKey(i)
-	if len(inlineKey) <= cursor.keyInlineSize {
-		return bytes.Compare(cursor.key, inlineKey)
+	if cursor.page.leafKeySize(i) <= cursor.keyInlineSize {
+		return compareLeafKey(cursor.key, cursor.page, i)
 	}
-	return cursor.compare(inlineKey)
+	return cursor.compare(cursor.page.LeafKey(i))
 }
 
 func (cursor *cursor[B]) searchBranch(count uint16, page Page) (index uint16) {
@@ -195,7 +191,7 @@ func (cursor *cursor[B]) compare(inlineKey []byte) int {
 	head, overflowSize, overflowID := Overflow(inlineKey, cursor.keyInlineSize)
 	cmp, err := overflow.Compare(cursor.block, cursor.key, head, overflowSize, overflowID)
 	if err != nil {
-		cursor.err = err
+		cursor.err = errKey("bptree.Seek", inlineKey, cursor.keyInlineSize, err)
 		return 0
 	}
 	return cmp
@@ -0,0 +1,188 @@
+// Copyright 2025 dacapoday
+// SPDX-License-Identifier: Apache-2.0
+
+package bptree
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/dacapoday/smol/block"
+	"github.com/dacapoday/smol/mem"
+)
+
+// TestSeparator tests the shortest separator between adjacent leaf pages.
+func TestSeparator(t *testing.T) {
+	tests := []struct {
+		last, first string
+		inline      int
+		want        string
+	}{
+		{"apple", "banana", 16, "b"},
+		{"tenant:1:order:0099", "tenant:1:order:0100", 32, "tenant:1:order:01"},
+		{"ab", "abc", 16, "ab"},
+		{"abc", "abd", 16, "abc"},
+		{"abc", "ad", 16, "ac"},
+		{"ab", "ad", 16, "ab"},
+		{"abx", "ad", 16, "ac"},
+		{"abx", "ac", 16, "abx"},
+		{"prefix-long-a", "prefix-long-b", 8, "prefix-long-a"},
+	}
+	for _, tt := range tests {
+		got := separator([]byte(tt.last), []byte(tt.first), tt.inline)
+		if string(got) != tt.want {
+			t.Fatalf("separator(%q, %q, %d) = %q, want %q", tt.last, tt.first, tt.inline, got, tt.want)
+		}
+		if bytes.Compare(got, []byte(tt.last)) < 0 || bytes.Compare(got, []byte(tt.first)) >= 0 {
+			t.Fatalf("separator(%q, %q) = %q does not separate", tt.last, tt.first, got)
+		}
+	}
+
+	t.Log("✓ Separators are short and between adjacent keys")
+}
+
+// TestSeparatorWrite tests trees of long keys, whose separators mostly stay
+// inline with Options.ShortSeparators, including writes and seeks between a
+// leaf's last key and its separator. Only the last page written in a run
+// keeps its full key as the separator.
+func TestSeparatorWrite(t *testing.T) {
+	var f mem.File
+	var b block.Heap[*mem.File]
+	_, ckpt, err := b.Load(&f, diffOption{})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer ckpt.Release()
+	defer b.Close()
+
+	klen, vlen := InlineSize(b.PageSize(), 5, 1<<20, 1<<20)
+
+	model := map[string][]byte{}
+	var high uint8
+	var root Page
+	write := func(changes map[string][]byte) {
+		high, root, err = WriteSortedChangesOptions(&b, root, klen, vlen, high, Options{ShortSeparators: true}, func(yield func([]byte, []byte) bool) {
+			for _, k := range slices.Sorted(maps.Keys(changes)) {
+				if !yield([]byte(k), changes[k]) {
+					return
+				}
+			}
+		})
+		if err != nil {
+			t.Fatalf("WriteSortedChangesOptions failed: %v", err)
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(model, k)
+			} else {
+				model[k] = v
+			}
+		}
+	}
+
+	changes := map[string][]byte{}
+	for i := range 4000 {
+		key := fmt.Sprintf("%016x/", rand.Uint64()) + string(bytes.Repeat([]byte("k"), klen+rand.IntN(klen)))
+		changes[key] = fmt.Appendf(nil, "v%d", i)
+	}
+	write(changes)
+
+	// keys right after existing ones fall between a leaf's last key and its separator
+	changes = map[string][]byte{}
+	for k := range model {
+		switch n := len(changes); {
+		case n%3 == 0:
+			changes[k+"!"] = []byte("gap")
+		case n%7 == 0:
+			changes[k] = nil
+		}
+	}
+	write(changes)
+
+	var branches, truncated, overflowed int
+	var check func(page Page)
+	check = func(page Page) {
+		if page.IsLeaf() {
+			return
+		}
+		buffer := b.AllocateBuffer()
+		defer b.RecycleBuffer(buffer)
+		for i := range page.Count() {
+			branches++
+			if key := page.BranchKey(i); len(key) > klen {
+				overflowed++
+			} else if len(key) <= 17 {
+				truncated++
+			}
+			if err := b.ReadBlock(page.BranchID(i), buffer, nil); err != nil {
+				t.Fatalf("ReadBlock failed: %v", err)
+			}
+			check(Page(buffer))
+		}
+	}
+	check(root)
+	t.Logf("%d of %d branch keys truncated, %d overflowed, high=%d", truncated, branches, overflowed, high)
+	if truncated == 0 || overflowed*4 > branches {
+		t.Fatal("branch keys not truncated")
+	}
+
+	var reader Reader[*block.Heap[*mem.File]]
+	reader.Load(&b, root, klen, vlen, high)
+	defer reader.Close()
+	keys := slices.Sorted(maps.Keys(model))
+	i := 0
+	for reader.SeekFirst(); reader.Valid(); reader.Next() {
+		if i >= len(keys) {
+			t.Fatalf("extra key %q", reader.Key())
+		}
+		if string(reader.Key()) != keys[i] || !bytes.Equal(reader.Val(), model[keys[i]]) {
+			t.Fatalf("item %d: got %q=%q, want %q=%q", i, reader.Key(), reader.Val(), keys[i], model[keys[i]])
+		}
+		i++
+	}
+	if err := reader.Error(); err != nil {
+		t.Fatalf("Reader error: %v", err)
+	}
+	if i != len(keys) {
+		t.Fatalf("iterated %d keys, want %d", i, len(keys))
+	}
+	for j, key := range keys {
+		if !reader.Seek([]byte(key)) || !reader.Equal([]byte(key)) {
+			t.Fatalf("Seek(%q) lands on %q", key, reader.Key())
+		}
+		// a missing key just after this one lands on the next key
+		gap := []byte(key + "\x00")
+		if j+1 == len(keys) {
+			if reader.Seek(gap) {
+				t.Fatalf("Seek(%q) past the end lands on %q", gap, reader.Key())
+			}
+			continue
+		}
+		if !reader.Seek(gap) || !reader.Equal([]byte(keys[j+1])) {
+			t.Fatalf("Seek(%q) lands on %q, want %q", gap, reader.Key(), keys[j+1])
+		}
+	}
+
+	// without the option every separator is a full key
+	_, root, err = WriteSortedChanges(&b, nil, klen, vlen, 0, func(yield func([]byte, []byte) bool) {
+		for _, k := range keys {
+			if !yield([]byte(k), model[k]) {
+				return
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("WriteSortedChanges failed: %v", err)
+	}
+	branches, truncated = 0, 0
+	check(root)
+	if truncated != 0 {
+		t.Fatalf("%d of %d branch keys truncated without ShortSeparators", truncated, branches)
+	}
+
+	t.Log("✓ Truncated separators written, updated and read")
+}
@@ -0,0 +1,86 @@
+// Copyright 2025 dacapoday
+// SPDX-License-Identifier: Apache-2.0
+
+package bptree
+
+import (
+	"bytes"
+
+	"github.com/dacapoday/smol/overflow"
+)
+
+// splitPages is the number of pages per range Split looks for, so that
+// the last page of a level, which may be far from full, unbalances the
+// ranges little.
+const splitPages = 16
+
+// Split returns up to n-1 ascending keys dividing the tree at root into
+// at most n ranges of about equal numbers of pages, for scanning the
+// ranges in parallel.
+//
+// The keys are derived from the separators of the highest level of the
+// tree with splitPages pages per range, reading the branch pages above
+// it; a tree with fewer leaf pages than n yields fewer keys. Each key is
+// the smallest key after its separator, so that every leaf page falls in
+// one range.
+func Split[B ReadOnly](block B, root Page, keyInlineSize int, n int) (keys [][]byte, err error) {
+	if n < 2 || root.IsLeaf() {
+		return
+	}
+
+	// the pages of one level, with their separators bounding their keys
+	var ids []BlockID
+	var separators [][]byte
+	for i := range root.Count() {
+		ids = append(ids, root.BranchID(i))
+		separators = append(separators, root.BranchKey(i))
+	}
+	buffer := block.AllocateBuffer()
+	defer block.RecycleBuffer(buffer)
+	for len(ids)/splitPages < n {
+		var childIDs []BlockID
+		var childSeparators [][]byte
+		leaf := false
+		for _, blockID := range ids {
+			if err = readBlock("bptree.Split", block, blockID, buffer, func(data []byte) {
+				page := Page(data)
+				if leaf = page.IsLeaf(); leaf {
+					return
+				}
+				for i := range page.Count() {
+					childIDs = append(childIDs, page.BranchID(i))
+					childSeparators = append(childSeparators, bytes.Clone(page.BranchKey(i)))
+				}
+			}); err != nil {
+				return nil, err
+			}
+			if leaf {
+				break
+			}
+		}
+		if leaf {
+			break
+		}
+		ids, separators = childIDs, childSeparators
+	}
+
+	// range j starts with page j*m/n of the level
+	m := len(ids)
+	last := 0
+	for j := 1; j < n; j++ {
+		i := j * m / n
+		if i == last {
+			continue
+		}
+		last = i
+		key := separators[i-1]
+		if len(key) > keyInlineSize {
+			head, overflowSize, overflowID := Overflow(key, keyInlineSize)
+			if key, err = overflow.Read(block, nil, head, overflowSize, overflowID); err != nil {
+				return nil, errKey("bptree.Split", separators[i-1], keyInlineSize, err)
+			}
+		}
+		keys = append(keys, append(bytes.Clone(key), 0))
+	}
+	return
+}
@@ -0,0 +1,77 @@
+// Copyright 2025 dacapoday
+// SPDX-License-Identifier: Apache-2.0
+
+package bptree
+
+import (
+	"bytes"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/dacapoday/smol/block"
+	"github.com/dacapoday/smol/mem"
+)
+
+// TestSplit tests that split keys are ascending, keep each leaf page in one
+// range, and balance the ranges.
+func TestSplit(t *testing.T) {
+	var f mem.File
+	var b block.Heap[*mem.File]
+	blk := &b
+	_, ckpt, err := blk.Load(&f, option{})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer ckpt.Release()
+	defer blk.Close()
+
+	const count = 20000
+	keyInlineSize := maxKeyInlineSize(blk.PageSize())
+	high, rootPage, err := writeRoot(blk, keyInlineSize, Options{}, 0, newMockLeafItems(count, 16, 8))
+	if err != nil {
+		t.Fatalf("writeRoot failed: %v", err)
+	}
+
+	// the first and last key of each leaf
+	var reader Reader[*block.Heap[*mem.File]]
+	reader.Load(blk, rootPage, 1000, 1000, high)
+	defer reader.Close()
+	var leaves [][2][]byte
+	for ok := reader.SeekFirst(); ok; ok = reader.NextLeaf() {
+		first := reader.KeyCopy(nil)
+		_, _, n := reader.Leaf()
+		for range n - 1 {
+			reader.Next()
+		}
+		leaves = append(leaves, [2][]byte{first, reader.KeyCopy(nil)})
+	}
+
+	for _, n := range []int{1, 2, 3, 8, 50, 100000} {
+		keys, err := Split(blk, rootPage, keyInlineSize, n)
+		if err != nil {
+			t.Fatalf("Split(%d): %v", n, err)
+		}
+		if len(keys) > n-1 && n > 1 || !slices.IsSortedFunc(keys, bytes.Compare) {
+			t.Fatalf("Split(%d) = %q", n, keys)
+		}
+		rangeOf := func(key []byte) int {
+			return sort.Search(len(keys), func(i int) bool { return bytes.Compare(key, keys[i]) < 0 })
+		}
+		pages := make([]int, len(keys)+1)
+		for _, leaf := range leaves {
+			r := rangeOf(leaf[0])
+			if rangeOf(leaf[1]) != r {
+				t.Fatalf("Split(%d): leaf %q..%q spans ranges", n, leaf[0], leaf[1])
+			}
+			pages[r]++
+		}
+		if len(keys) > 0 && (slices.Max(pages) > 2*slices.Min(pages)+1) {
+			t.Fatalf("Split(%d): unbalanced pages per range %v", n, pages)
+		}
+		if n == 100000 && len(keys) != len(leaves)-1 {
+			t.Fatalf("Split(%d) = %d keys for %d leaves", n, len(keys), len(leaves))
+		}
+	}
+	t.Logf("✓ Splits of %d leaves in a tree of height %d", len(leaves), high)
+}
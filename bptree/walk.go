@@ -0,0 +1,97 @@
+// Copyright 2025 dacapoday
+// SPDX-License-Identifier: Apache-2.0
+
+package bptree
+
+import (
+	"github.com/dacapoday/smol/overflow"
+)
+
+// Walk visits every block reachable from root in depth-first order:
+// branch and leaf pages, and the overflow pages of leaf keys and values.
+// The root page itself has no block and is not visited.
+//
+// Warning: The page passed to visit is valid only during the call.
+func Walk[B ReadOnly](block B, root Page, keyInlineSize, valInlineSize int, visit func(blockID BlockID, page []byte) error) error {
+	walker := walker[B]{
+		block:         block,
+		keyInlineSize: keyInlineSize,
+		valInlineSize: valInlineSize,
+		visit:         visit,
+	}
+	defer walker.close()
+	return walker.page(root, 0)
+}
+
+type walker[B ReadOnly] struct {
+	block         B
+	buffers       [][]byte // one per depth
+	keyInlineSize int
+	valInlineSize int
+	visit         func(BlockID, []byte) error
+}
+
+func (walker *walker[B]) close() {
+	for _, buffer := range walker.buffers {
+		walker.block.RecycleBuffer(buffer)
+	}
+}
+
+func (walker *walker[B]) buffer(depth int) []byte {
+	if depth == len(walker.buffers) {
+		walker.buffers = append(walker.buffers, walker.block.AllocateBuffer())
+	}
+	return walker.buffers[depth]
+}
+
+func (walker *walker[B]) page(page Page, depth int) (err error) {
+	count := page.Count()
+	if page.IsLeaf() {
+		for i := range count {
+			if page.leafKeySize(i) > walker.keyInlineSize {
+				if err = walker.overflow(overflowID(page.LeafKey(i)), depth); err != nil {
+					return
+				}
+			}
+			if val := page.LeafVal(i); len(val) > walker.valInlineSize {
+				if err = walker.overflow(overflowID(val), depth); err != nil {
+					return
+				}
+			}
+		}
+		return
+	}
+
+	buffer := walker.buffer(depth)
+	for i := range count {
+		blockID := page.BranchID(i)
+		if err = readBlock("bptree.Walk", walker.block, blockID, buffer, nil); err != nil {
+			return
+		}
+		if err = walker.visit(blockID, buffer); err != nil {
+			return
+		}
+		if err = walker.page(Page(buffer), depth+1); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (walker *walker[B]) overflow(blockID BlockID, depth int) (err error) {
+	buffer := walker.buffer(depth)
+	for blockID > 1 {
+		if err = readBlock("bptree.Walk", walker.block, blockID, buffer, nil); err != nil {
+			return
+		}
+		if err = walker.visit(blockID, buffer); err != nil {
+			return
+		}
+		if page := overflow.Page(buffer); page.IsOverflowTail() {
+			blockID = 0
+		} else {
+			blockID = page.OverflowID()
+		}
+	}
+	return
+}
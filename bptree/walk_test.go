@@ -0,0 +1,63 @@
+// Copyright 2025 dacapoday
+// SPDX-License-Identifier: Apache-2.0
+
+package bptree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/dacapoday/smol/block"
+	"github.com/dacapoday/smol/mem"
+)
+
+func TestWalk(t *testing.T) {
+	var f mem.File
+	var b block.Heap[*mem.File]
+	_, ckpt, err := b.Load(&f, diffOption{})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer ckpt.Release()
+	defer b.Close()
+
+	klen, vlen := InlineSize(b.PageSize(), 5, 1<<20, 1<<20)
+	big := bytes.Repeat([]byte("overflow"), 300)
+	_, root, err := WriteSortedChanges(&b, nil, klen, vlen, 0, func(yield func([]byte, []byte) bool) {
+		for i := range 1000 {
+			val := fmt.Appendf(nil, "val%05d", i)
+			if i%100 == 0 {
+				val = big
+			}
+			if !yield(fmt.Appendf(nil, "key%05d", i), val) {
+				return
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("WriteSortedChanges failed: %v", err)
+	}
+
+	// nothing was recycled, so every allocated block is reachable
+	next := b.AllocateBlock()
+	visited := make(map[BlockID]bool)
+	err = Walk(&b, root, klen, vlen, func(blockID BlockID, page []byte) error {
+		if visited[blockID] {
+			t.Errorf("block %d visited twice", blockID)
+		}
+		visited[blockID] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	for blockID := BlockID(2); blockID < next; blockID++ {
+		if !visited[blockID] {
+			t.Errorf("block %d not visited", blockID)
+		}
+	}
+	if len(visited) != int(next-2) {
+		t.Errorf("visited %d blocks, want %d", len(visited), next-2)
+	}
+}
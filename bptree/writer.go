@@ -5,11 +5,42 @@ package bptree
 
 import (
 	"bytes"
+	"context"
 	"errors"
 
 	"github.com/dacapoday/smol/overflow"
 )
 
+// WriteSortedChangesContext is WriteSortedChangesOptions, stopping with
+// the context's error once ctx is done. The context is checked between
+// changes. Blocks written before stopping are not reclaimed: the caller
+// must roll back the block storage, as block.Heap.Rollback does.
+func WriteSortedChangesContext[B ReadWrite](ctx context.Context, block B, root Page, keyInlineSize, valInlineSize int, high uint8, opts Options, sortedChanges func(func([]byte, []byte) bool)) (uint8, Page, error) {
+	done := ctx.Done()
+	if done == nil {
+		return WriteSortedChangesOptions(block, root, keyInlineSize, valInlineSize, high, opts, sortedChanges)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+	high, root, err := WriteSortedChangesOptions(block, root, keyInlineSize, valInlineSize, high, opts, func(yield func([]byte, []byte) bool) {
+		for key, val := range sortedChanges {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if !yield(key, val) {
+				return
+			}
+		}
+	})
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return 0, nil, ctxErr
+	}
+	return high, root, err
+}
+
 // WriteSortedChanges applies a batch of changes to the copy-on-write B+ tree.
 // It returns a new root page and tree height without modifying the original tree.
 //
@@ -17,7 +48,29 @@ import (
 // A nil value indicates deletion of the key. All yielded keys and values must
 // remain valid until the function returns, not just during iteration.
 func WriteSortedChanges[B ReadWrite](block B, root Page, keyInlineSize, valInlineSize int, high uint8, sortedChanges func(func([]byte, []byte) bool)) (uint8, Page, error) {
-	writer := itemWriter[B]{block: block}
+	return WriteSortedChangesOptions(block, root, keyInlineSize, valInlineSize, high, Options{}, sortedChanges)
+}
+
+// Options controls how WriteSortedChangesOptions writes pages.
+// The zero value writes them as WriteSortedChanges does.
+type Options struct {
+	// Fill sets how full the rewritten pages are packed.
+	Fill Fill
+
+	// PrefixLeaves stores the key prefix shared by all items of a leaf page
+	// once, where that makes the page smaller. Readers predating the
+	// prefix-compressed leaf page format cannot read such pages.
+	PrefixLeaves bool
+
+	// ShortSeparators separates adjacent leaf pages in their branch page by
+	// the shortest key between them instead of the last key of the left one.
+	// Readers predating shortened separators cannot read such trees.
+	ShortSeparators bool
+}
+
+// WriteSortedChangesOptions is WriteSortedChanges, writing pages as set by opts.
+func WriteSortedChangesOptions[B ReadWrite](block B, root Page, keyInlineSize, valInlineSize int, high uint8, opts Options, sortedChanges func(func([]byte, []byte) bool)) (uint8, Page, error) {
+	writer := itemWriter[B]{block: block, opts: opts}
 	writer.keyInlineSize = keyInlineSize
 	writer.valInlineSize = valInlineSize
 	writer.root.high = high
@@ -99,7 +152,7 @@ func WriteSortedChanges[B ReadWrite](block B, root Page, keyInlineSize, valInlin
 	}
 
 	return writeBranch(
-		block,
+		block, writer.keyInlineSize, writer.opts,
 		writer.root.high, writer.root.page,
 		writer.pages, writer.list.head,
 	)
@@ -115,6 +168,7 @@ type itemWriter[B ReadWrite] struct {
 	}
 	keyInlineSize int
 	valInlineSize int
+	opts          Options
 
 	pages
 	list[[]byte, LeafItems, leafItem, *leafItem]
@@ -139,7 +193,7 @@ func (writer *itemWriter[B]) flush() {
 	}
 
 	writer.root.high, writer.root.page, writer.err = writeBranch(
-		writer.block,
+		writer.block, writer.keyInlineSize, writer.opts,
 		writer.root.high, writer.root.page,
 		writer.pages, writer.list.head.next,
 	)
@@ -163,7 +217,7 @@ func (writer *itemWriter[B]) load(blockID BlockID) (page Page) {
 		return
 	}
 
-	page, writer.err = writer.block.LoadBlock(blockID)
+	page, writer.err = loadBlock("bptree.WriteSortedChanges", writer.block, blockID)
 	if writer.err == nil {
 		writer.pages[blockID] = page
 	}
@@ -282,6 +336,12 @@ func (writer *itemWriter[B]) seek(key []byte) {
 		writer.list.tail.level[i].Index = index
 		blockID = page.BranchID(index)
 	}
+	if prev != writer.list.head && prev.level[0].BlockID == blockID {
+		// key lies between the last key of the loaded leaf and its separator
+		prev.next = nil
+		writer.list.tail = prev
+		return
+	}
 	page = writer.load(blockID)
 	if writer.err != nil {
 		return
@@ -301,11 +361,12 @@ func (writer *itemWriter[B]) branch(i uint16) int {
 			return 0
 		}
 		head, overflowSize, overflowID := Overflow(branchKey, writer.keyInlineSize)
-		branchKey, writer.err = overflow.Read(writer.block, writer.buf, head, overflowSize, overflowID)
-		if writer.err != nil {
+		var err error
+		if writer.buf, err = overflow.Read(writer.block, writer.buf, head, overflowSize, overflowID); err != nil {
+			writer.err = errKey("bptree.WriteSortedChanges", branchKey, writer.keyInlineSize, err)
 			return 0
 		}
-		writer.buf = branchKey
+		branchKey = writer.buf
 	}
 	return bytes.Compare(writer.key, branchKey)
 }
@@ -317,19 +378,21 @@ func (writer *itemWriter[B]) find(key []byte) {
 }
 
 func (writer *itemWriter[B]) leaf(i uint16) int {
-	leafKey := writer.list.tail.page.LeafKey(writer.list.tail.page.tail.beg + i)
-	if len(leafKey) > writer.keyInlineSize {
-		if writer.err != nil {
-			return 0
-		}
-		head, overflowSize, overflowID := Overflow(leafKey, writer.keyInlineSize)
-		leafKey, writer.err = overflow.Read(writer.block, writer.buf, head, overflowSize, overflowID)
-		if writer.err != nil {
-			return 0
-		}
-		writer.buf = leafKey
+	page := writer.list.tail.Page
+	i += writer.list.tail.page.tail.beg
+	if page.leafKeySize(i) <= writer.keyInlineSize {
+		return compareLeafKey(writer.key, page, i)
+	}
+	if writer.err != nil {
+		return 0
 	}
-	return bytes.Compare(writer.key, leafKey)
+	head, overflowSize, overflowID := Overflow(page.LeafKey(i), writer.keyInlineSize)
+	var err error
+	if writer.buf, err = overflow.Read(writer.block, writer.buf, head, overflowSize, overflowID); err != nil {
+		writer.err = errKey("bptree.WriteSortedChanges", page.LeafKey(i), writer.keyInlineSize, err)
+		return 0
+	}
+	return bytes.Compare(writer.key, writer.buf)
 }
 
 func (writer *itemWriter[B]) write(key, val []byte) {
@@ -398,8 +461,8 @@ func (writer *itemWriter[B]) write(key, val []byte) {
 		}
 	} else if writer.found {
 		// delete
-		if leafKey := writer.list.tail.page.LeafKey(writer.index); len(leafKey) > writer.keyInlineSize {
-			overflowID := overflowID(leafKey)
+		if writer.list.tail.page.leafKeySize(writer.index) > writer.keyInlineSize {
+			overflowID := overflowID(writer.list.tail.page.LeafKey(writer.index))
 			writer.run(func() (err error) {
 				return overflow.Recycle(block, overflowID)
 			})
@@ -432,51 +495,51 @@ func (writer *itemWriter[B]) extend() (item *leafItem) {
 	return
 }
 
-func writeBranch[B ReadWrite](block B, high uint8, root Page, pages pages, leaf *leafNode) (uint8, Page, error) {
+func writeBranch[B ReadWrite](block B, keyInlineSize int, opts Options, high uint8, root Page, pages pages, leaf *leafNode) (uint8, Page, error) {
 	if leaf == nil {
 		// if high == 0 {
-		// 	return writeRoot(block, high, root.LeafItems(0, root.Count()))
+		// 	return writeRoot(block, keyInlineSize, opts, high, root.LeafItems(0, root.Count()))
 		// }
-		return writeRoot(block, high, root.BranchItems(0, root.Count()))
+		return writeRoot(block, keyInlineSize, opts, high, root.BranchItems(0, root.Count()))
 	}
 
 	if len(leaf.level) < 2 {
 		if len(leaf.level) == 0 {
-			return writeRoot(block, 0, LeafItems(leaf.items))
+			return writeRoot(block, keyInlineSize, opts, 0, LeafItems(leaf.items))
 		}
 
-		page, err := writeRootNodes(block, root, leaf)
+		page, err := writeRootNodes(block, keyInlineSize, opts, root, leaf)
 		if err != nil {
 			return 0, nil, err
 		}
-		return writeRoot(block, 1, BranchItems(page.items))
+		return writeRoot(block, keyInlineSize, opts, 1, BranchItems(page.items))
 	}
 
-	branch, err := writeBranchNodes(block, pages, leaf)
+	branch, err := writeBranchNodes(block, keyInlineSize, opts, pages, leaf)
 	if err != nil {
 		return 0, nil, err
 	}
 	if len(branch.level) == 0 {
-		return writeRoot(block, 1, BranchItems(branch.items))
+		return writeRoot(block, keyInlineSize, opts, 1, BranchItems(branch.items))
 	}
 
 	for high = 1; len(branch.level) > 1; high++ {
-		branch, err = writeBranchNodes(block, pages, branch)
+		branch, err = writeBranchNodes(block, keyInlineSize, opts, pages, branch)
 		if err != nil {
 			return 0, nil, err
 		}
 		if len(branch.level) == 0 {
 			high++
-			return writeRoot(block, high, BranchItems(branch.items))
+			return writeRoot(block, keyInlineSize, opts, high, BranchItems(branch.items))
 		}
 	}
 
-	page, err := writeRootNodes(block, root, branch)
+	page, err := writeRootNodes(block, keyInlineSize, opts, root, branch)
 	if err != nil {
 		return 0, nil, err
 	}
 	high++
-	return writeRoot(block, high, BranchItems(page.items))
+	return writeRoot(block, keyInlineSize, opts, high, BranchItems(page.items))
 }
 
 func writeRootNodes[
@@ -485,7 +548,7 @@ func writeRootNodes[
 	Items items[V],
 	Item branchItem | leafItem,
 	ItemPtr itemPtr[V, Items, Item],
-](block B, root Page, head *node[V, Items, Item, ItemPtr]) (branch *branchPage, err error) {
+](block B, keyInlineSize int, opts Options, root Page, head *node[V, Items, Item, ItemPtr]) (branch *branchPage, err error) {
 	head.prev.beg = 0
 	head.prev.end = head.level[0].Index
 
@@ -503,7 +566,7 @@ func writeRootNodes[
 	branch.tail.beg = prev.prev.end + 1
 	branch.tail.end = prev.level[0].Count
 
-	err = writeNodes(block, branch, head)
+	err = writeNodes(block, keyInlineSize, opts, true, branch, head)
 	return
 }
 
@@ -513,13 +576,13 @@ func writeBranchNodes[
 	Items items[V],
 	Item branchItem | leafItem,
 	ItemPtr itemPtr[V, Items, Item],
-](block B, pages pages, head *node[V, Items, Item, ItemPtr]) (branch *branchNode, err error) {
+](block B, keyInlineSize int, opts Options, pages pages, head *node[V, Items, Item, ItemPtr]) (branch *branchNode, err error) {
 	high := len(head.level) - 1
 
 	head.prev.beg = 0
 	head.prev.end = head.level[high].Index
 
-	writer := writer[B, V, Items, Item, ItemPtr]{block: block, pages: pages}
+	writer := writer[B, V, Items, Item, ItemPtr]{block: block, keyInlineSize: keyInlineSize, opts: opts, pages: pages}
 	var pending list[V, Items, Item, ItemPtr]
 
 	prev := head
@@ -613,7 +676,9 @@ type writer[
 	Item branchItem | leafItem,
 	ItemPtr itemPtr[V, Items, Item],
 ] struct {
-	block B
+	block         B
+	keyInlineSize int
+	opts          Options
 	pages
 	task
 	list[BlockID, BranchItems, branchItem, *branchItem]
@@ -629,9 +694,10 @@ func (writer *writer[B, V, Items, Item, ItemPtr]) write(
 	branch.page.tail = tail
 	branch.Page = writer.pages[blockID]
 	if head != nil {
-		block := writer.block
+		block, keyInlineSize, opts := writer.block, writer.keyInlineSize, writer.opts
+		edge := level.last()
 		writer.run(func() (err error) {
-			err = writeNodes(block, &branch.page, head)
+			err = writeNodes(block, keyInlineSize, opts, edge, &branch.page, head)
 			block.RecycleBlock(blockID)
 			return
 		})
@@ -0,0 +1,47 @@
+// Copyright 2025 dacapoday
+// SPDX-License-Identifier: Apache-2.0
+
+package bptree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// TestWriteSortedChangesContext tests that a write stops with the
+// context's error once it is cancelled, leaving the old root usable.
+func TestWriteSortedChangesContext(t *testing.T) {
+	tree := newFillTree(t, Fill{})
+	changes := map[string][]byte{}
+	for i := range 500 {
+		changes[fmt.Sprintf("key:%05d", i)] = []byte("value")
+	}
+	tree.write(changes)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, root, err := WriteSortedChangesContext(ctx, &tree.b, tree.root, tree.klen, tree.vlen, tree.high, Options{Fill: tree.fill}, func(yield func([]byte, []byte) bool) {
+		for i := range 5000 {
+			if i == 1000 {
+				cancel()
+			}
+			if !yield(fmt.Appendf(nil, "new:%05d", i), []byte("value")) {
+				return
+			}
+		}
+	})
+	if !errors.Is(err, context.Canceled) || root != nil {
+		t.Fatalf("WriteSortedChangesContext = %d bytes, %v, want context.Canceled", len(root), err)
+	}
+	tree.b.Rollback()
+	tree.leaves()
+
+	if err := RecycleContext(ctx, &tree.b, tree.root, tree.klen, tree.vlen); !errors.Is(err, context.Canceled) {
+		t.Fatalf("RecycleContext: %v, want context.Canceled", err)
+	}
+	tree.b.Rollback()
+	tree.leaves()
+
+	t.Log("✓ Cancelled write and recycle leave the tree intact")
+}
@@ -1,22 +1,24 @@
-// Package btree implements an append-only in-memory B-tree with iterator support.
+// Package btree implements an in-memory B-tree with iterator support.
 package btree
 
 import (
+	"bytes"
 	"sort"
 	"strings"
 	"unsafe"
 )
 
-// BTree is an append-only in-memory B-tree storing key-value pairs in lexicographic order.
+// BTree is an in-memory B-tree storing key-value pairs in lexicographic order.
 // Not thread-safe.
 //
-// Append-only: only value updates allowed (including to nil).
 // Use cases:
 //   - Change tracking: Set(key, nil) marks deletion
 //   - Set data structure: ignore values, use Get's found flag
 //
-// Warning: BTree stores references to key/val slices, not copies.
-// Caller must not modify slices after calling Set.
+// Delete removes a key entirely, releasing its memory.
+//
+// Warning: Unless Copy is set, BTree stores references to key/val slices,
+// not copies. Caller must not modify slices after calling Set.
 //
 // Example:
 //
@@ -30,6 +32,10 @@ import (
 //
 //	btree.Reset()  // Clear all data
 type BTree struct {
+	// Copy makes Set store copies of keys and values,
+	// so callers may reuse their slices.
+	Copy bool
+
 	items   []item
 	nodes   []*node
 	last    *node
@@ -51,10 +57,23 @@ func (btree *BTree) Reset() {
 // Set updates the value for a key (inserts if key doesn't exist).
 // Pass nil value to mark deletion.
 func (btree *BTree) Set(key, val []byte) {
+	if btree.Copy {
+		key, val = bytes.Clone(key), bytes.Clone(val)
+	}
 	btree.version++
 	btree.set(b2s(key), b2s(val))
 }
 
+// Delete removes a key, unlike Set(key, nil) which keeps it marked as
+// deleted. Reports whether the key was present.
+// Iterators positioned at a deleted key move on to the next key.
+func (btree *BTree) Delete(key []byte) (found bool) {
+	if found = btree.remove(b2s(key)); found {
+		btree.version++
+	}
+	return
+}
+
 // Get retrieves the value for a key.
 // If val is nil and found is true, the key exists but value is nil.
 // If found is false, the key doesn't exist.
@@ -0,0 +1,150 @@
+package btree
+
+import (
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// check verifies the item order against model, and that every node
+// other than the root holds minCount to order items, all leaves at one depth.
+func check(t *testing.T, btree *BTree, model map[string]string) {
+	t.Helper()
+	leafDepth := -1
+	var walk func(n *node, depth int)
+	walk = func(n *node, depth int) {
+		if n.count < minCount || n.count > order {
+			t.Fatalf("node of %d items", n.count)
+		}
+		if n.last == nil {
+			if leafDepth >= 0 && leafDepth != depth {
+				t.Fatalf("leaves at depth %d and %d", leafDepth, depth)
+			}
+			leafDepth = depth
+			return
+		}
+		for i := range n.count + 1 {
+			walk(n.node(i), depth+1)
+		}
+	}
+	if btree.last != nil {
+		if len(btree.nodes) != len(btree.items) {
+			t.Fatalf("root of %d items has %d children", len(btree.items), len(btree.nodes)+1)
+		}
+		for i := range len(btree.items) + 1 {
+			walk(btree.node(i), 1)
+		}
+	}
+
+	keys := slices.Sorted(maps.Keys(model))
+	i := 0
+	for key, val := range btree.Items {
+		if i >= len(keys) || string(key) != keys[i] || string(val) != model[keys[i]] {
+			t.Fatalf("item %d = %q:%q", i, key, val)
+		}
+		i++
+	}
+	if i != len(keys) {
+		t.Fatalf("%d items, want %d", i, len(keys))
+	}
+}
+
+// TestDelete tests random inserts and deletes against a map.
+func TestDelete(t *testing.T) {
+	var btree BTree
+	model := map[string]string{}
+	for round := range 20 {
+		for range 500 {
+			key := fmt.Sprintf("%04d", rand.IntN(1000))
+			if rand.IntN(2) == 0 {
+				val := fmt.Sprint(round)
+				btree.Set([]byte(key), []byte(val))
+				model[key] = val
+			} else {
+				_, want := model[key]
+				if found := btree.Delete([]byte(key)); found != want {
+					t.Fatalf("Delete(%q) = %v, want %v", key, found, want)
+				}
+				delete(model, key)
+			}
+		}
+		check(t, &btree, model)
+	}
+
+	for key := range model {
+		btree.Delete([]byte(key))
+	}
+	check(t, &btree, nil)
+	if !btree.Empty() || btree.last != nil {
+		t.Fatal("tree not empty after deleting every key")
+	}
+
+	t.Log("✓ Deletes keep the tree balanced")
+}
+
+// TestDeleteIter tests iterators positioned at a deleted key.
+func TestDeleteIter(t *testing.T) {
+	var btree BTree
+	for i := range 100 {
+		btree.Set(fmt.Appendf(nil, "%03d", i), []byte("v"))
+	}
+
+	it := btree.Iter()
+	it.Seek([]byte("050"))
+	back := it.Clone()
+	btree.Delete([]byte("050"))
+	if !it.Next() || string(it.Key()) != "051" {
+		t.Fatalf("Next after delete lands on %q, want 051", it.Key())
+	}
+	if !back.Prev() || string(back.Key()) != "049" {
+		t.Fatalf("Prev after delete lands on %q, want 049", back.Key())
+	}
+
+	it.SeekLast()
+	btree.Delete([]byte("099"))
+	if !it.Prev() || string(it.Key()) != "098" {
+		t.Fatalf("Prev after deleting the last key lands on %q, want 098", it.Key())
+	}
+
+	t.Log("✓ Iterators move on from deleted keys")
+}
+
+// TestSeekLE tests SeekLE against a sorted slice of the keys.
+func TestSeekLE(t *testing.T) {
+	var btree BTree
+	it := btree.Iter()
+	if it.SeekLE([]byte("x")) || it.Valid() {
+		t.Fatal("SeekLE in an empty tree")
+	}
+
+	var keys []string
+	for i := range 500 {
+		key := fmt.Sprintf("%04d", 2*i)
+		btree.Set([]byte(key), []byte("v"))
+		keys = append(keys, key)
+	}
+	for p := -1; p <= 1000; p++ {
+		probe := fmt.Sprintf("%04d", p)
+		i, found := slices.BinarySearch(keys, probe)
+		if !found {
+			i--
+		}
+		ok := it.SeekLE([]byte(probe))
+		if i < 0 {
+			if ok || it.Valid() {
+				t.Fatalf("SeekLE(%q) = %q", probe, it.Key())
+			}
+			continue
+		}
+		if !ok || string(it.Key()) != keys[i] {
+			t.Fatalf("SeekLE(%q) = %q, want %q", probe, it.Key(), keys[i])
+		}
+	}
+	if !it.SeekLE([]byte("0101")) || !it.Next() || string(it.Key()) != "0102" {
+		t.Fatalf("Next after SeekLE = %q", it.Key())
+	}
+
+	t.Log("✓ SeekLE lands on the last key <= the probe")
+}
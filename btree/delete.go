@@ -0,0 +1,208 @@
+package btree
+
+import "slices"
+
+// minCount is the fewest items in a node other than the root.
+// Splits leave at least this many on each side.
+const minCount = order - half
+
+// span is a copy of the items and children of the root or a node,
+// rearranged by deletes and written back. Nodes is nil in a leaf,
+// and holds one child more than keys otherwise.
+type span struct {
+	keys, vals []string
+	nodes      []*node
+}
+
+// frame is a step down the tree: a node, nil for the root,
+// and the index of the child taken.
+type frame struct {
+	node  *node
+	index int
+}
+
+// remove deletes key, refilling nodes left with too few items from
+// their siblings and dropping the root level once it empties.
+func (btree *BTree) remove(key string) bool {
+	var path []frame
+	var n *node
+	for {
+		var index int
+		var found bool
+		var next *node
+		if n == nil {
+			index, found = btree.find(key)
+			next = btree.node(index)
+		} else {
+			index, found = n.find(key)
+			next = n.node(index)
+		}
+
+		if found && next == nil {
+			if n == nil {
+				btree.items = slices.Delete(btree.items, index, index+1)
+				return true
+			}
+			n.remove(index)
+			break
+		}
+
+		if found {
+			// take the place of the key with its predecessor, the last
+			// item of the left subtree, and remove that from its leaf
+			path = append(path, frame{n, index})
+			leaf := next
+			for leaf.last != nil {
+				path = append(path, frame{leaf, leaf.count})
+				leaf = leaf.last
+			}
+			last := leaf.count - 1
+			if n == nil {
+				btree.items[index] = item{leaf.keys[last], leaf.vals[last]}
+			} else {
+				n.keys[index], n.vals[index] = leaf.keys[last], leaf.vals[last]
+			}
+			leaf.remove(last)
+			n = leaf
+			break
+		}
+
+		if next == nil {
+			return false
+		}
+		path = append(path, frame{n, index})
+		n = next
+	}
+
+	for i := len(path) - 1; i >= 0 && n.count < minCount; i-- {
+		parent := path[i]
+		btree.refill(parent.node, parent.index)
+		if n = parent.node; n == nil {
+			break
+		}
+	}
+
+	if len(btree.items) == 0 && btree.last != nil {
+		btree.setSpan(btree.last.span())
+	}
+	return true
+}
+
+// refill brings child index of parent, nil for the root, back to minCount
+// items: by rotating one through the parent from a sibling with items to
+// spare, or else by merging it with a sibling and their separator.
+func (btree *BTree) refill(parent *node, index int) {
+	s := btree.spanOf(parent)
+	child := s.nodes[index]
+	cs := child.span()
+
+	switch {
+	case index > 0 && s.nodes[index-1].count > minCount:
+		left := s.nodes[index-1]
+		ls := left.span()
+		last := len(ls.keys) - 1
+		cs.keys = slices.Insert(cs.keys, 0, s.keys[index-1])
+		cs.vals = slices.Insert(cs.vals, 0, s.vals[index-1])
+		if ls.nodes != nil {
+			cs.nodes = slices.Insert(cs.nodes, 0, ls.nodes[last+1])
+			ls.nodes = ls.nodes[:last+1]
+		}
+		s.keys[index-1], s.vals[index-1] = ls.keys[last], ls.vals[last]
+		ls.keys, ls.vals = ls.keys[:last], ls.vals[:last]
+		left.setSpan(ls)
+		child.setSpan(cs)
+
+	case index < len(s.keys) && s.nodes[index+1].count > minCount:
+		right := s.nodes[index+1]
+		rs := right.span()
+		cs.keys = append(cs.keys, s.keys[index])
+		cs.vals = append(cs.vals, s.vals[index])
+		if rs.nodes != nil {
+			cs.nodes = append(cs.nodes, rs.nodes[0])
+			rs.nodes = rs.nodes[1:]
+		}
+		s.keys[index], s.vals[index] = rs.keys[0], rs.vals[0]
+		rs.keys, rs.vals = rs.keys[1:], rs.vals[1:]
+		right.setSpan(rs)
+		child.setSpan(cs)
+
+	default:
+		if index == len(s.keys) {
+			index--
+		}
+		left, right := s.nodes[index].span(), s.nodes[index+1].span()
+		left.keys = slices.Concat(left.keys, s.keys[index:index+1], right.keys)
+		left.vals = slices.Concat(left.vals, s.vals[index:index+1], right.vals)
+		if left.nodes != nil {
+			left.nodes = slices.Concat(left.nodes, right.nodes)
+		}
+		s.nodes[index].setSpan(left)
+		s.keys = slices.Delete(s.keys, index, index+1)
+		s.vals = slices.Delete(s.vals, index, index+1)
+		s.nodes = slices.Delete(s.nodes, index+1, index+2)
+	}
+
+	btree.setSpanOf(parent, s)
+}
+
+func (btree *BTree) spanOf(n *node) span {
+	if n == nil {
+		return btree.span()
+	}
+	return n.span()
+}
+
+func (btree *BTree) setSpanOf(n *node, s span) {
+	if n == nil {
+		btree.setSpan(s)
+		return
+	}
+	n.setSpan(s)
+}
+
+func (btree *BTree) span() (s span) {
+	s.keys = make([]string, len(btree.items))
+	s.vals = make([]string, len(btree.items))
+	for i, item := range btree.items {
+		s.keys[i], s.vals[i] = item.key, item.val
+	}
+	if btree.last != nil {
+		s.nodes = append(slices.Clone(btree.nodes), btree.last)
+	}
+	return
+}
+
+func (btree *BTree) setSpan(s span) {
+	btree.items = make([]item, len(s.keys))
+	for i := range s.keys {
+		btree.items[i] = item{s.keys[i], s.vals[i]}
+	}
+	btree.nodes, btree.last = nil, nil
+	if s.nodes != nil {
+		btree.nodes = s.nodes[:len(s.keys):len(s.keys)]
+		btree.last = s.nodes[len(s.keys)]
+	}
+}
+
+func (node *node) span() (s span) {
+	s.keys = slices.Clone(node.keys[:node.count])
+	s.vals = slices.Clone(node.vals[:node.count])
+	if node.last != nil {
+		s.nodes = append(slices.Clone(node.nodes[:node.count]), node.last)
+	}
+	return
+}
+
+// setSpan writes s back, clearing the slots it leaves free.
+func (node *node) setSpan(s span) {
+	clear(node.keys[:])
+	clear(node.vals[:])
+	clear(node.nodes[:])
+	node.count = copy(node.keys[:], s.keys)
+	copy(node.vals[:], s.vals)
+	node.last = nil
+	if s.nodes != nil {
+		copy(node.nodes[:], s.nodes[:node.count])
+		node.last = s.nodes[node.count]
+	}
+}
@@ -73,3 +73,27 @@ func ExampleBTree_Items() {
 	// banana: <deleted>
 	// cherry: red
 }
+
+func ExampleBTree_Delete() {
+	btree := BTree{Copy: true}
+
+	key := []byte("apple")
+	btree.Set(key, []byte("red"))
+	copy(key, "grape") // safe with Copy
+	btree.Set(key, []byte("purple"))
+	btree.Set([]byte("banana"), nil)
+
+	// Delete removes the key, where Set(key, nil) marks it deleted
+	btree.Delete([]byte("apple"))
+	btree.Delete([]byte("banana"))
+	_, found := btree.Get([]byte("apple"))
+	fmt.Printf("apple found: %v\n", found)
+
+	for key, val := range btree.Items {
+		fmt.Printf("%s: %s\n", key, val)
+	}
+
+	// Output:
+	// apple found: false
+	// grape: purple
+}
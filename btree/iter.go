@@ -100,9 +100,14 @@ func (it Iter) Val() []byte {
 // Next advances to the next item. Returns false if no more items.
 func (it Iter) Next() bool {
 	if it.version != it.root.version {
+		key := it.key
 		if !it.sync() {
 			return false
 		}
+		if it.key != key {
+			// the current key was deleted, leaving its successor
+			return true
+		}
 	}
 
 	var node, next *node
@@ -163,7 +168,8 @@ func (it Iter) Next() bool {
 func (it Iter) Prev() bool {
 	if it.version != it.root.version {
 		if !it.sync() {
-			return false
+			// the current key was deleted, and no key follows it
+			return it.SeekLast()
 		}
 	}
 
@@ -301,6 +307,26 @@ func (it Iter) Seek(key []byte) bool {
 	return it.seek(b2s(key))
 }
 
+// SeekLE positions at the last key <= the given key.
+// Returns false if no such key exists.
+func (it Iter) SeekLE(key []byte) bool {
+	if len(it.root.items) == 0 {
+		it.version = it.root.version
+		it.cursors = it.cursors[:0]
+		it.index = 0
+		it.key = ""
+		return false
+	}
+
+	if !it.seek(b2s(key)) {
+		return it.SeekLast()
+	}
+	if it.key == b2s(key) {
+		return true
+	}
+	return it.Prev()
+}
+
 func (it Iter) seek(key string) bool {
 	it.version = it.root.version
 	it.cursors = it.cursors[:0]
@@ -55,6 +55,14 @@ func (node *node) insert(i int, entry *entry) {
 	node.nodes[i] = entry.node
 }
 
+// remove removes item i from a leaf node.
+func (node *node) remove(i int) {
+	copy(node.keys[i:], node.keys[i+1:node.count])
+	copy(node.vals[i:], node.vals[i+1:node.count])
+	node.count--
+	node.keys[node.count], node.vals[node.count] = "", ""
+}
+
 func (node *node) items(yield func(key, val []byte) bool) bool {
 	if node.last == nil {
 		for i := 0; i < node.count; i++ {
@@ -1,6 +1,9 @@
 package smol
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrClosed             = errors.New("closed")
@@ -21,4 +24,44 @@ var (
 	ErrUnsupported        = errors.New("unsupported")
 	ErrOutOfRange         = errors.New("out of range")
 	ErrAllocateFailed     = errors.New("allocate failed")
+	ErrLocked             = errors.New("locked")
+	ErrLagged             = errors.New("lagged")
+	ErrBadEncoding        = errors.New("bad encoding")
 )
+
+// BlockError records an operation that failed on a block.
+// It matches the sentinel it wraps, such as ErrBadChecksum, via errors.Is.
+type BlockError struct {
+	Op      string // operation, such as "heap.ReadBlock"
+	BlockID BlockID
+	Err     error
+}
+
+func (e *BlockError) Error() string {
+	return fmt.Sprintf("%s(%d): %v", e.Op, e.BlockID, e.Err)
+}
+
+func (e *BlockError) Unwrap() error {
+	return e.Err
+}
+
+// KeyError records an operation that failed on the overflow of a key or
+// its value. Key holds the key as stored inline in its page: a key that
+// overflowed itself is cut to its inline head.
+type KeyError struct {
+	Op  string // operation, such as "bptree.Reader.Val"
+	Key []byte
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	key := e.Key
+	if len(key) > 32 {
+		return fmt.Sprintf("%s(%q...): %v", e.Op, key[:32], e.Err)
+	}
+	return fmt.Sprintf("%s(%q): %v", e.Op, key, e.Err)
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
@@ -1,6 +1,7 @@
 package heap
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
@@ -57,6 +58,17 @@ func (codec *codec) load(file io.ReaderAt, opt Option, meta *Meta) (err error) {
 	return fmt.Errorf("%w cipher suite: %d", ErrUnsupported, suite)
 }
 
+// reload reads the entry of a meta committed after load.
+func (codec *codec) reload(file io.ReaderAt, meta *Meta) error {
+	if (codec.spec == nil) != (meta.CodecSpec == nil) || !bytes.Equal(codec.spec, meta.CodecSpec) {
+		return ErrBadCipherSpec
+	}
+	if codec.spec == nil {
+		return loadPlainEntry(file, meta)
+	}
+	return codec.loadEntry(file, meta)
+}
+
 func (codec *codec) init(file io.WriterAt, opt Option) (meta *Meta, err error) {
 	var blockSize int
 	if o, ok := opt.(BlockSize); ok {
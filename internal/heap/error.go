@@ -22,3 +22,5 @@ var (
 	ErrUnsupported        = smol.ErrUnsupported
 	errOutOfRange         = smol.ErrOutOfRange
 )
+
+type BlockError = smol.BlockError
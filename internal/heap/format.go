@@ -0,0 +1,84 @@
+package heap
+
+import (
+	"fmt"
+	"io"
+)
+
+// Format describes the on-disk format of a file: a version, moved forward
+// one step at a time by upgrades, and feature bits.
+//
+// A reader must understand every Required feature of a file to open it.
+// Optional features describe data a reader may ignore. A writer that does
+// not know an optional feature drops its bit on commit, as it does not keep
+// the data the feature describes up to date.
+type Format struct {
+	Version  byte   // (key: 1)
+	Required uint64 // (key: 2)
+	Optional uint64 // (key: 3)
+}
+
+// SupportedFormat is an optional Option reporting the newest version and
+// the features the opener understands. Without it, only files of version 0
+// without required features can be opened.
+type SupportedFormat interface {
+	SupportedFormat() Format
+}
+
+func getSupportedFormat(opt any) (format Format) {
+	if o, ok := opt.(SupportedFormat); ok {
+		format = o.SupportedFormat()
+	}
+	return
+}
+
+// Check returns ErrUnsupported if a file of this format cannot be opened
+// by a reader supporting the given format.
+func (format Format) Check(supported Format) error {
+	if format.Version > supported.Version {
+		return fmt.Errorf("%w meta version: %d", ErrUnsupported, format.Version)
+	}
+	if unknown := format.Required &^ supported.Required; unknown != 0 {
+		return fmt.Errorf("%w required features: %#x", ErrUnsupported, unknown)
+	}
+	return nil
+}
+
+func (meta *Meta) format() Format {
+	return Format{Version: meta.Version, Required: meta.Required, Optional: meta.Optional}
+}
+
+// Format returns the format the next commit writes.
+func (heap *Heap[F]) Format() Format {
+	heap.mutex.Lock()
+	defer heap.mutex.Unlock()
+	return heap.format
+}
+
+// SetFormat sets the format the next commit writes, such as a version
+// moved forward by an upgrade step, or a feature taken into use.
+// Rollback restores the committed format. It returns ErrUnsupported for
+// a format the opener does not support.
+func (heap *Heap[F]) SetFormat(format Format) error {
+	heap.mutex.Lock()
+	defer heap.mutex.Unlock()
+
+	if err := format.Check(heap.supported); err != nil {
+		return fmt.Errorf("heap.SetFormat: %w", err)
+	}
+	if unknown := format.Optional &^ heap.supported.Optional; unknown != 0 {
+		return fmt.Errorf("heap.SetFormat: %w optional features: %#x", ErrUnsupported, unknown)
+	}
+	heap.format = format
+	return nil
+}
+
+// Inspect reads the meta of the newest checkpoint of a file without
+// opening it, so its format can be checked before loading it.
+func Inspect[R io.ReaderAt](file R, magic [4]byte) (meta *Meta, err error) {
+	metaA, metaB, err := loadMeta(io.NewSectionReader(file, 0, 1<<17), magic)
+	if err != nil {
+		return
+	}
+	return newerMeta(metaA, metaB), nil
+}
@@ -0,0 +1,148 @@
+package heap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+
+	"github.com/dacapoday/smol/mem"
+)
+
+type formatOption struct {
+	testOption
+	format Format
+}
+
+func (o formatOption) SupportedFormat() Format { return o.format }
+
+// TestFormatCheck tests that newer versions and unknown required
+// features are rejected, and unknown optional features are not.
+func TestFormatCheck(t *testing.T) {
+	supported := Format{Version: 2, Required: 0b01, Optional: 0b10}
+	for _, tc := range []struct {
+		format Format
+		ok     bool
+	}{
+		{Format{}, true},
+		{Format{Version: 2, Required: 0b01, Optional: 0b10}, true},
+		{Format{Version: 1, Optional: 0b110}, true},
+		{Format{Version: 3}, false},
+		{Format{Required: 0b10}, false},
+	} {
+		err := tc.format.Check(supported)
+		if tc.ok != (err == nil) {
+			t.Errorf("%+v: unexpected error: %v", tc.format, err)
+		}
+		if err != nil && !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%+v: expected ErrUnsupported, got %v", tc.format, err)
+		}
+	}
+}
+
+// TestMetaSkipUnknownTag tests that decodeMeta skips value and bytes
+// tags it does not know.
+func TestMetaSkipUnknownTag(t *testing.T) {
+	var buf bytes.Buffer
+	e := tlvEncoder{&buf}
+	e.writeVal(1, 3)
+	e.writeVal(40, 12345)
+	e.writeBytes(41, []byte("future"))
+	e.writeVal(2, 0b100)
+	e.writeVal(5, 7)
+	buf.WriteByte(0)
+	buf.Write(binary.LittleEndian.AppendUint32(nil, crc32.Checksum(buf.Bytes(), castagnoliCrcTable)))
+
+	var meta Meta
+	if err := decodeMeta(&buf, &meta); err != nil {
+		t.Fatalf("decodeMeta failed: %v", err)
+	}
+	if meta.Version != 3 || meta.Required != 0b100 || meta.Ckp != 7 {
+		t.Errorf("unexpected meta: %+v", meta)
+	}
+}
+
+// TestHeapFormat tests that the format set before a commit is written
+// by it, dropped by Rollback, and checked when the file is loaded.
+func TestHeapFormat(t *testing.T) {
+	opt := formatOption{testOption: defaultOpt, format: Format{Version: 1, Required: 0b01, Optional: 0b10}}
+	file := new(mem.File)
+	var heap Heap[*mem.File]
+	_, ckpt, err := heap.Load(file, opt)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	ckpt.Release()
+	if format := heap.Format(); format != (Format{Version: 1}) {
+		t.Fatalf("new file format: %+v", format)
+	}
+
+	if err = heap.SetFormat(Format{Version: 2}); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+	if err = heap.SetFormat(Format{Version: 1, Optional: 0b100}); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+
+	if err = heap.SetFormat(Format{Version: 1, Optional: 0b10}); err != nil {
+		t.Fatalf("SetFormat failed: %v", err)
+	}
+	if err = heap.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if format := heap.Format(); format != (Format{Version: 1}) {
+		t.Fatalf("format after rollback: %+v", format)
+	}
+
+	want := Format{Version: 1, Required: 0b01, Optional: 0b10}
+	if err = heap.SetFormat(want); err != nil {
+		t.Fatalf("SetFormat failed: %v", err)
+	}
+	meta, ckpt, err := heap.Commit([]byte("entry"))
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	ckpt.Release()
+	if meta.format() != want {
+		t.Fatalf("committed format: %+v", meta.format())
+	}
+	var backup bytes.Buffer
+	file.WriteTo(&backup)
+	heap.Close()
+	file.ReadFrom(&backup)
+
+	meta, err = Inspect(file, defaultOpt.magicCode)
+	if err != nil {
+		t.Fatalf("Inspect failed: %v", err)
+	}
+	if meta.format() != want {
+		t.Fatalf("inspected format: %+v", meta.format())
+	}
+
+	// an opener without the required feature cannot load the file
+	var old Heap[*mem.File]
+	if _, _, err = old.Load(file, formatOption{testOption: defaultOpt, format: Format{Version: 1}}); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+
+	// an opener without the optional feature drops it on commit
+	var heap2 Heap[*mem.File]
+	_, ckpt, err = heap2.Load(file, formatOption{testOption: defaultOpt, format: Format{Version: 1, Required: 0b01}})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	ckpt.Release()
+	if format := heap2.Format(); format != (Format{Version: 1, Required: 0b01}) {
+		t.Fatalf("loaded format: %+v", format)
+	}
+	meta, ckpt, err = heap2.Commit([]byte("entry"))
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	ckpt.Release()
+	if meta.Optional != 0 {
+		t.Fatalf("optional features kept: %#x", meta.Optional)
+	}
+	heap2.Close()
+}
@@ -71,10 +71,10 @@ func (heap *Heap[F]) restore(meta *Meta) (err error) {
 	load := func(blockID BlockID) (Freelist, error) {
 		freelist := Freelist(heap.buffer)
 		if _, err := heap.block.readAt(freelist, blockID); err != nil {
-			return nil, fmt.Errorf("read freelist(%d) failed: %w", blockID, err)
+			return nil, &BlockError{Op: "heap.restore", BlockID: blockID, Err: fmt.Errorf("read freelist: %w", err)}
 		}
 		if freelist.invalid() {
-			return nil, fmt.Errorf("block(%d) is %w", blockID, ErrBadFreelist)
+			return nil, &BlockError{Op: "heap.restore", BlockID: blockID, Err: ErrBadFreelist}
 		}
 		return freelist, nil
 	}
@@ -125,7 +125,7 @@ func (heap *Heap[F]) restore(meta *Meta) (err error) {
 }
 
 func (heap *Heap[F]) allocate(recycle func(BlockID)) (blockID BlockID, reuse bool) {
-	for heap.head != nil && heap.head.ref.Load() <= 0 {
+	for heap.head != nil && heap.head.ref.Load() <= 0 && !heap.held(heap.head) {
 		if heap.head.recycled == 0 {
 			heap.head = heap.head.next
 			continue
@@ -150,7 +150,7 @@ func (heap *Heap[F]) allocate(recycle func(BlockID)) (blockID BlockID, reuse boo
 				if prevID != 0 {
 					heap.free.queue.unshift(prevID)
 				}
-				heap.phase.CompareAndSwap(readwrite, &phase{error: fmt.Errorf("heap.allocate: read freelist(%d) failed: %w", nextID, err)})
+				heap.phase.CompareAndSwap(readwrite, &phase{error: &BlockError{Op: "heap.allocate", BlockID: nextID, Err: fmt.Errorf("read freelist: %w", err)}})
 				break
 			}
 
@@ -158,7 +158,7 @@ func (heap *Heap[F]) allocate(recycle func(BlockID)) (blockID BlockID, reuse boo
 				if prevID != 0 {
 					heap.free.queue.unshift(prevID)
 				}
-				heap.phase.CompareAndSwap(readwrite, &phase{error: fmt.Errorf("heap.allocate: block(%d) is %w", nextID, ErrBadFreelist)})
+				heap.phase.CompareAndSwap(readwrite, &phase{error: &BlockError{Op: "heap.allocate", BlockID: nextID, Err: ErrBadFreelist}})
 				break
 			}
 
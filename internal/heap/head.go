@@ -29,27 +29,9 @@ func (heap *Heap[F]) load(file F, opt Option) (meta *Meta, err error) {
 		return heap.init(file, opt)
 	}
 
-	if metaA != nil && metaB != nil {
-		if metaA.Ckp < metaB.Ckp {
-			if metaA.Ckp == 0 && metaA.Ckp-1 == metaB.Ckp {
-				meta = metaA
-			} else {
-				meta = metaB
-			}
-		} else {
-			meta = metaA
-		}
-	} else if metaA != nil {
-		meta = metaA
-	} else if metaB != nil {
-		meta = metaB
-	} else {
-		panic(errors.New("metaA == nil && metaB == nil"))
-	}
-
-	if meta.Version != 0 {
+	meta = newerMeta(metaA, metaB)
+	if err = meta.format().Check(heap.supported); err != nil {
 		meta = nil
-		err = fmt.Errorf("%w meta version: %d", ErrUnsupported, meta.Version)
 		return
 	}
 	if meta.BlockCount > 2 {
@@ -68,11 +50,51 @@ func (heap *Heap[F]) load(file F, opt Option) (meta *Meta, err error) {
 	heap.ckp = meta.Ckp
 	heap.magic = magic
 	heap.metaID = meta.ID
+	heap.format = meta.format()
+	heap.format.Optional &= heap.supported.Optional
+	heap.committed = heap.format
 	heap.buffer = make([]byte, meta.BlockSize)
 	heap.block.load(file, meta.BlockSize, meta.BlockCount)
 	return
 }
 
+func newerMeta(metaA, metaB *Meta) *Meta {
+	if metaA != nil && metaB != nil {
+		if metaA.Ckp < metaB.Ckp {
+			if metaA.Ckp == 0 && metaA.Ckp-1 == metaB.Ckp {
+				return metaA
+			}
+			return metaB
+		}
+		return metaA
+	} else if metaA != nil {
+		return metaA
+	} else if metaB != nil {
+		return metaB
+	}
+	panic(errors.New("metaA == nil && metaB == nil"))
+}
+
+// latest reads both meta blocks again and returns the newer one,
+// which may have been committed by a writer in another process.
+func (heap *Heap[F]) latest() (meta *Meta, err error) {
+	metaA, metaB, err := loadMeta(io.NewSectionReader(heap.block.file, 0, 1<<17), heap.magic)
+	if err != nil {
+		return
+	}
+
+	meta = newerMeta(metaA, metaB)
+	if err = meta.format().Check(heap.supported); err != nil {
+		meta = nil
+		return
+	}
+	if int64(meta.BlockSize) != heap.block.size {
+		err = fmt.Errorf("%w: block size changed", ErrBadMeta)
+		meta = nil
+	}
+	return
+}
+
 func loadMeta[RS io.ReadSeeker](f RS, magic [4]byte) (metaA, metaB *Meta, err error) {
 	load := func(offset int64) (meta *Meta, err error) {
 		if _, err = f.Seek(offset, io.SeekStart); err != nil {
@@ -126,6 +148,8 @@ func (heap *Heap[F]) init(file F, opt Option) (meta *Meta, err error) {
 		return
 	}
 
+	meta0.Version = heap.supported.Version
+
 	magic := opt.MagicCode()
 	buffer := make([]byte, meta0.BlockSize)
 	{
@@ -154,6 +178,8 @@ func (heap *Heap[F]) init(file F, opt Option) (meta *Meta, err error) {
 	heap.ckp = meta.Ckp
 	heap.magic = magic
 	heap.metaID = meta.ID
+	heap.format = meta.format()
+	heap.committed = heap.format
 	heap.buffer = buffer
 	heap.block.load(file, meta.BlockSize, meta.BlockCount)
 	return
@@ -198,7 +224,7 @@ func (heap *Heap[F]) flush(meta *Meta) (err error) {
 		}
 	}
 
-	if err = heap.block.sync(); err != nil {
+	if err = heap.fsync(); err != nil {
 		return
 	}
 
@@ -211,5 +237,5 @@ func (heap *Heap[F]) flush(meta *Meta) (err error) {
 		return
 	}
 
-	return heap.block.sync()
+	return heap.fsync()
 }
@@ -28,10 +28,16 @@ type Heap[F File] struct {
 	mutex sync.Mutex
 
 	ckp    uint32
+	hold   uint32
 	magic  [4]byte
 	metaID BlockID
 
+	format, committed, supported Format
+
 	ignoreInvalidFreelist bool
+
+	observer Observer
+	observed observed
 }
 
 type phase struct{ error }
@@ -44,6 +50,7 @@ type Checkpoint = *checkpoint
 type checkpoint struct {
 	next     *checkpoint
 	recycled uint32
+	ckp      uint32 // 0 if unknown
 	ref      atomic.Int32
 }
 
@@ -61,6 +68,11 @@ func (ckpt *checkpoint) Valid() bool {
 	return ckpt.ref.Load() > 1
 }
 
+// Ckp returns the commit number of the checkpoint.
+func (ckpt *checkpoint) Ckp() uint32 {
+	return ckpt.ckp
+}
+
 func (heap *Heap[F]) PageSize() int {
 	return heap.BlockSize() - heap.codec.size()
 }
@@ -73,6 +85,8 @@ func (heap *Heap[F]) Load(file F, opt Option) (meta *Meta, ckpt Checkpoint, err
 		panic("heap.Load: already open")
 	}
 
+	heap.observer = getObserver(opt)
+	heap.supported = getSupportedFormat(opt)
 	meta, err = heap.load(file, opt)
 	if err != nil {
 		err = fmt.Errorf("heap.Load: %w", err)
@@ -82,8 +96,10 @@ func (heap *Heap[F]) Load(file F, opt Option) (meta *Meta, ckpt Checkpoint, err
 
 	if opt.ReadOnly() {
 		ckpt = new(checkpoint)
+		ckpt.ckp = meta.Ckp
 		ckpt.Acquire()
 		heap.head = ckpt
+		heap.tail = ckpt
 		heap.phase.Store(readonly)
 		return
 	}
@@ -99,9 +115,10 @@ func (heap *Heap[F]) Load(file F, opt Option) (meta *Meta, ckpt Checkpoint, err
 
 	heap.head = nil
 	countdown := int(opt.RetainCheckpoints())
-	replay := func(recycled uint32) {
+	replay := func(recycled, ckp uint32) {
 		ckpt := new(checkpoint)
 		ckpt.recycled = recycled
+		ckpt.ckp = ckp
 		ckpt.next = heap.head
 		heap.head = ckpt
 		if countdown >= 0 {
@@ -113,7 +130,7 @@ func (heap *Heap[F]) Load(file F, opt Option) (meta *Meta, ckpt Checkpoint, err
 		countdown--
 	}
 
-	replay(meta.FreeRecycled)
+	replay(meta.FreeRecycled, meta.Ckp)
 	heap.tail = heap.head
 	ckpt = heap.tail
 	ckpt.Acquire()
@@ -130,7 +147,7 @@ func (heap *Heap[F]) Load(file F, opt Option) (meta *Meta, ckpt Checkpoint, err
 			break
 		}
 
-		replay(min(meta.FreeRecycled, rest))
+		replay(min(meta.FreeRecycled, rest), meta.Ckp)
 
 		if rest -= heap.head.recycled; rest == 0 {
 			break
@@ -139,10 +156,12 @@ func (heap *Heap[F]) Load(file F, opt Option) (meta *Meta, ckpt Checkpoint, err
 		prevID = meta.PrevID
 	}
 	if rest > 0 {
-		replay(rest)
+		// blocks of unknown checkpoints were recycled no later than
+		// the checkpoint before the oldest known one
+		replay(rest, heap.head.ckp-1)
 	}
 	for countdown >= 0 {
-		replay(0)
+		replay(0, 0)
 	}
 
 	heap.phase.Store(readwrite)
@@ -193,6 +212,8 @@ func (heap *Heap[F]) Close() error {
 	heap.free = free{}
 	heap.codec = codec{}
 	heap.buffer = nil
+	heap.observer = nil
+	heap.observed = observed{}
 	return heap.block.close()
 }
 
@@ -220,6 +241,9 @@ func (heap *Heap[F]) extend() BlockID {
 func (heap *Heap[F]) Allocate() (blockID BlockID, reuse bool) {
 	heap.mutex.Lock()
 	blockID, reuse = heap.allocate(heap.recycle)
+	if heap.observer != nil && blockID > 1 {
+		heap.observed.allocated++
+	}
 	heap.mutex.Unlock()
 	return
 }
@@ -229,6 +253,9 @@ func (heap *Heap[F]) RecycleN(iter func(yield func(BlockID) bool)) {
 	for blockID := range iter {
 		assertBlockID("heap.RecycleN", blockID)
 		heap.recycle(blockID)
+		if heap.observer != nil {
+			heap.observed.recycled++
+		}
 	}
 	heap.mutex.Unlock()
 }
@@ -237,11 +264,14 @@ func (heap *Heap[F]) Recycle(blockID BlockID) {
 	assertBlockID("heap.Recycle", blockID)
 	heap.mutex.Lock()
 	heap.recycle(blockID)
+	if heap.observer != nil {
+		heap.observed.recycled++
+	}
 	heap.mutex.Unlock()
 }
 
 func (heap *Heap[F]) ReadBlock(blockID BlockID, buffer []byte) (err error) {
-	if phase := heap.phase.Load(); phase != readwrite {
+	if phase := heap.phase.Load(); phase != readwrite && phase != readonly {
 		if phase == nil {
 			err = ErrClosed
 			return
@@ -250,14 +280,22 @@ func (heap *Heap[F]) ReadBlock(blockID BlockID, buffer []byte) (err error) {
 		return
 	}
 
-	if _, err = heap.block.readAt(buffer, blockID); err != nil {
-		return
+	if heap.observer != nil {
+		start := time.Now()
+		defer func() { heap.observer.BlockRead(blockID, len(buffer), time.Since(start), err) }()
+	}
+
+	if _, err = heap.block.readAt(buffer, blockID); err == nil {
+		err = heap.codec.decode(buffer, blockID)
+	}
+	if err != nil {
+		err = &BlockError{Op: "heap.ReadBlock", BlockID: blockID, Err: err}
 	}
-	return heap.codec.decode(buffer, blockID)
+	return
 }
 
 func (heap *Heap[F]) ReadAt(buffer []byte, blockID BlockID) (n int, err error) {
-	if phase := heap.phase.Load(); phase != readwrite {
+	if phase := heap.phase.Load(); phase != readwrite && phase != readonly {
 		if phase == nil {
 			err = ErrClosed
 			return
@@ -266,7 +304,15 @@ func (heap *Heap[F]) ReadAt(buffer []byte, blockID BlockID) (n int, err error) {
 		return
 	}
 
-	return heap.block.readAt(buffer, blockID)
+	if heap.observer != nil {
+		start := time.Now()
+		defer func() { heap.observer.BlockRead(blockID, n, time.Since(start), err) }()
+	}
+
+	if n, err = heap.block.readAt(buffer, blockID); err != nil {
+		err = &BlockError{Op: "heap.ReadAt", BlockID: blockID, Err: err}
+	}
+	return
 }
 
 func (heap *Heap[F]) WriteBlock(blockID BlockID, buffer []byte) (err error) {
@@ -284,10 +330,15 @@ func (heap *Heap[F]) WriteBlock(blockID BlockID, buffer []byte) (err error) {
 		return
 	}
 
+	if heap.observer != nil {
+		start := time.Now()
+		defer func() { heap.observer.BlockWrite(blockID, len(buffer), time.Since(start), err) }()
+	}
+
 	heap.codec.encode(buffer, blockID)
 
 	if _, err = heap.block.writeAt(buffer, blockID); err != nil {
-		err = fmt.Errorf("heap.WriteBlock(%d): %w", blockID, err)
+		err = &BlockError{Op: "heap.WriteBlock", BlockID: blockID, Err: err}
 		heap.phase.CompareAndSwap(readwrite, &phase{err})
 	}
 	return
@@ -308,8 +359,13 @@ func (heap *Heap[F]) WriteAt(buffer []byte, blockID BlockID) (n int, err error)
 		return
 	}
 
+	if heap.observer != nil {
+		start := time.Now()
+		defer func() { heap.observer.BlockWrite(blockID, n, time.Since(start), err) }()
+	}
+
 	if n, err = heap.block.writeAt(buffer, blockID); err != nil {
-		err = fmt.Errorf("heap.WriteAt(%d): %w", blockID, err)
+		err = &BlockError{Op: "heap.WriteAt", BlockID: blockID, Err: err}
 		heap.phase.CompareAndSwap(readwrite, &phase{err})
 	}
 	return
@@ -353,6 +409,8 @@ func (heap *Heap[F]) Rollback() (err error) {
 	}
 
 	rollback := meta.FreeRecycled + meta.FreeTotal - heap.free.total
+	heap.observed = observed{}
+	heap.format = heap.committed
 
 	if err = heap.restore(meta); err != nil {
 		err = fmt.Errorf("heap.Rollback: %w", err)
@@ -375,10 +433,122 @@ func (heap *Heap[F]) Rollback() (err error) {
 	return
 }
 
+// Latest returns the commit number of the newest checkpoint in the file,
+// which may be ahead of the loaded one if another process writes the file.
+func (heap *Heap[F]) Latest() (ckp uint32, err error) {
+	if phase := heap.phase.Load(); phase != readwrite && phase != readonly {
+		if phase == nil {
+			err = ErrClosed
+			return
+		}
+		err = phase.error
+		return
+	}
+
+	heap.mutex.Lock()
+	defer heap.mutex.Unlock()
+
+	if heap.phase.Load() == readwrite {
+		ckp = heap.ckp
+		return
+	}
+
+	meta, err := heap.latest()
+	if err != nil {
+		err = fmt.Errorf("heap.Latest: %w", err)
+		return
+	}
+	ckp = meta.Ckp
+	return
+}
+
+// Refresh loads the newest checkpoint committed by another process into
+// a read-only heap. It returns a nil meta if there is nothing newer.
+// Earlier checkpoints stay readable until released.
+func (heap *Heap[F]) Refresh() (meta *Meta, ckpt Checkpoint, err error) {
+	heap.mutex.Lock()
+	defer heap.mutex.Unlock()
+
+	if phase := heap.phase.Load(); phase != readonly {
+		if phase == readwrite {
+			return
+		}
+		if phase == nil {
+			err = ErrClosed
+			return
+		}
+		err = phase.error
+		return
+	}
+
+	if meta, err = heap.latest(); err != nil {
+		err = fmt.Errorf("heap.Refresh: %w", err)
+		return
+	}
+	if meta.Ckp == heap.ckp {
+		meta = nil
+		return
+	}
+	if err = heap.codec.reload(heap.block.file, meta); err != nil {
+		meta = nil
+		err = fmt.Errorf("heap.Refresh: %w", err)
+		return
+	}
+
+	heap.ckp = meta.Ckp
+	heap.metaID = meta.ID
+	heap.format = meta.format()
+	heap.format.Optional &= heap.supported.Optional
+	heap.committed = heap.format
+	heap.block.count = meta.BlockCount
+	heap.block.limit = meta.BlockCount
+
+	ckpt = new(checkpoint)
+	ckpt.ckp = meta.Ckp
+	ckpt.Acquire()
+	heap.tail.next = ckpt
+	heap.tail = ckpt
+	return
+}
+
+// Oldest returns the commit number of the oldest checkpoint of a
+// read-only heap that is still acquired.
+func (heap *Heap[F]) Oldest() (ckp uint32) {
+	heap.mutex.Lock()
+	defer heap.mutex.Unlock()
+
+	if heap.phase.Load() != readonly {
+		return heap.ckp
+	}
+
+	for heap.head != heap.tail && heap.head.ref.Load() <= 0 {
+		heap.head = heap.head.next
+	}
+	return heap.head.ckp
+}
+
+// Hold keeps blocks recycled after checkpoint ckp from being reused,
+// so that readers of ckp in other processes stay consistent.
+// Zero releases the hold.
+func (heap *Heap[F]) Hold(ckp uint32) {
+	heap.mutex.Lock()
+	heap.hold = ckp
+	heap.mutex.Unlock()
+}
+
+func (heap *Heap[F]) held(ckpt *checkpoint) bool {
+	return heap.hold != 0 && ckpt.ckp != 0 && int32(ckpt.ckp-heap.hold) > 0
+}
+
 func (heap *Heap[F]) Commit(entry []byte) (meta *Meta, ckpt Checkpoint, err error) {
 	heap.mutex.Lock()
 	defer heap.mutex.Unlock()
 
+	if heap.observer != nil {
+		start := time.Now()
+		defer func() { heap.observeCommit(start, meta, err) }()
+	}
+
 	if phase := heap.phase.Load(); phase != readwrite {
 		if phase == readonly {
 			err = ErrReadOnly
@@ -413,6 +583,9 @@ func (heap *Heap[F]) Commit(entry []byte) (meta *Meta, ckpt Checkpoint, err erro
 	meta.BlockSize = uint32(heap.block.size)
 	meta.CodecSpec = heap.codec.spec
 	meta.Ckp = heap.ckp + 1
+	meta.Version = heap.format.Version
+	meta.Required = heap.format.Required
+	meta.Optional = heap.format.Optional
 
 	defer func() {
 		if err != nil {
@@ -425,6 +598,7 @@ func (heap *Heap[F]) Commit(entry []byte) (meta *Meta, ckpt Checkpoint, err erro
 
 		ckpt = new(checkpoint)
 		ckpt.recycled = meta.FreeRecycled
+		ckpt.ckp = meta.Ckp
 		ckpt.ref.Store(2)
 
 		heap.tail.next = ckpt
@@ -435,6 +609,7 @@ func (heap *Heap[F]) Commit(entry []byte) (meta *Meta, ckpt Checkpoint, err erro
 
 		heap.ckp = meta.Ckp
 		heap.metaID = meta.ID
+		heap.committed = heap.format
 
 		meta.Entry = entry
 		if meta.EntryID > 1 {
@@ -3,8 +3,11 @@ package heap
 import (
 	"bytes"
 	"crypto/rand"
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/dacapoday/smol"
 	"github.com/dacapoday/smol/mem"
 )
 
@@ -104,6 +107,29 @@ func TestHeapReadWriteBlock(t *testing.T) {
 	heap.Close()
 }
 
+func TestHeapReadBlockError(t *testing.T) {
+	heap, file, ckpt := newTestHeap(t, defaultOpt)
+	ckpt.Release()
+	defer heap.Close()
+
+	bid, _ := heap.Allocate()
+	buffer := make([]byte, heap.BlockSize())
+	rand.Read(buffer[:heap.PageSize()])
+	if err := heap.WriteBlock(bid, buffer); err != nil {
+		t.Fatalf("WriteBlock failed: %v", err)
+	}
+	file.WriteAt([]byte{buffer[0] ^ 0xff}, int64(bid)*int64(heap.BlockSize()))
+
+	err := heap.ReadBlock(bid, buffer)
+	var blockErr *BlockError
+	if !errors.As(err, &blockErr) || blockErr.BlockID != bid || blockErr.Op != "heap.ReadBlock" {
+		t.Fatalf("expected BlockError for block %d, got %v", bid, err)
+	}
+	if !errors.Is(err, ErrBadChecksum) {
+		t.Errorf("expected ErrBadChecksum, got %v", err)
+	}
+}
+
 func TestHeapAllocateRecycle(t *testing.T) {
 	heap, _, ckpt := newTestHeap(t, defaultOpt)
 	ckpt.Release()
@@ -227,6 +253,10 @@ func TestHeapReadOnly(t *testing.T) {
 		t.Errorf("expected ErrReadOnly, got %v", heap2.Error())
 	}
 
+	if _, err = heap2.ReadAt(make([]byte, 4), 0); err != nil {
+		t.Errorf("ReadAt should succeed: %v", err)
+	}
+
 	_, err = heap2.WriteAt([]byte("x"), 2)
 	if err != ErrReadOnly {
 		t.Errorf("WriteAt should fail: %v", err)
@@ -239,3 +269,156 @@ func TestHeapReadOnly(t *testing.T) {
 
 	heap2.Close()
 }
+
+func TestHeapRefresh(t *testing.T) {
+	heap, file, ckpt := newTestHeap(t, defaultOpt)
+	ckpt.Release()
+	_, ckpt, _ = heap.Commit([]byte("v1"))
+	ckpt.Release()
+
+	opt := defaultOpt
+	opt.readOnly = true
+	var reader Heap[*mem.File]
+	meta, ckpt1, err := reader.Load(file, opt)
+	if err != nil {
+		t.Fatalf("readonly load failed: %v", err)
+	}
+	if ckpt1.Ckp() != meta.Ckp {
+		t.Errorf("Ckp: got %d, want %d", ckpt1.Ckp(), meta.Ckp)
+	}
+
+	meta, _, err = reader.Refresh()
+	if err != nil || meta != nil {
+		t.Fatalf("Refresh without commit: meta=%v err=%v", meta, err)
+	}
+
+	_, ckpt, _ = heap.Commit([]byte("v2"))
+	ckpt.Release()
+
+	if ckp, err := reader.Latest(); err != nil || ckp != heap.ckp {
+		t.Errorf("Latest: got %d %v, want %d", ckp, err, heap.ckp)
+	}
+
+	meta, ckpt2, err := reader.Refresh()
+	if err != nil || meta == nil {
+		t.Fatalf("Refresh: meta=%v err=%v", meta, err)
+	}
+	if !bytes.Equal(meta.Entry, []byte("v2")) {
+		t.Errorf("entry after refresh: %q", meta.Entry)
+	}
+
+	if ckp := reader.Oldest(); ckp != ckpt1.Ckp() {
+		t.Errorf("Oldest: got %d, want %d", ckp, ckpt1.Ckp())
+	}
+	ckpt1.Release()
+	if ckp := reader.Oldest(); ckp != ckpt2.Ckp() {
+		t.Errorf("Oldest after release: got %d, want %d", ckp, ckpt2.Ckp())
+	}
+	ckpt2.Release()
+
+	reader.Close()
+	heap.Close()
+}
+
+func TestHeapHold(t *testing.T) {
+	heap, _, ckpt := newTestHeap(t, defaultOpt)
+	ckpt.Release()
+
+	bid, _ := heap.Allocate()
+	_, ckpt, _ = heap.Commit([]byte("v1"))
+	ckpt.Release()
+
+	heap.Hold(heap.ckp)
+	heap.Recycle(bid)
+	for i := 0; i < 3; i++ {
+		_, ckpt, _ := heap.Commit([]byte{byte(i)})
+		ckpt.Release()
+	}
+
+	for i := 0; i < 8; i++ {
+		if got, _ := heap.Allocate(); got == bid {
+			t.Fatalf("held block %d reused", bid)
+		}
+	}
+
+	heap.Hold(0)
+	if _, reuse := heap.Allocate(); !reuse {
+		t.Error("expected reuse after hold released")
+	}
+
+	heap.Close()
+}
+
+type observedOption struct {
+	testOption
+	observer Observer
+}
+
+func (o observedOption) Observer() Observer { return o.observer }
+
+type countingObserver struct {
+	smol.NopObserver
+	reads, writes, readBytes, writeBytes int
+	commits                              []CommitInfo
+}
+
+func (o *countingObserver) BlockRead(_ BlockID, n int, _ time.Duration, err error) {
+	o.reads++
+	o.readBytes += n
+}
+
+func (o *countingObserver) BlockWrite(_ BlockID, n int, _ time.Duration, err error) {
+	o.writes++
+	o.writeBytes += n
+}
+
+func (o *countingObserver) Commit(info CommitInfo) {
+	o.commits = append(o.commits, info)
+}
+
+func TestHeapObserver(t *testing.T) {
+	observer := new(countingObserver)
+	var heap Heap[*mem.File]
+	_, ckpt, err := heap.Load(new(mem.File), observedOption{defaultOpt, observer})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer heap.Close()
+
+	buffer := make([]byte, heap.BlockSize())
+	bid1, _ := heap.Allocate()
+	bid2, _ := heap.Allocate()
+	heap.WriteBlock(bid1, buffer)
+	heap.WriteBlock(bid2, buffer)
+	heap.ReadBlock(bid1, buffer)
+	if observer.writes != 2 || observer.writeBytes != 2*len(buffer) || observer.reads != 1 || observer.readBytes != len(buffer) {
+		t.Fatalf("observed %d writes of %d bytes, %d reads of %d bytes", observer.writes, observer.writeBytes, observer.reads, observer.readBytes)
+	}
+
+	_, ckpt2, err := heap.Commit([]byte("v1"))
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	heap.Recycle(bid2)
+	_, ckpt3, err := heap.Commit([]byte("v2"))
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	ckpt3.Release()
+
+	if len(observer.commits) != 2 {
+		t.Fatalf("observed %d commits, want 2", len(observer.commits))
+	}
+	first, second := observer.commits[0], observer.commits[1]
+	if first.Ckp == 0 || first.Allocated != 2 || first.Recycled != 0 || first.Err != nil {
+		t.Errorf("first commit: %+v", first)
+	}
+	if second.Ckp != first.Ckp+1 || second.Allocated != 0 || second.Recycled != 1 {
+		t.Errorf("second commit: %+v", second)
+	}
+	if first.Retained != 1 || second.Retained != 2 {
+		t.Errorf("retained %d then %d checkpoints, want 1 then 2", first.Retained, second.Retained)
+	}
+	ckpt.Release()
+	ckpt2.Release()
+}
@@ -32,9 +32,14 @@ type Meta struct {
 	Ckp        uint32 // Checkpoint identifier (key: 5)
 	UpdateTime int64  // Last update timestamp (key: 6)
 
-	Version byte // Version (key: 1)
+	Optional uint64 // Optional feature bits, see Format (key: 3)
+	Required uint64 // Required feature bits, see Format (key: 2)
+	Version  byte   // Version (key: 1)
 }
 
+// decodeMeta skips unknown tags, so that fields added later can be read
+// by older versions: a field that changes how a file must be read comes
+// with a version or a required feature bit, see Format.
 func decodeMeta[R io.Reader](f R, meta *Meta) (err error) {
 	c := crc32.New(castagnoliCrcTable)
 	r := io.TeeReader(f, c)
@@ -127,6 +132,16 @@ func decodeMeta[R io.Reader](f R, meta *Meta) (err error) {
 				return
 			}
 			meta.Version = byte(val)
+		case 2:
+			if val, err = d.readVal(); err != nil {
+				return
+			}
+			meta.Required = val
+		case 3:
+			if val, err = d.readVal(); err != nil {
+				return
+			}
+			meta.Optional = val
 		case 0:
 			var buf [4]byte
 			if _, err = io.ReadFull(f, buf[:]); err != nil {
@@ -141,7 +156,14 @@ func decodeMeta[R io.Reader](f R, meta *Meta) (err error) {
 			}
 			return
 		default:
-			return fmt.Errorf("%w meta tag: %d", ErrUnsupported, key)
+			if val, err = d.readVal(); err != nil {
+				return
+			}
+			if key < 0 {
+				if _, err = d.readBytes(val); err != nil {
+					return
+				}
+			}
 		}
 	}
 }
@@ -153,6 +175,12 @@ func encodeMeta[W io.Writer](f W, meta *Meta) (err error) {
 	if err = e.writeVal(1, uint64(meta.Version)); err != nil {
 		return
 	}
+	if err = e.writeVal(2, meta.Required); err != nil {
+		return
+	}
+	if err = e.writeVal(3, meta.Optional); err != nil {
+		return
+	}
 	if err = e.writeBytes(14, meta.CodecSpec); err != nil {
 		return
 	}
@@ -275,6 +303,8 @@ func (e tlvEncoder) writeBytes(key int64, val []byte) (err error) {
 
 func sizeMeta(meta *Meta) int {
 	size := sizeVal(1, uint64(meta.Version))
+	size += sizeVal(2, meta.Required)
+	size += sizeVal(3, meta.Optional)
 	size += sizeBytes(14, meta.CodecSpec)
 	size += sizeBytes(15, meta.Entry)
 	size += sizeBytes(13, meta.Freelist)
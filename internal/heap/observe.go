@@ -0,0 +1,65 @@
+// Copyright 2025 dacapoday
+// SPDX-License-Identifier: Apache-2.0
+
+package heap
+
+import (
+	"time"
+
+	"github.com/dacapoday/smol"
+)
+
+type Observer = smol.Observer
+type CommitInfo = smol.CommitInfo
+
+// WithObserver is an Option that reports heap events to an Observer.
+type WithObserver interface {
+	Observer() Observer
+}
+
+func getObserver(opt any) (observer Observer) {
+	if o, ok := opt.(WithObserver); ok {
+		observer = o.Observer()
+	}
+	return
+}
+
+// observed counts the work of the current transaction for CommitInfo.
+type observed struct {
+	allocated int
+	recycled  int
+	synced    time.Duration
+}
+
+// fsync syncs the file, timing it when observed.
+func (heap *Heap[F]) fsync() (err error) {
+	if heap.observer == nil {
+		return heap.block.sync()
+	}
+	start := time.Now()
+	err = heap.block.sync()
+	heap.observed.synced += time.Since(start)
+	return
+}
+
+// observeCommit reports a commit that started at start, and resets the
+// counts for the next transaction.
+func (heap *Heap[F]) observeCommit(start time.Time, meta *Meta, err error) {
+	info := CommitInfo{
+		Duration:  time.Since(start),
+		Sync:      heap.observed.synced,
+		Allocated: heap.observed.allocated,
+		Recycled:  heap.observed.recycled,
+		Err:       err,
+	}
+	if meta != nil {
+		info.Ckp = meta.Ckp
+	}
+	for cur := heap.head; cur != nil && cur != heap.tail; cur = cur.next {
+		if cur.ref.Load() > 0 {
+			info.Retained++
+		}
+	}
+	heap.observed = observed{}
+	heap.observer.Commit(info)
+}
@@ -27,7 +27,7 @@ func (iter *Combine[Over, Base]) Load(over Over, base Base, idle *Combine[Over,
 	}
 }
 
-var _ Iterator = (*Combine[Iterator, Iterator])(nil)
+var _ SeekerLE = (*Combine[Iterator, Iterator])(nil)
 
 // Next advances to the next key.
 func (iter *Combine[Over, Base]) Next() bool {
@@ -87,3 +87,14 @@ func (iter *Combine[Over, Base]) Seek(key []byte) bool {
 	}
 	return true
 }
+
+// SeekLE positions the iterator at the last key <= the given key.
+func (iter *Combine[Over, Base]) SeekLE(key []byte) bool {
+	if !iter.merge.SeekLE(key) {
+		return false
+	}
+	if iter.merge.cover && iter.merge.over.Val() == nil {
+		return iter.Prev()
+	}
+	return true
+}
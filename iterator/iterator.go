@@ -4,8 +4,13 @@
 //   - Iterator interface: bidirectional cursor over sorted key-value pairs
 //   - Merge: combines two sorted iterators with overlay semantics (LSM-tree style)
 //   - Combine: extends Merge to filter out tombstone entries (nil values)
+//   - MergeN, CombineN: the same over any number of iterators, in order of precedence
+//   - Filter, Map, Range, Prefix, Limit, Reverse: views that wrap an iterator
+//   - SeekLE: positions any iterator at the last key <= a key, natively if it is a SeekerLE
 package iterator
 
+import "bytes"
+
 // Iterator represents a cursor over a sorted key-value dataset.
 // The iterator maintains a current position and can be moved forward or backward
 // through the dataset in sorted key order.
@@ -70,3 +75,33 @@ type Iterator interface {
 	// false otherwise. Use Error() to check for errors.
 	Seek(key []byte) bool
 }
+
+// SeekerLE is an Iterator that seeks backward natively, for descending scans
+// from an arbitrary key without the extra moves of Seek followed by Prev.
+type SeekerLE interface {
+	Iterator
+
+	// SeekLE positions the iterator at the last key that is less than or equal
+	// to the given key. Returns true if positioned at a valid entry,
+	// false otherwise. Use Error() to check for errors.
+	SeekLE(key []byte) bool
+}
+
+// SeekLE positions iter at the last key <= key, by its own SeekLE if it is
+// a SeekerLE. Otherwise it seeks the first key >= key and steps back from it
+// unless it is key, or positions at the last key if there is none.
+func SeekLE[Iter Iterator](iter Iter, key []byte) bool {
+	if seeker, ok := any(iter).(SeekerLE); ok {
+		return seeker.SeekLE(key)
+	}
+	if !iter.Seek(key) {
+		if iter.Error() != nil {
+			return false
+		}
+		return iter.SeekLast()
+	}
+	if bytes.Equal(iter.Key(), key) {
+		return true
+	}
+	return iter.Prev()
+}
@@ -93,7 +93,7 @@ func (iter *Merge[Over, Base]) Cover() bool {
 	return iter.cover
 }
 
-var _ Iterator = (*Merge[Iterator, Iterator])(nil)
+var _ SeekerLE = (*Merge[Iterator, Iterator])(nil)
 
 // Valid returns true if the iterator points to a valid key-value pair.
 func (iter *Merge[Over, Base]) Valid() bool {
@@ -264,6 +264,14 @@ func (iter *Merge[Over, Base]) Seek(key []byte) bool {
 	return iter.mergeNext(over, base)
 }
 
+// SeekLE positions the iterator at the last key <= the given key,
+// seeking each child natively if it is a SeekerLE.
+func (iter *Merge[Over, Base]) SeekLE(key []byte) bool {
+	over := SeekLE(iter.over, key)
+	base := SeekLE(iter.base, key)
+	return iter.mergePrev(over, base)
+}
+
 // mergeNext transitions to the next state after forward operations.
 // Transitions from S1,S2,S3,S4,S5,S6,S7,S8,S9,S12 to S1,S2,S5,S6,S7,S10,S11,S12.
 func (iter *Merge[Over, Base]) mergeNext(over, base bool) bool {
@@ -0,0 +1,315 @@
+package iterator
+
+import "bytes"
+
+// MergeN merges any number of sorted iterators into a single sorted iterator,
+// such as several stores, snapshots or transaction layers queried together.
+//
+// Iterators earlier in the list take precedence: when several hold the same
+// key, the entry of the first is returned and the others are skipped, as Merge
+// does with its overlay. Positioned iterators are kept in a heap ordered by
+// key and precedence, so each step costs O(log n) comparisons, plus one move
+// per iterator holding a skipped duplicate. Changing direction repositions
+// every iterator.
+type MergeN[Iter Iterator] struct {
+	iters    []Iter
+	heap     []int // indexes into iters, smallest key on top, largest if backward
+	backward bool
+	err      error
+	key      []byte
+}
+
+// Load initializes the merge iterator with the given iterators,
+// in order of precedence. If idle is provided, copies its state;
+// otherwise initializes to default state.
+func (iter *MergeN[Iter]) Load(iters []Iter, idle *MergeN[Iter]) {
+	iter.iters = iters
+	iter.heap = iter.heap[:0]
+	iter.backward, iter.err = false, nil
+	if idle != nil {
+		iter.heap = append(iter.heap, idle.heap...)
+		iter.backward, iter.err = idle.backward, idle.err
+	}
+}
+
+// Iters returns the merged iterators.
+func (iter *MergeN[Iter]) Iters() []Iter {
+	return iter.iters
+}
+
+// Index returns the position in Iters of the iterator holding the current
+// key-value pair, or -1 if not positioned.
+func (iter *MergeN[Iter]) Index() int {
+	if !iter.Valid() {
+		return -1
+	}
+	return iter.heap[0]
+}
+
+var _ SeekerLE = (*MergeN[Iterator])(nil)
+
+// Valid returns true if the iterator points to a valid key-value pair.
+func (iter *MergeN[Iter]) Valid() bool {
+	return len(iter.heap) != 0 && iter.err == nil
+}
+
+// Error returns the first error encountered from the merged iterators, or nil.
+func (iter *MergeN[Iter]) Error() error {
+	return iter.err
+}
+
+// Key returns the current key from the iterator of highest precedence holding it.
+func (iter *MergeN[Iter]) Key() []byte {
+	if !iter.Valid() {
+		return nil
+	}
+	return iter.iters[iter.heap[0]].Key()
+}
+
+// Val returns the current value from the iterator of highest precedence holding it.
+func (iter *MergeN[Iter]) Val() []byte {
+	if !iter.Valid() {
+		return nil
+	}
+	return iter.iters[iter.heap[0]].Val()
+}
+
+// Next advances to the next key.
+func (iter *MergeN[Iter]) Next() bool {
+	if !iter.Valid() {
+		return false
+	}
+	iter.key = append(iter.key[:0], iter.Key()...)
+	if iter.backward {
+		iter.reset(false)
+		for i, it := range iter.iters {
+			ok := it.Seek(iter.key)
+			if ok && bytes.Equal(it.Key(), iter.key) {
+				ok = it.Next()
+			}
+			iter.push(i, ok)
+		}
+		iter.init()
+		return iter.Valid()
+	}
+	iter.skip(Iter.Next)
+	return iter.Valid()
+}
+
+// Prev moves to the previous key.
+func (iter *MergeN[Iter]) Prev() bool {
+	if !iter.Valid() {
+		return false
+	}
+	iter.key = append(iter.key[:0], iter.Key()...)
+	if !iter.backward {
+		iter.reset(true)
+		for i, it := range iter.iters {
+			iter.push(i, seekBefore(it, iter.key))
+		}
+		iter.init()
+		return iter.Valid()
+	}
+	iter.skip(Iter.Prev)
+	return iter.Valid()
+}
+
+// SeekFirst positions at the first key.
+func (iter *MergeN[Iter]) SeekFirst() bool {
+	iter.reset(false)
+	for i, it := range iter.iters {
+		iter.push(i, it.SeekFirst())
+	}
+	iter.init()
+	return iter.Valid()
+}
+
+// SeekLast positions at the last key.
+func (iter *MergeN[Iter]) SeekLast() bool {
+	iter.reset(true)
+	for i, it := range iter.iters {
+		iter.push(i, it.SeekLast())
+	}
+	iter.init()
+	return iter.Valid()
+}
+
+// Seek positions the iterator at the first key >= the given key.
+func (iter *MergeN[Iter]) Seek(key []byte) bool {
+	iter.reset(false)
+	for i, it := range iter.iters {
+		iter.push(i, it.Seek(key))
+	}
+	iter.init()
+	return iter.Valid()
+}
+
+// SeekLE positions the iterator at the last key <= the given key,
+// seeking each iterator natively if it is a SeekerLE.
+func (iter *MergeN[Iter]) SeekLE(key []byte) bool {
+	iter.reset(true)
+	for i, it := range iter.iters {
+		iter.push(i, SeekLE(it, key))
+	}
+	iter.init()
+	return iter.Valid()
+}
+
+func (iter *MergeN[Iter]) reset(backward bool) {
+	iter.heap = iter.heap[:0]
+	iter.backward, iter.err = backward, nil
+}
+
+// push adds iterator i to the heap if positioned, keeping its error otherwise.
+// The heap is restored by init.
+func (iter *MergeN[Iter]) push(i int, ok bool) {
+	if ok {
+		iter.heap = append(iter.heap, i)
+	} else if err := iter.iters[i].Error(); err != nil && iter.err == nil {
+		iter.err = err
+	}
+}
+
+// skip moves every iterator positioned at iter.key with move,
+// dropping those that run out.
+func (iter *MergeN[Iter]) skip(move func(Iter) bool) {
+	for len(iter.heap) != 0 && iter.err == nil {
+		top := iter.iters[iter.heap[0]]
+		if !bytes.Equal(top.Key(), iter.key) {
+			return
+		}
+		if !move(top) {
+			last := len(iter.heap) - 1
+			iter.push(iter.heap[0], false)
+			iter.heap[0] = iter.heap[last]
+			iter.heap = iter.heap[:last]
+		}
+		iter.down(0)
+	}
+}
+
+func (iter *MergeN[Iter]) init() {
+	for i := len(iter.heap)/2 - 1; i >= 0; i-- {
+		iter.down(i)
+	}
+}
+
+func (iter *MergeN[Iter]) down(i int) {
+	n := len(iter.heap)
+	for {
+		least := i
+		if l := 2*i + 1; l < n && iter.less(l, least) {
+			least = l
+		}
+		if r := 2*i + 2; r < n && iter.less(r, least) {
+			least = r
+		}
+		if least == i {
+			return
+		}
+		iter.heap[i], iter.heap[least] = iter.heap[least], iter.heap[i]
+		i = least
+	}
+}
+
+// less orders heap entries by key, reversed if backward,
+// then by precedence.
+func (iter *MergeN[Iter]) less(a, b int) bool {
+	a, b = iter.heap[a], iter.heap[b]
+	cmp := bytes.Compare(iter.iters[a].Key(), iter.iters[b].Key())
+	if iter.backward {
+		cmp = -cmp
+	}
+	if cmp != 0 {
+		return cmp < 0
+	}
+	return a < b
+}
+
+// CombineN extends MergeN to filter out entries with nil values.
+//
+// When the iterator of highest precedence holding a key has a nil value
+// (a tombstone), the key is skipped entirely rather than falling back to
+// the iterators after it, as Combine does with its overlay.
+type CombineN[Iter Iterator] struct {
+	mergeN[Iter]
+}
+
+type mergeN[Iter Iterator] = MergeN[Iter]
+
+// Load initializes the combine iterator with the given iterators,
+// in order of precedence. If idle is provided, copies its state;
+// otherwise initializes to default state.
+func (iter *CombineN[Iter]) Load(iters []Iter, idle *CombineN[Iter]) {
+	if idle == nil {
+		iter.mergeN.Load(iters, nil)
+	} else {
+		iter.mergeN.Load(iters, &idle.mergeN)
+	}
+}
+
+var _ SeekerLE = (*CombineN[Iterator])(nil)
+
+// Next advances to the next key.
+func (iter *CombineN[Iter]) Next() bool {
+	for iter.mergeN.Next() {
+		if iter.mergeN.Val() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Prev moves to the previous key.
+func (iter *CombineN[Iter]) Prev() bool {
+	for iter.mergeN.Prev() {
+		if iter.mergeN.Val() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// SeekFirst positions at the first key.
+func (iter *CombineN[Iter]) SeekFirst() bool {
+	if !iter.mergeN.SeekFirst() {
+		return false
+	}
+	if iter.mergeN.Val() == nil {
+		return iter.Next()
+	}
+	return true
+}
+
+// SeekLast positions at the last key.
+func (iter *CombineN[Iter]) SeekLast() bool {
+	if !iter.mergeN.SeekLast() {
+		return false
+	}
+	if iter.mergeN.Val() == nil {
+		return iter.Prev()
+	}
+	return true
+}
+
+// Seek positions the iterator at the first key >= the given key.
+func (iter *CombineN[Iter]) Seek(key []byte) bool {
+	if !iter.mergeN.Seek(key) {
+		return false
+	}
+	if iter.mergeN.Val() == nil {
+		return iter.Next()
+	}
+	return true
+}
+
+// SeekLE positions the iterator at the last key <= the given key.
+func (iter *CombineN[Iter]) SeekLE(key []byte) bool {
+	if !iter.mergeN.SeekLE(key) {
+		return false
+	}
+	if iter.mergeN.Val() == nil {
+		return iter.Prev()
+	}
+	return true
+}
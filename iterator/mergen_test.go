@@ -0,0 +1,180 @@
+package iterator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"testing"
+)
+
+// sliceIter iterates sorted items, failing with err once moved fail times.
+type sliceIter struct {
+	keys, vals [][]byte
+	i          int
+	fail       int
+	err        error
+}
+
+func (it *sliceIter) at(i int) bool {
+	if it.fail--; it.fail == 0 {
+		it.err = errors.New("broken")
+	}
+	if it.err != nil || i < 0 || i >= len(it.keys) {
+		it.i = -1
+		return false
+	}
+	it.i = i
+	return true
+}
+
+func (it *sliceIter) Valid() bool  { return it.i >= 0 && it.err == nil }
+func (it *sliceIter) Error() error { return it.err }
+func (it *sliceIter) Key() []byte  { return it.keys[it.i] }
+func (it *sliceIter) Val() []byte  { return it.vals[it.i] }
+func (it *sliceIter) Next() bool   { return it.i >= 0 && it.at(it.i+1) }
+func (it *sliceIter) Prev() bool   { return it.i >= 0 && it.at(it.i-1) }
+func (it *sliceIter) SeekFirst() bool {
+	return it.at(0)
+}
+func (it *sliceIter) SeekLast() bool {
+	return it.at(len(it.keys) - 1)
+}
+func (it *sliceIter) Seek(key []byte) bool {
+	return it.at(sort.Search(len(it.keys), func(i int) bool { return bytes.Compare(it.keys[i], key) >= 0 }))
+}
+
+// TestMergeN tests MergeN and CombineN against a model on random layers,
+// walking in both directions.
+func TestMergeN(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	layers := make([]map[string][]byte, 5)
+	model := map[string][]byte{}
+	for i := range layers {
+		layers[i] = map[string][]byte{}
+		for range 40 {
+			key := fmt.Sprintf("k%03d", rng.IntN(120))
+			var val []byte
+			if rng.IntN(4) != 0 {
+				val = fmt.Appendf(nil, "%d", i)
+			}
+			layers[i][key] = val
+		}
+	}
+	for i := len(layers) - 1; i >= 0; i-- {
+		for key, val := range layers[i] {
+			model[key] = val
+		}
+	}
+	iters := func() (iters []*sliceIter) {
+		for _, layer := range layers {
+			it := &sliceIter{i: -1}
+			for _, key := range slices.Sorted(maps.Keys(layer)) {
+				it.keys = append(it.keys, []byte(key))
+				it.vals = append(it.vals, layer[key])
+			}
+			iters = append(iters, it)
+		}
+		return
+	}
+
+	for _, combine := range []bool{false, true} {
+		var keys []string
+		for key, val := range model {
+			if !combine || val != nil {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+
+		var iter Iterator
+		if combine {
+			c := new(CombineN[*sliceIter])
+			c.Load(iters(), nil)
+			iter = c
+		} else {
+			m := new(MergeN[*sliceIter])
+			m.Load(iters(), nil)
+			iter = m
+		}
+		check := func(ok bool, at int, op string) {
+			t.Helper()
+			if want := at >= 0 && at < len(keys); ok != want || ok != iter.Valid() {
+				t.Fatalf("combine=%v %s: ok=%v valid=%v, want %v at %d", combine, op, ok, iter.Valid(), want, at)
+			}
+			if ok {
+				if key := string(iter.Key()); key != keys[at] {
+					t.Fatalf("combine=%v %s: key %q, want %q", combine, op, key, keys[at])
+				}
+				if !bytes.Equal(iter.Val(), model[keys[at]]) {
+					t.Fatalf("combine=%v %s: %q=%q, want %q", combine, op, keys[at], iter.Val(), model[keys[at]])
+				}
+			}
+		}
+
+		var got []string
+		for ok := iter.SeekFirst(); ok; ok = iter.Next() {
+			got = append(got, string(iter.Key()))
+		}
+		if !slices.Equal(got, keys) {
+			t.Fatalf("combine=%v forward: %q, want %q", combine, got, keys)
+		}
+		got = got[:0]
+		for ok := iter.SeekLast(); ok; ok = iter.Prev() {
+			got = append(got, string(iter.Key()))
+		}
+		slices.Reverse(got)
+		if !slices.Equal(got, keys) {
+			t.Fatalf("combine=%v backward: %q, want %q", combine, got, keys)
+		}
+
+		for range 200 {
+			probe := fmt.Sprintf("k%03d", rng.IntN(130))
+			at, found := slices.BinarySearch(keys, probe)
+			if rng.IntN(2) == 0 {
+				check(iter.Seek([]byte(probe)), at, "Seek("+probe+")")
+			} else {
+				if !found {
+					at--
+				}
+				check(SeekLE(iter, []byte(probe)), at, "SeekLE("+probe+")")
+			}
+			for range 10 {
+				if !iter.Valid() {
+					break
+				}
+				if rng.IntN(2) == 0 {
+					at++
+					check(iter.Next(), at, "Next")
+				} else {
+					at--
+					check(iter.Prev(), at, "Prev")
+				}
+			}
+		}
+	}
+
+	t.Log("✓ MergeN and CombineN match the model")
+}
+
+// TestMergeNError tests that an error of any merged iterator stops the merge.
+func TestMergeNError(t *testing.T) {
+	good := &sliceIter{keys: [][]byte{[]byte("a"), []byte("c")}, vals: [][]byte{{1}, {1}}}
+	bad := &sliceIter{keys: [][]byte{[]byte("b"), []byte("d")}, vals: [][]byte{{2}, {2}}, fail: 2}
+	var iter MergeN[*sliceIter]
+	iter.Load([]*sliceIter{good, bad}, nil)
+	if !iter.SeekFirst() || string(iter.Key()) != "a" || iter.Index() != 0 {
+		t.Fatalf("SeekFirst: %q", iter.Key())
+	}
+	if !iter.Next() || string(iter.Key()) != "b" || iter.Index() != 1 {
+		t.Fatalf("Next: %q", iter.Key())
+	}
+	if iter.Next() || iter.Valid() || iter.Error() == nil || iter.Index() != -1 {
+		t.Fatalf("Next past a broken iterator: valid=%v err=%v", iter.Valid(), iter.Error())
+	}
+
+	t.Log("✓ Errors stop the merge")
+}
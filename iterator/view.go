@@ -0,0 +1,441 @@
+package iterator
+
+import "bytes"
+
+// The views below wrap an Iterator and are Iterators themselves, so they
+// compose with each other and with Merge and Combine. A view is not
+// positioned until one of its Seek methods is called. Copy returns a view
+// with the same settings and position over another iterator, such as a
+// Clone of the inner one.
+
+// Filter is a view of the entries of an iterator for which keep returns true.
+type Filter[Iter Iterator] struct {
+	iter  Iter
+	keep  func(key, val []byte) bool
+	valid bool
+}
+
+// Load initializes the view over iter.
+func (view *Filter[Iter]) Load(iter Iter, keep func(key, val []byte) bool) {
+	view.iter, view.keep, view.valid = iter, keep, false
+}
+
+// Inner returns the wrapped iterator.
+func (view *Filter[Iter]) Inner() Iter {
+	return view.iter
+}
+
+// Copy returns a view like this one over iter.
+func (view *Filter[Iter]) Copy(iter Iter) *Filter[Iter] {
+	c := *view
+	c.iter = iter
+	return &c
+}
+
+var _ SeekerLE = (*Filter[Iterator])(nil)
+
+func (view *Filter[Iter]) Valid() bool  { return view.valid }
+func (view *Filter[Iter]) Error() error { return view.iter.Error() }
+func (view *Filter[Iter]) Key() []byte  { return view.iter.Key() }
+func (view *Filter[Iter]) Val() []byte  { return view.iter.Val() }
+
+// Next advances to the next kept entry.
+func (view *Filter[Iter]) Next() bool {
+	return view.valid && view.forward(view.iter.Next())
+}
+
+// Prev moves to the previous kept entry.
+func (view *Filter[Iter]) Prev() bool {
+	return view.valid && view.backward(view.iter.Prev())
+}
+
+// SeekFirst positions at the first kept entry.
+func (view *Filter[Iter]) SeekFirst() bool {
+	return view.forward(view.iter.SeekFirst())
+}
+
+// SeekLast positions at the last kept entry.
+func (view *Filter[Iter]) SeekLast() bool {
+	return view.backward(view.iter.SeekLast())
+}
+
+// Seek positions at the first kept entry with a key >= the given key.
+func (view *Filter[Iter]) Seek(key []byte) bool {
+	return view.forward(view.iter.Seek(key))
+}
+
+// SeekLE positions at the last kept entry with a key <= the given key.
+func (view *Filter[Iter]) SeekLE(key []byte) bool {
+	return view.backward(SeekLE(view.iter, key))
+}
+
+func (view *Filter[Iter]) forward(ok bool) bool {
+	for ok && !view.keep(view.iter.Key(), view.iter.Val()) {
+		ok = view.iter.Next()
+	}
+	view.valid = ok
+	return ok
+}
+
+func (view *Filter[Iter]) backward(ok bool) bool {
+	for ok && !view.keep(view.iter.Key(), view.iter.Val()) {
+		ok = view.iter.Prev()
+	}
+	view.valid = ok
+	return ok
+}
+
+// Map is a view of an iterator with values replaced by fn. Tombstones
+// are passed to fn too; returning nil makes an entry a tombstone.
+type Map[Iter Iterator] struct {
+	iter Iter
+	fn   func(key, val []byte) []byte
+}
+
+// Load initializes the view over iter.
+func (view *Map[Iter]) Load(iter Iter, fn func(key, val []byte) []byte) {
+	view.iter, view.fn = iter, fn
+}
+
+// Inner returns the wrapped iterator.
+func (view *Map[Iter]) Inner() Iter {
+	return view.iter
+}
+
+// Copy returns a view like this one over iter.
+func (view *Map[Iter]) Copy(iter Iter) *Map[Iter] {
+	return &Map[Iter]{iter, view.fn}
+}
+
+var _ SeekerLE = (*Map[Iterator])(nil)
+
+func (view *Map[Iter]) Valid() bool          { return view.iter.Valid() }
+func (view *Map[Iter]) Error() error         { return view.iter.Error() }
+func (view *Map[Iter]) Key() []byte          { return view.iter.Key() }
+func (view *Map[Iter]) Next() bool           { return view.iter.Next() }
+func (view *Map[Iter]) Prev() bool           { return view.iter.Prev() }
+func (view *Map[Iter]) SeekFirst() bool      { return view.iter.SeekFirst() }
+func (view *Map[Iter]) SeekLast() bool       { return view.iter.SeekLast() }
+func (view *Map[Iter]) Seek(key []byte) bool { return view.iter.Seek(key) }
+
+// SeekLE positions at the last key <= the given key.
+func (view *Map[Iter]) SeekLE(key []byte) bool { return SeekLE(view.iter, key) }
+
+// Val returns the current value as replaced by fn.
+func (view *Map[Iter]) Val() []byte {
+	if !view.iter.Valid() {
+		return nil
+	}
+	return view.fn(view.iter.Key(), view.iter.Val())
+}
+
+// Range is a view of the keys of an iterator in [start, end).
+// A nil start or end leaves that side unbounded.
+type Range[Iter Iterator] struct {
+	iter       Iter
+	start, end []byte
+	valid      bool
+}
+
+// Load initializes the view over iter.
+func (view *Range[Iter]) Load(iter Iter, start, end []byte) {
+	view.iter, view.start, view.end, view.valid = iter, start, end, false
+}
+
+// Inner returns the wrapped iterator.
+func (view *Range[Iter]) Inner() Iter {
+	return view.iter
+}
+
+// Copy returns a view like this one over iter.
+func (view *Range[Iter]) Copy(iter Iter) *Range[Iter] {
+	c := *view
+	c.iter = iter
+	return &c
+}
+
+var _ SeekerLE = (*Range[Iterator])(nil)
+
+func (view *Range[Iter]) Valid() bool  { return view.valid }
+func (view *Range[Iter]) Error() error { return view.iter.Error() }
+func (view *Range[Iter]) Key() []byte  { return view.iter.Key() }
+func (view *Range[Iter]) Val() []byte  { return view.iter.Val() }
+
+// Next advances to the next key, if still before end.
+func (view *Range[Iter]) Next() bool {
+	return view.valid && view.within(view.iter.Next())
+}
+
+// Prev moves to the previous key, if still at or after start.
+func (view *Range[Iter]) Prev() bool {
+	return view.valid && view.within(view.iter.Prev())
+}
+
+// SeekFirst positions at the first key >= start.
+func (view *Range[Iter]) SeekFirst() bool {
+	if view.start == nil {
+		return view.within(view.iter.SeekFirst())
+	}
+	return view.within(view.iter.Seek(view.start))
+}
+
+// SeekLast positions at the last key < end.
+func (view *Range[Iter]) SeekLast() bool {
+	if view.end == nil {
+		return view.within(view.iter.SeekLast())
+	}
+	return view.within(seekBefore(view.iter, view.end))
+}
+
+// Seek positions at the first key >= both key and start.
+func (view *Range[Iter]) Seek(key []byte) bool {
+	if view.start != nil && bytes.Compare(key, view.start) < 0 {
+		key = view.start
+	}
+	return view.within(view.iter.Seek(key))
+}
+
+// SeekLE positions at the last key <= key and < end.
+func (view *Range[Iter]) SeekLE(key []byte) bool {
+	if view.end != nil && bytes.Compare(key, view.end) >= 0 {
+		return view.within(seekBefore(view.iter, view.end))
+	}
+	return view.within(SeekLE(view.iter, key))
+}
+
+func (view *Range[Iter]) within(ok bool) bool {
+	if ok {
+		key := view.iter.Key()
+		ok = (view.start == nil || bytes.Compare(key, view.start) >= 0) &&
+			(view.end == nil || bytes.Compare(key, view.end) < 0)
+	}
+	view.valid = ok
+	return ok
+}
+
+// seekBefore positions iter at the last key < key.
+func seekBefore[Iter Iterator](iter Iter, key []byte) bool {
+	if !SeekLE(iter, key) {
+		return false
+	}
+	if bytes.Equal(iter.Key(), key) {
+		return iter.Prev()
+	}
+	return true
+}
+
+// PrefixEnd returns the smallest key greater than all keys starting with
+// prefix, or nil if there is none, as for a prefix of 0xff bytes.
+func PrefixEnd(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := bytes.Clone(prefix[:i+1])
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
+
+// Prefix is a view of the keys of an iterator starting with a prefix.
+// With strip set, keys are returned without the prefix, and Seek takes
+// keys without it.
+type Prefix[Iter Iterator] struct {
+	rangeView[Iter]
+	prefix []byte
+	strip  bool
+	buf    []byte
+}
+
+type rangeView[Iter Iterator] = Range[Iter]
+
+// Load initializes the view over iter.
+func (view *Prefix[Iter]) Load(iter Iter, prefix []byte, strip bool) {
+	view.rangeView.Load(iter, prefix, PrefixEnd(prefix))
+	if len(prefix) == 0 {
+		view.rangeView.start = nil
+	}
+	view.prefix, view.strip = prefix, strip
+}
+
+// Copy returns a view like this one over iter.
+func (view *Prefix[Iter]) Copy(iter Iter) *Prefix[Iter] {
+	c := *view
+	c.iter, c.buf = iter, nil
+	return &c
+}
+
+var _ SeekerLE = (*Prefix[Iterator])(nil)
+
+// Key returns the current key, without the prefix if stripped.
+func (view *Prefix[Iter]) Key() []byte {
+	if !view.valid {
+		return nil
+	}
+	key := view.iter.Key()
+	if view.strip {
+		key = key[len(view.prefix):]
+	}
+	return key
+}
+
+// Seek positions at the first key >= the given key, which is without
+// the prefix if stripped.
+func (view *Prefix[Iter]) Seek(key []byte) bool {
+	if view.strip {
+		view.buf = append(append(view.buf[:0], view.prefix...), key...)
+		key = view.buf
+	}
+	return view.rangeView.Seek(key)
+}
+
+// SeekLE positions at the last key <= the given key, which is without
+// the prefix if stripped.
+func (view *Prefix[Iter]) SeekLE(key []byte) bool {
+	if view.strip {
+		view.buf = append(append(view.buf[:0], view.prefix...), key...)
+		key = view.buf
+	}
+	return view.rangeView.SeekLE(key)
+}
+
+// Limit is a view of at most limit entries of an iterator, after skipping
+// the first offset. A negative limit leaves the number unbounded.
+//
+// The window is counted from the first entry, so SeekLast and Seek step
+// forward from its start: they cost O(offset + limit) moves.
+type Limit[Iter Iterator] struct {
+	iter          Iter
+	offset, limit int
+	rank          int // of the current entry in the inner iterator
+	valid         bool
+}
+
+// Load initializes the view over iter.
+func (view *Limit[Iter]) Load(iter Iter, offset, limit int) {
+	view.iter, view.offset, view.limit = iter, max(offset, 0), limit
+	view.rank, view.valid = 0, false
+}
+
+// Inner returns the wrapped iterator.
+func (view *Limit[Iter]) Inner() Iter {
+	return view.iter
+}
+
+// Copy returns a view like this one over iter.
+func (view *Limit[Iter]) Copy(iter Iter) *Limit[Iter] {
+	c := *view
+	c.iter = iter
+	return &c
+}
+
+var _ Iterator = (*Limit[Iterator])(nil)
+
+func (view *Limit[Iter]) Valid() bool  { return view.valid }
+func (view *Limit[Iter]) Error() error { return view.iter.Error() }
+func (view *Limit[Iter]) Key() []byte  { return view.iter.Key() }
+func (view *Limit[Iter]) Val() []byte  { return view.iter.Val() }
+
+// Next advances to the next entry within the window.
+func (view *Limit[Iter]) Next() bool {
+	if !view.valid {
+		return false
+	}
+	view.rank++
+	return view.within(view.iter.Next())
+}
+
+// Prev moves to the previous entry within the window.
+func (view *Limit[Iter]) Prev() bool {
+	if !view.valid {
+		return false
+	}
+	view.rank--
+	return view.within(view.iter.Prev())
+}
+
+// SeekFirst positions at the first entry of the window.
+func (view *Limit[Iter]) SeekFirst() bool {
+	ok := view.iter.SeekFirst()
+	for view.rank = 0; ok && view.rank < view.offset; view.rank++ {
+		ok = view.iter.Next()
+	}
+	return view.within(ok)
+}
+
+// SeekLast positions at the last entry of the window.
+func (view *Limit[Iter]) SeekLast() bool {
+	if !view.SeekFirst() {
+		return false
+	}
+	for view.limit < 0 || view.rank < view.offset+view.limit-1 {
+		if !view.iter.Next() {
+			if view.iter.Error() != nil {
+				view.valid = false
+				return false
+			}
+			return view.within(view.iter.SeekLast())
+		}
+		view.rank++
+	}
+	return true
+}
+
+// Seek positions at the first entry of the window with a key >= the given key.
+func (view *Limit[Iter]) Seek(key []byte) bool {
+	ok := view.SeekFirst()
+	for ok && bytes.Compare(view.iter.Key(), key) < 0 {
+		ok = view.Next()
+	}
+	return ok
+}
+
+func (view *Limit[Iter]) within(ok bool) bool {
+	view.valid = ok && view.rank >= view.offset &&
+		(view.limit < 0 || view.rank < view.offset+view.limit)
+	return view.valid
+}
+
+// Reverse is a view of an iterator in descending key order: Next and Prev,
+// and SeekFirst and SeekLast, are swapped.
+type Reverse[Iter Iterator] struct {
+	iter Iter
+}
+
+// Load initializes the view over iter.
+func (view *Reverse[Iter]) Load(iter Iter) {
+	view.iter = iter
+}
+
+// Inner returns the wrapped iterator.
+func (view *Reverse[Iter]) Inner() Iter {
+	return view.iter
+}
+
+// Copy returns a view like this one over iter.
+func (view *Reverse[Iter]) Copy(iter Iter) *Reverse[Iter] {
+	return &Reverse[Iter]{iter}
+}
+
+var _ SeekerLE = (*Reverse[Iterator])(nil)
+
+func (view *Reverse[Iter]) Valid() bool     { return view.iter.Valid() }
+func (view *Reverse[Iter]) Error() error    { return view.iter.Error() }
+func (view *Reverse[Iter]) Key() []byte     { return view.iter.Key() }
+func (view *Reverse[Iter]) Val() []byte     { return view.iter.Val() }
+func (view *Reverse[Iter]) Next() bool      { return view.iter.Prev() }
+func (view *Reverse[Iter]) Prev() bool      { return view.iter.Next() }
+func (view *Reverse[Iter]) SeekFirst() bool { return view.iter.SeekLast() }
+func (view *Reverse[Iter]) SeekLast() bool  { return view.iter.SeekFirst() }
+
+// Seek positions at the last key <= the given key,
+// the first at or after it in descending order.
+func (view *Reverse[Iter]) Seek(key []byte) bool {
+	return SeekLE(view.iter, key)
+}
+
+// SeekLE positions at the first key >= the given key,
+// the last at or before it in descending order.
+func (view *Reverse[Iter]) SeekLE(key []byte) bool {
+	return view.iter.Seek(key)
+}
@@ -0,0 +1,239 @@
+package iterator
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func newSliceIter(keys ...string) *sliceIter {
+	it := &sliceIter{i: -1}
+	for _, key := range keys {
+		it.keys = append(it.keys, []byte(key))
+		it.vals = append(it.vals, []byte("v"+key))
+	}
+	return it
+}
+
+// checkView walks iter in both directions and seeks it at random, comparing
+// against keys in iteration order. seek returns the expected index for a
+// Seek to the given key, from which that of SeekLE follows.
+func checkView(t *testing.T, name string, iter Iterator, keys []string, seek func(key string) int) {
+	t.Helper()
+	var got []string
+	for ok := iter.SeekFirst(); ok; ok = iter.Next() {
+		got = append(got, string(iter.Key()))
+	}
+	if !slices.Equal(got, keys) {
+		t.Fatalf("%s forward: %q, want %q", name, got, keys)
+	}
+	got = got[:0]
+	for ok := iter.SeekLast(); ok; ok = iter.Prev() {
+		got = append(got, string(iter.Key()))
+	}
+	slices.Reverse(got)
+	if !slices.Equal(got, keys) {
+		t.Fatalf("%s backward: %q, want %q", name, got, keys)
+	}
+
+	check := func(ok bool, at int, op string) {
+		t.Helper()
+		if want := at >= 0 && at < len(keys); ok != want || ok != iter.Valid() {
+			t.Fatalf("%s %s: ok=%v valid=%v, want %v at %d", name, op, ok, iter.Valid(), want, at)
+		}
+		if ok && string(iter.Key()) != keys[at] {
+			t.Fatalf("%s %s: key %q, want %q", name, op, iter.Key(), keys[at])
+		}
+	}
+	rng := rand.New(rand.NewPCG(3, 4))
+	for range 100 {
+		probe := fmt.Sprintf("%c%d", 'a'+rng.IntN(4), rng.IntN(10))
+		at := seek(probe)
+		le := at - 1
+		if at < len(keys) && keys[at] == probe {
+			le = at
+		}
+		check(SeekLE(iter, []byte(probe)), le, "SeekLE("+probe+")")
+		check(iter.Seek([]byte(probe)), at, "Seek("+probe+")")
+		for range 5 {
+			if !iter.Valid() {
+				break
+			}
+			if rng.IntN(2) == 0 {
+				at++
+				check(iter.Next(), at, "Next")
+			} else {
+				at--
+				check(iter.Prev(), at, "Prev")
+			}
+		}
+	}
+}
+
+// seekIn returns the index of the first of the sorted keys >= key.
+func seekIn(keys []string) func(string) int {
+	return func(key string) int {
+		at, _ := slices.BinarySearch(keys, key)
+		return at
+	}
+}
+
+// TestViews tests each view against the keys it should yield.
+func TestViews(t *testing.T) {
+	var all []string
+	for _, c := range "abc" {
+		for i := range 10 {
+			all = append(all, fmt.Sprintf("%c%d", c, i))
+		}
+	}
+	pick := func(keep func(key string) bool) (keys []string) {
+		for _, key := range all {
+			if keep(key) {
+				keys = append(keys, key)
+			}
+		}
+		return
+	}
+
+	even := pick(func(key string) bool { return key[1]%2 == 0 })
+	var filter Filter[*sliceIter]
+	filter.Load(newSliceIter(all...), func(key, val []byte) bool { return key[1]%2 == 0 })
+	checkView(t, "Filter", &filter, even, seekIn(even))
+
+	for _, r := range [][2]string{{"", ""}, {"a5", "b5"}, {"", "b0"}, {"b0", ""}, {"x", ""}, {"b5", "b5"}} {
+		start, end := []byte(r[0]), []byte(r[1])
+		if r[0] == "" {
+			start = nil
+		}
+		if r[1] == "" {
+			end = nil
+		}
+		keys := pick(func(key string) bool {
+			return (start == nil || key >= r[0]) && (end == nil || key < r[1])
+		})
+		var view Range[*sliceIter]
+		view.Load(newSliceIter(all...), start, end)
+		checkView(t, fmt.Sprintf("Range[%q,%q)", r[0], r[1]), &view, keys, func(key string) int {
+			if key < r[0] {
+				key = r[0]
+			}
+			return seekIn(keys)(key)
+		})
+	}
+
+	bs := pick(func(key string) bool { return key[0] == 'b' })
+	var prefix Prefix[*sliceIter]
+	prefix.Load(newSliceIter(all...), []byte("b"), false)
+	checkView(t, "Prefix", &prefix, bs, func(key string) int {
+		return seekIn(bs)(max(key, "b"))
+	})
+	stripped := make([]string, len(bs))
+	for i, key := range bs {
+		stripped[i] = strings.TrimPrefix(key, "b")
+	}
+	prefix.Load(newSliceIter(all...), []byte("b"), true)
+	checkView(t, "Prefix strip", &prefix, stripped, func(key string) int {
+		return seekIn(bs)("b" + key)
+	})
+
+	for _, w := range [][2]int{{0, -1}, {0, 5}, {7, 10}, {25, -1}, {28, 10}, {40, 1}, {3, 0}} {
+		keys := all[min(w[0], len(all)):]
+		if w[1] >= 0 && w[1] < len(keys) {
+			keys = keys[:w[1]]
+		}
+		var limit Limit[*sliceIter]
+		limit.Load(newSliceIter(all...), w[0], w[1])
+		checkView(t, fmt.Sprintf("Limit(%d,%d)", w[0], w[1]), &limit, keys, seekIn(keys))
+	}
+
+	desc := slices.Clone(all)
+	slices.Reverse(desc)
+	var reverse Reverse[*sliceIter]
+	reverse.Load(newSliceIter(all...))
+	checkView(t, "Reverse", &reverse, desc, func(key string) int {
+		at, found := slices.BinarySearch(all, key)
+		if !found {
+			at--
+		}
+		return len(all) - 1 - at
+	})
+
+	var upper Map[*sliceIter]
+	upper.Load(newSliceIter(all...), func(key, val []byte) []byte { return bytes.ToUpper(val) })
+	if !upper.Seek([]byte("b3")) || string(upper.Val()) != "VB3" {
+		t.Fatalf("Map: %q=%q", upper.Key(), upper.Val())
+	}
+
+	t.Log("✓ Views yield the expected keys")
+}
+
+// TestViewCompose tests Merge and Combine, views stacked on each other and
+// on Combine, and copied over a second iterator.
+func TestViewCompose(t *testing.T) {
+	over := newSliceIter("a1", "b2", "b4", "c1")
+	over.vals[1] = nil // tombstone for b2
+	base := newSliceIter("a0", "b1", "b2", "b3", "b5", "c0")
+	var combine Combine[*sliceIter, *sliceIter]
+	combine.Load(over, base, nil)
+	var merge Merge[*sliceIter, *sliceIter]
+	merge.Load(over, base, nil)
+	merged := []string{"a0", "a1", "b1", "b2", "b3", "b4", "b5", "c0", "c1"}
+	checkView(t, "merge", &merge, merged, seekIn(merged))
+	combined := slices.Delete(merged, 3, 4)
+	checkView(t, "combine", &combine, combined, seekIn(combined))
+
+	var prefix Prefix[*Combine[*sliceIter, *sliceIter]]
+	prefix.Load(&combine, []byte("b"), true)
+	var reverse Reverse[*Prefix[*Combine[*sliceIter, *sliceIter]]]
+	reverse.Load(&prefix)
+	var limit Limit[*Reverse[*Prefix[*Combine[*sliceIter, *sliceIter]]]]
+	limit.Load(&reverse, 1, 2)
+
+	var got []string
+	for ok := limit.SeekFirst(); ok; ok = limit.Next() {
+		got = append(got, string(limit.Key()))
+	}
+	if want := []string{"4", "3"}; !slices.Equal(got, want) {
+		t.Fatalf("composed: %q, want %q", got, want)
+	}
+
+	if !limit.SeekLast() || string(limit.Key()) != "3" {
+		t.Fatalf("SeekLast: %q", limit.Key())
+	}
+	var clone Combine[*sliceIter, *sliceIter]
+	cover, cbase := *over, *base
+	clone.Load(&cover, &cbase, &combine)
+	copied := limit.Copy(reverse.Copy(prefix.Copy(&clone)))
+	if string(copied.Key()) != "3" || !copied.Prev() || string(copied.Key()) != "4" || copied.Prev() {
+		t.Fatalf("copy: %q", copied.Key())
+	}
+	if !limit.Valid() || string(limit.Key()) != "3" {
+		t.Fatalf("original moved with its copy: %q", limit.Key())
+	}
+
+	t.Log("✓ Views compose and copy")
+}
+
+// TestViewError tests that views stop on an error of the wrapped iterator.
+func TestViewError(t *testing.T) {
+	it := newSliceIter("a", "b", "c", "d")
+	it.fail = 3
+	var filter Filter[*sliceIter]
+	filter.Load(it, func(key, val []byte) bool { return key[0] != 'b' })
+	if !filter.SeekFirst() || filter.Next() || filter.Valid() || filter.Error() == nil {
+		t.Fatalf("Filter past a broken iterator: valid=%v err=%v", filter.Valid(), filter.Error())
+	}
+
+	it = newSliceIter("a", "b", "c", "d")
+	it.fail = 2
+	var limit Limit[*sliceIter]
+	limit.Load(it, 0, -1)
+	if limit.SeekLast() || limit.Valid() || limit.Error() == nil {
+		t.Fatalf("Limit past a broken iterator: valid=%v err=%v", limit.Valid(), limit.Error())
+	}
+
+	t.Log("✓ Errors stop the views")
+}
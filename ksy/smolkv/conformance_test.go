@@ -0,0 +1,383 @@
+package smolkv_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dacapoday/smol/block"
+	"github.com/dacapoday/smol/bptree"
+	"github.com/dacapoday/smol/ksy/smolkv"
+	"github.com/dacapoday/smol/kv"
+	"github.com/dacapoday/smol/mem"
+)
+
+type option struct {
+	blockSize   int
+	cipherSuite string
+	cipherKey   []byte
+	retain      uint8
+}
+
+func (o option) MagicCode() [4]byte          { return [4]byte{'D', 'I', 'C', 'T'} }
+func (o option) ReadOnly() bool              { return false }
+func (o option) IgnoreInvalidFreelist() bool { return false }
+func (o option) RetainCheckpoints() uint8    { return o.retain }
+func (o option) BlockSize() int              { return o.blockSize }
+func (o option) CipherSuite() string         { return o.cipherSuite }
+func (o option) CipherKey() []byte           { return o.cipherKey }
+
+// verify decodes file with smolkv and checks it against root as read by bptree.
+func verify(t *testing.T, name string, file *mem.File, opts *smolkv.Options, b *block.Heap[*mem.File], root bptree.Page, klen, vlen int) {
+	t.Helper()
+	f, err := smolkv.Open(file, opts)
+	if err != nil {
+		t.Fatalf("%s: Open: %v", name, err)
+	}
+	if f.PageSize() != b.PageSize() {
+		t.Fatalf("%s: page size %d, want %d", name, f.PageSize(), b.PageSize())
+	}
+	if k, v := f.InlineSizes(); k != klen || v != vlen {
+		t.Fatalf("%s: inline sizes %d, %d, want %d, %d", name, k, v, klen, vlen)
+	}
+	decoded, expiry, err := f.Entry()
+	if err != nil {
+		t.Fatalf("%s: Entry: %v", name, err)
+	}
+	if !bytes.Equal(decoded, root) || expiry != nil {
+		t.Fatalf("%s: entry root differs", name)
+	}
+
+	var reader bptree.Reader[*block.Heap[*mem.File]]
+	reader.Load(b, root, klen, vlen, 0)
+	defer reader.Close()
+	ok := reader.SeekFirst()
+	n := 0
+	err = f.Walk(decoded, func(key, val []byte) error {
+		if !ok {
+			return fmt.Errorf("extra key %q", key)
+		}
+		if !bytes.Equal(key, reader.Key()) || !bytes.Equal(val, reader.Val()) {
+			return fmt.Errorf("item %d: decoded %.20q, bptree %.20q", n, key, reader.Key())
+		}
+		ok = reader.Next()
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%s: Walk: %v", name, err)
+	}
+	if ok || reader.Error() != nil {
+		t.Fatalf("%s: %d keys decoded, bptree has more: %v", name, n, reader.Error())
+	}
+
+	used, err := f.Blocks(decoded)
+	if err != nil {
+		t.Fatalf("%s: Blocks: %v", name, err)
+	}
+	free, chain, err := f.Free()
+	if err != nil {
+		t.Fatalf("%s: Free: %v", name, err)
+	}
+	owner := map[uint32]string{}
+	claim := func(ids []uint32, what string) {
+		for _, id := range ids {
+			if id < 2 || id >= f.Meta.BlockCount {
+				t.Fatalf("%s: %s block %d out of %d", name, what, id, f.Meta.BlockCount)
+			}
+			if prev, ok := owner[id]; ok {
+				t.Fatalf("%s: block %d is both %s and %s", name, id, prev, what)
+			}
+			owner[id] = what
+		}
+	}
+	claim(used, "tree")
+	claim(free, "free")
+	claim(chain, "freelist")
+
+	if id := f.Meta.ID; id > 1 {
+		if meta, err := f.ReadMeta(id); err != nil || meta.Ckp != f.Meta.Ckp {
+			t.Fatalf("%s: retained meta %d: %v", name, id, err)
+		}
+		if prev := f.Meta.PrevID; prev > 1 {
+			if meta, err := f.ReadMeta(prev); err != nil || meta.Ckp != f.Meta.Ckp-1 {
+				t.Fatalf("%s: previous meta %d: %v", name, prev, err)
+			}
+		}
+	}
+}
+
+// TestConformance writes files with every combination of block size,
+// cipher suite, checkpoint retention, fill and page layout, and checks that smolkv
+// decodes what bptree reads back, after each of several commits.
+func TestConformance(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	for _, blockSize := range []int{1024, 4096, 16384, 65536} {
+		for _, suite := range []string{"plain", "crc32", "aes-256-gcm"} {
+			for _, retain := range []uint8{0, 2} {
+				for _, fill := range []bptree.Fill{{}, {Split: 60, Append: 90, Merge: 40}} {
+					for _, compact := range []bool{false, true} {
+						write := bptree.Options{Fill: fill, PrefixLeaves: compact, ShortSeparators: compact}
+						name := fmt.Sprintf("%d/%s/retain=%d/%+v", blockSize, suite, retain, write)
+						conform(t, name, option{blockSize, suite, key, retain}, write)
+					}
+				}
+			}
+		}
+	}
+
+	t.Log("✓ smolkv agrees with bptree for every option combination")
+}
+
+func conform(t *testing.T, name string, opt option, write bptree.Options) {
+	t.Helper()
+	var file mem.File
+	var b block.Heap[*mem.File]
+	_, ckpt, err := b.Load(&file, opt)
+	if err != nil {
+		t.Fatalf("%s: Load: %v", name, err)
+	}
+	defer b.Close()
+
+	pageSize := b.PageSize()
+	maxOverflowSize := math.MaxUint32 * pageSize
+	klen, vlen := bptree.InlineSize(pageSize, 5, maxOverflowSize, maxOverflowSize)
+	opts := &smolkv.Options{CipherKey: opt.cipherKey}
+
+	rng := rand.New(rand.NewPCG(uint64(opt.blockSize), uint64(opt.retain)))
+	model := map[string][]byte{}
+	var root bptree.Page
+	var high uint8
+	for round := range 6 {
+		changes := map[string][]byte{}
+		for range 300 {
+			var k []byte
+			switch rng.IntN(10) {
+			case 0: // overflowing key
+				k = fmt.Appendf(bytes.Repeat([]byte{'K'}, klen+rng.IntN(pageSize)), "%d", rng.IntN(50))
+			case 1, 2: // shared prefix, for prefix leaf pages
+				k = fmt.Appendf(nil, "user/profile/%08d", rng.IntN(2000))
+			default:
+				k = fmt.Appendf(nil, "k%06d", rng.IntN(5000))
+			}
+			v := fmt.Appendf(nil, "v%d-%d", round, rng.IntN(1000))
+			switch n := rng.IntN(20); {
+			case n == 0: // overflowing value
+				v = bytes.Repeat(v, vlen/len(v)+rng.IntN(3)+1)
+			case n < 4 && round > 0: // delete
+				v = nil
+			}
+			changes[string(k)] = v
+		}
+		keys := slices.Sorted(func(yield func(string) bool) {
+			for k := range changes {
+				if !yield(k) {
+					return
+				}
+			}
+		})
+		high, root, err = bptree.WriteSortedChangesOptions(&b, root, klen, vlen, high, write, func(yield func([]byte, []byte) bool) {
+			for _, k := range keys {
+				if !yield([]byte(k), changes[k]) {
+					return
+				}
+			}
+		})
+		if err != nil {
+			t.Fatalf("%s round %d: write: %v", name, round, err)
+		}
+		next, err := b.Commit(root)
+		if err != nil {
+			t.Fatalf("%s round %d: Commit: %v", name, round, err)
+		}
+		ckpt.Release()
+		ckpt = next
+		for k, v := range changes {
+			if v == nil {
+				delete(model, k)
+			} else {
+				model[k] = v
+			}
+		}
+
+		verify(t, fmt.Sprintf("%s round %d", name, round), &file, opts, &b, root, klen, vlen)
+	}
+	ckpt.Release()
+
+	count := 0
+	f, _ := smolkv.Open(&file, opts)
+	decoded, _, _ := f.Entry()
+	f.Walk(decoded, func(key, val []byte) error {
+		if !bytes.Equal(model[string(key)], val) {
+			t.Fatalf("%s: %.20q=%.20q, want %.20q", name, key, val, model[string(key)])
+		}
+		count++
+		return nil
+	})
+	if count != len(model) {
+		t.Fatalf("%s: decoded %d keys, want %d", name, count, len(model))
+	}
+}
+
+// TestConformanceKV checks a file written by kv, with an expiry index,
+// against the store's own view of it.
+func TestConformanceKV(t *testing.T) {
+	var file mem.File
+	var db kv.KV[*mem.File]
+	if err := db.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer db.Close()
+
+	expireAt := time.Now().Add(time.Hour)
+	for i := range 2000 {
+		k := fmt.Appendf(nil, "key-%05d", i)
+		var err error
+		if i%7 == 0 {
+			err = db.SetExpiry(k, []byte("expiring"), expireAt)
+		} else {
+			err = db.Set(k, bytes.Repeat([]byte{byte(i)}, i%50))
+		}
+		if err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+
+	f, err := smolkv.Open(&file, nil)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	root, expiry, err := f.Entry()
+	if err != nil || expiry == nil {
+		t.Fatalf("Entry: expiry=%v err=%v", expiry != nil, err)
+	}
+
+	iter := db.Iter()
+	defer iter.Close()
+	ok := iter.SeekFirst()
+	err = f.Walk(root, func(key, val []byte) error {
+		if !ok || !bytes.Equal(key, iter.Key()) || !bytes.Equal(val, iter.Val()) {
+			return fmt.Errorf("decoded %q, kv %q", key, iter.Key())
+		}
+		ok = iter.Next()
+		return nil
+	})
+	if err != nil || ok {
+		t.Fatalf("Walk: %v, kv has more: %v", err, ok)
+	}
+
+	indexed := 0
+	err = f.Walk(expiry, func(key, val []byte) error {
+		if key[0] != 'k' {
+			return nil
+		}
+		at, ok, err := db.Expiry(key[1:])
+		if err != nil || !ok || len(val) != 8 || !at.Equal(expireAt) {
+			return fmt.Errorf("expiry of %q: %v, %v, %v", key[1:], at, ok, err)
+		}
+		indexed++
+		return nil
+	})
+	if err != nil || indexed != 2000/7+1 {
+		t.Fatalf("expiry index: %d keys, %v", indexed, err)
+	}
+
+	t.Log("✓ smolkv agrees with kv, expiry index included")
+}
+
+// TestCorrupt tests that a damaged block, or a missing cipher key,
+// is reported.
+func TestCorrupt(t *testing.T) {
+	for _, suite := range []string{"plain", "crc32", "aes-256-gcm"} {
+		var file mem.File
+		var b block.Heap[*mem.File]
+		opt := option{4096, suite, bytes.Repeat([]byte{7}, 32), 0}
+		_, ckpt, err := b.Load(&file, opt)
+		if err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		defer b.Close()
+		pageSize := b.PageSize()
+		klen, vlen := bptree.InlineSize(pageSize, 5, math.MaxUint32*pageSize, math.MaxUint32*pageSize)
+		_, root, err := bptree.WriteSortedChanges(&b, nil, klen, vlen, 0, func(yield func([]byte, []byte) bool) {
+			for i := range 1000 {
+				yield(fmt.Appendf(nil, "key-%05d", i), []byte("value"))
+			}
+		})
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		next, err := b.Commit(root)
+		if err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+		ckpt.Release()
+		next.Release()
+
+		if suite == "aes-256-gcm" {
+			if _, err := smolkv.Open(&file, nil); !errors.Is(err, smolkv.ErrCipher) {
+				t.Fatalf("%s: Open without key: %v", suite, err)
+			}
+		}
+		file.WriteAt([]byte{0xff}, 2*4096+100)
+		f, err := smolkv.Open(&file, &smolkv.Options{CipherKey: opt.cipherKey})
+		if err != nil {
+			t.Fatalf("%s: Open: %v", suite, err)
+		}
+		root, _, err = f.Entry()
+		if err != nil {
+			t.Fatalf("%s: Entry: %v", suite, err)
+		}
+		if err = f.Walk(root, func(key, val []byte) error { return nil }); !errors.Is(err, smolkv.ErrChecksum) {
+			t.Fatalf("%s: Walk over a damaged block: %v", suite, err)
+		}
+	}
+
+	t.Log("✓ Damage and missing keys are reported")
+}
+
+// TestSample decodes the sample file checked in next to the specification.
+func TestSample(t *testing.T) {
+	data, err := os.ReadFile("../kv_sample.kv")
+	if err != nil {
+		t.Fatalf("read sample: %v", err)
+	}
+	f, err := smolkv.Open(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	root, _, err := f.Entry()
+	if err != nil {
+		t.Fatalf("Entry: %v", err)
+	}
+	got := map[string]int{}
+	err = f.Walk(root, func(key, val []byte) error {
+		switch {
+		case string(key) == "hello" && string(val) == "world":
+			got["hello"]++
+		case bytes.HasPrefix(key, []byte("bk")) && bytes.HasPrefix(val, []byte("bv")):
+			got["bk"]++
+		case bytes.HasPrefix(key, []byte("bigkey[")) && len(key) > 6000:
+			got["bigkey"]++
+		case string(key) == "bigval-key" && len(val) > 6000:
+			got["bigval"]++
+		default:
+			return fmt.Errorf("unexpected %.20q", key)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	if want := map[string]int{"hello": 1, "bk": 1000, "bigkey": 1, "bigval": 1}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("sample: %v, want %v", got, want)
+	}
+
+	t.Log("✓ Sample file decodes")
+}
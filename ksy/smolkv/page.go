@@ -0,0 +1,240 @@
+package smolkv
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// page is a decoded page: {u2 tag, u2 length, u2 offsets..., items...},
+// where tag is {bit15: has_prefix, bit14: is_branch, bits 0-13: count}.
+type page struct {
+	data      []byte
+	count     int
+	leaf      bool
+	prefix    bool
+	offsets   []int
+	firstItem int // 1 on a prefix leaf page, whose slot 0 is the prefix
+}
+
+func decodePage(data []byte) (p page, err error) {
+	if len(data) < 4 {
+		return p, fmt.Errorf("%w: page size %d", ErrFormat, len(data))
+	}
+	tag := binary.LittleEndian.Uint16(data)
+	if tag >= 0x8000 && (tag&0x4000 != 0 || tag&0x3FFF == 0) {
+		return p, fmt.Errorf("%w: page tag %#x", ErrFormat, tag)
+	}
+	size := 4 + int(binary.LittleEndian.Uint16(data[2:]))
+	if size > len(data) {
+		return p, fmt.Errorf("%w: page length %d", ErrFormat, size)
+	}
+	p.data = data[:size]
+	p.count = int(tag & 0x3FFF)
+	p.leaf = tag&0x4000 == 0
+	p.prefix = tag&0x8000 != 0
+	if p.count == 0 {
+		return
+	}
+
+	slots := p.count + 1
+	if p.prefix {
+		slots, p.firstItem = slots+1, 1
+	}
+	if 2+2*slots > size {
+		return p, fmt.Errorf("%w: page offsets", ErrFormat)
+	}
+	p.offsets = make([]int, slots)
+	for i := range p.offsets {
+		p.offsets[i] = 4 + int(binary.LittleEndian.Uint16(data[2+2*i:]))
+	}
+	// offsets[0] is the length, items are stored last to first
+	for i := 1; i < slots; i++ {
+		if p.offsets[i] > p.offsets[i-1] || p.offsets[i] < 2+2*slots {
+			return p, fmt.Errorf("%w: page offset %d", ErrFormat, i)
+		}
+	}
+	return
+}
+
+// slot returns the bytes of slot i, counting the prefix slot.
+func (p page) slot(i int) []byte {
+	return p.data[p.offsets[i+1]:p.offsets[i]]
+}
+
+// item returns item i.
+func (p page) item(i int) []byte {
+	return p.slot(i + p.firstItem)
+}
+
+// Walk calls fn for every key-value pair of the B+ tree with the given
+// root page, in key order, with overflowed keys and values read in full.
+func (f *File) Walk(root []byte, fn func(key, val []byte) error) error {
+	return f.walk(root, fn, nil)
+}
+
+// Blocks returns the blocks of the B+ tree with the given root page,
+// pages and overflow chains, each once: a branch key may share the
+// overflow chain of the leaf key it was taken from.
+func (f *File) Blocks(root []byte) (blocks []uint32, err error) {
+	seen := map[uint32]bool{}
+	err = f.walk(root, nil, func(blockID uint32) {
+		if !seen[blockID] {
+			seen[blockID] = true
+			blocks = append(blocks, blockID)
+		}
+	})
+	return
+}
+
+func (f *File) walk(root []byte, fn func(key, val []byte) error, visit func(uint32)) error {
+	if len(root) == 0 {
+		return nil
+	}
+	w := walker{f: f, fn: fn, visit: visit}
+	w.keyInline, w.valInline = f.InlineSizes()
+	return w.page(root, 0)
+}
+
+type walker struct {
+	f                    *File
+	fn                   func(key, val []byte) error
+	visit                func(uint32)
+	keyInline, valInline int
+	last                 []byte
+	seen                 bool
+}
+
+func (w *walker) page(data []byte, depth int) error {
+	if depth > 64 {
+		return fmt.Errorf("%w: tree too deep", ErrFormat)
+	}
+	p, err := decodePage(data)
+	if err != nil {
+		return err
+	}
+	if p.count == 0 {
+		return fmt.Errorf("%w: empty tree page", ErrFormat)
+	}
+	if !p.leaf {
+		for i := range p.count {
+			item := p.item(i)
+			if len(item) < 4 {
+				return fmt.Errorf("%w: branch item %d", ErrFormat, i)
+			}
+			if _, err = w.key(item[4:]); err != nil {
+				return err
+			}
+			child := binary.LittleEndian.Uint32(item)
+			if w.visit != nil {
+				w.visit(child)
+			}
+			data, err := w.f.Block(child)
+			if err != nil {
+				return err
+			}
+			if err = w.page(data, depth+1); err != nil {
+				return fmt.Errorf("block %d: %w", child, err)
+			}
+		}
+		return nil
+	}
+
+	var prefix []byte
+	if p.prefix {
+		prefix = p.slot(0)
+	}
+	for i := range p.count {
+		d := decoder{buf: p.item(i)}
+		suffix := d.bytes(d.uvarint())
+		if d.err != nil {
+			return fmt.Errorf("leaf item %d: %w", i, d.err)
+		}
+		key, err := w.key(append(append([]byte{}, prefix...), suffix...))
+		if err != nil {
+			return err
+		}
+		val, err := w.val(d.buf[d.off:])
+		if err != nil {
+			return err
+		}
+		if w.seen && string(key) <= string(w.last) {
+			return fmt.Errorf("%w: key %q after %q", ErrFormat, key, w.last)
+		}
+		w.last, w.seen = append(w.last[:0], key...), true
+		if w.fn != nil {
+			if err = w.fn(key, val); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *walker) key(stored []byte) ([]byte, error) {
+	return w.overflow(stored, w.keyInline)
+}
+
+func (w *walker) val(stored []byte) ([]byte, error) {
+	return w.overflow(stored, w.valInline)
+}
+
+// overflow resolves stored data longer than inline, an overflow_head:
+// {front[inline], uvarint size, u4 id}, where size bytes follow front
+// in the overflow chain starting at block id.
+func (w *walker) overflow(stored []byte, inline int) ([]byte, error) {
+	if len(stored) <= inline {
+		return stored, nil
+	}
+	d := decoder{buf: stored, off: inline}
+	size := d.uvarint()
+	blockID := d.u4()
+	if d.err != nil || d.off != len(stored) {
+		return nil, fmt.Errorf("%w: overflow head", ErrFormat)
+	}
+	data := append(make([]byte, 0, inline+int(size)), stored[:inline]...)
+	err := w.chain(blockID, size, func(payload []byte) {
+		data = append(data, payload...)
+	})
+	return data, err
+}
+
+// chain reads size bytes of an overflow chain: overflow_body pages
+// {0x00, 0x40, u2 length, u4 next_id, payload} ending with an
+// overflow_tail page {0x00, 0x00, u2 length, payload}.
+func (w *walker) chain(blockID uint32, size uint64, fn func([]byte)) error {
+	for {
+		if w.visit != nil {
+			w.visit(blockID)
+		}
+		data, err := w.f.Block(blockID)
+		if err != nil {
+			return err
+		}
+		p, err := decodePage(data)
+		if err != nil || p.count != 0 {
+			return fmt.Errorf("%w: overflow page %d", ErrFormat, blockID)
+		}
+		var payload []byte
+		next := uint32(0)
+		if p.leaf {
+			payload = p.data[4:]
+		} else if len(p.data) >= 8 {
+			payload = p.data[8:]
+			next = binary.LittleEndian.Uint32(p.data[4:])
+		} else {
+			return fmt.Errorf("%w: overflow page %d", ErrFormat, blockID)
+		}
+		if uint64(len(payload)) > size {
+			return fmt.Errorf("%w: overflow chain longer than its size", ErrFormat)
+		}
+		size -= uint64(len(payload))
+		fn(payload)
+		if next < 2 {
+			if size != 0 {
+				return fmt.Errorf("%w: overflow chain %d bytes short", ErrFormat, size)
+			}
+			return nil
+		}
+		blockID = next
+	}
+}
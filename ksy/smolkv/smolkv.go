@@ -0,0 +1,527 @@
+// Package smolkv is a read-only decoder of the smol kv file format, written
+// from the specification in ksy/kv_format.ksy rather than from the writer.
+//
+// It shares no code with the packages that write the format, so it can
+// check them, and serve as a reference for other implementations. It
+// decodes the meta blocks, the freelist chain, the kv entry, B+ tree pages
+// and overflow chains, verifying checksums along the way.
+//
+//	f, err := smolkv.Open(file, nil)
+//	root, _, err := f.Entry()
+//	err = f.Walk(root, func(key, val []byte) error { ... })
+package smolkv
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+var (
+	ErrFormat   = errors.New("bad format")
+	ErrChecksum = errors.New("bad checksum")
+	ErrCipher   = errors.New("cipher key required")
+)
+
+// Meta tags, as the meta enum of the specification.
+const (
+	tagEnd          = 0
+	tagVersion      = 1
+	tagRequired     = 2
+	tagOptional     = 3
+	tagCkp          = 5
+	tagUpdateTime   = 6
+	tagBlockSize    = 7
+	tagBlockCount   = 8
+	tagID           = 9
+	tagPrevID       = 10
+	tagFreeRecycled = 11
+	tagFreeTotal    = 12
+	tagFreelist     = 13
+	tagCodecSpec    = 14
+	tagEntry        = 15
+	tagEntryID      = 16
+)
+
+// Features is the required feature bits this reader understands, as the
+// features enum of the specification: prefix leaves, short separators and
+// the expiry index root in the entry.
+const Features = 1 | 2 | 4
+
+// cipherAES256GCM is the cipher suite number in a codec spec.
+const cipherAES256GCM = 5
+
+// Meta is a decoded meta_block.
+type Meta struct {
+	Version      uint64
+	Required     uint64 // feature bits a reader must understand
+	Optional     uint64 // feature bits a reader may ignore
+	Ckp          uint32
+	UpdateTime   int64 // unix milliseconds
+	BlockSize    uint32
+	BlockCount   uint32
+	ID           uint32 // block of this meta when checkpoints are retained
+	PrevID       uint32 // block of the previous meta when checkpoints are retained
+	FreeRecycled uint32
+	FreeTotal    uint32
+	Freelist     []byte
+	CodecSpec    []byte // nil: plain, empty: crc32, else a varint cipher suite
+	Entry        []byte // head of the entry, the rest is in block EntryID
+	EntryID      uint32
+	Checksum     uint32
+}
+
+// Options configures Open.
+type Options struct {
+	Magic     [4]byte // zero means "DICT"
+	CipherKey []byte  // for files encrypted with aes-256-gcm
+}
+
+// File is an opened smol kv file.
+type File struct {
+	r       io.ReaderAt
+	magic   [4]byte
+	MetaA   *Meta // meta_a, nil if unreadable
+	MetaB   *Meta // meta_b, nil if unreadable
+	Meta    *Meta // the newer of MetaA and MetaB
+	aead    cipher.AEAD
+	nonce   int // bytes of nonce at the end of a block
+	tag     int // bytes of checksum or tag before the nonce
+	adBlock bool
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Open decodes the meta blocks of r and selects the newer one.
+// A nil opts uses the defaults.
+func Open(r io.ReaderAt, opts *Options) (*File, error) {
+	var opt Options
+	if opts != nil {
+		opt = *opts
+	}
+	f := &File{r: r, magic: opt.Magic}
+	if f.magic == [4]byte{} {
+		f.magic = [4]byte{'D', 'I', 'C', 'T'}
+	}
+
+	var errA, errB error
+	f.MetaA, errA = f.readMeta(0, true)
+	if f.MetaA != nil {
+		f.MetaB, errB = f.readMeta(int64(f.MetaA.BlockSize), true)
+	} else {
+		for i := range 5 {
+			if f.MetaB, errB = f.readMeta(int64(4096)<<i, true); f.MetaB != nil {
+				break
+			}
+		}
+	}
+	switch {
+	case f.MetaA == nil && f.MetaB == nil:
+		return nil, fmt.Errorf("meta_a: %w, meta_b: %w", errA, errB)
+	case f.MetaB == nil:
+		f.Meta = f.MetaA
+	case f.MetaA == nil:
+		f.Meta = f.MetaB
+	case f.MetaA.Ckp == 0 && f.MetaB.Ckp == math.MaxUint32:
+		f.Meta = f.MetaA // ckp wrapped around
+	case f.MetaA.Ckp < f.MetaB.Ckp:
+		f.Meta = f.MetaB
+	default:
+		f.Meta = f.MetaA
+	}
+
+	meta := f.Meta
+	if meta.Version != 0 {
+		return nil, fmt.Errorf("%w: version %d", ErrFormat, meta.Version)
+	}
+	if meta.Required&^Features != 0 {
+		return nil, fmt.Errorf("%w: required features %#x", ErrFormat, meta.Required&^Features)
+	}
+	if meta.BlockSize < 512 || meta.BlockSize > 1<<16 {
+		return nil, fmt.Errorf("%w: block size %d", ErrFormat, meta.BlockSize)
+	}
+	if err := f.loadCodec(meta.CodecSpec, opt.CipherKey); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) loadCodec(spec, key []byte) error {
+	switch {
+	case spec == nil:
+		f.tag = 4
+	case len(spec) == 0:
+		f.tag, f.adBlock = 4, true
+	default:
+		suite, n := binary.Varint(spec)
+		if n <= 0 || suite != cipherAES256GCM {
+			return fmt.Errorf("%w: codec spec %x", ErrFormat, spec)
+		}
+		if len(key) != 32 {
+			return ErrCipher
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return err
+		}
+		if f.aead, err = cipher.NewGCM(block); err != nil {
+			return err
+		}
+		f.tag, f.nonce = f.aead.Overhead(), f.aead.NonceSize()
+	}
+	return nil
+}
+
+// ReadMeta decodes a meta retained in block blockID, as linked by ID and PrevID.
+func (f *File) ReadMeta(blockID uint32) (*Meta, error) {
+	return f.readMeta(int64(blockID)*int64(f.Meta.BlockSize), false)
+}
+
+// readMeta decodes a meta_block at offset, which starts with the magic
+// code for meta_a and meta_b, and with zeros for a retained meta.
+func (f *File) readMeta(offset int64, magic bool) (*Meta, error) {
+	buf := make([]byte, 1<<16)
+	n, err := f.r.ReadAt(buf, offset)
+	if n < 4 {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	buf = buf[:n]
+	if want := f.magic; !magic {
+		want = [4]byte{}
+		if [4]byte(buf) != want {
+			return nil, fmt.Errorf("%w: retained meta head %x", ErrFormat, buf[:4])
+		}
+	} else if [4]byte(buf) != want {
+		return nil, fmt.Errorf("%w: magic %q", ErrFormat, buf[:4])
+	}
+
+	meta := new(Meta)
+	d := decoder{buf: buf, off: 4}
+	for {
+		tag := d.varint()
+		key := tag
+		if key < 0 {
+			key = -key
+		}
+		if key == tagEnd {
+			break
+		}
+		var val uint64
+		var bytes []byte
+		switch key {
+		case tagFreelist, tagCodecSpec, tagEntry:
+			if tag > 0 {
+				return nil, fmt.Errorf("%w: meta tag %d", ErrFormat, tag)
+			}
+		case tagVersion, tagRequired, tagOptional, tagCkp, tagUpdateTime,
+			tagBlockSize, tagBlockCount, tagID, tagPrevID,
+			tagFreeRecycled, tagFreeTotal, tagEntryID:
+			if tag < 0 {
+				return nil, fmt.Errorf("%w: meta tag %d", ErrFormat, tag)
+			}
+		}
+		// unknown tags are skipped: negative ones carry bytes
+		if tag < 0 {
+			bytes = d.bytes(d.uvarint())
+		} else {
+			val = d.uvarint()
+		}
+		if d.err != nil {
+			return nil, d.err
+		}
+		switch key {
+		case tagVersion:
+			meta.Version = val
+		case tagRequired:
+			meta.Required = val
+		case tagOptional:
+			meta.Optional = val
+		case tagCkp:
+			meta.Ckp = uint32(val)
+		case tagUpdateTime:
+			meta.UpdateTime = int64(val)
+		case tagBlockSize:
+			meta.BlockSize = uint32(val)
+		case tagBlockCount:
+			meta.BlockCount = uint32(val)
+		case tagID:
+			meta.ID = uint32(val)
+		case tagPrevID:
+			meta.PrevID = uint32(val)
+		case tagFreeRecycled:
+			meta.FreeRecycled = uint32(val)
+		case tagFreeTotal:
+			meta.FreeTotal = uint32(val)
+		case tagFreelist:
+			meta.Freelist = bytes
+		case tagCodecSpec:
+			meta.CodecSpec = bytes
+		case tagEntry:
+			meta.Entry = bytes
+		case tagEntryID:
+			meta.EntryID = uint32(val)
+		}
+	}
+	end := d.off
+	meta.Checksum = d.u4()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if crc32.Checksum(buf[4:end], castagnoli) != meta.Checksum {
+		return nil, fmt.Errorf("meta: %w", ErrChecksum)
+	}
+	return meta, nil
+}
+
+// BlockSize returns the size of a block, including the codec overhead.
+func (f *File) BlockSize() int {
+	return int(f.Meta.BlockSize)
+}
+
+// PageSize returns the size of the page a data_block holds.
+func (f *File) PageSize() int {
+	return f.BlockSize() - f.tag - f.nonce
+}
+
+// InlineSizes returns the sizes above which a stored key or value
+// is an overflow_head, for the page size of the file.
+func (f *File) InlineSizes() (key, val int) {
+	pageSize := f.PageSize()
+	maxOverflow := uint64(math.MaxUint32) * uint64(pageSize)
+	keyHead := (pageSize-4)/5 - 2 - 4
+	key = keyHead - uvarintLen(maxOverflow) - 4
+	val = pageSize - 4 - 2 - uvarintLen(uint64(keyHead)) - keyHead - uvarintLen(maxOverflow) - 4
+	return
+}
+
+// Block reads a data_block, verifies it and returns its page.
+func (f *File) Block(blockID uint32) ([]byte, error) {
+	if blockID < 2 || blockID >= f.Meta.BlockCount {
+		return nil, fmt.Errorf("%w: block %d out of %d", ErrFormat, blockID, f.Meta.BlockCount)
+	}
+	buf := make([]byte, f.BlockSize())
+	if _, err := f.r.ReadAt(buf, int64(blockID)*int64(f.BlockSize())); err != nil {
+		return nil, fmt.Errorf("block %d: %w", blockID, err)
+	}
+	page, err := f.open(buf, blockID)
+	if err != nil {
+		return nil, fmt.Errorf("block %d: %w", blockID, err)
+	}
+	return page, nil
+}
+
+// open verifies or decrypts data sealed for blockID, returning the payload.
+func (f *File) open(data []byte, blockID uint32) ([]byte, error) {
+	if len(data) < f.tag+f.nonce {
+		return nil, fmt.Errorf("%w: sealed size %d", ErrFormat, len(data))
+	}
+	var ad [4]byte
+	binary.LittleEndian.PutUint32(ad[:], blockID)
+	if f.aead != nil {
+		off := len(data) - f.nonce
+		plain, err := f.aead.Open(nil, data[off:], data[:off], ad[:])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrChecksum, err)
+		}
+		return plain, nil
+	}
+	off := len(data) - f.tag
+	sum := crc32.Checksum(data[:off], castagnoli)
+	if f.adBlock {
+		sum = crc32.Update(sum, castagnoli, ad[:])
+	}
+	if binary.LittleEndian.Uint32(data[off:]) != sum {
+		return nil, ErrChecksum
+	}
+	return data[:off], nil
+}
+
+// Entry returns the root page of the kv tree, and the root page of the
+// expiry index if any, from the entry of the meta. Both are nil for an
+// empty store.
+func (f *File) Entry() (root, expiry []byte, err error) {
+	entry, err := f.entry()
+	if err != nil || len(entry) == 0 {
+		return
+	}
+	if len(entry) < 4 {
+		return nil, nil, fmt.Errorf("%w: entry size %d", ErrFormat, len(entry))
+	}
+	size := 4 + int(binary.LittleEndian.Uint16(entry[2:]))
+	switch {
+	case size > len(entry):
+		err = fmt.Errorf("%w: entry root size %d", ErrFormat, size)
+	case size == len(entry):
+		root = entry
+	default:
+		root, expiry = entry[:size], entry[size:]
+		if 4+int(binary.LittleEndian.Uint16(expiry[2:])) != len(expiry) {
+			err = fmt.Errorf("%w: entry expiry root size", ErrFormat)
+		}
+	}
+	return
+}
+
+// entry assembles the entry: inline in the meta, or split between the meta
+// and block EntryID, whose head is {0, 0, u2 length}.
+func (f *File) entry() ([]byte, error) {
+	meta := f.Meta
+	var tail []byte
+	if meta.EntryID >= 2 {
+		buf := make([]byte, f.BlockSize())
+		if _, err := f.r.ReadAt(buf, int64(meta.EntryID)*int64(f.BlockSize())); err != nil {
+			return nil, fmt.Errorf("entry block %d: %w", meta.EntryID, err)
+		}
+		size := 4 + int(binary.LittleEndian.Uint16(buf[2:]))
+		if buf[0] != 0 || buf[1] != 0 || size > len(buf) {
+			return nil, fmt.Errorf("%w: entry block %d head", ErrFormat, meta.EntryID)
+		}
+		if meta.CodecSpec == nil {
+			if size > len(buf)-4 {
+				return nil, fmt.Errorf("%w: entry block %d head", ErrFormat, meta.EntryID)
+			}
+			// the block holds the front of the entry, checksummed as is
+			if binary.LittleEndian.Uint32(buf[size:]) != crc32.Checksum(buf[:size], castagnoli) {
+				return nil, fmt.Errorf("entry block %d: %w", meta.EntryID, ErrChecksum)
+			}
+			return append(buf[4:size:size], meta.Entry...), nil
+		}
+		tail = buf[4:size]
+	}
+	if meta.CodecSpec == nil {
+		return meta.Entry, nil
+	}
+	// the sealed entry is split, its front in the meta
+	sealed := append(append([]byte{}, meta.Entry...), tail...)
+	if len(sealed) == 0 {
+		return nil, nil
+	}
+	entry, err := f.open(sealed, 1)
+	if err != nil {
+		return nil, fmt.Errorf("entry: %w", err)
+	}
+	return entry, nil
+}
+
+// Free returns the free blocks listed by the freelist chain, and the
+// blocks holding the chain itself.
+func (f *File) Free() (free, chain []uint32, err error) {
+	meta := f.Meta
+	total := int(meta.FreeRecycled) + int(meta.FreeTotal)
+	if total == 0 {
+		return
+	}
+	list := meta.Freelist
+	var blockID uint32
+	for {
+		ids, prev, err := decodeFreelist(list)
+		if err != nil {
+			if blockID != 0 {
+				err = fmt.Errorf("freelist block %d: %w", blockID, err)
+			}
+			return nil, nil, err
+		}
+		if n := total - len(free); len(ids) >= n {
+			free = append(free, ids[:n]...)
+			return free, chain, nil
+		}
+		free = append(free, ids...)
+		if prev < 2 || prev >= meta.BlockCount || len(chain) > int(meta.BlockCount) {
+			return nil, nil, fmt.Errorf("%w: freelist lists %d of %d free blocks", ErrFormat, len(free), total)
+		}
+		blockID = prev
+		chain = append(chain, blockID)
+		list = make([]byte, f.BlockSize())
+		if _, err = f.r.ReadAt(list, int64(blockID)*int64(f.BlockSize())); err != nil {
+			return nil, nil, fmt.Errorf("freelist block %d: %w", blockID, err)
+		}
+	}
+}
+
+// decodeFreelist decodes a freelist: {0x00, 0x40, u2 length, u4 next_id,
+// u4 free_ids..., u4 checksum}.
+func decodeFreelist(list []byte) (ids []uint32, prev uint32, err error) {
+	if len(list) < 12 || list[0] != 0 || list[1] != 0x40 {
+		return nil, 0, fmt.Errorf("%w: freelist head", ErrFormat)
+	}
+	length := int(binary.LittleEndian.Uint16(list[2:]))
+	end := 4 + length
+	if length < 4 || length%4 != 0 || end+4 > len(list) {
+		return nil, 0, fmt.Errorf("%w: freelist length %d", ErrFormat, length)
+	}
+	if binary.LittleEndian.Uint32(list[end:]) != crc32.Checksum(list[:end], castagnoli) {
+		return nil, 0, fmt.Errorf("freelist: %w", ErrChecksum)
+	}
+	prev = binary.LittleEndian.Uint32(list[4:])
+	for off := 8; off < end; off += 4 {
+		ids = append(ids, binary.LittleEndian.Uint32(list[off:]))
+	}
+	return
+}
+
+// decoder reads the primitive types of the specification.
+type decoder struct {
+	buf []byte
+	off int
+	err error
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: truncated at %d", ErrFormat, d.off)
+	}
+	d.off = len(d.buf)
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.buf[d.off:])
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.off += n
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	v, n := binary.Varint(d.buf[d.off:])
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.off += n
+	return v
+}
+
+func (d *decoder) bytes(n uint64) []byte {
+	if n > uint64(len(d.buf)-d.off) {
+		d.fail()
+		return nil
+	}
+	b := d.buf[d.off : d.off+int(n) : d.off+int(n)]
+	d.off += int(n)
+	return b
+}
+
+func (d *decoder) u4() uint32 {
+	if b := d.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func uvarintLen(v uint64) int {
+	n := 1
+	for ; v >= 0x80; v >>= 7 {
+		n++
+	}
+	return n
+}
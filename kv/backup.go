@@ -0,0 +1,537 @@
+package kv
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/dacapoday/smol/block"
+	"github.com/dacapoday/smol/bptree"
+)
+
+// Backup file layout, little-endian:
+//
+//	header:   magic "SMBK", version u32, block size u32, block count u32,
+//	          base checkpoint u32, checkpoint u32, flags u32,
+//	          format version u32, required features u64, entry size u32, entry
+//	manifest: count u32, then {BlockID u32, hash u64} sorted by BlockID
+//	blocks:   count u32, then {BlockID u32, raw block}
+//	trailer:  CRC-32C of all preceding bytes
+//
+// The manifest lists every block reachable from the entry, the root pages
+// of the kv tree and its expiry index, with a CRC-64 of its content. An
+// incremental backup holds only blocks whose BlockID and hash are not in
+// the manifest of its base. Version 1
+// backups have no format fields, and restore requiring every feature.
+var backupMagic = [4]byte{'S', 'M', 'B', 'K'}
+
+const (
+	backupVersion     = 2
+	backupIncremental = 1 << 0
+)
+
+var (
+	backupCRC32 = crc32.MakeTable(crc32.Castagnoli)
+	backupCRC64 = crc64.MakeTable(crc64.ECMA)
+)
+
+// BackupInfo describes a backup.
+type BackupInfo struct {
+	Ckp         uint32 // checkpoint captured by the backup
+	BaseCkp     uint32 // checkpoint of the base backup, if Incremental
+	Incremental bool
+	Format      Format // format of the store backed up, without Optional
+	Blocks      int    // blocks reachable from the checkpoint
+	Stored      int    // blocks stored in the backup
+}
+
+type backupHeader struct {
+	BackupInfo
+	blockSize  uint32
+	blockCount uint32
+	entry      []byte
+}
+
+type manifestItem struct {
+	blockID bptree.BlockID
+	hash    uint64
+}
+
+// backupBase is the manifest of the last backup written or loaded,
+// the base of the next incremental backup.
+type backupBase struct {
+	sync.Mutex
+	ckp      uint32
+	manifest []manifestItem
+	ok       bool
+}
+
+func (base *backupBase) set(ckp uint32, manifest []manifestItem) {
+	base.Lock()
+	base.ckp, base.manifest, base.ok = ckp, manifest, true
+	base.Unlock()
+}
+
+// get returns the manifest of the base of checkpoint ckp, if retained.
+func (base *backupBase) get(ckp uint32) (manifest []manifestItem, ok bool) {
+	base.Lock()
+	defer base.Unlock()
+	if base.ok && base.ckp == ckp {
+		return base.manifest, true
+	}
+	return nil, false
+}
+
+func (base *backupBase) reset() {
+	base.Lock()
+	base.ckp, base.manifest, base.ok = 0, nil, false
+	base.Unlock()
+}
+
+// Backup writes a full backup of the current snapshot to w.
+//
+// The KV retains the manifest of its last backup, about 16 bytes per block,
+// to be the base of the next incremental one (see BackupSince).
+func (kv *KV[F]) Backup(w io.Writer) (info BackupInfo, err error) {
+	return kv.BackupContext(context.Background(), w)
+}
+
+// BackupContext is Backup, stopping with the context's error once ctx
+// is done. What was written to w by then is not a usable backup.
+func (kv *KV[F]) BackupContext(ctx context.Context, w io.Writer) (info BackupInfo, err error) {
+	return kv.backup(ctx, w, info)
+}
+
+// BackupSince writes an incremental backup of the current snapshot to w,
+// holding only the blocks changed since the backup of checkpoint baseCkp.
+// Copy-on-write leaves unchanged subtrees in place, so this is roughly the
+// blocks written since then. Every reachable block is still read, since a
+// BlockID recycled and reused since the base cannot be told apart otherwise.
+//
+// The base is the last backup written by the KV, or loaded by
+// LoadBackupBase. Returns ErrOutOfRange for any other checkpoint.
+func (kv *KV[F]) BackupSince(w io.Writer, baseCkp uint32) (info BackupInfo, err error) {
+	return kv.BackupSinceContext(context.Background(), w, baseCkp)
+}
+
+// BackupSinceContext is BackupSince, stopping with the context's error once
+// ctx is done, like BackupContext.
+func (kv *KV[F]) BackupSinceContext(ctx context.Context, w io.Writer, baseCkp uint32) (info BackupInfo, err error) {
+	info.Incremental = true
+	info.BaseCkp = baseCkp
+	return kv.backup(ctx, w, info)
+}
+
+// LoadBackupBase reads the header and manifest of the backup base, such as
+// the last one taken before the store was reopened, and retains it as the
+// base of BackupSince. It returns the info of base, whose Ckp is the
+// checkpoint to pass to BackupSince.
+func (kv *KV[F]) LoadBackupBase(base io.Reader) (info BackupInfo, err error) {
+	r := newBackupReader(base)
+	header, err := r.header()
+	if err != nil {
+		err = fmt.Errorf("kv.LoadBackupBase: %w", err)
+		return
+	}
+	manifest, err := r.manifest()
+	if err != nil {
+		err = fmt.Errorf("kv.LoadBackupBase: %w", err)
+		return
+	}
+	kv.backupBase.set(header.Ckp, manifest)
+	return header.BackupInfo, nil
+}
+
+// backup writes a backup of the current snapshot, incremental if
+// info is.
+func (kv *KV[F]) backup(ctx context.Context, w io.Writer, info BackupInfo) (_ BackupInfo, err error) {
+	root, ckpt := kv.atom.Acquire()
+	if ckpt == nil {
+		err = ErrClosed
+		return
+	}
+	defer ckpt.Release()
+
+	var baseManifest []manifestItem
+	if info.Incremental {
+		var ok bool
+		if baseManifest, ok = kv.backupBase.get(info.BaseCkp); !ok {
+			err = fmt.Errorf("kv.Backup: %w: no base of checkpoint %d", ErrOutOfRange, info.BaseCkp)
+			return
+		}
+	}
+	info.Ckp = ckpt.Ckp()
+	// read after the snapshot, so it covers the features of its pages
+	format := kv.block.Format()
+	info.Format = Format{Version: format.Version, Required: format.Required}
+
+	pageSize := kv.block.PageSize()
+	var manifest []manifestItem
+	var stored []bptree.BlockID
+	visit := func(blockID bptree.BlockID, page []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		item := manifestItem{blockID, crc64.Checksum(page[:pageSize], backupCRC64)}
+		manifest = append(manifest, item)
+		if _, found := slices.BinarySearchFunc(baseManifest, item, compareManifestItem); !found {
+			stored = append(stored, blockID)
+		}
+		return nil
+	}
+	main, index := splitEntry(root)
+	if err = bptree.Walk(&kv.block, main, kv.klen, kv.vlen, visit); err == nil {
+		err = bptree.Walk(&kv.block, index, kv.klen, kv.vlen, visit)
+	}
+	if err != nil {
+		err = fmt.Errorf("kv.Backup: %w", err)
+		return
+	}
+	slices.SortFunc(manifest, compareManifestItem)
+	slices.Sort(stored)
+	info.Blocks = len(manifest)
+	info.Stored = len(stored)
+
+	header := backupHeader{
+		BackupInfo: info,
+		blockSize:  uint32(kv.block.BlockSize()),
+		blockCount: 2,
+		entry:      root,
+	}
+	if len(manifest) != 0 {
+		header.blockCount = manifest[len(manifest)-1].blockID + 1
+	}
+
+	bw := newBackupWriter(w)
+	bw.header(header)
+	bw.u32(uint32(len(manifest)))
+	for _, item := range manifest {
+		bw.u32(item.blockID)
+		bw.u64(item.hash)
+	}
+
+	buffer := kv.block.AllocateBuffer()
+	defer kv.block.RecycleBuffer(buffer)
+	bw.u32(uint32(len(stored)))
+	for _, blockID := range stored {
+		if err = ctx.Err(); err != nil {
+			err = fmt.Errorf("kv.Backup: %w", err)
+			return
+		}
+		if _, err = kv.block.ReadAt(buffer, blockID); err != nil {
+			err = fmt.Errorf("kv.Backup: read block %d: %w", blockID, err)
+			return
+		}
+		bw.u32(blockID)
+		bw.write(buffer)
+	}
+
+	if err = bw.close(); err != nil {
+		err = fmt.Errorf("kv.Backup: %w", err)
+		return
+	}
+	kv.backupBase.set(info.Ckp, manifest)
+	return info, nil
+}
+
+// ReadBackupInfo reads the header of a backup, to pick the base of the next
+// incremental backup or order a chain for Restore.
+func ReadBackupInfo(r io.Reader) (info BackupInfo, err error) {
+	header, err := newBackupReader(r).header()
+	info = header.BackupInfo
+	return
+}
+
+// Restore initializes the KV store from backups: a full backup followed
+// by incremental backups, each based on the one before. The file must be
+// empty. On error the store is left loaded and empty.
+func (kv *KV[F]) Restore(file F, backups ...io.Reader) (err error) {
+	if len(backups) == 0 {
+		return errors.New("kv.Restore: no backup")
+	}
+	if _, err = file.ReadAt([]byte{0}, 0); !errors.Is(err, io.EOF) {
+		if err == nil {
+			err = errors.New("file not empty")
+		}
+		return fmt.Errorf("kv.Restore: %w", err)
+	}
+
+	if err = kv.LoadFile(file, nil); err != nil {
+		return
+	}
+	if err = kv.restore(backups); err != nil {
+		kv.block.Rollback()
+		err = fmt.Errorf("kv.Restore: %w", err)
+	}
+	return
+}
+
+func (kv *KV[F]) restore(backups []io.Reader) (err error) {
+	blockSize := kv.block.BlockSize()
+	blockCount := bptree.BlockID(2)
+	buffer := kv.block.AllocateBuffer()
+	defer kv.block.RecycleBuffer(buffer)
+
+	var header backupHeader
+	var manifest []manifestItem
+	for i, backup := range backups {
+		r := newBackupReader(backup)
+		ckp := header.Ckp
+		if header, err = r.header(); err != nil {
+			return fmt.Errorf("backup %d: %w", i, err)
+		}
+		if i == 0 && header.Incremental {
+			return fmt.Errorf("backup 0: %w: incremental backup without base", ErrOutOfRange)
+		}
+		if i != 0 && (!header.Incremental || header.BaseCkp != ckp) {
+			return fmt.Errorf("backup %d: %w: not based on checkpoint %d", i, ErrOutOfRange, ckp)
+		}
+		if int(header.blockSize) != blockSize {
+			return fmt.Errorf("backup %d: %w block size: %d", i, ErrUnsupported, header.blockSize)
+		}
+		if err = header.Format.Check(supportedFormat(kv.disabled)); err != nil {
+			return fmt.Errorf("backup %d: %w", i, err)
+		}
+		if manifest, err = r.manifest(); err != nil {
+			return fmt.Errorf("backup %d: %w", i, err)
+		}
+
+		n := r.u32()
+		for range n {
+			blockID := r.u32()
+			r.read(buffer)
+			if r.err != nil {
+				break
+			}
+			if blockID < 2 || blockID >= header.blockCount {
+				return fmt.Errorf("backup %d: %w: block %d", i, ErrBadMeta, blockID)
+			}
+			for blockCount <= blockID {
+				if kv.block.Extend() != blockCount {
+					return kv.block.Error()
+				}
+				blockCount++
+			}
+			if _, err = kv.block.WriteAt(buffer, blockID); err != nil {
+				return
+			}
+		}
+		if err = r.close(); err != nil {
+			return fmt.Errorf("backup %d: %w", i, err)
+		}
+	}
+
+	root, err := rootPage(header.entry)
+	if err != nil {
+		return
+	}
+
+	for blockID := bptree.BlockID(2); blockID < blockCount; blockID++ {
+		if _, found := slices.BinarySearchFunc(manifest, manifestItem{blockID: blockID}, compareManifestID); !found {
+			kv.block.RecycleBlock(blockID)
+		}
+	}
+
+	// the restored store requires, and uses, the features its source did
+	if err = kv.block.SetFormat(header.Format); err != nil {
+		return
+	}
+	return kv.atom.Swap(func(bptree.Page) (bptree.Page, block.HeapCheckpoint, error) {
+		ckpt, err := kv.block.Commit(root)
+		if err == nil {
+			kv.features = 0
+			kv.enable(header.Format.Required)
+		}
+		return root, ckpt, err
+	})
+}
+
+func compareManifestItem(a, b manifestItem) int {
+	if a.blockID != b.blockID {
+		return compareManifestID(a, b)
+	}
+	if a.hash < b.hash {
+		return -1
+	}
+	if a.hash > b.hash {
+		return 1
+	}
+	return 0
+}
+
+func compareManifestID(a, b manifestItem) int {
+	if a.blockID < b.blockID {
+		return -1
+	}
+	if a.blockID > b.blockID {
+		return 1
+	}
+	return 0
+}
+
+// backupWriter writes a backup, keeping the first error.
+type backupWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	buf [8]byte
+	err error
+}
+
+func newBackupWriter(w io.Writer) *backupWriter {
+	crc := crc32.New(backupCRC32)
+	return &backupWriter{w: bufio.NewWriter(io.MultiWriter(w, crc)), crc: crc}
+}
+
+func (bw *backupWriter) write(p []byte) {
+	if bw.err == nil {
+		_, bw.err = bw.w.Write(p)
+	}
+}
+
+func (bw *backupWriter) u32(v uint32) {
+	bw.write(binary.LittleEndian.AppendUint32(bw.buf[:0], v))
+}
+
+func (bw *backupWriter) u64(v uint64) {
+	bw.write(binary.LittleEndian.AppendUint64(bw.buf[:0], v))
+}
+
+func (bw *backupWriter) header(header backupHeader) {
+	var flags uint32
+	if header.Incremental {
+		flags |= backupIncremental
+	}
+	bw.write(backupMagic[:])
+	bw.u32(backupVersion)
+	bw.u32(header.blockSize)
+	bw.u32(header.blockCount)
+	bw.u32(header.BaseCkp)
+	bw.u32(header.Ckp)
+	bw.u32(flags)
+	bw.u32(uint32(header.Format.Version))
+	bw.u64(header.Format.Required)
+	bw.u32(uint32(len(header.entry)))
+	bw.write(header.entry)
+}
+
+func (bw *backupWriter) close() error {
+	if bw.err != nil {
+		return bw.err
+	}
+	if bw.err = bw.w.Flush(); bw.err != nil {
+		return bw.err
+	}
+	bw.u32(bw.crc.Sum32())
+	if bw.err == nil {
+		bw.err = bw.w.Flush()
+	}
+	return bw.err
+}
+
+// backupReader reads a backup, keeping the first error.
+type backupReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	buf [8]byte
+	err error
+}
+
+func newBackupReader(r io.Reader) *backupReader {
+	return &backupReader{r: bufio.NewReader(r), crc: crc32.New(backupCRC32)}
+}
+
+func (br *backupReader) read(p []byte) {
+	if br.err != nil {
+		return
+	}
+	if _, br.err = io.ReadFull(br.r, p); br.err != nil {
+		if errors.Is(br.err, io.EOF) {
+			br.err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	br.crc.Write(p)
+}
+
+func (br *backupReader) u32() uint32 {
+	br.read(br.buf[:4])
+	return binary.LittleEndian.Uint32(br.buf[:4])
+}
+
+func (br *backupReader) u64() uint64 {
+	br.read(br.buf[:8])
+	return binary.LittleEndian.Uint64(br.buf[:8])
+}
+
+func (br *backupReader) header() (header backupHeader, err error) {
+	var magic [4]byte
+	br.read(magic[:])
+	if br.err == nil && magic != backupMagic {
+		return header, fmt.Errorf("%w: %v", ErrUnknownMagicCode, magic)
+	}
+	version := br.u32()
+	if br.err == nil && (version == 0 || version > backupVersion) {
+		return header, fmt.Errorf("%w backup version: %d", ErrUnsupported, version)
+	}
+	header.blockSize = br.u32()
+	header.blockCount = br.u32()
+	header.BaseCkp = br.u32()
+	header.Ckp = br.u32()
+	header.Incremental = br.u32()&backupIncremental != 0
+	if version == 1 {
+		header.Format = Format{Version: FormatVersion(), Required: Features}
+	} else if v := br.u32(); br.err == nil {
+		if v > 255 {
+			return header, fmt.Errorf("%w: format version %d", ErrBadMeta, v)
+		}
+		header.Format = Format{Version: byte(v), Required: br.u64()}
+	}
+	if size := br.u32(); br.err == nil {
+		if size > header.blockSize {
+			return header, fmt.Errorf("%w: entry size %d", ErrBadMeta, size)
+		}
+		header.entry = make([]byte, size)
+		br.read(header.entry)
+	}
+	return header, br.err
+}
+
+func (br *backupReader) manifest() (manifest []manifestItem, err error) {
+	n := br.u32()
+	if br.err != nil {
+		return nil, br.err
+	}
+	manifest = make([]manifestItem, 0, min(n, 1<<20))
+	for range n {
+		item := manifestItem{blockID: br.u32(), hash: br.u64()}
+		if br.err != nil {
+			return nil, br.err
+		}
+		manifest = append(manifest, item)
+	}
+	if !slices.IsSortedFunc(manifest, compareManifestID) {
+		return nil, fmt.Errorf("%w: manifest not sorted", ErrBadMeta)
+	}
+	return
+}
+
+// close verifies the trailer.
+func (br *backupReader) close() error {
+	sum := br.crc.Sum32()
+	var trailer [4]byte
+	if _, err := io.ReadFull(br.r, trailer[:]); br.err == nil && err != nil {
+		br.err = err
+	}
+	if br.err == nil && binary.LittleEndian.Uint32(trailer[:]) != sum {
+		br.err = ErrBadChecksum
+	}
+	return br.err
+}
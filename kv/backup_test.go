@@ -0,0 +1,219 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dacapoday/smol/mem"
+)
+
+// TestBackupRestore tests a full backup followed by incremental backups.
+// Restoring the chain reproduces the store.
+func TestBackupRestore(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	big := bytes.Repeat([]byte("0123456789"), 3000)
+	kv.Batch(func(yield func([]byte, []byte) bool) {
+		for i := range 30000 {
+			yield(fmt.Appendf(nil, "key%05d", i), fmt.Appendf(nil, "val%05d", i))
+		}
+		yield([]byte("big"), big)
+	})
+
+	var backups []*bytes.Buffer
+	var prev BackupInfo
+	backup := func() BackupInfo {
+		t.Helper()
+		w := new(bytes.Buffer)
+		var err error
+		if len(backups) == 0 {
+			prev, err = kv.Backup(w)
+		} else {
+			prev, err = kv.BackupSince(w, prev.Ckp)
+		}
+		if err != nil {
+			t.Fatalf("Backup: %v", err)
+		}
+		backups = append(backups, w)
+		return prev
+	}
+
+	full := backup()
+	if full.Incremental || full.Stored != full.Blocks {
+		t.Fatalf("full backup: %+v", full)
+	}
+
+	for round := range 2 {
+		if round == 1 {
+			// the base is retained by the KV, or loaded after reopening
+			kv.backupBase.reset()
+			if _, err := kv.BackupSince(io.Discard, prev.Ckp); !errors.Is(err, ErrOutOfRange) {
+				t.Fatalf("BackupSince without base: %v, want ErrOutOfRange", err)
+			}
+			if base, err := kv.LoadBackupBase(bytes.NewReader(backups[round].Bytes())); err != nil || base.Ckp != prev.Ckp {
+				t.Fatalf("LoadBackupBase = %+v, %v, want Ckp %d", base, err, prev.Ckp)
+			}
+		}
+		for i := range 5 {
+			kv.Set(fmt.Appendf(nil, "key%05d", i*5000+round), []byte("changed"))
+		}
+		kv.Set([]byte("key29999"), nil)
+		info := backup()
+		if !info.Incremental || info.Stored*4 > info.Blocks {
+			t.Fatalf("incremental backup %d: %+v", round, info)
+		}
+		if prev, _ := ReadBackupInfo(bytes.NewReader(backups[round].Bytes())); info.BaseCkp != prev.Ckp {
+			t.Fatalf("BaseCkp = %d, want %d", info.BaseCkp, prev.Ckp)
+		}
+	}
+
+	var restoredFile mem.File
+	var restored KV[*mem.File]
+	if err := restored.Restore(&restoredFile, backupReaders(backups...)...); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	defer restored.Close()
+
+	want, got := kv.Iter(), restored.Iter()
+	defer want.Close()
+	defer got.Close()
+	n := 0
+	got.SeekFirst()
+	for want.SeekFirst(); want.Valid(); want.Next() {
+		if !got.Valid() || !bytes.Equal(want.Key(), got.Key()) || !bytes.Equal(want.Val(), got.Val()) {
+			t.Fatalf("restored mismatch at %q: got %q", want.Key(), got.Key())
+		}
+		got.Next()
+		n++
+	}
+	if got.Valid() {
+		t.Fatalf("restored has extra key %q", got.Key())
+	}
+
+	if err := restored.Set([]byte("after"), []byte("restore")); err != nil {
+		t.Fatalf("Set after Restore: %v", err)
+	}
+
+	var broken KV[*mem.File]
+	var brokenFile mem.File
+	if err := broken.Restore(&brokenFile, backupReaders(backups[0], backups[2])...); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("Restore with gap: err=%v, want ErrOutOfRange", err)
+	}
+	broken.Close()
+
+	t.Logf("✓ Restored %d keys from %d backups", n, len(backups))
+}
+
+func backupReaders(buffers ...*bytes.Buffer) (r []io.Reader) {
+	for _, b := range buffers {
+		r = append(r, bytes.NewReader(b.Bytes()))
+	}
+	return
+}
+
+// TestBackupContext tests that a backup stops once its context is cancelled.
+func TestBackupContext(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	kv.Batch(func(yield func([]byte, []byte) bool) {
+		for i := range 5000 {
+			yield(fmt.Appendf(nil, "key%05d", i), fmt.Appendf(nil, "val%05d", i))
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := kv.BackupContext(ctx, io.Discard); !errors.Is(err, context.Canceled) {
+		t.Fatalf("BackupContext: %v, want context.Canceled", err)
+	}
+	if _, err := kv.BackupContext(context.Background(), io.Discard); err != nil {
+		t.Fatalf("BackupContext: %v", err)
+	}
+
+	t.Log("✓ Backup stopped by its context")
+}
+
+// TestBackupFormat tests that a restored store has the format of the one
+// backed up, and that formats this build cannot read are rejected.
+func TestBackupFormat(t *testing.T) {
+	restore := func(backup []byte) (*KV[*mem.File], error) {
+		t.Helper()
+		restored := new(KV[*mem.File])
+		return restored, restored.Restore(new(mem.File), bytes.NewReader(backup))
+	}
+
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+	for i := range 500 {
+		kv.Set(fmt.Appendf(nil, "tenant:0001:order:%06d", i), []byte("val"))
+	}
+	kv.SetTTL([]byte("session"), []byte("token"), time.Hour)
+	var w bytes.Buffer
+	info, err := kv.Backup(&w)
+	if err != nil || info.Format != kv.Format() || info.Format.Required != Features {
+		t.Fatalf("Backup = %+v, %v, store format %+v", info, err, kv.Format())
+	}
+	restored, err := restore(w.Bytes())
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if got := restored.Format(); got != kv.Format() {
+		t.Fatalf("restored format = %+v, want %+v", got, kv.Format())
+	}
+	restored.Close()
+
+	// a store without features stays without them once restored
+	data, err := os.ReadFile("../ksy/kv_sample.kv")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	var sampleFile mem.File
+	sampleFile.ReadFrom(bytes.NewReader(data))
+	var sample KV[*mem.File]
+	if err = sample.Load(&sampleFile); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer sample.Close()
+	w.Reset()
+	if _, err = sample.Backup(&w); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	if restored, err = restore(w.Bytes()); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	restored.Set([]byte("tenant:0002"), []byte("val"))
+	if required := restored.Format().Required; required != 0 {
+		t.Fatalf("restored sample requires %#x, want 0", required)
+	}
+	restored.Close()
+
+	// required features are at offset 32 of the header, checked first
+	unknown := bytes.Clone(w.Bytes())
+	unknown[32+7] = 0x80
+	if restored, err = restore(unknown); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Restore with unknown features: %v", err)
+	}
+	restored.Close()
+
+	t.Log("✓ Restore takes the format of the backed up store")
+}
@@ -0,0 +1,245 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dacapoday/smol/tuple"
+)
+
+// KeyCodec encodes keys of type K into bytes whose lexicographic order is
+// the order of the keys. Encodings are self-delimiting, so that keys can
+// be combined into tuples by PairKey. Decoding malformed data returns an
+// error matching ErrBadEncoding.
+type KeyCodec[K any] interface {
+	// AppendKey appends the encoding of key to buf.
+	AppendKey(buf []byte, key K) []byte
+	// DecodeKey decodes a key from the front of data and returns the rest.
+	DecodeKey(data []byte) (key K, rest []byte, err error)
+}
+
+// ValueCodec encodes values of type V, in no particular order.
+// Unmarshaling malformed data returns an error, matching ErrBadEncoding
+// for the codecs of this package other than JSON and Gob.
+type ValueCodec[V any] interface {
+	Marshal(val V) ([]byte, error)
+	Unmarshal(data []byte) (V, error)
+}
+
+// Key codecs. Each encodes a key as an element of package tuple, so a key
+// of them, or a Pair of such keys, is the encoding of the tuple of its parts.
+var (
+	// Uint64Key encodes a uint64 as a tuple integer.
+	Uint64Key KeyCodec[uint64] = uint64Key{}
+	// Int64Key encodes an int64 as a tuple integer, so negative numbers
+	// sort first.
+	Int64Key KeyCodec[int64] = int64Key{}
+	// StringKey encodes a string as a tuple string, so a string sorts
+	// before its extensions.
+	StringKey KeyCodec[string] = stringKey{}
+	// BytesKey encodes a byte slice as a tuple []byte.
+	BytesKey KeyCodec[[]byte] = bytesKey{}
+	// TimeKey encodes a time as Int64Key does its unix nanoseconds,
+	// dropping its location and monotonic reading. Times decode in UTC.
+	TimeKey KeyCodec[time.Time] = timeKey{}
+)
+
+type uint64Key struct{}
+
+func (uint64Key) AppendKey(buf []byte, key uint64) []byte {
+	return tuple.AppendUint(buf, key)
+}
+
+func (uint64Key) DecodeKey(data []byte) (key uint64, rest []byte, err error) {
+	elem, rest, err := tuple.Decode(data)
+	switch n := elem.(type) {
+	case uint64:
+		return n, rest, nil
+	case int64:
+		if n >= 0 {
+			return uint64(n), rest, nil
+		}
+	}
+	return 0, data, keyError(elem, "uint64", err)
+}
+
+type int64Key struct{}
+
+func (int64Key) AppendKey(buf []byte, key int64) []byte {
+	return tuple.AppendInt(buf, key)
+}
+
+func (int64Key) DecodeKey(data []byte) (key int64, rest []byte, err error) {
+	elem, rest, err := tuple.Decode(data)
+	if n, ok := elem.(int64); ok {
+		return n, rest, nil
+	}
+	return 0, data, keyError(elem, "int64", err)
+}
+
+type stringKey struct{}
+
+func (stringKey) AppendKey(buf []byte, key string) []byte {
+	return tuple.AppendString(buf, key)
+}
+
+func (stringKey) DecodeKey(data []byte) (key string, rest []byte, err error) {
+	elem, rest, err := tuple.Decode(data)
+	if s, ok := elem.(string); ok {
+		return s, rest, nil
+	}
+	return "", data, keyError(elem, "string", err)
+}
+
+type bytesKey struct{}
+
+func (bytesKey) AppendKey(buf []byte, key []byte) []byte {
+	return tuple.AppendBytes(buf, key)
+}
+
+func (bytesKey) DecodeKey(data []byte) (key []byte, rest []byte, err error) {
+	if key, rest, err = tuple.DecodeBytes(data, []byte{}); err != nil {
+		return nil, data, err
+	}
+	return
+}
+
+type timeKey struct{}
+
+func (timeKey) AppendKey(buf []byte, key time.Time) []byte {
+	return int64Key{}.AppendKey(buf, key.UnixNano())
+}
+
+func (timeKey) DecodeKey(data []byte) (key time.Time, rest []byte, err error) {
+	n, rest, err := int64Key{}.DecodeKey(data)
+	return time.Unix(0, n).UTC(), rest, err
+}
+
+// keyError returns err, the error decoding a tuple element, or if there
+// is none, the error of an element that is not of the key type.
+func keyError(elem any, typ string, err error) error {
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w key: %T element, not %s", ErrBadEncoding, elem, typ)
+}
+
+// Pair is a key of two parts, ordered by First, then Second.
+// Nest pairs for longer tuples.
+type Pair[A, B any] struct {
+	First  A
+	Second B
+}
+
+// PairKey returns the codec of pairs encoded as the encoding of First
+// followed by that of Second.
+func PairKey[A, B any](first KeyCodec[A], second KeyCodec[B]) KeyCodec[Pair[A, B]] {
+	return pairKey[A, B]{first, second}
+}
+
+type pairKey[A, B any] struct {
+	first  KeyCodec[A]
+	second KeyCodec[B]
+}
+
+func (codec pairKey[A, B]) AppendKey(buf []byte, key Pair[A, B]) []byte {
+	return codec.second.AppendKey(codec.first.AppendKey(buf, key.First), key.Second)
+}
+
+func (codec pairKey[A, B]) DecodeKey(data []byte) (key Pair[A, B], rest []byte, err error) {
+	if key.First, rest, err = codec.first.DecodeKey(data); err != nil {
+		return
+	}
+	key.Second, rest, err = codec.second.DecodeKey(rest)
+	return
+}
+
+// Value codecs.
+var (
+	// BytesValue stores a byte slice as it is.
+	BytesValue ValueCodec[[]byte] = bytesValue{}
+	// StringValue stores a string as it is.
+	StringValue ValueCodec[string] = stringValue{}
+)
+
+type bytesValue struct{}
+
+func (bytesValue) Marshal(val []byte) ([]byte, error) {
+	if val == nil {
+		return []byte{}, nil
+	}
+	return val, nil
+}
+
+func (bytesValue) Unmarshal(data []byte) ([]byte, error) {
+	return bytes.Clone(data), nil
+}
+
+type stringValue struct{}
+
+func (stringValue) Marshal(val string) ([]byte, error) {
+	return []byte(val), nil
+}
+
+func (stringValue) Unmarshal(data []byte) (string, error) {
+	return string(data), nil
+}
+
+// JSON returns the codec of values stored as JSON.
+func JSON[V any]() ValueCodec[V] {
+	return jsonValue[V]{}
+}
+
+type jsonValue[V any] struct{}
+
+func (jsonValue[V]) Marshal(val V) ([]byte, error) {
+	return json.Marshal(val)
+}
+
+func (jsonValue[V]) Unmarshal(data []byte) (val V, err error) {
+	err = json.Unmarshal(data, &val)
+	return
+}
+
+// Gob returns the codec of values stored with encoding/gob, each value
+// carrying its own type description.
+func Gob[V any]() ValueCodec[V] {
+	return gobValue[V]{}
+}
+
+type gobValue[V any] struct{}
+
+func (gobValue[V]) Marshal(val V) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(val)
+	return buf.Bytes(), err
+}
+
+func (gobValue[V]) Unmarshal(data []byte) (val V, err error) {
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&val)
+	return
+}
+
+// Binary returns the codec of fixed-size values, such as numbers and
+// structs of them, stored big-endian with encoding/binary.
+func Binary[V any]() ValueCodec[V] {
+	return binaryValue[V]{}
+}
+
+type binaryValue[V any] struct{}
+
+func (binaryValue[V]) Marshal(val V) ([]byte, error) {
+	return binary.Append(nil, binary.BigEndian, val)
+}
+
+func (binaryValue[V]) Unmarshal(data []byte) (val V, err error) {
+	n, err := binary.Decode(data, binary.BigEndian, &val)
+	if err == nil && n != len(data) {
+		err = fmt.Errorf("%w value: %d trailing bytes", ErrBadEncoding, len(data)-n)
+	}
+	return
+}
@@ -0,0 +1,88 @@
+package kv
+
+import (
+	"fmt"
+
+	"github.com/dacapoday/smol/block"
+	"github.com/dacapoday/smol/bptree"
+)
+
+// Diff is an iterator over the keys that differ between two snapshots.
+type Diff[F File] struct {
+	ator *diff[F]
+}
+
+type diff[F File] = struct {
+	from, to block.HeapCheckpoint
+	err      error
+	bptree.Diff[*block.Heap[F]]
+}
+
+// Diff creates an iterator over the differences from the snapshot of
+// iterator from to the snapshot of iterator to, in key order. Both must
+// be iterators of this store; their positions are ignored.
+// Subtrees shared by both snapshots are skipped without being read when
+// both sides reach them at the same key boundary.
+//
+// Important: Caller must call Close to release resources.
+func (kv *KV[F]) Diff(from, to Iter[F]) Diff[F] {
+	diff := new(diff[F])
+	if from.ator.ckpt == nil || to.ator.ckpt == nil {
+		diff.err = ErrClosed
+		return Diff[F]{diff}
+	}
+	if from.ator.Block() != &kv.block || to.ator.Block() != &kv.block {
+		diff.err = fmt.Errorf("kv.Diff: %w: iterator of another store", ErrUnsupported)
+		return Diff[F]{diff}
+	}
+
+	from.ator.ckpt.Acquire()
+	to.ator.ckpt.Acquire()
+	diff.from, diff.to = from.ator.ckpt, to.ator.ckpt
+	diff.Load(&kv.block, from.ator.Root(), to.ator.Root(), kv.klen, kv.vlen)
+	return Diff[F]{diff}
+}
+
+// Close releases resources held by the iterator.
+func (diff Diff[F]) Close() {
+	if diff.ator.from != nil {
+		diff.ator.Diff.Close()
+		diff.ator.from.Release()
+		diff.ator.to.Release()
+		diff.ator.from, diff.ator.to = nil, nil
+	}
+}
+
+// Error returns any error encountered during iteration.
+func (diff Diff[F]) Error() error {
+	if diff.ator.err != nil {
+		return diff.ator.err
+	}
+	return diff.ator.Diff.Error()
+}
+
+// Next advances to the next differing key.
+func (diff Diff[F]) Next() bool {
+	return diff.ator.Next()
+}
+
+// Key returns the current key.
+//
+// Warning: Returned slice is valid only until next method call.
+func (diff Diff[F]) Key() []byte {
+	return diff.ator.Key()
+}
+
+// Old returns the value in the from snapshot, or nil if the key was added.
+//
+// Warning: Returned slice is valid only until next method call.
+func (diff Diff[F]) Old() []byte {
+	return diff.ator.Old()
+}
+
+// New returns the value in the to snapshot, or nil if the key was deleted.
+//
+// Warning: Returned slice is valid only until next method call.
+func (diff Diff[F]) New() []byte {
+	return diff.ator.New()
+}
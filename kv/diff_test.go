@@ -0,0 +1,70 @@
+package kv
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/dacapoday/smol/mem"
+)
+
+// TestDiff tests iterating changes between two snapshots.
+// Reports added, changed, and deleted keys in order.
+func TestDiff(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	kv.Batch(func(yield func([]byte, []byte) bool) {
+		for i := range 5000 {
+			yield(fmt.Appendf(nil, "key%05d", i), fmt.Appendf(nil, "val%05d", i))
+		}
+	})
+	before := kv.Iter()
+	defer before.Close()
+
+	kv.Batch(func(yield func([]byte, []byte) bool) {
+		yield([]byte("key00100"), []byte("changed"))
+		yield([]byte("key02000"), nil)
+		yield([]byte("key04999+"), []byte("added"))
+		yield([]byte("key03000"), []byte("val03000"))
+	})
+	after := kv.Iter()
+	defer after.Close()
+
+	diff := kv.Diff(before, after)
+	defer diff.Close()
+
+	var got []string
+	for diff.Next() {
+		got = append(got, fmt.Sprintf("%s:%s:%s", diff.Key(), diff.Old(), diff.New()))
+	}
+	if err := diff.Error(); err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	want := []string{
+		"key00100:val00100:changed",
+		"key02000:val02000:",
+		"key04999+::added",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("Diff = %q, want %q", got, want)
+	}
+
+	var other KV[*mem.File]
+	var otherFile mem.File
+	other.Load(&otherFile)
+	defer other.Close()
+	foreign := other.Iter()
+	defer foreign.Close()
+	bad := kv.Diff(before, foreign)
+	if bad.Next() || bad.Error() == nil {
+		t.Fatalf("Diff across stores: err=%v", bad.Error())
+	}
+	bad.Close()
+
+	t.Log("✓ Diff reports changed keys only")
+}
@@ -4,3 +4,19 @@ import "github.com/dacapoday/smol"
 
 var ErrClosed = smol.ErrClosed
 var ErrUnsupported = smol.ErrUnsupported
+var ErrReadOnly = smol.ErrReadOnly
+var ErrLocked = smol.ErrLocked
+var ErrOutOfRange = smol.ErrOutOfRange
+var ErrLagged = smol.ErrLagged
+var ErrBadMeta = smol.ErrBadMeta
+var ErrBadChecksum = smol.ErrBadChecksum
+var ErrUnknownMagicCode = smol.ErrUnknownMagicCode
+var ErrBadOverflow = smol.ErrBadOverflow
+var ErrBadEncoding = smol.ErrBadEncoding
+
+type BlockError = smol.BlockError
+type KeyError = smol.KeyError
+
+type Observer = smol.Observer
+type CommitInfo = smol.CommitInfo
+type NopObserver = smol.NopObserver
@@ -0,0 +1,165 @@
+package kv
+
+import (
+	"bytes"
+	"math"
+
+	"github.com/dacapoday/smol/block"
+	"github.com/dacapoday/smol/bptree"
+)
+
+// EstimateRange estimates the number of keys in [start, end) and the bytes
+// of the leaf pages holding them, in O(tree height) block reads.
+// A nil start or end leaves that side unbounded.
+//
+// The estimate reads the fan-out of the pages on the paths to start and
+// end and assumes the pages off them are as full: it is exact while the
+// tree is a single leaf, and otherwise off by the variation of page fill.
+// Expired keys not yet reaped are counted; overflow pages of large keys
+// and values are not.
+func (kv *KV[F]) EstimateRange(start, end []byte) (keys, size int64, err error) {
+	entry, ckpt := kv.atom.Acquire()
+	if ckpt == nil {
+		err = ErrClosed
+		return
+	}
+	defer ckpt.Release()
+	root, _ := splitEntry(entry)
+	var reader bptree.Reader[*block.Heap[F]]
+	reader.Load(&kv.block, root, kv.klen, kv.vlen, 0)
+	defer reader.Close()
+
+	from, ok, err := locate(&reader, start, false)
+	if !ok {
+		return
+	}
+	to, _, err := locate(&reader, end, true)
+	if err != nil || to.at <= from.at {
+		return
+	}
+
+	// pages per level, and items per leaf, averaged over the two paths;
+	// the last page of a level is left out, as it is the one least full
+	// after appends
+	leaves := 1.0
+	for h := range from.path {
+		leaves *= fanout(from, to, h, from.path[h].Count, to.path[h].Count)
+	}
+	items := leaves * fanout(from, to, len(from.path), from.count, to.count)
+	span := to.at - from.at
+	keys = int64(math.Round(span * items))
+	size = int64(math.Round(span * leaves * float64(kv.block.BlockSize())))
+	return
+}
+
+// treePoint is the place of a key in a tree: the path to the leaf holding
+// it, its index in the leaf, and the estimated fraction of the items of
+// the tree before it.
+type treePoint struct {
+	path         bptree.Level
+	index, count uint16
+	at           float64
+}
+
+// last reports whether the page at depth h of the path of the point is the
+// last of its level.
+func (point treePoint) last(h int) bool {
+	for _, level := range point.path[:h] {
+		if level.Index != level.Count-1 {
+			return false
+		}
+	}
+	return true
+}
+
+// fanout averages the counts of the pages at depth h on the paths of from
+// and to, taking the first alone when only the second is the last page of
+// its level.
+func fanout(from, to treePoint, h int, first, second uint16) float64 {
+	if to.last(h) && !from.last(h) {
+		return float64(first)
+	}
+	return float64(first+second) / 2
+}
+
+// locate positions reader at the first key >= key, or at the first key of
+// the tree for a nil key, unless past is set: then a nil key, like one
+// greater than every key, is located past the last. It reports false for
+// an empty tree.
+func locate[B bptree.ReadOnly](reader *bptree.Reader[B], key []byte, past bool) (point treePoint, ok bool, err error) {
+	switch {
+	case key == nil && !past:
+		ok = reader.SeekFirst()
+	case key != nil:
+		ok, past = reader.Seek(key), false
+		if !ok && reader.Error() == nil {
+			past = true
+		}
+	}
+	if past {
+		ok = reader.SeekLast()
+	}
+	if !ok {
+		return point, false, reader.Error()
+	}
+	point.path = reader.Level()
+	_, point.index, point.count = reader.Leaf()
+	if past {
+		point.at = 1
+		return point, true, nil
+	}
+	at := float64(point.index) / float64(point.count)
+	for h := len(point.path) - 1; h >= 0; h-- {
+		at = (float64(point.path[h].Index) + at) / float64(point.path[h].Count)
+	}
+	point.at = at
+	return point, true, nil
+}
+
+// Count returns the number of keys in [start, end), reading only the item
+// counts of the leaf pages in between, without decoding their items.
+// A nil start or end leaves that side unbounded. Expired keys not yet
+// reaped are counted.
+func (kv *KV[F]) Count(start, end []byte) (n int64, err error) {
+	entry, ckpt := kv.atom.Acquire()
+	if ckpt == nil {
+		err = ErrClosed
+		return
+	}
+	defer ckpt.Release()
+	if start != nil && end != nil && bytes.Compare(start, end) >= 0 {
+		return
+	}
+	root, _ := splitEntry(entry)
+	var reader bptree.Reader[*block.Heap[F]]
+	reader.Load(&kv.block, root, kv.klen, kv.vlen, 0)
+	defer reader.Close()
+
+	// the count stops in the leaf of the first key >= end
+	var endLeaf bptree.BlockID
+	var endIndex uint16
+	bounded := end != nil && reader.Seek(end)
+	if bounded {
+		endLeaf, endIndex, _ = reader.Leaf()
+	} else if err = reader.Error(); err != nil {
+		return
+	}
+
+	var ok bool
+	if start == nil {
+		ok = reader.SeekFirst()
+	} else {
+		ok = reader.Seek(start)
+	}
+	for ok {
+		leaf, index, count := reader.Leaf()
+		if bounded && leaf == endLeaf {
+			n += int64(endIndex) - int64(index)
+			break
+		}
+		n += int64(count - index)
+		ok = reader.NextLeaf()
+	}
+	err = reader.Error()
+	return
+}
@@ -0,0 +1,83 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/dacapoday/smol/mem"
+)
+
+// TestCountEstimate tests Count against a scan, and EstimateRange against
+// Count, on a tree of one leaf and on one of many.
+func TestCountEstimate(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	if n, err := kv.Count(nil, nil); n != 0 || err != nil {
+		t.Fatalf("Count of empty = %d, %v", n, err)
+	}
+	if keys, size, err := kv.EstimateRange(nil, nil); keys != 0 || size != 0 || err != nil {
+		t.Fatalf("EstimateRange of empty = %d, %d, %v", keys, size, err)
+	}
+
+	key := func(i int) []byte { return fmt.Appendf(nil, "key-%05d", i) }
+	ranges := [][2][]byte{
+		{nil, nil},
+		{nil, key(1000)},
+		{key(1000), nil},
+		{key(2500), key(7500)},
+		{[]byte("key-03000x"), []byte("key-03100x")},
+		{key(5000), key(5000)},
+		{key(6000), key(5000)},
+		{[]byte("zzz"), nil},
+	}
+	scan := func(start, end []byte) (n int64) {
+		iter := kv.Iter()
+		defer iter.Close()
+		for ok := iter.SeekFirst(); ok; ok = iter.Next() {
+			k := string(iter.Key())
+			if (start == nil || k >= string(start)) && (end == nil || k < string(end)) {
+				n++
+			}
+		}
+		return
+	}
+
+	for _, total := range []int{50, 10000} {
+		kv.Batch(func(yield func([]byte, []byte) bool) {
+			for i := range total {
+				yield(key(i), fmt.Appendf(nil, "value-%d", i))
+			}
+		})
+		for _, r := range ranges {
+			want := scan(r[0], r[1])
+			n, err := kv.Count(r[0], r[1])
+			if err != nil || n != want {
+				t.Fatalf("%d keys: Count(%q, %q) = %d, %v, want %d", total, r[0], r[1], n, err, want)
+			}
+			keys, size, err := kv.EstimateRange(r[0], r[1])
+			if err != nil {
+				t.Fatalf("EstimateRange: %v", err)
+			}
+			// exact in one leaf, within a tenth of the keys of the tree otherwise
+			if diff := keys - want; diff > int64(total)/10 || -diff > int64(total)/10 || total == 50 && diff != 0 {
+				t.Fatalf("%d keys: EstimateRange(%q, %q) = %d keys, want about %d", total, r[0], r[1], keys, want)
+			}
+			if (size == 0) != (keys == 0) || size < 0 {
+				t.Fatalf("EstimateRange(%q, %q) = %d bytes for %d keys", r[0], r[1], size, keys)
+			}
+		}
+	}
+
+	kv.Close()
+	if _, err := kv.Count(nil, nil); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Count after Close: %v", err)
+	}
+
+	t.Log("✓ Count is exact and EstimateRange close to it")
+}
@@ -0,0 +1,89 @@
+package kv_test
+
+import (
+	"expvar"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dacapoday/smol/kv"
+)
+
+// expvarObserver publishes store metrics as expvar variables, which
+// http.DefaultServeMux serves as JSON at /debug/vars. Prometheus or
+// OpenTelemetry instruments are adapted the same way.
+type expvarObserver struct {
+	kv.NopObserver
+	vars *expvar.Map
+}
+
+func (o expvarObserver) BlockRead(_ uint32, n int, d time.Duration, err error) {
+	o.vars.Add("block_reads", 1)
+	o.vars.Add("block_read_bytes", int64(n))
+	o.vars.AddFloat("block_read_seconds", d.Seconds())
+}
+
+func (o expvarObserver) BlockWrite(_ uint32, n int, d time.Duration, err error) {
+	o.vars.Add("block_writes", 1)
+	o.vars.Add("block_write_bytes", int64(n))
+	o.vars.AddFloat("block_write_seconds", d.Seconds())
+}
+
+func (o expvarObserver) Commit(info kv.CommitInfo) {
+	if info.Err != nil {
+		o.vars.Add("commit_errors", 1)
+		return
+	}
+	o.vars.Add("commits", 1)
+	o.vars.AddFloat("commit_seconds", info.Duration.Seconds())
+	o.vars.AddFloat("sync_seconds", info.Sync.Seconds())
+	o.vars.Add("blocks_allocated", int64(info.Allocated))
+	o.vars.Add("blocks_recycled", int64(info.Recycled))
+	retained := new(expvar.Int)
+	retained.Set(int64(info.Retained))
+	o.vars.Set("checkpoints_retained", retained)
+}
+
+func (o expvarObserver) IterOpen() {
+	o.vars.Add("iterators_open", 1)
+}
+
+func (o expvarObserver) IterClose(lifetime time.Duration) {
+	o.vars.Add("iterators_open", -1)
+	o.vars.AddFloat("iterator_seconds", lifetime.Seconds())
+}
+
+func ExampleObserver() {
+	// Create temporary file for demo
+	var path string
+	{
+		f, err := os.CreateTemp("", "example-*.kv")
+		if err != nil {
+			panic(err)
+		}
+		path = f.Name()
+		f.Close()
+	}
+
+	metrics := expvarObserver{vars: expvar.NewMap("smol")}
+	db, err := kv.OpenFile(path, &kv.Options{Observer: metrics})
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	db.Set([]byte("hello"), []byte("world"))
+	db.Set([]byte("hola"), []byte("mundo"))
+
+	iter := db.Iter()
+	fmt.Println("open iterators:", metrics.vars.Get("iterators_open"))
+	iter.Close()
+
+	fmt.Println("commits:", metrics.vars.Get("commits"))
+	fmt.Println("open iterators:", metrics.vars.Get("iterators_open"))
+
+	// Output:
+	// open iterators: 1
+	// commits: 2
+	// open iterators: 0
+}
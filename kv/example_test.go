@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/dacapoday/smol/iterator"
 	"github.com/dacapoday/smol/kv"
 )
 
@@ -222,3 +223,54 @@ func ExampleTx_Iter() {
 	//   Moon: passed
 	// after rollback: Mars=target, Moon=[]
 }
+
+func ExampleKV_Iter_views() {
+	// Create temporary file for demo
+	var path string
+	{
+		f, err := os.CreateTemp("", "example-*.kv")
+		if err != nil {
+			panic(err)
+		}
+		path = f.Name()
+		f.Close()
+	}
+
+	db, err := kv.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	db.Set([]byte("planet:Mars"), []byte("Red Planet"))
+	db.Set([]byte("planet:Jupiter"), []byte("Gas Giant"))
+	db.Set([]byte("planet:Saturn"), []byte("Ringed"))
+	db.Set([]byte("star:Sun"), []byte("Yellow Dwarf"))
+
+	iter := db.Iter()
+	defer iter.Close()
+
+	// Planets only, without their prefix, in descending order
+	var planets iterator.Prefix[kv.DBIter]
+	planets.Load(iter, []byte("planet:"), true)
+	var desc iterator.Reverse[*iterator.Prefix[kv.DBIter]]
+	desc.Load(&planets)
+
+	for desc.SeekFirst(); desc.Valid(); desc.Next() {
+		fmt.Printf("%s: %s\n", desc.Key(), desc.Val())
+		if string(desc.Key()) == "Mars" {
+			// Views copy over a clone of the store iterator
+			clone := planets.Inner().Clone()
+			defer clone.Close()
+			rest := desc.Copy(planets.Copy(clone))
+			rest.Next()
+			fmt.Printf("after Mars: %s\n", rest.Key())
+		}
+	}
+
+	// Output:
+	// Saturn: Ringed
+	// Mars: Red Planet
+	// after Mars: Jupiter
+	// Jupiter: Gas Giant
+}
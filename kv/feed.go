@@ -0,0 +1,308 @@
+package kv
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/dacapoday/smol/block"
+	"github.com/dacapoday/smol/bptree"
+)
+
+// Change is a key-value change applied by a commit.
+// Val is nil for a deleted key.
+type Change struct {
+	Key, Val []byte
+}
+
+// Changeset is the changes applied by one successful commit,
+// sorted by key, and the checkpoint number the commit created.
+type Changeset struct {
+	Ckp     uint32
+	Changes []Change
+}
+
+// SubscribeOptions configures a Subscription.
+type SubscribeOptions struct {
+	// Buffer is the number of changesets queued for the subscriber.
+	Buffer int
+
+	// Drop ends the subscription with ErrLagged when its buffer is full.
+	// By default commits wait for the subscriber to catch up.
+	Drop bool
+}
+
+// Subscription delivers the changesets of a KV in checkpoint order,
+// after the commits become visible to readers.
+//
+// Important: Without Drop, a subscriber that stops receiving stalls
+// every writer; never commit from the goroutine receiving from C.
+type Subscription struct {
+	// C receives changesets. It is closed when the subscription ends.
+	C <-chan Changeset
+
+	c    chan Changeset
+	send sync.Mutex // held while sending to c, and to close it
+	done chan struct{}
+	once sync.Once
+	drop bool
+	feed *feed
+	err  error // set before done is closed
+}
+
+// Close ends the subscription and closes C.
+func (sub *Subscription) Close() {
+	sub.end(nil)
+}
+
+// Err returns why the subscription ended: nil after Close,
+// ErrLagged if it fell behind with Drop, ErrClosed if the KV was closed.
+func (sub *Subscription) Err() error {
+	select {
+	case <-sub.done:
+		return sub.err
+	default:
+		return nil
+	}
+}
+
+// end closes done first, which releases a send blocked on this
+// subscription, then C, then unregisters it.
+func (sub *Subscription) end(err error) {
+	sub.once.Do(func() {
+		sub.err = err
+		close(sub.done)
+		sub.send.Lock()
+		close(sub.c)
+		sub.send.Unlock()
+		sub.feed.remove(sub)
+	})
+}
+
+// deliver sends changeset, waiting for the subscriber without Drop.
+// It reports false if the subscriber lags with Drop.
+func (sub *Subscription) deliver(changeset Changeset) bool {
+	sub.send.Lock()
+	defer sub.send.Unlock()
+
+	select {
+	case <-sub.done:
+		return true
+	default:
+	}
+	select {
+	case sub.c <- changeset:
+		return true
+	default:
+	}
+	if sub.drop {
+		return false
+	}
+	select {
+	case sub.c <- changeset:
+	case <-sub.done:
+	}
+	return true
+}
+
+// feed fans out changesets to subscriptions and retains recent ones.
+//
+// Commits queue their changeset while holding the atom, which orders them
+// by checkpoint; deliver then sends queued changesets to subscribers.
+// Sending happens outside mutex, so a slow subscriber holds up only
+// deliveries, not subscribing, ending or closing.
+type feed struct {
+	mutex   sync.Mutex
+	subs    map[*Subscription]struct{}
+	history []Changeset
+	retain  int
+	ckp     uint32 // last delivered
+	closed  bool
+
+	sending sync.Mutex // keeps deliveries in checkpoint order
+	queue   struct {
+		sync.Mutex
+		changesets []Changeset
+		recorded   []bool
+	}
+	subscribers atomic.Int32
+}
+
+func (feed *feed) load(ckp uint32, retain int) {
+	feed.ckp = ckp
+	feed.retain = retain
+	feed.closed = false
+}
+
+// active reports whether commits need to record their changes. Commits
+// call it holding the atom, which subscribe holds to register.
+func (feed *feed) active() bool {
+	return feed.retain > 0 || feed.subscribers.Load() > 0
+}
+
+// enqueue is called by a commit holding the atom. Changes are recorded
+// only if the feed was active when the commit started.
+func (feed *feed) enqueue(changeset Changeset, recorded bool) {
+	feed.queue.Lock()
+	feed.queue.changesets = append(feed.queue.changesets, changeset)
+	feed.queue.recorded = append(feed.queue.recorded, recorded)
+	feed.queue.Unlock()
+}
+
+// deliver sends queued changesets, waiting for subscribers without Drop.
+func (feed *feed) deliver() {
+	feed.sending.Lock()
+	defer feed.sending.Unlock()
+
+	feed.queue.Lock()
+	changesets, recorded := feed.queue.changesets, feed.queue.recorded
+	feed.queue.changesets, feed.queue.recorded = nil, nil
+	feed.queue.Unlock()
+
+	var subs []*Subscription
+	for i, changeset := range changesets {
+		subs = feed.record(changeset, recorded[i], subs[:0])
+		for _, sub := range subs {
+			if !sub.deliver(changeset) {
+				sub.end(ErrLagged)
+			}
+		}
+	}
+}
+
+// record moves the feed to the checkpoint of changeset, retains it, and
+// appends the subscriptions to send it to. Subscriptions made after this
+// get it from the retained history instead.
+func (feed *feed) record(changeset Changeset, recorded bool, subs []*Subscription) []*Subscription {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+
+	feed.ckp = changeset.Ckp
+	if !recorded {
+		// nobody could see these changes, and no history may span them
+		feed.history = feed.history[:0]
+		return subs
+	}
+
+	if feed.retain > 0 {
+		if len(feed.history) == feed.retain {
+			feed.history = append(feed.history[:0], feed.history[1:]...)
+		}
+		feed.history = append(feed.history, changeset)
+	}
+	for sub := range feed.subs {
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// subscribe registers a subscription, replaying retained changesets
+// after checkpoint from if replay is set.
+func (feed *feed) subscribe(opt SubscribeOptions, from uint32, replay bool) (sub *Subscription, err error) {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+
+	if feed.closed {
+		err = ErrClosed
+		return
+	}
+
+	var backlog []Changeset
+	if replay && from != feed.ckp {
+		if int32(from-feed.ckp) > 0 {
+			err = ErrOutOfRange
+			return
+		}
+		i := len(feed.history)
+		for i > 0 && int32(feed.history[i-1].Ckp-from) > 0 {
+			i--
+		}
+		if i == 0 && (len(feed.history) == 0 || feed.history[0].Ckp != from+1) {
+			err = ErrOutOfRange
+			return
+		}
+		backlog = feed.history[i:]
+	}
+
+	c := make(chan Changeset, max(opt.Buffer, 0)+len(backlog))
+	for _, changeset := range backlog {
+		c <- changeset
+	}
+
+	sub = &Subscription{
+		C:    c,
+		c:    c,
+		done: make(chan struct{}),
+		drop: opt.Drop,
+		feed: feed,
+	}
+	if feed.subs == nil {
+		feed.subs = make(map[*Subscription]struct{})
+	}
+	feed.subs[sub] = struct{}{}
+	feed.subscribers.Add(1)
+	return
+}
+
+// remove unregisters sub.
+func (feed *feed) remove(sub *Subscription) {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+
+	if _, ok := feed.subs[sub]; ok {
+		delete(feed.subs, sub)
+		feed.subscribers.Add(-1)
+	}
+}
+
+// close ends all subscriptions with ErrClosed.
+func (feed *feed) close() {
+	feed.mutex.Lock()
+	subs := make([]*Subscription, 0, len(feed.subs))
+	for sub := range feed.subs {
+		subs = append(subs, sub)
+	}
+	feed.history = nil
+	feed.closed = true
+	feed.mutex.Unlock()
+
+	for _, sub := range subs {
+		sub.end(ErrClosed)
+	}
+}
+
+// Subscribe returns a subscription to the changesets of commits made
+// from now on: every commit that finishes after Subscribe returns is
+// delivered. A nil opts uses an unbuffered, blocking subscription.
+func (kv *KV[F]) Subscribe(opts *SubscribeOptions) (*Subscription, error) {
+	var opt SubscribeOptions
+	if opts != nil {
+		opt = *opts
+	}
+	return kv.subscribe(opt, 0, false)
+}
+
+// SubscribeFrom is like Subscribe, but first replays the retained
+// changesets of commits after checkpoint ckp (see Options.RetainChangesets).
+// Returns ErrOutOfRange if some of those commits are no longer retained.
+func (kv *KV[F]) SubscribeFrom(ckp uint32, opts *SubscribeOptions) (*Subscription, error) {
+	var opt SubscribeOptions
+	if opts != nil {
+		opt = *opts
+	}
+	return kv.subscribe(opt, ckp, true)
+}
+
+// subscribe registers the subscription while writers are blocked, so a
+// commit in progress finishes first, and every later one records its
+// changes for it.
+func (kv *KV[F]) subscribe(opt SubscribeOptions, from uint32, replay bool) (sub *Subscription, err error) {
+	err = kv.atom.Swap(func(bptree.Page) (bptree.Page, block.HeapCheckpoint, error) {
+		var err error
+		sub, err = kv.feed.subscribe(opt, from, replay)
+		return nil, nil, errUnchanged{err}
+	})
+	if unchanged, ok := err.(errUnchanged); ok {
+		err = unchanged.error
+	}
+	return
+}
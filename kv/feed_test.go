@@ -0,0 +1,253 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/dacapoday/smol/mem"
+)
+
+// TestSubscribe tests delivery of changesets in commit order.
+// Deletes are delivered with a nil value.
+func TestSubscribe(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	sub, err := kv.Subscribe(&SubscribeOptions{Buffer: 4})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Close()
+
+	kv.Set([]byte("a"), []byte("1"))
+	kv.Batch(func(yield func([]byte, []byte) bool) {
+		yield([]byte("c"), []byte("3"))
+		yield([]byte("a"), nil)
+		yield([]byte("b"), []byte("2"))
+	})
+
+	first := <-sub.C
+	if len(first.Changes) != 1 || string(first.Changes[0].Key) != "a" || string(first.Changes[0].Val) != "1" {
+		t.Fatalf("first changeset: %+v", first)
+	}
+
+	second := <-sub.C
+	if second.Ckp != first.Ckp+1 {
+		t.Fatalf("Ckp = %d, want %d", second.Ckp, first.Ckp+1)
+	}
+	want := []Change{{[]byte("a"), nil}, {[]byte("b"), []byte("2")}, {[]byte("c"), []byte("3")}}
+	if len(second.Changes) != len(want) {
+		t.Fatalf("second changeset: %+v", second)
+	}
+	for i, change := range second.Changes {
+		if !bytes.Equal(change.Key, want[i].Key) || !bytes.Equal(change.Val, want[i].Val) || (change.Val == nil) != (want[i].Val == nil) {
+			t.Fatalf("change %d = %q:%q, want %q:%q", i, change.Key, change.Val, want[i].Key, want[i].Val)
+		}
+	}
+
+	sub.Close()
+	if _, ok := <-sub.C; ok {
+		t.Fatal("C open after Close")
+	}
+	if err = sub.Err(); err != nil {
+		t.Fatalf("Err after Close: %v", err)
+	}
+
+	t.Log("✓ Changesets delivered in order")
+}
+
+// TestSubscribeFrom tests replaying retained changesets.
+func TestSubscribeFrom(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.LoadFile(&file, &Options{RetainChangesets: 2}); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	sub, _ := kv.Subscribe(&SubscribeOptions{Buffer: 3})
+	for _, key := range []string{"k1", "k2", "k3"} {
+		kv.Set([]byte(key), []byte("v"))
+	}
+	first := <-sub.C
+	sub.Close()
+
+	replay, err := kv.SubscribeFrom(first.Ckp, nil)
+	if err != nil {
+		t.Fatalf("SubscribeFrom: %v", err)
+	}
+	defer replay.Close()
+	for i, key := range []string{"k2", "k3"} {
+		changeset := <-replay.C
+		if changeset.Ckp != first.Ckp+uint32(i)+1 || string(changeset.Changes[0].Key) != key {
+			t.Fatalf("replayed %d: %+v", i, changeset)
+		}
+	}
+
+	if _, err = kv.SubscribeFrom(first.Ckp-1, nil); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("SubscribeFrom evicted: err=%v, want ErrOutOfRange", err)
+	}
+	if _, err = kv.SubscribeFrom(first.Ckp+10, nil); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("SubscribeFrom future: err=%v, want ErrOutOfRange", err)
+	}
+
+	t.Log("✓ Retained changesets replayed")
+}
+
+// TestSubscribeDrop tests ending a lagging subscription.
+func TestSubscribeDrop(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	lagging, _ := kv.Subscribe(&SubscribeOptions{Buffer: 1, Drop: true})
+	idle, _ := kv.Subscribe(&SubscribeOptions{Buffer: 8})
+	for _, key := range []string{"k1", "k2", "k3"} {
+		if err := kv.Set([]byte(key), []byte("v")); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+
+	n := 0
+	for range lagging.C {
+		n++
+	}
+	if n != 1 {
+		t.Fatalf("received %d changesets, want 1", n)
+	}
+	if err := lagging.Err(); !errors.Is(err, ErrLagged) {
+		t.Fatalf("Err = %v, want ErrLagged", err)
+	}
+
+	kv.Close()
+	n = 0
+	for range idle.C {
+		n++
+	}
+	if n != 3 {
+		t.Fatalf("received %d changesets, want 3", n)
+	}
+	if err := idle.Err(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Err after KV.Close = %v, want ErrClosed", err)
+	}
+
+	t.Log("✓ Lagging subscription dropped")
+}
+
+// TestSubscribeStalled tests that a subscriber that stops receiving
+// holds up only commits, not other subscriptions or Close.
+func TestSubscribeStalled(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	stalled, _ := kv.Subscribe(nil)
+	other, _ := kv.Subscribe(&SubscribeOptions{Buffer: 1})
+	committed := make(chan error)
+	go func() {
+		committed <- kv.Set([]byte("k"), []byte("v"))
+	}()
+
+	// wait for the commit to deliver, which stalls on stalled
+	for kv.feed.sending.TryLock() {
+		kv.feed.sending.Unlock()
+		runtime.Gosched()
+	}
+	if err := other.Err(); err != nil {
+		t.Fatalf("Err = %v", err)
+	}
+	late, err := kv.Subscribe(nil)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	late.Close()
+
+	kv.Close()
+	if err = <-committed; err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if _, ok := <-stalled.C; ok {
+		t.Fatal("stalled received after KV.Close")
+	}
+	if err = stalled.Err(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Err after KV.Close = %v, want ErrClosed", err)
+	}
+
+	t.Log("✓ Stalled subscriber held up only its commit")
+}
+
+// TestSubscribeConcurrentCommit tests that subscribing waits for a commit
+// in progress, so that every commit finishing after Subscribe returns is
+// delivered.
+func TestSubscribeConcurrentCommit(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	// a commit stopped while writing, after checking for subscribers
+	writing, release := make(chan struct{}), make(chan struct{})
+	committed := make(chan error)
+	go func() {
+		committed <- kv.commit(context.Background(), fixed(func(yield func([]byte, []byte) bool) {
+			close(writing)
+			<-release
+			yield([]byte("k"), []byte("v"))
+		}), nil)
+	}()
+	<-writing
+
+	subscribed := make(chan *Subscription)
+	go func() {
+		sub, err := kv.Subscribe(&SubscribeOptions{Buffer: 2})
+		if err != nil {
+			t.Errorf("Subscribe: %v", err)
+		}
+		subscribed <- sub
+	}()
+	var sub *Subscription
+	select {
+	case sub = <-subscribed:
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	if sub == nil {
+		sub = <-subscribed
+	}
+	if sub == nil {
+		t.FailNow()
+	}
+	defer sub.Close()
+	_, ckpt := kv.atom.Acquire()
+	ckp := ckpt.Ckp()
+	ckpt.Release()
+
+	if err := <-committed; err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if err := kv.Set([]byte("last"), []byte("v")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	// the commit in progress finished before Subscribe returned
+	first := <-sub.C
+	if first.Ckp != ckp+1 || string(first.Changes[0].Key) != "last" {
+		t.Fatalf("first changeset: %+v, want the commit after %d", first, ckp)
+	}
+
+	t.Log("✓ Commit in progress while subscribing finished first")
+}
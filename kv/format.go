@@ -0,0 +1,230 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dacapoday/smol/block"
+	"github.com/dacapoday/smol/bptree"
+	"github.com/dacapoday/smol/btree"
+	"github.com/dacapoday/smol/iterator"
+)
+
+// Format is the on-disk format of a store: a version and feature bits.
+//
+// A store can be opened if its version is not newer than FormatVersion
+// and every required feature is known. Stores of an older version are
+// opened as they are, and moved forward by KV.Upgrade.
+type Format = block.Format
+
+// Required features of the store format. A feature is required by a store
+// from the first commit using it on; readers without it cannot open the
+// store from then on. New stores use every feature, existing ones those
+// they already require, FeatureExpiryIndex once an expiry time or index
+// entry is set, and all of them after KV.Upgrade. Options.DisabledFeatures
+// keeps a store readable by readers without them.
+const (
+	// FeaturePrefixLeaves writes leaf pages storing the key prefix shared
+	// by their items once.
+	FeaturePrefixLeaves uint64 = 1 << iota
+
+	// FeatureShortSeparators separates leaf pages in branch pages by the
+	// shortest key between them.
+	FeatureShortSeparators
+
+	// FeatureExpiryIndex keeps the root page of the index of expiry times
+	// and secondary index entries after the root page of the tree.
+	FeatureExpiryIndex
+
+	// Features holds every feature this version of the package knows.
+	Features = FeaturePrefixLeaves | FeatureShortSeparators | FeatureExpiryIndex
+)
+
+// Upgrade is a step rewriting a store from format version From to From+1.
+type Upgrade struct {
+	From byte
+	Name string
+
+	// Rewrite yields the changes moving the data of snapshot to the next
+	// version, in any order. A nil value deletes a key. It runs while
+	// writers are blocked, and its changes are committed together with
+	// the new version, or not at all if it returns an error. Keys expired
+	// by then are not in snapshot, and are deleted by that commit.
+	Rewrite func(snapshot iterator.Iterator, yield func(key, val []byte) bool) error
+}
+
+// upgrades holds the registered steps, the step from version i at index i.
+var upgrades []Upgrade
+
+// RegisterUpgrade registers the step from the current FormatVersion to
+// the next one. Steps must be registered in order, at init time.
+func RegisterUpgrade(step Upgrade) {
+	if int(step.From) != len(upgrades) || len(upgrades) == 255 {
+		panic(fmt.Errorf("kv.RegisterUpgrade: %q from version %d, expected %d", step.Name, step.From, len(upgrades)))
+	}
+	upgrades = append(upgrades, step)
+}
+
+// FormatVersion returns the format version of new stores, the one every
+// registered upgrade leads to.
+func FormatVersion() byte {
+	return byte(len(upgrades))
+}
+
+// supportedFormat returns the format a store may have to be opened with
+// the features not disabled.
+func supportedFormat(disabled uint64) Format {
+	return Format{Version: FormatVersion(), Required: Features &^ disabled}
+}
+
+// enable takes features into use in the commits from now on, unless
+// disabled. FeatureExpiryIndex is always in use, and required only by
+// commits writing an expiry index. Called while writers are serialized.
+func (kv *KV[F]) enable(features uint64) {
+	kv.features |= (features | FeatureExpiryIndex) &^ kv.disabled
+	kv.write.PrefixLeaves = kv.features&FeaturePrefixLeaves != 0
+	kv.write.ShortSeparators = kv.features&FeatureShortSeparators != 0
+}
+
+// require sets the features used by a commit writing the index root in
+// the format it commits, unless they are already set. It returns
+// ErrUnsupported for a disabled feature.
+func (kv *KV[F]) require(index bptree.Page) error {
+	features := kv.features &^ FeatureExpiryIndex
+	if len(index) != 0 {
+		if kv.features&FeatureExpiryIndex == 0 {
+			return fmt.Errorf("%w: expiry index without FeatureExpiryIndex", ErrUnsupported)
+		}
+		features |= FeatureExpiryIndex
+	}
+	format := kv.block.Format()
+	if format.Required&features == features {
+		return nil
+	}
+	format.Required |= features
+	return kv.block.SetFormat(format)
+}
+
+// Format returns the format of the store.
+func (kv *KV[F]) Format() Format {
+	return kv.block.Format()
+}
+
+// Upgrade moves the store to FormatVersion, one commit per registered
+// step, and returns the version it started from. A last commit takes
+// every feature not disabled into use, which makes them required. Once
+// ctx is done, the step in progress is rolled back and the store stays
+// at the version of the last step committed.
+func (kv *KV[F]) Upgrade(ctx context.Context) (from byte, err error) {
+	from = kv.block.Format().Version
+	for version := from; version < FormatVersion(); version++ {
+		if err = kv.upgrade(ctx, upgrades[version]); err != nil {
+			err = fmt.Errorf("kv.Upgrade: %q: %w", upgrades[version].Name, err)
+			return
+		}
+	}
+	err = kv.commit(ctx, func(bptree.Page, bptree.Page) (func(func([]byte, []byte) bool), error) {
+		if kv.features|kv.disabled == Features {
+			return nil, errUnchanged{}
+		}
+		kv.enable(Features)
+		return func(func([]byte, []byte) bool) {}, nil
+	}, nil)
+	if err != nil {
+		err = fmt.Errorf("kv.Upgrade: %w", err)
+	}
+	return
+}
+
+func (kv *KV[F]) upgrade(ctx context.Context, step Upgrade) error {
+	exp := &expiring{keep: true}
+	return kv.commit(ctx, func(root, index bptree.Page) (func(func([]byte, []byte) bool), error) {
+		format := kv.block.Format()
+		if format.Version != step.From {
+			// upgraded by a concurrent call
+			return nil, errUnchanged{}
+		}
+
+		// keys expired by now are hidden from the step and reaped, so
+		// none is rewritten into a live key
+		var hidden expiry[F]
+		hidden.load(kv, index)
+		defer hidden.close()
+		exp.now, exp.reap = hidden.now, hidden.now
+		expired, err := kv.expired(index, exp)
+		if err != nil {
+			return nil, err
+		}
+		var changes btree.BTree
+		for key := range expired {
+			changes.Set(key, nil)
+		}
+
+		var reader bptree.Reader[*block.Heap[F]]
+		reader.Load(&kv.block, root, kv.klen, kv.vlen, 0)
+		defer reader.Close()
+		var snapshot iterator.Filter[*bptree.Reader[*block.Heap[F]]]
+		snapshot.Load(&reader, func(key, _ []byte) bool { return !hidden.expired(key) })
+		err = step.Rewrite(&snapshot, func(key, val []byte) bool {
+			changes.Set(bytes.Clone(key), bytes.Clone(val))
+			return true
+		})
+		if err == nil {
+			err = reader.Error()
+		}
+		if err == nil {
+			err = hidden.err
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		format.Version++
+		if err = kv.block.SetFormat(format); err != nil {
+			return nil, err
+		}
+		return changes.Items, nil
+	}, exp)
+}
+
+// FileInfo describes a store file without opening it.
+type FileInfo struct {
+	Format    Format
+	Ckp       uint32 // commit number of the newest checkpoint
+	BlockSize int
+}
+
+// Supported returns ErrUnsupported if the store cannot be opened by
+// this version of the package.
+func (info FileInfo) Supported() error {
+	return info.Format.Check(supportedFormat(0))
+}
+
+// NeedsUpgrade reports whether the store has an older format version,
+// which KV.Upgrade moves forward.
+func (info FileInfo) NeedsUpgrade() bool {
+	return info.Format.Version < FormatVersion()
+}
+
+// Inspect reads the format of the store file at path, without locking
+// or opening it, so it can be checked before opening it read-write.
+func Inspect(path string) (info FileInfo, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	return InspectFile(file)
+}
+
+// InspectFile is Inspect for an open file.
+func InspectFile[R io.ReaderAt](file R) (info FileInfo, err error) {
+	info.Format, info.Ckp, info.BlockSize, err = block.Inspect(file, BlockOption{}.MagicCode())
+	if err != nil {
+		err = fmt.Errorf("kv.Inspect: %w", err)
+	}
+	return
+}
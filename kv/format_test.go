@@ -0,0 +1,323 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dacapoday/smol/iterator"
+	"github.com/dacapoday/smol/mem"
+)
+
+// withUpgrades replaces the registered upgrades for the rest of the test.
+func withUpgrades(t *testing.T, steps ...Upgrade) {
+	saved := upgrades
+	t.Cleanup(func() { upgrades = saved })
+	upgrades = nil
+	for _, step := range steps {
+		RegisterUpgrade(step)
+	}
+}
+
+var upperStep = Upgrade{From: 0, Name: "upper", Rewrite: func(snapshot iterator.Iterator, yield func(key, val []byte) bool) error {
+	for ok := snapshot.SeekFirst(); ok; ok = snapshot.Next() {
+		if !yield(snapshot.Key(), bytes.ToUpper(snapshot.Val())) {
+			break
+		}
+	}
+	return nil
+}}
+
+var prefixStep = Upgrade{From: 1, Name: "prefix", Rewrite: func(snapshot iterator.Iterator, yield func(key, val []byte) bool) error {
+	for ok := snapshot.SeekFirst(); ok; ok = snapshot.Next() {
+		yield(snapshot.Key(), nil)
+		yield(append([]byte("v2/"), snapshot.Key()...), snapshot.Val())
+	}
+	return nil
+}}
+
+func checkStore(t *testing.T, kv *KV[*mem.File], want map[string]string) {
+	t.Helper()
+	iter := kv.Iter()
+	defer iter.Close()
+	n := 0
+	for iter.SeekFirst(); iter.Valid(); iter.Next() {
+		if val, ok := want[string(iter.Key())]; !ok || val != string(iter.Val()) {
+			t.Fatalf("unexpected %q = %q", iter.Key(), iter.Val())
+		}
+		n++
+	}
+	if n != len(want) {
+		t.Fatalf("got %d keys, want %d", n, len(want))
+	}
+}
+
+// TestUpgrade tests that registered steps move a store forward one
+// commit at a time, and that a failing step leaves it at the last
+// version committed.
+func TestUpgrade(t *testing.T) {
+	withUpgrades(t)
+
+	var file mem.File
+	var kv0 KV[*mem.File]
+	if err := kv0.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := kv0.Set([]byte(key), []byte("val-"+key)); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	var buf bytes.Buffer
+	file.WriteTo(&buf)
+	kv0.Close()
+	file.ReadFrom(&buf)
+
+	failed := errors.New("failed")
+	withUpgrades(t, upperStep, Upgrade{From: 1, Name: "fail", Rewrite: func(iterator.Iterator, func(key, val []byte) bool) error {
+		return failed
+	}})
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+	info, err := InspectFile(&file)
+	if err != nil {
+		t.Fatalf("InspectFile: %v", err)
+	}
+	if info.Format.Version != 0 || !info.NeedsUpgrade() || info.Supported() != nil {
+		t.Fatalf("unexpected info before upgrade: %+v", info)
+	}
+
+	from, err := kv.Upgrade(context.Background())
+	if from != 0 || !errors.Is(err, failed) {
+		t.Fatalf("Upgrade = %d, %v, want 0, %v", from, err, failed)
+	}
+	if version := kv.Format().Version; version != 1 {
+		t.Fatalf("version after failed step = %d, want 1", version)
+	}
+	checkStore(t, &kv, map[string]string{"a": "VAL-A", "b": "VAL-B", "c": "VAL-C"})
+
+	withUpgrades(t, upperStep, prefixStep)
+	if from, err = kv.Upgrade(context.Background()); from != 1 || err != nil {
+		t.Fatalf("Upgrade = %d, %v, want 1, nil", from, err)
+	}
+	if version := kv.Format().Version; version != 2 {
+		t.Fatalf("version after upgrade = %d, want 2", version)
+	}
+	checkStore(t, &kv, map[string]string{"v2/a": "VAL-A", "v2/b": "VAL-B", "v2/c": "VAL-C"})
+
+	if from, err = kv.Upgrade(context.Background()); from != 2 || err != nil {
+		t.Fatalf("Upgrade = %d, %v, want 2, nil", from, err)
+	}
+
+	info, err = InspectFile(&file)
+	if err != nil {
+		t.Fatalf("InspectFile: %v", err)
+	}
+	if info.Format.Version != 2 || info.NeedsUpgrade() || info.BlockSize != 1<<14 {
+		t.Fatalf("unexpected info after upgrade: %+v", info)
+	}
+
+	t.Log("✓ Upgraded from version 0 to 2, a failed step left version 1")
+}
+
+// TestUpgradeUnsupported tests that a store of a newer version is
+// reported by Inspect and not opened.
+func TestUpgradeUnsupported(t *testing.T) {
+	withUpgrades(t, upperStep)
+
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if err := kv.Set([]byte("key"), []byte("val")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if version := kv.Format().Version; version != 1 {
+		t.Fatalf("new store version = %d, want 1", version)
+	}
+	var buf bytes.Buffer
+	file.WriteTo(&buf)
+	kv.Close()
+	file.ReadFrom(&buf)
+
+	withUpgrades(t)
+	info, err := InspectFile(&file)
+	if err != nil {
+		t.Fatalf("InspectFile: %v", err)
+	}
+	if err = info.Supported(); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Supported = %v, want ErrUnsupported", err)
+	}
+	var old KV[*mem.File]
+	if err = old.Load(&file); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Load = %v, want ErrUnsupported", err)
+	}
+
+	t.Log("✓ Newer store rejected by Inspect and Load")
+}
+
+// TestRegisterUpgradeOrder tests that steps registered out of order panic.
+func TestRegisterUpgradeOrder(t *testing.T) {
+	withUpgrades(t)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic")
+		}
+		t.Log("✓ Out of order step rejected")
+	}()
+	RegisterUpgrade(prefixStep)
+}
+
+// TestDisabledFeatures tests that a store written with every feature
+// disabled requires none, that it requires only the features used once
+// opened without, and that a store requiring a feature is not opened by
+// a caller disabling it.
+func TestDisabledFeatures(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.LoadFile(&file, &Options{DisabledFeatures: Features}); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if err := kv.Set([]byte("key"), []byte("val")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := kv.SetTTL([]byte("ttl"), []byte("val"), time.Hour); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("SetTTL = %v, want ErrUnsupported", err)
+	}
+	if required := kv.Format().Required; required != 0 {
+		t.Fatalf("required features = %#x, want 0", required)
+	}
+	var buf bytes.Buffer
+	file.WriteTo(&buf)
+	kv.Close()
+	file.ReadFrom(&buf)
+
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if err := kv.SetTTL([]byte("ttl"), []byte("val"), time.Hour); err != nil {
+		t.Fatalf("SetTTL: %v", err)
+	}
+	if required := kv.Format().Required; required != FeatureExpiryIndex {
+		t.Fatalf("required features = %#x, want %#x", required, FeatureExpiryIndex)
+	}
+	buf.Reset()
+	file.WriteTo(&buf)
+	kv.Close()
+	file.ReadFrom(&buf)
+
+	var old KV[*mem.File]
+	if err := old.LoadFile(&file, &Options{DisabledFeatures: FeatureExpiryIndex}); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Load = %v, want ErrUnsupported", err)
+	}
+
+	t.Log("✓ Disabled features kept out of the store, and stores requiring them rejected")
+}
+
+// TestUpgradeExpired tests that keys expired before an upgrade are hidden
+// from its step and reaped, while live keys keep their expiry.
+func TestUpgradeExpired(t *testing.T) {
+	withUpgrades(t)
+
+	var file mem.File
+	var kv0 KV[*mem.File]
+	if err := kv0.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	expireAt := time.Now().Add(time.Hour)
+	kv0.SetExpiry([]byte("a"), []byte("val-a"), time.Now().Add(-time.Second))
+	kv0.SetExpiry([]byte("b"), []byte("val-b"), expireAt)
+	kv0.Set([]byte("c"), []byte("val-c"))
+	var buf bytes.Buffer
+	file.WriteTo(&buf)
+	kv0.Close()
+	file.ReadFrom(&buf)
+
+	withUpgrades(t, upperStep)
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+	if _, err := kv.Upgrade(context.Background()); err != nil {
+		t.Fatalf("Upgrade: %v", err)
+	}
+	checkStore(t, &kv, map[string]string{"b": "VAL-B", "c": "VAL-C"})
+	at, ok, err := kv.Expiry([]byte("b"))
+	if err != nil || !ok || !at.Equal(time.Unix(0, expireAt.UnixNano())) {
+		t.Fatalf("Expiry = %v, %v, %v, want %v", at, ok, err, expireAt)
+	}
+	if n, err := kv.Reap(time.Now()); err != nil || n != 0 {
+		t.Fatalf("Reap = %d, %v, want 0", n, err)
+	}
+
+	t.Log("✓ Expired keys reaped by the upgrade")
+}
+
+// TestFeaturesOptIn tests that writes to a store of the baseline format
+// leave it readable by readers without the features, until Upgrade takes
+// them into use, while new stores use them from the first commit.
+func TestFeaturesOptIn(t *testing.T) {
+	data, err := os.ReadFile("../ksy/kv_sample.kv")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	var file mem.File
+	file.ReadFrom(bytes.NewReader(data))
+	var kv KV[*mem.File]
+	if err = kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+	for i := range 500 {
+		key := fmt.Appendf(nil, "tenant:0001:order:%06d", i)
+		if err = kv.Set(key, []byte("val")); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	if required := kv.Format().Required; required != 0 {
+		t.Fatalf("required features after Set = %#x, want 0", required)
+	}
+
+	// an Upgrade canceled before its commit leaves features out of use
+	if _, err = kv.Upgrade(&errAfter{Context: context.Background(), n: 1}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled Upgrade: %v", err)
+	}
+	if err = kv.Set([]byte("tenant:0002"), []byte("val")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if required := kv.Format().Required; required != 0 {
+		t.Fatalf("required features after a canceled Upgrade = %#x, want 0", required)
+	}
+
+	if _, err = kv.Upgrade(context.Background()); err != nil {
+		t.Fatalf("Upgrade: %v", err)
+	}
+	if required := kv.Format().Required; required != FeaturePrefixLeaves|FeatureShortSeparators {
+		t.Fatalf("required features after Upgrade = %#x", required)
+	}
+	if val, err := kv.Get([]byte("tenant:0001:order:000042")); err != nil || string(val) != "val" {
+		t.Fatalf("Get = %q, %v", val, err)
+	}
+
+	var newFile mem.File
+	var created KV[*mem.File]
+	if err = created.Load(&newFile); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer created.Close()
+	created.Set([]byte("key"), []byte("val"))
+	if required := created.Format().Required; required != FeaturePrefixLeaves|FeatureShortSeparators {
+		t.Fatalf("required features of a new store = %#x", required)
+	}
+
+	t.Log("✓ Features taken into use only by new stores and Upgrade")
+}
@@ -0,0 +1,320 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/dacapoday/smol/block"
+	"github.com/dacapoday/smol/bptree"
+	"github.com/dacapoday/smol/tuple"
+)
+
+// Secondary index entries are kept in the expiry index tree, next to the
+// expiry entries, so they are written in the same commit as the records
+// they index and stay out of the kv tree:
+//
+//	'i' + name + 0x00 + tuple of index key and key -> 0x00
+//
+// where the tuple is that of two []byte elements of package tuple, so
+// entries sort by index key, then by key. They sort before the expiry
+// entries by key, so iterators of a store with indexes but no expiring
+// key find none and skip the expiry lookups.
+
+const indexEntry = 'i'
+
+// Index declares a secondary index of a store, see Options.Indexes.
+type Index struct {
+	// Name identifies the index in the file. It must be unique,
+	// non-empty and free of 0x00 bytes.
+	Name string
+
+	// Extract returns the index keys of a record from its value.
+	// It must return the same keys for the same value: the entries of an
+	// old value are found by extracting it again. It runs while writers
+	// are blocked, and must not retain val.
+	Extract func(val []byte) [][]byte
+}
+
+func indexPrefix(name string) []byte {
+	return append(append([]byte{indexEntry}, name...), 0)
+}
+
+// indexKey returns the entry of key under index key ik, or with a nil key,
+// a bound below the entries an index key >= ik can have, and above those
+// of lesser index keys.
+func indexKey(prefix, ik, key []byte) []byte {
+	buf := make([]byte, 0, len(prefix)+len(ik)+len(key)+4)
+	buf = tuple.AppendBytes(append(buf, prefix...), ik)
+	if key == nil {
+		return buf
+	}
+	return tuple.AppendBytes(buf, key)
+}
+
+func checkIndexes(indexes []Index) error {
+	names := map[string]bool{}
+	for _, index := range indexes {
+		if index.Name == "" || strings.IndexByte(index.Name, 0) >= 0 || index.Extract == nil || names[index.Name] {
+			return fmt.Errorf("invalid index %q", index.Name)
+		}
+		names[index.Name] = true
+	}
+	return nil
+}
+
+func (kv *KV[F]) index(name string) *Index {
+	for i := range kv.indexes {
+		if kv.indexes[i].Name == name {
+			return &kv.indexes[i]
+		}
+	}
+	return nil
+}
+
+// reindex collects the index changes of a record written from old to val.
+func (tracker *expiryTracker[F]) reindex(key, old, val []byte) {
+	for _, index := range tracker.kv.indexes {
+		prefix := indexPrefix(index.Name)
+		if old != nil {
+			for _, ik := range index.Extract(old) {
+				tracker.changes.Set(indexKey(prefix, ik, key), nil)
+			}
+		}
+		if val != nil {
+			for _, ik := range index.Extract(val) {
+				tracker.changes.Set(indexKey(prefix, ik, key), []byte{0})
+			}
+		}
+	}
+}
+
+// rebuild collects the changes replacing the entries of index with ones
+// extracted from every record of root.
+func (tracker *expiryTracker[F]) rebuild(ctx context.Context, root bptree.Page, index *Index) error {
+	kv := tracker.kv
+	prefix := indexPrefix(index.Name)
+	if len(tracker.index) != 0 {
+		var entries bptree.Reader[*block.Heap[F]]
+		entries.Load(&kv.block, tracker.index, kv.klen, kv.vlen, 0)
+		defer entries.Close()
+		for ok := entries.Seek(prefix); ok && bytes.HasPrefix(entries.Key(), prefix); ok = entries.Next() {
+			tracker.changes.Set(bytes.Clone(entries.Key()), nil)
+		}
+		if err := entries.Error(); err != nil {
+			return err
+		}
+	}
+	var reader bptree.Reader[*block.Heap[F]]
+	reader.Load(&kv.block, root, kv.klen, kv.vlen, 0)
+	defer reader.Close()
+	for ok := reader.SeekFirst(); ok; ok = reader.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for _, ik := range index.Extract(reader.Val()) {
+			tracker.changes.Set(indexKey(prefix, ik, reader.Key()), []byte{0})
+		}
+	}
+	return reader.Error()
+}
+
+// Reindex rebuilds the entries of the named index from every record,
+// as needed once an index is declared for a store with records, or its
+// Extract changes. Entries are maintained by writes only while the
+// index is declared.
+func (kv *KV[F]) Reindex(ctx context.Context, name string) error {
+	index := kv.index(name)
+	if index == nil {
+		return fmt.Errorf("kv.Reindex: no index %q", name)
+	}
+	return kv.commit(ctx, fixed(func(func([]byte, []byte) bool) {}), &expiring{keep: true, rebuild: index})
+}
+
+// IndexIter is an iterator over the entries of a secondary index, in
+// index key order, then key order. Key returns the index key, repeated
+// for each record it indexes; Record and Val return the record.
+// Records expired when the iterator was created are skipped.
+type IndexIter[F File] struct {
+	ator *indexIter[F]
+}
+
+type indexIter[F File] struct {
+	ckpt    block.HeapCheckpoint
+	kv      *KV[F]
+	root    bptree.Page
+	expiry  expiry[F]
+	opened  observedIter
+	prefix  []byte
+	ik, key []byte
+	val     []byte
+	valid   bool
+	err     error
+	bptree.Reader[*block.Heap[F]]
+}
+
+// IndexIter creates a new iterator over the named index.
+// Captures a consistent snapshot at the current moment.
+//
+// Important: Caller must call Close to release resources.
+func (kv *KV[F]) IndexIter(name string) IndexIter[F] {
+	iter := new(indexIter[F])
+	if kv.index(name) == nil {
+		iter.err = fmt.Errorf("kv.IndexIter: no index %q", name)
+		return IndexIter[F]{iter}
+	}
+	if entry, ckpt := kv.atom.Acquire(); ckpt != nil {
+		root, index := splitEntry(entry)
+		iter.ckpt = ckpt
+		iter.kv = kv
+		iter.root = root
+		iter.prefix = indexPrefix(name)
+		iter.Load(&kv.block, index, kv.klen, kv.vlen, 0)
+		iter.expiry.load(kv, index)
+		iter.opened.open(kv.observer)
+	}
+	return IndexIter[F]{iter}
+}
+
+// Close releases resources held by the iterator.
+func (iter IndexIter[F]) Close() {
+	if iter.ator.ckpt != nil {
+		iter.ator.ckpt.Release()
+		iter.ator.ckpt = nil
+		iter.ator.expiry.close()
+		iter.ator.opened.close()
+		iter.ator.Close()
+		iter.ator.valid = false
+	}
+}
+
+// Valid returns true if positioned at a valid entry.
+func (iter IndexIter[F]) Valid() bool {
+	return iter.ator.valid
+}
+
+// Error returns any error encountered during iteration.
+func (iter IndexIter[F]) Error() error {
+	if iter.ator.err != nil {
+		return iter.ator.err
+	}
+	if iter.ator.expiry.err != nil {
+		return iter.ator.expiry.err
+	}
+	return iter.ator.Reader.Error()
+}
+
+// Key returns the current index key, or nil if invalid.
+//
+// Warning: Returned slice is valid only until next method call.
+func (iter IndexIter[F]) Key() []byte {
+	if !iter.ator.valid {
+		return nil
+	}
+	return iter.ator.ik
+}
+
+// Record returns the key of the current record, or nil if invalid.
+//
+// Warning: Returned slice is valid only until next method call.
+func (iter IndexIter[F]) Record() []byte {
+	if !iter.ator.valid {
+		return nil
+	}
+	return iter.ator.key
+}
+
+// Val returns the value of the current record, or nil if invalid.
+//
+// Warning: Returned slice is valid only until next method call.
+func (iter IndexIter[F]) Val() []byte {
+	if !iter.ator.valid {
+		return nil
+	}
+	return iter.ator.val
+}
+
+// Next advances to the next entry.
+func (iter IndexIter[F]) Next() bool {
+	return iter.ator.forward(iter.ator.valid && iter.ator.Reader.Next())
+}
+
+// Prev moves to the previous entry.
+func (iter IndexIter[F]) Prev() bool {
+	return iter.ator.backward(iter.ator.valid && iter.ator.Reader.Prev())
+}
+
+// SeekFirst positions at the first entry.
+func (iter IndexIter[F]) SeekFirst() bool {
+	return iter.ator.forward(iter.ator.ckpt != nil && iter.ator.Reader.Seek(iter.ator.prefix))
+}
+
+// SeekLast positions at the last entry.
+func (iter IndexIter[F]) SeekLast() bool {
+	return iter.ator.backward(iter.ator.ckpt != nil && iter.ator.seekBefore(iter.ator.prefix[:len(iter.ator.prefix)-1], 1))
+}
+
+// Seek positions at the first entry with an index key >= the given key.
+func (iter IndexIter[F]) Seek(key []byte) bool {
+	return iter.ator.forward(iter.ator.ckpt != nil && iter.ator.Reader.Seek(indexKey(iter.ator.prefix, key, nil)))
+}
+
+// SeekLE positions at the last entry with an index key <= the given key.
+func (iter IndexIter[F]) SeekLE(key []byte) bool {
+	// entries under key go on with a record key, a []byte element (0x01)
+	return iter.ator.backward(iter.ator.ckpt != nil && iter.ator.seekBefore(indexKey(iter.ator.prefix, key, nil), 2))
+}
+
+// seekBefore positions at the last entry before name + b.
+func (iter *indexIter[F]) seekBefore(name []byte, b byte) bool {
+	key := append(bytes.Clone(name), b)
+	if !iter.Reader.SeekLE(key) {
+		return false
+	}
+	if iter.Reader.Equal(key) {
+		return iter.Reader.Prev()
+	}
+	return true
+}
+
+// forward moves forward past entries of expired records.
+func (iter *indexIter[F]) forward(ok bool) bool {
+	return iter.skip(ok, iter.Reader.Next)
+}
+
+// backward moves backward past entries of expired records.
+func (iter *indexIter[F]) backward(ok bool) bool {
+	return iter.skip(ok, iter.Reader.Prev)
+}
+
+// skip moves on past entries of expired records, stopping at the first
+// entry of another index.
+func (iter *indexIter[F]) skip(ok bool, move func() bool) bool {
+	for ; ok; ok = move() {
+		if !bytes.HasPrefix(iter.Reader.Key(), iter.prefix) || iter.load() || iter.err != nil {
+			break
+		}
+	}
+	iter.valid = ok && iter.err == nil && bytes.HasPrefix(iter.Reader.Key(), iter.prefix)
+	return iter.valid
+}
+
+// load reads the record of the current entry, reporting whether it is live.
+func (iter *indexIter[F]) load() bool {
+	entry := iter.Reader.Key()
+	ik, rest, err := tuple.DecodeBytes(entry[len(iter.prefix):], iter.ik)
+	if err == nil {
+		iter.ik = ik
+		iter.key, rest, err = tuple.DecodeBytes(rest, iter.key)
+	}
+	if err != nil || len(rest) != 0 {
+		iter.err = fmt.Errorf("kv.IndexIter: %w: malformed entry %q", ErrBadEncoding, entry)
+		return false
+	}
+	if iter.expiry.expired(iter.key) || iter.expiry.err != nil {
+		return false
+	}
+	iter.val, iter.err = bptree.Get(&iter.kv.block, iter.root, iter.kv.klen, iter.kv.vlen, 0, iter.val[:0], iter.key)
+	return iter.err == nil && iter.val != nil
+}
@@ -0,0 +1,323 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dacapoday/smol/block"
+	"github.com/dacapoday/smol/bptree"
+	"github.com/dacapoday/smol/mem"
+	"github.com/dacapoday/smol/tuple"
+)
+
+// cityIndex indexes records "name|city|tag,tag..." by city.
+var cityIndex = Index{Name: "city", Extract: func(val []byte) [][]byte {
+	if fields := bytes.Split(val, []byte("|")); len(fields) > 1 {
+		return [][]byte{fields[1]}
+	}
+	return nil
+}}
+
+// tagIndex indexes the same records by each of their tags.
+var tagIndex = Index{Name: "tag", Extract: func(val []byte) [][]byte {
+	if fields := bytes.Split(val, []byte("|")); len(fields) > 2 && len(fields[2]) != 0 {
+		return bytes.Split(fields[2], []byte(","))
+	}
+	return nil
+}}
+
+// indexEntries lists the entries of an index as "index key=record".
+func indexEntries[F File](t *testing.T, kv *KV[F], name string) string {
+	t.Helper()
+	iter := kv.IndexIter(name)
+	defer iter.Close()
+	var entries []string
+	for iter.SeekFirst(); iter.Valid(); iter.Next() {
+		entries = append(entries, fmt.Sprintf("%s=%s", iter.Key(), iter.Record()))
+	}
+	if err := iter.Error(); err != nil {
+		t.Fatalf("IndexIter(%q): %v", name, err)
+	}
+	return strings.Join(entries, " ")
+}
+
+// TestIndex tests that index entries follow every kind of write.
+func TestIndex(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.LoadFile(&file, &Options{Indexes: []Index{cityIndex, tagIndex}}); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	check := func(name, want string) {
+		t.Helper()
+		if got := indexEntries(t, &kv, name); got != want {
+			t.Fatalf("index %q = %q, want %q", name, got, want)
+		}
+	}
+
+	kv.Batch(func(yield func([]byte, []byte) bool) {
+		yield([]byte("u1"), []byte("ann|paris|a,b"))
+		yield([]byte("u2"), []byte("bob|oslo|b"))
+		yield([]byte("u3"), []byte("cid|paris|"))
+	})
+	check("city", "oslo=u2 paris=u1 paris=u3")
+	check("tag", "a=u1 b=u1 b=u2")
+
+	// update moves the entries, delete drops them
+	if err := kv.Set([]byte("u1"), []byte("ann|rome|c")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := kv.Set([]byte("u2"), nil); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	check("city", "paris=u3 rome=u1")
+	check("tag", "c=u1")
+
+	// transactions and read-modify-write go through the same commit
+	tx := kv.Begin()
+	tx.Set([]byte("u4"), []byte("dan|oslo|c"))
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if err := kv.Update([]byte("u3"), func(old []byte) []byte {
+		return bytes.Replace(old, []byte("paris"), []byte("lima"), 1)
+	}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	check("city", "lima=u3 oslo=u4 rome=u1")
+	check("tag", "c=u1 c=u4")
+
+	// expired records are hidden, and reaped with their entries
+	if err := kv.SetExpiry([]byte("u4"), []byte("dan|oslo|c"), time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("SetExpiry: %v", err)
+	}
+	check("city", "lima=u3 rome=u1")
+	if _, err := kv.Reap(time.Now()); err != nil {
+		t.Fatalf("Reap: %v", err)
+	}
+	check("tag", "c=u1")
+
+	// records are read through the index
+	iter := kv.IndexIter("city")
+	defer iter.Close()
+	if !iter.Seek([]byte("m")) || string(iter.Record()) != "u1" || string(iter.Val()) != "ann|rome|c" {
+		t.Fatalf("Seek(m) = %q %q", iter.Record(), iter.Val())
+	}
+	if !iter.Prev() || string(iter.Key()) != "lima" || iter.Prev() {
+		t.Fatalf("Prev = %q", iter.Key())
+	}
+	if !iter.SeekLast() || string(iter.Key()) != "rome" {
+		t.Fatalf("SeekLast = %q", iter.Key())
+	}
+	for probe, want := range map[string]string{"a": "", "lima": "lima", "m": "lima", "rome": "rome", "zz": "rome"} {
+		if ok := iter.SeekLE([]byte(probe)); ok != (want != "") || string(iter.Key()) != want {
+			t.Fatalf("SeekLE(%q) = %q", probe, iter.Key())
+		}
+	}
+
+	// the kv tree holds the records only
+	all := kv.Iter()
+	defer all.Close()
+	n := 0
+	for all.SeekFirst(); all.Valid(); all.Next() {
+		n++
+	}
+	if n != 2 {
+		t.Fatalf("kv tree has %d keys, want 2", n)
+	}
+
+	t.Log("✓ Index entries followed Batch, Set, Tx, Update, expiry and Reap")
+}
+
+// TestIndexKeyOrder tests that entries sort by index key, including
+// index keys holding 0x00 bytes, then by record key.
+func TestIndexKeyOrder(t *testing.T) {
+	raw := Index{Name: "raw", Extract: func(val []byte) [][]byte { return [][]byte{val} }}
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.LoadFile(&file, &Options{Indexes: []Index{raw}}); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	vals := map[string]string{"k1": "a\x00", "k2": "a", "k3": "a\x00\x01", "k4": "", "k5": "a"}
+	for key, val := range vals {
+		if err := kv.Set([]byte(key), []byte(val)); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	want := "=k4 a=k2 a=k5 a\x00=k1 a\x00\x01=k3"
+	if got := indexEntries(t, &kv, "raw"); got != want {
+		t.Fatalf("entries = %q, want %q", got, want)
+	}
+
+	iter := kv.IndexIter("raw")
+	defer iter.Close()
+	if !iter.Seek([]byte("a\x00")) || string(iter.Record()) != "k1" {
+		t.Fatalf("Seek = %q", iter.Record())
+	}
+	if !iter.SeekLE([]byte("a")) || string(iter.Record()) != "k5" {
+		t.Fatalf("SeekLE = %q", iter.Record())
+	}
+
+	t.Log("✓ Entries sorted by escaped index key, then record key")
+}
+
+// TestReindex tests that Reindex builds an index declared for a store
+// with records, and drops entries left stale by writes made without it.
+func TestReindex(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.LoadFile(&file, &Options{Indexes: []Index{cityIndex}}); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	kv.Set([]byte("u1"), []byte("ann|paris|a"))
+	kv.Set([]byte("u2"), []byte("bob|oslo|b"))
+	var buf bytes.Buffer
+	file.WriteTo(&buf)
+	kv.Close()
+	file.ReadFrom(&buf)
+
+	// written without the index: its entries go stale
+	var plain KV[*mem.File]
+	if err := plain.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	plain.Set([]byte("u1"), []byte("ann|rome|a"))
+	buf.Reset()
+	file.WriteTo(&buf)
+	plain.Close()
+	file.ReadFrom(&buf)
+
+	var indexed KV[*mem.File]
+	if err := indexed.LoadFile(&file, &Options{Indexes: []Index{cityIndex, tagIndex}}); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer indexed.Close()
+	if got := indexEntries(t, &indexed, "tag"); got != "" {
+		t.Fatalf("tag entries before Reindex = %q", got)
+	}
+	for _, name := range []string{"city", "tag"} {
+		if err := indexed.Reindex(context.Background(), name); err != nil {
+			t.Fatalf("Reindex(%q): %v", name, err)
+		}
+	}
+	if got := indexEntries(t, &indexed, "city"); got != "oslo=u2 rome=u1" {
+		t.Fatalf("city entries = %q", got)
+	}
+	if got := indexEntries(t, &indexed, "tag"); got != "a=u1 b=u2" {
+		t.Fatalf("tag entries = %q", got)
+	}
+
+	if err := indexed.Reindex(context.Background(), "none"); err == nil {
+		t.Fatal("expected error for unknown index")
+	}
+	iter := indexed.IndexIter("none")
+	defer iter.Close()
+	if iter.SeekFirst() || iter.Error() == nil {
+		t.Fatal("expected error for unknown index")
+	}
+
+	t.Log("✓ Reindex built a new index and fixed a stale one")
+}
+
+// TestIndexInvalid tests that invalid index declarations are rejected.
+func TestIndexInvalid(t *testing.T) {
+	for _, indexes := range [][]Index{
+		{{Name: "", Extract: cityIndex.Extract}},
+		{{Name: "a\x00b", Extract: cityIndex.Extract}},
+		{{Name: "city"}},
+		{cityIndex, cityIndex},
+	} {
+		var file mem.File
+		var kv KV[*mem.File]
+		if err := kv.LoadFile(&file, &Options{Indexes: indexes}); err == nil {
+			kv.Close()
+			t.Fatalf("expected error for %q", indexes[0].Name)
+		}
+	}
+	t.Log("✓ Invalid indexes rejected")
+}
+
+// TestIndexWithoutExpiry tests that iterators of a store with index
+// entries, but no expiring key, skip the expiry lookups.
+func TestIndexWithoutExpiry(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.LoadFile(&file, &Options{Indexes: []Index{cityIndex}}); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	kv.Set([]byte("ann"), []byte("ann|rome|"))
+	kv.Set([]byte("bob"), []byte("bob|oslo|"))
+	iter := kv.Iter()
+	if iter.ator.expiry.now != 0 || !iter.SeekFirst() || string(iter.Key()) != "ann" {
+		t.Fatalf("expiry lookups on, at %q", iter.Key())
+	}
+	iter.Close()
+
+	kv.SetTTL([]byte("cid"), []byte("cid|rome|"), time.Hour)
+	kv.SetExpiry([]byte("bob"), []byte("bob|oslo|"), time.Now().Add(-time.Second))
+	iter = kv.Iter()
+	defer iter.Close()
+	var keys []string
+	for iter.SeekFirst(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	if iter.ator.expiry.now == 0 || strings.Join(keys, " ") != "ann cid" {
+		t.Fatalf("keys = %q", keys)
+	}
+	if got := indexEntries(t, &kv, "city"); got != "rome=ann rome=cid" {
+		t.Fatalf("entries = %q", got)
+	}
+
+	t.Log("✓ Expiry lookups only once a key has an expiry time")
+}
+
+// TestIndexCorrupt tests that a malformed index entry stops an index
+// iterator with an error matching ErrBadEncoding.
+func TestIndexCorrupt(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.LoadFile(&file, &Options{Indexes: []Index{cityIndex}}); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+	kv.Set([]byte("ann"), []byte("ann|rome|"))
+
+	// an entry of index key "rome" without a record key
+	bad := tuple.AppendBytes(indexPrefix("city"), []byte("rome"))
+	err := kv.atom.Swap(func(entry bptree.Page) (bptree.Page, block.HeapCheckpoint, error) {
+		root, index := splitEntry(entry)
+		_, index, err := bptree.WriteSortedChangesContext(context.Background(), &kv.block,
+			index, kv.klen, kv.vlen, 0, kv.write, single(bad, []byte{0}))
+		if err == nil {
+			entry, err = kv.joinEntry(root, index)
+		}
+		if err != nil {
+			kv.block.Rollback()
+			return nil, nil, err
+		}
+		ckpt, err := kv.block.Commit(entry)
+		return entry, ckpt, err
+	})
+	if err != nil {
+		t.Fatalf("corrupt: %v", err)
+	}
+
+	iter := kv.IndexIter("city")
+	defer iter.Close()
+	if iter.SeekFirst() || !errors.Is(iter.Error(), ErrBadEncoding) {
+		t.Fatalf("SeekFirst on a malformed entry: %v", iter.Error())
+	}
+
+	t.Log("✓ Malformed index entry reported as ErrBadEncoding")
+}
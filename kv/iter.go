@@ -1,7 +1,11 @@
 package kv
 
 import (
+	"bytes"
+	"context"
 	"os"
+	"slices"
+	"time"
 
 	"github.com/dacapoday/smol/block"
 	"github.com/dacapoday/smol/bptree"
@@ -24,6 +28,7 @@ type Iterator[Iter iterator.Iterator] interface {
 type DBIter = Iter[*os.File]
 
 var _ Iterator[DBIter] = DBIter{}
+var _ iterator.SeekerLE = DBIter{}
 
 // Iter is an iterator over a KV store snapshot.
 type Iter[F File] struct {
@@ -31,23 +36,65 @@ type Iter[F File] struct {
 }
 
 type iter[F File] = struct {
-	ckpt block.HeapCheckpoint
+	ckpt       block.HeapCheckpoint
+	expiry     expiry[F]
+	ctx        context.Context
+	ctxErr     error
+	opened     observedIter
+	start, end []byte // bounds of a partition, nil if open
+	outside    bool   // positioned past the bounds
 	bptree.Reader[*block.Heap[F]]
 }
 
+// observedIter reports the lifetime of an iterator to an Observer.
+type observedIter struct {
+	observer Observer
+	at       time.Time
+}
+
+func (o *observedIter) open(observer Observer) {
+	if observer != nil {
+		o.observer, o.at = observer, time.Now()
+		observer.IterOpen()
+	}
+}
+
+func (o *observedIter) close() {
+	if o.observer != nil {
+		o.observer.IterClose(time.Since(o.at))
+		o.observer = nil
+	}
+}
+
 // Iter creates a new iterator over the key-value store.
-// Captures a consistent snapshot at the current moment.
+// Captures a consistent snapshot at the current moment,
+// hiding keys expired by then.
 //
 // Important: Caller must call Close to release resources.
 func (kv *KV[F]) Iter() Iter[F] {
 	iter := new(iter[F])
-	if root, ckpt := kv.atom.Acquire(); ckpt != nil {
+	if entry, ckpt := kv.atom.Acquire(); ckpt != nil {
+		root, index := splitEntry(entry)
 		iter.ckpt = ckpt
 		iter.Load(&kv.block, root, kv.klen, kv.vlen, 0)
+		iter.expiry.load(kv, index)
+		iter.opened.open(kv.observer)
 	}
 	return Iter[F]{iter}
 }
 
+// IterContext is Iter, for an iterator that stops once ctx is done:
+// moves fail, Valid reports false and Error returns the context's error.
+//
+// Important: Caller must call Close to release resources.
+func (kv *KV[F]) IterContext(ctx context.Context) Iter[F] {
+	iter := kv.Iter()
+	if ctx.Done() != nil {
+		iter.ator.ctx = ctx
+	}
+	return iter
+}
+
 // Clone creates an independent copy at current position.
 func (kv Iter[F]) Clone() Iter[F] {
 	iter := new(iter[F])
@@ -55,6 +102,10 @@ func (kv Iter[F]) Clone() Iter[F] {
 		kv.ator.ckpt.Acquire()
 		iter.ckpt = kv.ator.ckpt
 		iter.LoadFrom(&kv.ator.Reader)
+		iter.expiry.loadFrom(&kv.ator.expiry)
+		iter.ctx, iter.ctxErr = kv.ator.ctx, kv.ator.ctxErr
+		iter.start, iter.end, iter.outside = kv.ator.start, kv.ator.end, kv.ator.outside
+		iter.opened.open(kv.ator.opened.observer)
 	}
 	return Iter[F]{iter}
 }
@@ -64,17 +115,26 @@ func (iter Iter[F]) Close() {
 	if iter.ator.ckpt != nil {
 		iter.ator.ckpt.Release()
 		iter.ator.ckpt = nil
+		iter.ator.expiry.close()
+		iter.ator.ctx = nil
+		iter.ator.opened.close()
 		iter.ator.Close()
 	}
 }
 
 // Valid returns true if positioned at a valid item.
 func (iter Iter[F]) Valid() bool {
-	return iter.ator.Valid()
+	return iter.ator.Valid() && !iter.ator.outside && iter.ator.expiry.err == nil && iter.ator.ctxErr == nil
 }
 
 // Error returns any error encountered during iteration.
 func (iter Iter[F]) Error() error {
+	if iter.ator.ctxErr != nil {
+		return iter.ator.ctxErr
+	}
+	if iter.ator.expiry.err != nil {
+		return iter.ator.expiry.err
+	}
 	return iter.ator.Error()
 }
 
@@ -82,6 +142,9 @@ func (iter Iter[F]) Error() error {
 //
 // Warning: Returned slice is valid only until next method call.
 func (iter Iter[F]) Key() []byte {
+	if iter.ator.outside {
+		return nil
+	}
 	return iter.ator.Key()
 }
 
@@ -89,57 +152,128 @@ func (iter Iter[F]) Key() []byte {
 //
 // Warning: Returned slice is valid only until next method call.
 func (iter Iter[F]) Val() []byte {
+	if iter.ator.outside {
+		return nil
+	}
 	return iter.ator.Val()
 }
 
 // Next advances to the next item.
 func (iter Iter[F]) Next() bool {
-	return iter.ator.Next()
+	return !iter.ator.outside && !iter.canceled() && iter.within(iter.ator.expiry.forward(&iter.ator.Reader, iter.ator.Next()))
 }
 
 // Prev moves to the previous item.
 func (iter Iter[F]) Prev() bool {
-	return iter.ator.Prev()
+	return !iter.ator.outside && !iter.canceled() && iter.within(iter.ator.expiry.backward(&iter.ator.Reader, iter.ator.Prev()))
 }
 
 // SeekFirst positions at the first key.
 func (iter Iter[F]) SeekFirst() bool {
-	return iter.ator.SeekFirst()
+	if iter.ator.start != nil {
+		return iter.Seek(iter.ator.start)
+	}
+	return !iter.canceled() && iter.within(iter.ator.expiry.forward(&iter.ator.Reader, iter.ator.SeekFirst()))
 }
 
 // SeekLast positions at the last key.
 func (iter Iter[F]) SeekLast() bool {
-	return iter.ator.SeekLast()
+	if iter.ator.end != nil {
+		return iter.seekBefore(iter.ator.end)
+	}
+	return !iter.canceled() && iter.within(iter.ator.expiry.backward(&iter.ator.Reader, iter.ator.SeekLast()))
 }
 
 // Seek positions at the first key >= the given key.
 func (iter Iter[F]) Seek(key []byte) bool {
-	return iter.ator.Seek(key)
+	if iter.ator.start != nil && bytes.Compare(key, iter.ator.start) < 0 {
+		key = iter.ator.start
+	}
+	return !iter.canceled() && iter.within(iter.ator.expiry.forward(&iter.ator.Reader, iter.ator.Seek(key)))
+}
+
+// SeekLE positions at the last key <= the given key.
+func (iter Iter[F]) SeekLE(key []byte) bool {
+	if iter.ator.end != nil && bytes.Compare(key, iter.ator.end) >= 0 {
+		return iter.seekBefore(iter.ator.end)
+	}
+	return !iter.canceled() && iter.within(iter.ator.expiry.backward(&iter.ator.Reader, iter.ator.SeekLE(key)))
+}
+
+// seekBefore positions at the last key < the given key.
+func (iter Iter[F]) seekBefore(key []byte) bool {
+	if iter.canceled() {
+		return false
+	}
+	ok := iter.ator.SeekLE(key)
+	if ok && iter.ator.Equal(key) {
+		ok = iter.ator.Prev()
+	}
+	return iter.within(iter.ator.expiry.backward(&iter.ator.Reader, ok))
+}
+
+// within reports whether ok and the key positioned at is within the bounds
+// of the iterator, which are left invalid otherwise.
+func (iter Iter[F]) within(ok bool) bool {
+	iter.ator.outside = false
+	if !ok || iter.ator.start == nil && iter.ator.end == nil {
+		return ok
+	}
+	key := iter.ator.Key()
+	if iter.ator.start != nil && bytes.Compare(key, iter.ator.start) < 0 ||
+		iter.ator.end != nil && bytes.Compare(key, iter.ator.end) >= 0 {
+		iter.ator.outside = true
+		return false
+	}
+	return true
+}
+
+// canceled reports whether the context of the iterator is done,
+// keeping its error.
+func (iter Iter[F]) canceled() bool {
+	if iter.ator.ctx == nil {
+		return false
+	}
+	if iter.ator.ctxErr == nil {
+		iter.ator.ctxErr = iter.ator.ctx.Err()
+	}
+	return iter.ator.ctxErr != nil
 }
 
 // Iter creates a new iterator over the transaction's view.
-// Combines pending changes with the base snapshot.
+// Layers the pending changes, and those made since each savepoint, over
+// the base snapshot. The iterator sees changes made after it was created,
+// except those made since a savepoint taken after it was created.
 //
 // Important: Caller must call Close to release resources.
 func (tx *Tx[Iter]) Iter() (iter TxIter[Iter]) {
-	iter.ator = new(iterator.Combine[btree.Iter, Iter])
-	iter.ator.Load(tx.pending.Iter(), tx.snapshot.Clone(), nil)
+	over := new(iterator.MergeN[btree.Iter])
+	over.Load(tx.layers(), nil)
+	iter.ator = new(iterator.Combine[*iterator.MergeN[btree.Iter], Iter])
+	iter.ator.Load(over, tx.snapshot.Clone(), nil)
 	return
 }
 
 var _ Iterator[TxIter[DBIter]] = TxIter[DBIter]{}
+var _ iterator.SeekerLE = TxIter[DBIter]{}
 
 // TxIter is an iterator over a transaction's view.
 // Merges pending changes with the base snapshot.
 // Implements iterator.Iterator interface.
 type TxIter[Iter Iterator[Iter]] struct {
-	ator *iterator.Combine[btree.Iter, Iter]
+	ator *iterator.Combine[*iterator.MergeN[btree.Iter], Iter]
 }
 
 // Clone creates an independent copy at current position.
 func (iter TxIter[Iter]) Clone() (newIter TxIter[Iter]) {
-	newIter.ator = new(iterator.Combine[btree.Iter, Iter])
-	newIter.ator.Load(iter.ator.Over().Clone(), iter.ator.Base().Clone(), iter.ator)
+	layers := slices.Clone(iter.ator.Over().Iters())
+	for i, layer := range layers {
+		layers[i] = layer.Clone()
+	}
+	over := new(iterator.MergeN[btree.Iter])
+	over.Load(layers, iter.ator.Over())
+	newIter.ator = new(iterator.Combine[*iterator.MergeN[btree.Iter], Iter])
+	newIter.ator.Load(over, iter.ator.Base().Clone(), iter.ator)
 	return
 }
 
@@ -197,3 +331,9 @@ func (iter TxIter[Iter]) SeekLast() bool {
 func (iter TxIter[Iter]) Seek(key []byte) bool {
 	return iter.ator.Seek(key)
 }
+
+// SeekLE positions at the last key <= the given key,
+// natively in the snapshot if its iterator is an iterator.SeekerLE.
+func (iter TxIter[Iter]) SeekLE(key []byte) bool {
+	return iter.ator.SeekLE(key)
+}
@@ -10,6 +10,12 @@
 //   - Thread-safe: concurrent reads and writes supported
 //   - Isolation: MVCC snapshot isolation with Read Committed transaction level
 //
+// Multi-process:
+//   - Open takes an advisory lock on the file: exclusive for read-write,
+//     shared for read-only (see Options). A second writer fails with ErrLocked.
+//   - Followers (Options.Follow) read alongside the writer and move to
+//     its latest commit with Refresh.
+//
 // Important: Complete transactions (Commit/Rollback) and close iterators (Close)
 // promptly to prevent unexpected database file growth due to retained snapshots.
 //
@@ -46,9 +52,12 @@
 package kv
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"math"
 	"os"
+	"time"
 
 	"github.com/dacapoday/smol/atom"
 	"github.com/dacapoday/smol/block"
@@ -61,16 +70,69 @@ type DB = KV[*os.File]
 
 // Open creates or opens a database file at the specified path.
 // Creates the file with 0600 permissions if it doesn't exist.
-// Returns error if the file cannot be opened or contains corrupted data.
+// Returns error if the file cannot be opened or contains corrupted data,
+// or ErrLocked if another process holds the file.
 func Open(path string) (db *DB, err error) {
-	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	return OpenFile(path, nil)
+}
+
+// OpenFile opens a database file at the specified path with options.
+// A nil opts is equivalent to Open.
+//
+// The file is locked for the lifetime of the DB: exclusively when
+// read-write, shared when opts.ReadOnly. The lock is released by Close.
+// Followers take no lock. Read-only modes do not create the file.
+// Locks are taken with flock on Unix and LockFileEx on Windows; other
+// platforms take none, and two writers there corrupt the file.
+func OpenFile(path string, opts *Options) (db *DB, err error) {
+	var opt Options
+	if opts != nil {
+		opt = *opts
+	}
+	if opt.Follow {
+		opt.ReadOnly = true
+	}
+
+	flag := os.O_RDWR | os.O_CREATE
+	if opt.ReadOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(path, flag, 0600)
 	if err != nil {
 		return
 	}
 
+	var r *readers
+	if opt.Follow {
+		r, err = joinReaders(path)
+	} else {
+		r = &readers{path: path}
+		err = lockFile(file, !opt.ReadOnly, opt.LockTimeout)
+	}
+	if err != nil {
+		file.Close()
+		err = fmt.Errorf("kv.Open: %w", err)
+		return
+	}
+
 	db = new(DB)
-	if err = db.Load(file); err != nil {
+	if err = db.LoadFile(file, &opt); err != nil {
+		r.close()
+		file.Close()
 		db = nil
+		return
+	}
+
+	if opt.ReadOnly && !opt.Follow {
+		return
+	}
+	db.readers = r
+	if opt.Follow {
+		if err = db.follow(); err != nil {
+			db.Close()
+			db = nil
+			err = fmt.Errorf("kv.Open: %w", err)
+		}
 	}
 	return
 }
@@ -85,6 +147,16 @@ type KV[F File] struct {
 	block      block.Heap[F]
 	atom       atom.Atom[bptree.Page, block.HeapCheckpoint]
 	klen, vlen int
+	write      bptree.Options
+	features   uint64 // enabled
+	disabled   uint64 // features
+	readOnly   bool
+	readers    *readers
+	feed       feed
+	backupBase backupBase
+	reaper     func()
+	observer   Observer
+	indexes    []Index
 }
 
 // File returns the underlying file handle.
@@ -101,18 +173,35 @@ func (kv *KV[F]) Block() *block.Heap[F] {
 // Recovers B+ tree state from the latest checkpoint.
 // Returns error if the file is corrupted or incompatible.
 func (kv *KV[F]) Load(file F) (err error) {
-	entry, ckpt, err := kv.block.Load(file, BlockOption{})
+	return kv.LoadFile(file, nil)
+}
+
+// LoadFile initializes the KV store from an existing file with options.
+// A nil opts is equivalent to Load. Locking is the caller's responsibility.
+func (kv *KV[F]) LoadFile(file F, opts *Options) (err error) {
+	var opt Options
+	if opts != nil {
+		opt = *opts
+	}
+
+	if err = checkIndexes(opt.Indexes); err != nil {
+		err = fmt.Errorf("kv.Load: %w", err)
+		return
+	}
+	if len(opt.Indexes) != 0 && opt.DisabledFeatures&FeatureExpiryIndex != 0 {
+		err = fmt.Errorf("kv.Load: %w: indexes without FeatureExpiryIndex", ErrUnsupported)
+		return
+	}
+
+	entry, ckpt, err := kv.block.Load(file, opt.blockOption())
 	if err != nil {
 		return
 	}
 
-	var root bptree.Page
-	if entrySize := len(entry); entrySize != 0 {
-		root = bptree.Page(entry)
-		if root.Count() == 0 {
-			err = fmt.Errorf("kv.Load: %w kv entry", ErrUnsupported)
-			return
-		}
+	root, err := rootPage(entry)
+	if err != nil {
+		err = fmt.Errorf("kv.Load: %w", err)
+		return
 	}
 	{
 		pageSize := kv.block.PageSize()
@@ -120,7 +209,92 @@ func (kv *KV[F]) Load(file F) (err error) {
 		kv.klen, kv.vlen = bptree.InlineSize(pageSize, 5, maxOverflowSize, maxOverflowSize)
 	}
 
+	kv.readOnly = opt.ReadOnly || opt.Follow
+	kv.features, kv.disabled = 0, opt.DisabledFeatures
+	kv.write.Fill = opt.Fill
+	if ckpt.Ckp() == 0 && len(entry) == 0 {
+		// a new store takes every feature into use
+		kv.enable(Features)
+	} else {
+		kv.enable(kv.block.Format().Required)
+	}
+	kv.observer = opt.Observer
+	kv.indexes = opt.Indexes
+	kv.feed.load(ckpt.Ckp(), opt.RetainChangesets)
 	kv.atom.Load(root, ckpt)
+	if !kv.readOnly && opt.ReapInterval > 0 {
+		kv.startReaper(opt.ReapInterval)
+	}
+	return
+}
+
+// rootPage validates a kv entry: a root page, optionally followed by the
+// root page of the expiry index.
+func rootPage(entry []byte) (root bptree.Page, err error) {
+	if entrySize := len(entry); entrySize != 0 {
+		root = bptree.Page(entry)
+		main, index := splitEntry(root)
+		if main.Count() == 0 || len(index) != 0 && (index.Count() == 0 || index.Size() != len(index)) {
+			err = fmt.Errorf("%w kv entry", ErrUnsupported)
+		}
+	}
+	return
+}
+
+// Refresh moves a read-only store to the newest checkpoint committed by
+// the writer process, reporting whether there was one. Open iterators and
+// transactions keep their snapshot. A no-op for a read-write store.
+func (kv *KV[F]) Refresh() (changed bool, err error) {
+	if !kv.readOnly {
+		return
+	}
+
+	err = kv.atom.Swap(func(bptree.Page) (root bptree.Page, ckpt block.HeapCheckpoint, err error) {
+		entry, ckpt, err := kv.block.Refresh()
+		if err != nil || ckpt == nil {
+			err = errUnchanged{err}
+			return
+		}
+		if root, err = rootPage(entry); err != nil {
+			ckpt.Release()
+			ckpt = nil
+			return
+		}
+		changed = true
+		return
+	})
+	if unchanged, ok := err.(errUnchanged); ok {
+		err = unchanged.error
+	}
+	if err == nil && kv.readers != nil {
+		err = kv.readers.publish(kv.block.Oldest())
+	}
+	if err != nil {
+		err = fmt.Errorf("kv.Refresh: %w", err)
+	}
+	return
+}
+
+// errUnchanged aborts the swap in Refresh, carrying the error if any.
+type errUnchanged struct{ error }
+
+// follow registers the snapshot loaded by a follower. The writer may
+// have reused its blocks before seeing the registration if it committed
+// twice since, in which case the follower moves to a newer snapshot,
+// one loaded after registering and so protected by it.
+func (kv *KV[F]) follow() (err error) {
+	ckp := kv.block.Oldest()
+	if err = kv.readers.publish(ckp); err != nil {
+		return
+	}
+
+	latest, err := kv.block.Latest()
+	if err != nil {
+		return
+	}
+	if int32(latest-ckp) >= 2 {
+		_, err = kv.Refresh()
+	}
 	return
 }
 
@@ -129,14 +303,18 @@ func (kv *KV[F]) Load(file F) (err error) {
 //
 // Advanced features can be accessed through the bptree and block packages
 // using this option with KV.File().
-type BlockOption struct{}
+type BlockOption struct {
+	readOnly bool
+	observer Observer
+	disabled uint64 // features
+}
 
 func (o BlockOption) MagicCode() [4]byte {
 	return [4]byte{'D', 'I', 'C', 'T'}
 }
 
 func (o BlockOption) ReadOnly() bool {
-	return false
+	return o.readOnly
 }
 
 func (o BlockOption) IgnoreInvalidFreelist() bool {
@@ -151,30 +329,69 @@ func (o BlockOption) BlockSize() int {
 	return 1 << 14
 }
 
+func (o BlockOption) Observer() Observer {
+	return o.observer
+}
+
+// SupportedFormat reports FormatVersion and the features not disabled
+// by Options.DisabledFeatures.
+func (o BlockOption) SupportedFormat() Format {
+	return supportedFormat(o.disabled)
+}
+
 // Close releases all resources and closes the underlying file.
 func (kv *KV[F]) Close() (err error) {
+	if kv.reaper != nil {
+		kv.reaper()
+		kv.reaper = nil
+	}
+	kv.feed.close()
+	kv.backupBase.reset()
 	kv.atom.Close()
-	return kv.block.Close()
+	err = kv.block.Close()
+	if kv.readers != nil {
+		kv.readers.close()
+		kv.readers = nil
+	}
+	return
 }
 
 // Get retrieves the value for the given key.
-// Returns nil if key does not exist.
+// Returns nil if key does not exist or has expired.
 // Returned value is safe to modify.
 func (kv *KV[F]) Get(key []byte) (val []byte, err error) {
-	root, ckpt := kv.atom.Acquire()
+	entry, ckpt := kv.atom.Acquire()
 	if ckpt == nil {
 		err = ErrClosed
 		return
 	}
+	defer ckpt.Release()
+	root, index := splitEntry(entry)
+	return kv.get(root, index, key)
+}
+
+// get retrieves the value for key from the kv tree, unless expired.
+func (kv *KV[F]) get(root, index bptree.Page, key []byte) (val []byte, err error) {
+	return kv.getAt(root, index, key, expiryTime(time.Now()))
+}
+
+// getAt is get for keys expired by now, an expiryTime.
+func (kv *KV[F]) getAt(root, index bptree.Page, key []byte, now uint64) (val []byte, err error) {
 	val, err = bptree.Get(&kv.block, root, kv.klen, kv.vlen, 0, nil, key)
-	ckpt.Release()
+	if err != nil || val == nil || len(index) == 0 {
+		return
+	}
+	at, ok, err := kv.expiresAt(index, key)
+	if err != nil || ok && at <= now {
+		val = nil
+	}
 	return
 }
 
 // Set inserts or updates a key-value pair.
 // Pass nil value to delete a key.
 func (kv *KV[F]) Set(key []byte, val []byte) (err error) {
-	return kv.commitSortedChanges(func(yield func([]byte, []byte) bool) { yield(key, val) })
+	return kv.commitSortedChanges(context.Background(), single(key, val))
 }
 
 // Batch atomically commits multiple key-value changes.
@@ -182,23 +399,124 @@ func (kv *KV[F]) Set(key []byte, val []byte) (err error) {
 //
 // Warning: Caller must not modify yielded keys/values until Batch returns.
 func (kv *KV[F]) Batch(changes func(yield func([]byte, []byte) bool)) error {
+	return kv.BatchContext(context.Background(), changes)
+}
+
+// BatchContext is Batch, aborting once ctx is done: the blocks written so
+// far are rolled back, the store is left unchanged, and the context's
+// error is returned.
+func (kv *KV[F]) BatchContext(ctx context.Context, changes func(yield func([]byte, []byte) bool)) error {
 	var batch btree.BTree
 	for k, v := range changes {
 		batch.Set(k, v)
 	}
-	return kv.commitSortedChanges(batch.Items)
+	return kv.commitSortedChanges(ctx, batch.Items)
 }
 
-func (kv *KV[F]) commitSortedChanges(sortedChanges func(func([]byte, []byte) bool)) error {
-	return kv.atom.Swap(func(root bptree.Page) (newRoot bptree.Page, newCkpt block.HeapCheckpoint, err error) {
-		_, newRoot, err = bptree.WriteSortedChanges(&kv.block,
-			root, kv.klen, kv.vlen, 0, sortedChanges)
+func (kv *KV[F]) commitSortedChanges(ctx context.Context, sortedChanges func(func([]byte, []byte) bool)) error {
+	return kv.commit(ctx, fixed(sortedChanges), nil)
+}
+
+// prepare returns the sorted changes of a commit from the roots of the
+// kv tree and its expiry index, while writers are serialized.
+// Returning errUnchanged aborts the commit with the error it carries.
+type prepare = func(root, index bptree.Page) (sortedChanges func(func([]byte, []byte) bool), err error)
+
+// fixed prepares changes that do not depend on the store.
+func fixed(sortedChanges func(func([]byte, []byte) bool)) prepare {
+	return func(bptree.Page, bptree.Page) (func(func([]byte, []byte) bool), error) {
+		return sortedChanges, nil
+	}
+}
+
+// commit writes the changes prepared against the current snapshot
+// to the kv tree and its expiry index. Once ctx is done, the blocks
+// written are rolled back and nothing is committed.
+func (kv *KV[F]) commit(ctx context.Context, prepare prepare, exp *expiring) error {
+	if kv.readOnly {
+		return ErrReadOnly
+	}
+	err := kv.atom.Swap(func(entry bptree.Page) (newEntry bptree.Page, newCkpt block.HeapCheckpoint, err error) {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		// features taken into use by prepare are dropped with the commit,
+		// as Rollback restores the format
+		features, write := kv.features, kv.write
+		defer func() {
+			if err != nil {
+				kv.features, kv.write = features, write
+			}
+		}()
+		root, index := splitEntry(entry)
+		sortedChanges, err := prepare(root, index)
+		if err != nil {
+			return
+		}
+
+		var changes []Change
+		recorded := kv.feed.active()
+		if recorded {
+			sortedChanges = recordChanges(sortedChanges, &changes)
+		}
+
+		if kv.readers != nil {
+			ckp, _, err := kv.readers.oldest()
+			if err != nil {
+				return nil, nil, fmt.Errorf("kv: reader table: %w", err)
+			}
+			kv.block.Hold(ckp)
+		}
+
+		var tracker *expiryTracker[F]
+		if len(index) != 0 || exp != nil && exp.at != nil || len(kv.indexes) != 0 {
+			tracker = &expiryTracker[F]{kv: kv, root: root, index: index, exp: exp}
+			sortedChanges = tracker.track(sortedChanges)
+		}
+
+		_, root, err = bptree.WriteSortedChangesContext(ctx, &kv.block,
+			root, kv.klen, kv.vlen, 0, kv.write, sortedChanges)
+		if err == nil && tracker != nil {
+			if err = tracker.err; err == nil {
+				index, err = tracker.write(ctx, root)
+			}
+		}
+		if err == nil {
+			err = kv.require(index)
+		}
+		if err == nil {
+			newEntry, err = kv.joinEntry(root, index)
+		}
+		if err == nil {
+			err = ctx.Err()
+		}
 		if err != nil {
 			kv.block.Rollback()
 			return
 		}
 
-		newCkpt, err = kv.block.Commit(newRoot)
+		if newCkpt, err = kv.block.Commit(newEntry); err == nil {
+			kv.feed.enqueue(Changeset{Ckp: newCkpt.Ckp(), Changes: changes}, recorded)
+		}
 		return
 	})
+	if unchanged, ok := err.(errUnchanged); ok {
+		return unchanged.error
+	}
+	if err == nil {
+		kv.feed.deliver()
+	}
+	return err
+}
+
+// recordChanges copies changes into *changes as they are consumed.
+func recordChanges(sortedChanges func(func([]byte, []byte) bool), changes *[]Change) func(func([]byte, []byte) bool) {
+	return func(yield func([]byte, []byte) bool) {
+		for key, val := range sortedChanges {
+			*changes = append(*changes, Change{Key: bytes.Clone(key), Val: bytes.Clone(val)})
+			if !yield(key, val) {
+				return
+			}
+		}
+	}
 }
@@ -2,11 +2,14 @@ package kv
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/dacapoday/smol/bptree"
+	"github.com/dacapoday/smol/iterator"
 	"github.com/dacapoday/smol/mem"
 )
 
@@ -410,3 +413,219 @@ func TestKVBatchOverwrite(t *testing.T) {
 
 	t.Logf("✓ Batch overwrite %d keys", count)
 }
+
+// TestKVFill tests commits with a fill policy for appends and deletes.
+func TestKVFill(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+
+	err := kv.LoadFile(&file, &Options{Fill: bptree.Fill{Append: 100, Merge: 40}})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	count := 3000
+	for batch := 0; batch < count; batch += 50 {
+		err = kv.Batch(func(yield func([]byte, []byte) bool) {
+			for i := batch; i < batch+50; i++ {
+				if !yield(fmt.Appendf(nil, "log-%06d", i), fmt.Appendf(nil, "entry-%d", i)) {
+					return
+				}
+			}
+		})
+		if err != nil {
+			t.Fatalf("Batch append: %v", err)
+		}
+	}
+
+	err = kv.Batch(func(yield func([]byte, []byte) bool) {
+		for i := range count {
+			if i%10 != 0 && !yield(fmt.Appendf(nil, "log-%06d", i), nil) {
+				return
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("Batch delete: %v", err)
+	}
+
+	iter := kv.Iter()
+	defer iter.Close()
+	i := 0
+	for iter.SeekFirst(); iter.Valid(); iter.Next() {
+		want := fmt.Appendf(nil, "log-%06d", i)
+		if !bytes.Equal(iter.Key(), want) {
+			t.Fatalf("Key = %q, want %q", iter.Key(), want)
+		}
+		i += 10
+	}
+	if err = iter.Error(); err != nil {
+		t.Fatalf("Iter: %v", err)
+	}
+	if i != count {
+		t.Fatalf("iterated up to %d, want %d", i, count)
+	}
+
+	t.Logf("✓ Fill policy applied to %d appends", count)
+}
+
+// errAfter is a context reporting context.Canceled from Err after n calls,
+// to cancel a commit between its writes and the heap commit.
+type errAfter struct {
+	context.Context
+	n int
+}
+
+func (ctx *errAfter) Err() error {
+	if ctx.n--; ctx.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+// TestKVBatchContext tests that a cancelled batch is rolled back
+// and leaves the store unchanged.
+func TestKVBatchContext(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	kv.Set([]byte("a"), []byte("1"))
+	batch := func(yield func([]byte, []byte) bool) {
+		yield([]byte("a"), []byte("2"))
+		for i := range 2000 {
+			yield(fmt.Appendf(nil, "key-%04d", i), []byte("value"))
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := kv.BatchContext(ctx, batch); !errors.Is(err, context.Canceled) {
+		t.Fatalf("BatchContext with cancelled context: %v", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	if err := kv.BatchContext(&errAfter{ctx, 3}, batch); !errors.Is(err, context.Canceled) {
+		t.Fatalf("BatchContext cancelled after writing: %v", err)
+	}
+
+	check := func(want string, keys int) {
+		t.Helper()
+		if val, err := kv.Get([]byte("a")); err != nil || string(val) != want {
+			t.Fatalf("Get(a) = %q, %v, want %q", val, err, want)
+		}
+		iter := kv.Iter()
+		defer iter.Close()
+		n := 0
+		for iter.SeekFirst(); iter.Valid(); iter.Next() {
+			n++
+		}
+		if n != keys {
+			t.Fatalf("iterated %d keys, want %d", n, keys)
+		}
+	}
+	check("1", 1)
+
+	if err := kv.BatchContext(ctx, batch); err != nil {
+		t.Fatalf("BatchContext: %v", err)
+	}
+	check("2", 2001)
+
+	t.Log("✓ Cancelled batches rolled back")
+}
+
+// TestKVIterContext tests that an iterator stops once its context is cancelled.
+func TestKVIterContext(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	kv.Batch(func(yield func([]byte, []byte) bool) {
+		for i := range 100 {
+			yield(fmt.Appendf(nil, "key-%03d", i), []byte("value"))
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	iter := kv.IterContext(ctx)
+	defer iter.Close()
+	n := 0
+	for iter.SeekFirst(); iter.Valid(); iter.Next() {
+		if n++; n == 10 {
+			clone := iter.Clone()
+			cancel()
+			if clone.Next() {
+				t.Fatal("clone moved after cancel")
+			}
+			clone.Close()
+		}
+	}
+	if n != 10 {
+		t.Fatalf("iterated %d keys, want 10", n)
+	}
+	if err := iter.Error(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Error() = %v, want context.Canceled", err)
+	}
+
+	t.Log("✓ Iterator stopped by its context")
+}
+
+// TestKVSeekLE tests SeekLE on a KV iterator, hiding expired keys,
+// and on a transaction iterator over pending writes and deletes.
+func TestKVSeekLE(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	kv.Batch(func(yield func([]byte, []byte) bool) {
+		for i := range 1000 {
+			yield(fmt.Appendf(nil, "key-%04d", 2*i), []byte("value"))
+		}
+	})
+	if err := kv.SetExpiry([]byte("key-0100"), []byte("value"), time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("SetExpiry: %v", err)
+	}
+
+	check := func(iter iterator.SeekerLE, probe, want string) {
+		t.Helper()
+		ok := iter.SeekLE([]byte(probe))
+		if ok != (want != "") || string(iter.Key()) != want {
+			t.Fatalf("SeekLE(%q) = %v %q, want %q", probe, ok, iter.Key(), want)
+		}
+	}
+	iter := kv.Iter()
+	defer iter.Close()
+	check(iter, "key-0500", "key-0500")
+	check(iter, "key-0501", "key-0500")
+	check(iter, "key-0101", "key-0098")
+	check(iter, "key-", "")
+	check(iter, "zzz", "key-1998")
+	if !iter.SeekLE([]byte("key-0101")) || !iter.Next() || string(iter.Key()) != "key-0102" {
+		t.Fatalf("Next after SeekLE = %q", iter.Key())
+	}
+
+	tx := kv.Begin()
+	defer tx.Rollback()
+	tx.Set([]byte("key-0501"), []byte("new"))
+	tx.Set([]byte("key-0498"), nil)
+	tx.Set([]byte("key-0496"), nil)
+	txIter := tx.Iter()
+	defer txIter.Close()
+	check(txIter, "key-0501", "key-0501")
+	check(txIter, "key-0499", "key-0494")
+	check(txIter, "key-0101", "key-0098")
+	check(txIter, "zzz", "key-1998")
+
+	t.Log("✓ SeekLE lands on the last live key <= the probe")
+}
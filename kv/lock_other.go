@@ -0,0 +1,14 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package kv
+
+import (
+	"os"
+	"time"
+)
+
+// lockFile is a no-op on platforms without flock or LockFileEx, such as
+// Plan 9 and js/wasm: nothing keeps a second writer from opening the file.
+func lockFile(file *os.File, exclusive bool, timeout time.Duration) error {
+	return nil
+}
@@ -0,0 +1,96 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows
+
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestOpenLocked tests single-writer enforcement across handles.
+// A read-write handle excludes every other handle; read-only handles share.
+func TestOpenLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locked.kv")
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err = db.Set([]byte("key"), []byte("val")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	if _, err = Open(path); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second Open: err=%v, want ErrLocked", err)
+	}
+	if _, err = OpenFile(path, &Options{ReadOnly: true}); !errors.Is(err, ErrLocked) {
+		t.Fatalf("read-only Open while writing: err=%v, want ErrLocked", err)
+	}
+
+	if err = db.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	ro1, err := OpenFile(path, &Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("read-only Open: %v", err)
+	}
+	defer ro1.Close()
+	ro2, err := OpenFile(path, &Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("second read-only Open: %v", err)
+	}
+	defer ro2.Close()
+
+	if _, err = Open(path); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Open while reading: err=%v, want ErrLocked", err)
+	}
+
+	got, err := ro2.Get([]byte("key"))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(got, []byte("val")) {
+		t.Fatalf("Get = %q, want %q", got, "val")
+	}
+
+	if err = ro1.Set([]byte("key"), nil); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Set on read-only: err=%v, want ErrReadOnly", err)
+	}
+
+	t.Log("✓ Exclusive writer, shared readers")
+}
+
+// TestOpenLockTimeout tests waiting for a lock to be released.
+func TestOpenLockTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "timeout.kv")
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	start := time.Now()
+	if _, err = OpenFile(path, &Options{LockTimeout: 30 * time.Millisecond}); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Open with timeout: err=%v, want ErrLocked", err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatalf("Open returned after %v, want >= 30ms", elapsed)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		db.Close()
+	}()
+
+	db2, err := OpenFile(path, &Options{LockTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Open after release: %v", err)
+	}
+	db2.Close()
+
+	t.Log("✓ Lock acquired after holder closed")
+}
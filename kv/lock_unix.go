@@ -0,0 +1,43 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package kv
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// lockFile places an advisory flock on file, exclusive or shared.
+// Retries with backoff until timeout; see Options.LockTimeout.
+// The lock is released when file is closed.
+func lockFile(file *os.File, exclusive bool, timeout time.Duration) error {
+	how := syscall.LOCK_SH | syscall.LOCK_NB
+	if exclusive {
+		how = syscall.LOCK_EX | syscall.LOCK_NB
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	backoff := time.Millisecond
+	for {
+		err := syscall.Flock(int(file.Fd()), how)
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return &os.PathError{Op: "flock", Path: file.Name(), Err: err}
+		}
+		if timeout == 0 || (timeout > 0 && time.Now().After(deadline)) {
+			return ErrLocked
+		}
+		time.Sleep(backoff)
+		backoff = min(backoff*2, 100*time.Millisecond)
+	}
+}
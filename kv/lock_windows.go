@@ -0,0 +1,52 @@
+//go:build windows
+
+package kv
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	errorLockViolation syscall.Errno = 33 // ERROR_LOCK_VIOLATION
+)
+
+// lockFile places a LockFileEx lock on file, exclusive or shared.
+// Retries with backoff until timeout; see Options.LockTimeout.
+// The lock covers one byte past the largest file size, so it does not
+// block reads and writes of the file. It is released when file is closed.
+func lockFile(file *os.File, exclusive bool, timeout time.Duration) error {
+	flags := uintptr(lockfileFailImmediately)
+	if exclusive {
+		flags |= lockfileExclusiveLock
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	backoff := time.Millisecond
+	for {
+		overlapped := syscall.Overlapped{Offset: 0xFFFFFFFF, OffsetHigh: 0xFFFFFFFF}
+		ok, _, err := procLockFileEx.Call(file.Fd(), flags, 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+		if ok != 0 {
+			return nil
+		}
+		if !errors.Is(err, errorLockViolation) {
+			return &os.PathError{Op: "LockFileEx", Path: file.Name(), Err: err}
+		}
+		if timeout == 0 || (timeout > 0 && time.Now().After(deadline)) {
+			return ErrLocked
+		}
+		time.Sleep(backoff)
+		backoff = min(backoff*2, 100*time.Millisecond)
+	}
+}
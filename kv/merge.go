@@ -0,0 +1,254 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/dacapoday/smol/bptree"
+	"github.com/dacapoday/smol/btree"
+)
+
+// The read-modify-write operations below read the current value and write
+// the new one in a single commit, serialized with all other writers, so
+// no write can slip in between. Written keys keep their expiry time,
+// unless it has passed: a key read as expired is written without one.
+// Their callbacks run while writers are blocked: they must be quick and
+// must not write to the store.
+
+// CompareAndSwap sets key to new if its current value is old, reporting
+// whether it did. A nil old matches a key that does not exist or has
+// expired; a nil new deletes the key.
+func (kv *KV[F]) CompareAndSwap(key, old, new []byte) (swapped bool, err error) {
+	exp := &expiring{keep: true}
+	err = kv.commit(context.Background(), func(root, index bptree.Page) (func(func([]byte, []byte) bool), error) {
+		val, err := kv.getKept(exp, root, index, key)
+		if err != nil {
+			return nil, err
+		}
+		if (val == nil) != (old == nil) || !bytes.Equal(val, old) {
+			return nil, errUnchanged{}
+		}
+		swapped = true
+		return single(key, new), nil
+	}, exp)
+	swapped = swapped && err == nil
+	return
+}
+
+// Update sets key to the value returned by update, called with its
+// current value, nil if the key does not exist or has expired.
+// Returning nil deletes the key.
+func (kv *KV[F]) Update(key []byte, update func(old []byte) (new []byte)) error {
+	exp := &expiring{keep: true}
+	return kv.commit(context.Background(), func(root, index bptree.Page) (func(func([]byte, []byte) bool), error) {
+		val, err := kv.getKept(exp, root, index, key)
+		if err != nil {
+			return nil, err
+		}
+		return single(key, update(val)), nil
+	}, exp)
+}
+
+// MergeOperator combines the current value of a key with an operand.
+type MergeOperator interface {
+	// Merge returns the new value of key from its current value, nil if
+	// the key does not exist, and an operand. A nil result deletes the key.
+	// An error aborts the commit.
+	Merge(key, existing, operand []byte) ([]byte, error)
+}
+
+// MergeFunc adapts a function to a MergeOperator.
+type MergeFunc func(key, existing, operand []byte) ([]byte, error)
+
+// Merge calls f.
+func (f MergeFunc) Merge(key, existing, operand []byte) ([]byte, error) {
+	return f(key, existing, operand)
+}
+
+// AddInt64 adds operands to counters, both 8-byte big-endian int64.
+// A missing key counts as zero. Values of any other size are rejected.
+var AddInt64 MergeOperator = MergeFunc(func(key, existing, operand []byte) ([]byte, error) {
+	if len(operand) != 8 || existing != nil && len(existing) != 8 {
+		return nil, fmt.Errorf("kv.AddInt64: %w: %q is not an int64", ErrUnsupported, key)
+	}
+	var n uint64
+	if existing != nil {
+		n = binary.BigEndian.Uint64(existing)
+	}
+	return binary.BigEndian.AppendUint64(nil, n+binary.BigEndian.Uint64(operand)), nil
+})
+
+// Append appends operands to the current value.
+var Append MergeOperator = MergeFunc(func(key, existing, operand []byte) ([]byte, error) {
+	return append(append(make([]byte, 0, len(existing)+len(operand)), existing...), operand...), nil
+})
+
+// Merge merges operand into the value of key with op.
+func (kv *KV[F]) Merge(op MergeOperator, key, operand []byte) error {
+	return kv.MergeBatch(op, func(yield func([]byte, []byte) bool) { yield(key, operand) })
+}
+
+// MergeBatch atomically merges operands into the values of their keys
+// with op. Keys do not need to be sorted; the operands of a key are
+// merged in the order yielded.
+//
+// Warning: Caller must not modify yielded keys/operands until MergeBatch returns.
+func (kv *KV[F]) MergeBatch(op MergeOperator, operands func(yield func(key, operand []byte) bool)) error {
+	return kv.BatchMerge(op, func(yield func([]byte, []byte, bool) bool) {
+		for key, operand := range operands {
+			if !yield(key, operand, true) {
+				return
+			}
+		}
+	})
+}
+
+// BatchMerge is Batch, where changes yielded with merge set are operands
+// merged with op into the value of their key: the value it has when the
+// batch is committed, or was given earlier in the batch. Changes of a key
+// apply in the order yielded.
+//
+// Warning: Caller must not modify yielded keys/values until BatchMerge returns.
+func (kv *KV[F]) BatchMerge(op MergeOperator, changes func(yield func(key, val []byte, merge bool) bool)) (err error) {
+	var batch btree.BTree
+	var merges merging
+	changes(func(key, val []byte, merge bool) bool {
+		if !merge {
+			batch.Set(key, val)
+			delete(merges, string(key))
+			return true
+		}
+		if old, found := batch.Get(key); found {
+			if val, err = op.Merge(key, old, val); err != nil {
+				return false
+			}
+			batch.Set(key, val)
+			return true
+		}
+		merges = merges.add(key, op, val)
+		return true
+	})
+	if err != nil {
+		return
+	}
+	return kv.commitMerging(context.Background(), batch.Items, merges)
+}
+
+// mergeOperand is an operand to merge with its operator.
+type mergeOperand struct {
+	op  MergeOperator
+	val []byte
+}
+
+// merging holds the operands of keys to merge into the values the keys
+// have when a commit is applied, in the order given.
+type merging map[string][]mergeOperand
+
+func (merges merging) add(key []byte, op MergeOperator, operand []byte) merging {
+	if merges == nil {
+		merges = merging{}
+	}
+	merges[string(key)] = append(merges[string(key)], mergeOperand{op, operand})
+	return merges
+}
+
+// without yields the sorted changes of keys not merged.
+func (merges merging) without(sortedChanges func(func([]byte, []byte) bool)) func(func([]byte, []byte) bool) {
+	if len(merges) == 0 {
+		return sortedChanges
+	}
+	return func(yield func([]byte, []byte) bool) {
+		for key, val := range sortedChanges {
+			if _, merged := merges[string(key)]; !merged && !yield(key, val) {
+				return
+			}
+		}
+	}
+}
+
+// commitMerging commits sorted changes together with merges of other keys,
+// resolved in the commit. Merged keys keep their expiry, as with Merge.
+func (kv *KV[F]) commitMerging(ctx context.Context, sortedChanges func(func([]byte, []byte) bool), merges merging) error {
+	if len(merges) == 0 {
+		return kv.commitSortedChanges(ctx, sortedChanges)
+	}
+	keys := slices.Sorted(maps.Keys(merges))
+	exp := &expiring{merged: merges}
+	return kv.commit(ctx, func(root, index bptree.Page) (func(func([]byte, []byte) bool), error) {
+		exp.now = expiryTime(time.Now())
+		vals := make([][]byte, len(keys))
+		for i, key := range keys {
+			val, err := kv.getAt(root, index, []byte(key), exp.now)
+			if err != nil {
+				return nil, err
+			}
+			for _, operand := range merges[key] {
+				if val, err = operand.op.Merge([]byte(key), val, operand.val); err != nil {
+					return nil, err
+				}
+			}
+			vals[i] = val
+		}
+		return joinSorted(sortedChanges, keys, vals), nil
+	}, exp)
+}
+
+// joinSorted yields sorted changes and the changes of other keys, sorted.
+func joinSorted(sortedChanges func(func([]byte, []byte) bool), keys []string, vals [][]byte) func(func([]byte, []byte) bool) {
+	return func(yield func([]byte, []byte) bool) {
+		i := 0
+		for key, val := range sortedChanges {
+			for ; i < len(keys) && keys[i] < string(key); i++ {
+				if !yield([]byte(keys[i]), vals[i]) {
+					return
+				}
+			}
+			if !yield(key, val) {
+				return
+			}
+		}
+		for ; i < len(keys); i++ {
+			if !yield([]byte(keys[i]), vals[i]) {
+				return
+			}
+		}
+	}
+}
+
+// Add adds delta to the int64 counter at key, as AddInt64 does,
+// and returns the new count.
+func (kv *KV[F]) Add(key []byte, delta int64) (n int64, err error) {
+	operand := binary.BigEndian.AppendUint64(nil, uint64(delta))
+	exp := &expiring{keep: true}
+	err = kv.commit(context.Background(), func(root, index bptree.Page) (func(func([]byte, []byte) bool), error) {
+		val, err := kv.getKept(exp, root, index, key)
+		if err == nil {
+			val, err = AddInt64.Merge(key, val, operand)
+		}
+		if err != nil {
+			return nil, err
+		}
+		n = int64(binary.BigEndian.Uint64(val))
+		return single(key, val), nil
+	}, exp)
+	if err != nil {
+		n = 0
+	}
+	return
+}
+
+// getKept reads key for a commit keeping expiry times, fixing the time
+// both the read and the commit take as now.
+func (kv *KV[F]) getKept(exp *expiring, root, index bptree.Page, key []byte) ([]byte, error) {
+	exp.now = expiryTime(time.Now())
+	return kv.getAt(root, index, key, exp.now)
+}
+
+func single(key, val []byte) func(func([]byte, []byte) bool) {
+	return func(yield func([]byte, []byte) bool) { yield(key, val) }
+}
@@ -0,0 +1,315 @@
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dacapoday/smol/mem"
+)
+
+// TestCompareAndSwap tests that CompareAndSwap writes only over the expected value.
+func TestCompareAndSwap(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	key := []byte("leader")
+	steps := []struct {
+		old, new string
+		nilOld   bool
+		nilNew   bool
+		swapped  bool
+		want     string
+	}{
+		{nilOld: true, new: "a", swapped: true, want: "a"},
+		{nilOld: true, new: "b", swapped: false, want: "a"},
+		{old: "b", new: "c", swapped: false, want: "a"},
+		{old: "a", new: "", swapped: true, want: ""},
+		{old: "", nilNew: true, swapped: true},
+	}
+	for i, step := range steps {
+		old, new := []byte(step.old), []byte(step.new)
+		if step.nilOld {
+			old = nil
+		}
+		if step.nilNew {
+			new = nil
+		}
+		swapped, err := kv.CompareAndSwap(key, old, new)
+		if err != nil {
+			t.Fatalf("step %d: CompareAndSwap: %v", i, err)
+		}
+		if swapped != step.swapped {
+			t.Fatalf("step %d: swapped = %v, want %v", i, swapped, step.swapped)
+		}
+		val, _ := kv.Get(key)
+		if string(val) != step.want || (val == nil) != step.nilNew {
+			t.Fatalf("step %d: Get = %q, want %q", i, val, step.want)
+		}
+	}
+
+	t.Log("✓ CompareAndSwap writes only over the expected value")
+}
+
+// TestUpdateAdd tests that concurrent Update and Add calls are serialized.
+func TestUpdateAdd(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				if _, err := kv.Add([]byte("hits"), 2); err != nil {
+					t.Errorf("Add: %v", err)
+				}
+				err := kv.Update([]byte("log"), func(old []byte) []byte {
+					return append(old, 'x')
+				})
+				if err != nil {
+					t.Errorf("Update: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	n, err := kv.Add([]byte("hits"), -1)
+	if err != nil || n != 799 {
+		t.Fatalf("Add = %d, %v, want 799", n, err)
+	}
+	if val, _ := kv.Get([]byte("log")); len(val) != 400 {
+		t.Fatalf("log holds %d updates, want 400", len(val))
+	}
+
+	t.Log("✓ Concurrent read-modify-writes serialized")
+}
+
+// TestMergeBatch tests merge operators applied in one commit,
+// in yield order per key.
+func TestMergeBatch(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	kv.Set([]byte("b"), []byte("0"))
+	err := kv.MergeBatch(Append, func(yield func([]byte, []byte) bool) {
+		yield([]byte("b"), []byte("1"))
+		yield([]byte("a"), []byte("x"))
+		yield([]byte("b"), []byte("2"))
+	})
+	if err != nil {
+		t.Fatalf("MergeBatch: %v", err)
+	}
+	for key, want := range map[string]string{"a": "x", "b": "012"} {
+		if val, _ := kv.Get([]byte(key)); string(val) != want {
+			t.Fatalf("Get(%q) = %q, want %q", key, val, want)
+		}
+	}
+
+	// a rejected operand aborts the whole batch
+	err = kv.MergeBatch(AddInt64, func(yield func([]byte, []byte) bool) {
+		yield([]byte("c"), binary.BigEndian.AppendUint64(nil, 5))
+		yield([]byte("b"), binary.BigEndian.AppendUint64(nil, 5))
+	})
+	if !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("MergeBatch on a non-counter: %v, want ErrUnsupported", err)
+	}
+	if val, _ := kv.Get([]byte("c")); val != nil {
+		t.Fatalf("aborted batch wrote c = %q", val)
+	}
+
+	drop := MergeFunc(func(key, existing, operand []byte) ([]byte, error) { return nil, nil })
+	if err = kv.Merge(drop, []byte("a"), nil); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if val, _ := kv.Get([]byte("a")); val != nil {
+		t.Fatalf("nil merge result left a = %q", val)
+	}
+
+	t.Log("✓ Merge operators applied atomically")
+}
+
+// TestAddKeepsExpiry tests that read-modify-writes keep the key's expiry.
+func TestAddKeepsExpiry(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	expireAt := time.Now().Add(time.Minute)
+	kv.SetExpiry([]byte("rate:alice"), binary.BigEndian.AppendUint64(nil, 1), expireAt)
+	if n, err := kv.Add([]byte("rate:alice"), 1); err != nil || n != 2 {
+		t.Fatalf("Add = %d, %v", n, err)
+	}
+	at, ok, err := kv.Expiry([]byte("rate:alice"))
+	if err != nil || !ok || !at.Equal(time.Unix(0, expireAt.UnixNano())) {
+		t.Fatalf("Expiry = %v, %v, %v, want %v", at, ok, err, expireAt)
+	}
+
+	kv.Set([]byte("rate:alice"), []byte("reset"))
+	if _, ok, _ := kv.Expiry([]byte("rate:alice")); ok {
+		t.Fatal("Set kept the expiry")
+	}
+
+	t.Log("✓ Counters keep their expiry")
+}
+
+// TestReadModifyWriteExpired tests that read-modify-writes of a key that
+// has expired but is not reaped yet write a live value without expiry.
+func TestReadModifyWriteExpired(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	five := binary.BigEndian.AppendUint64(nil, 5)
+	writes := []struct {
+		name  string
+		write func(key []byte) error
+		want  []byte
+	}{
+		{"Add", func(key []byte) error {
+			n, err := kv.Add(key, 5)
+			if err == nil && n != 5 {
+				err = errors.New("not 5")
+			}
+			return err
+		}, five},
+		{"CompareAndSwap", func(key []byte) error {
+			swapped, err := kv.CompareAndSwap(key, nil, []byte("new"))
+			if err == nil && !swapped {
+				err = errors.New("not swapped")
+			}
+			return err
+		}, []byte("new")},
+		{"Update", func(key []byte) error {
+			return kv.Update(key, func(old []byte) []byte {
+				return append(old, "new"...)
+			})
+		}, []byte("new")},
+		{"Merge", func(key []byte) error {
+			return kv.Merge(Append, key, []byte("new"))
+		}, []byte("new")},
+	}
+	for _, w := range writes {
+		key := []byte("expired:" + w.name)
+		if err := kv.SetExpiry(key, []byte("old"), time.Now().Add(-time.Second)); err != nil {
+			t.Fatalf("SetExpiry: %v", err)
+		}
+		if err := w.write(key); err != nil {
+			t.Fatalf("%s: %v", w.name, err)
+		}
+		if val, err := kv.Get(key); err != nil || string(val) != string(w.want) {
+			t.Fatalf("%s: Get = %q, %v, want %q", w.name, val, err, w.want)
+		}
+		if _, ok, _ := kv.Expiry(key); ok {
+			t.Fatalf("%s: kept the passed expiry", w.name)
+		}
+	}
+	if n, err := kv.Reap(time.Now()); err != nil || n != 0 {
+		t.Fatalf("Reap = %d, %v, want 0", n, err)
+	}
+
+	t.Log("✓ Expired keys written live by read-modify-writes")
+}
+
+// TestBatchMerge tests that a batch mixes sets, deletes and merges,
+// merging into the value set earlier in the batch or committed.
+func TestBatchMerge(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	kv.Set([]byte("log"), []byte("a"))
+	kv.Set([]byte("old"), []byte("x"))
+	err := kv.BatchMerge(Append, func(yield func([]byte, []byte, bool) bool) {
+		_ = yield([]byte("log"), []byte("b"), true) &&
+			yield([]byte("new"), []byte("1"), false) &&
+			yield([]byte("new"), []byte("2"), true) &&
+			yield([]byte("old"), nil, false) &&
+			yield([]byte("log"), []byte("c"), true)
+	})
+	if err != nil {
+		t.Fatalf("BatchMerge: %v", err)
+	}
+	for key, want := range map[string]string{"log": "abc", "new": "12"} {
+		if val, _ := kv.Get([]byte(key)); string(val) != want {
+			t.Fatalf("Get(%q) = %q, want %q", key, val, want)
+		}
+	}
+	if val, _ := kv.Get([]byte("old")); val != nil {
+		t.Fatalf("Get(old) = %q, want deleted", val)
+	}
+
+	t.Log("✓ Sets, deletes and merges committed together")
+}
+
+// TestTxMerge tests that merges in a transaction are seen in its view
+// and merged into the value committed by then.
+func TestTxMerge(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	one := binary.BigEndian.AppendUint64(nil, 1)
+	kv.Add([]byte("hits"), 10)
+	tx := kv.Begin()
+	tx.Set([]byte("page"), []byte("home"))
+	if err := tx.Merge(AddInt64, []byte("hits"), one); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	sp := tx.Savepoint()
+	tx.Merge(AddInt64, []byte("hits"), one)
+	tx.Merge(Append, []byte("page"), []byte("/a"))
+	if val, _ := tx.Get([]byte("hits")); binary.BigEndian.Uint64(val) != 12 {
+		t.Fatalf("tx.Get(hits) = %d, want 12", binary.BigEndian.Uint64(val))
+	}
+	tx.RollbackTo(sp)
+	tx.Merge(Append, []byte("page"), []byte("/b"))
+
+	inner := BeginNested(tx)
+	inner.Merge(AddInt64, []byte("hits"), one)
+	if err := inner.Commit(); err != nil {
+		t.Fatalf("inner Commit: %v", err)
+	}
+
+	// a commit in between is merged into, not overwritten
+	kv.Add([]byte("hits"), 100)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if n, _ := kv.Add([]byte("hits"), 0); n != 112 {
+		t.Fatalf("hits = %d, want 112", n)
+	}
+	if val, _ := kv.Get([]byte("page")); string(val) != "home/b" {
+		t.Fatalf("Get(page) = %q, want \"home/b\"", val)
+	}
+
+	t.Log("✓ Transaction merges resolved at commit")
+}
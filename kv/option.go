@@ -0,0 +1,66 @@
+package kv
+
+import (
+	"time"
+
+	"github.com/dacapoday/smol/bptree"
+)
+
+// Options configures how a KV store is opened.
+// The zero value opens the file read-write with an exclusive lock,
+// failing immediately if another process holds a lock. Platforms other
+// than Unix and Windows take no lock, see OpenFile.
+type Options struct {
+	// ReadOnly opens the store without write access.
+	// OpenFile takes a shared lock instead of an exclusive one,
+	// and Set, Batch and Tx.Commit return ErrReadOnly.
+	ReadOnly bool
+
+	// LockTimeout bounds how long OpenFile waits for a conflicting lock.
+	// Zero fails immediately with ErrLocked; negative waits indefinitely.
+	LockTimeout time.Duration
+
+	// Follow opens the store read-only alongside a writer in another
+	// process, without taking a lock. The follower reads the snapshot
+	// current at open time until KV.Refresh moves it to a newer one.
+	// It registers in a reader table next to the file so the writer does
+	// not reuse blocks its snapshots still reference, so a follower that
+	// never refreshes keeps the file growing. Linux only.
+	Follow bool
+
+	// RetainChangesets is the number of recent changesets kept in memory
+	// for KV.SubscribeFrom to replay. Zero keeps none.
+	RetainChangesets int
+
+	// Fill sets how full commits pack the B+ tree pages they rewrite:
+	// fuller pages for append-only keys, spare room for update-heavy ones,
+	// and merging of pages left underfull by deletes.
+	Fill bptree.Fill
+
+	// ReapInterval starts a background reaper in a read-write store that
+	// deletes expired keys at this interval until Close, as KV.Reap does.
+	// Zero starts none.
+	ReapInterval time.Duration
+
+	// Observer receives block I/O, commit and iterator events for metrics
+	// and tracing. Nil observes nothing, at no cost.
+	Observer Observer
+
+	// Indexes declares secondary indexes, whose entries are updated in the
+	// same commit as the records they index and read with KV.IndexIter.
+	// Entries of an index are maintained only while it is declared;
+	// KV.Reindex rebuilds them.
+	Indexes []Index
+
+	// DisabledFeatures lists format features, such as FeaturePrefixLeaves,
+	// the caller does not support: stores requiring them are not opened,
+	// and commits do not take them into use, so the store stays readable
+	// by readers without them. A commit needing a disabled feature, such
+	// as one setting an expiry time without FeatureExpiryIndex, fails with
+	// ErrUnsupported. Zero supports every feature.
+	DisabledFeatures uint64
+}
+
+func (o *Options) blockOption() BlockOption {
+	return BlockOption{readOnly: o.ReadOnly || o.Follow, observer: o.Observer, disabled: o.DisabledFeatures}
+}
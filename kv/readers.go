@@ -0,0 +1,113 @@
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// readerSlotSize is the size of a reader table slot:
+// the checkpoint number followed by the owner pid, both little-endian u32.
+const readerSlotSize = 8
+
+// readers is the reader table of a database file, kept in a sidecar file
+// named by readersPath. Each follower owns one slot, locked for as long as
+// it is open, recording the oldest checkpoint it still reads. Before each
+// commit the writer holds back blocks recycled after that checkpoint.
+type readers struct {
+	path string
+	file *os.File
+	slot int64
+	ckp  uint32 // last published, 0 if none
+}
+
+func readersPath(path string) string {
+	return path + "-readers"
+}
+
+// joinReaders opens the reader table of path, creating it if needed,
+// and claims a free slot.
+func joinReaders(path string) (r *readers, err error) {
+	file, err := os.OpenFile(readersPath(path), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+
+	for slot := int64(0); ; slot++ {
+		var ok bool
+		if ok, err = lockSlot(file, slot); err != nil {
+			file.Close()
+			return
+		}
+		if ok {
+			r = &readers{path: path, file: file, slot: slot}
+			return
+		}
+	}
+}
+
+// publish records ckp as the oldest checkpoint read by this follower.
+func (r *readers) publish(ckp uint32) (err error) {
+	if ckp == r.ckp {
+		return
+	}
+
+	var buf [readerSlotSize]byte
+	binary.LittleEndian.PutUint32(buf[0:], ckp)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(os.Getpid()))
+	if _, err = r.file.WriteAt(buf[:], r.slot*readerSlotSize); err != nil {
+		return
+	}
+	r.ckp = ckp
+	return
+}
+
+// oldest returns the oldest checkpoint read by live followers.
+// ok is false if there is none. The table is opened on first use,
+// so a writer without followers never creates it.
+func (r *readers) oldest() (ckp uint32, ok bool, err error) {
+	if r.file == nil {
+		file, err := os.Open(readersPath(r.path))
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				err = nil
+			}
+			return 0, false, err
+		}
+		r.file = file
+	}
+
+	stat, err := r.file.Stat()
+	if err != nil {
+		return
+	}
+	table := make([]byte, stat.Size()/readerSlotSize*readerSlotSize)
+	if _, err = r.file.ReadAt(table, 0); err != nil {
+		return
+	}
+
+	for slot := int64(0); slot*readerSlotSize < int64(len(table)); slot++ {
+		c := binary.LittleEndian.Uint32(table[slot*readerSlotSize:])
+		if c == 0 {
+			continue
+		}
+
+		var locked bool
+		if locked, err = slotLocked(r.file, slot); err != nil {
+			return
+		}
+		if locked && (!ok || int32(c-ckp) < 0) {
+			ckp, ok = c, true
+		}
+	}
+	return
+}
+
+func (r *readers) close() (err error) {
+	if r.file != nil {
+		err = r.file.Close()
+		r.file = nil
+	}
+	return
+}
@@ -0,0 +1,52 @@
+package kv
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// Open file description locks belong to the open file rather than the
+// process, so a slot lock is released when its file is closed and is
+// visible to other files opened in the same process.
+const (
+	fOFDGetLock = 36 // F_OFD_GETLK
+	fOFDSetLock = 37 // F_OFD_SETLK
+)
+
+func slotLock(slot int64) syscall.Flock_t {
+	return syscall.Flock_t{
+		Type:   syscall.F_WRLCK,
+		Whence: io.SeekStart,
+		Start:  slot * readerSlotSize,
+		Len:    readerSlotSize,
+	}
+}
+
+// lockSlot tries to lock slot of the reader table without waiting.
+func lockSlot(file *os.File, slot int64) (ok bool, err error) {
+	lock := slotLock(slot)
+	for {
+		err = syscall.FcntlFlock(file.Fd(), fOFDSetLock, &lock)
+		if !errors.Is(err, syscall.EINTR) {
+			break
+		}
+	}
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EACCES) {
+		return false, nil
+	}
+	return false, &os.PathError{Op: "fcntl", Path: file.Name(), Err: err}
+}
+
+// slotLocked reports whether slot of the reader table is locked by a follower.
+func slotLocked(file *os.File, slot int64) (bool, error) {
+	lock := slotLock(slot)
+	if err := syscall.FcntlFlock(file.Fd(), fOFDGetLock, &lock); err != nil {
+		return false, &os.PathError{Op: "fcntl", Path: file.Name(), Err: err}
+	}
+	return lock.Type != syscall.F_UNLCK, nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package kv
+
+import "os"
+
+// Following a writer relies on open file description locks, which are
+// only available on Linux.
+
+func lockSlot(file *os.File, slot int64) (bool, error) {
+	return false, ErrUnsupported
+}
+
+func slotLocked(file *os.File, slot int64) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,120 @@
+//go:build linux
+
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// TestFollow tests a follower reading alongside a writer.
+// The follower sees new commits only after Refresh.
+func TestFollow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "follow.kv")
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	if err = db.Set([]byte("k1"), []byte("v1")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	follower, err := OpenFile(path, &Options{Follow: true})
+	if err != nil {
+		t.Fatalf("Open follower: %v", err)
+	}
+	defer follower.Close()
+
+	if got, _ := follower.Get([]byte("k1")); !bytes.Equal(got, []byte("v1")) {
+		t.Fatalf("Get k1 = %q, want v1", got)
+	}
+
+	if err = db.Set([]byte("k2"), []byte("v2")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if got, _ := follower.Get([]byte("k2")); got != nil {
+		t.Fatalf("Get k2 before Refresh = %q, want nil", got)
+	}
+
+	changed, err := follower.Refresh()
+	if err != nil || !changed {
+		t.Fatalf("Refresh: changed=%v err=%v", changed, err)
+	}
+	if got, _ := follower.Get([]byte("k2")); !bytes.Equal(got, []byte("v2")) {
+		t.Fatalf("Get k2 after Refresh = %q, want v2", got)
+	}
+
+	if changed, err = follower.Refresh(); err != nil || changed {
+		t.Fatalf("second Refresh: changed=%v err=%v", changed, err)
+	}
+	if err = follower.Set([]byte("k3"), nil); err != ErrReadOnly {
+		t.Fatalf("Set on follower: err=%v, want ErrReadOnly", err)
+	}
+
+	t.Log("✓ Follower observes commits on Refresh")
+}
+
+// TestFollowSnapshot tests that the writer keeps blocks of a follower snapshot.
+// Rewrites every key many times while a follower iterator reads the old values.
+func TestFollowSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.kv")
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+
+	const n = 2000
+	write := func(round int) {
+		err := db.Batch(func(yield func([]byte, []byte) bool) {
+			for i := range n {
+				yield(fmt.Appendf(nil, "key%05d", i), fmt.Appendf(nil, "val%05d-%d", i, round))
+			}
+		})
+		if err != nil {
+			t.Fatalf("Batch: %v", err)
+		}
+	}
+	write(0)
+
+	follower, err := OpenFile(path, &Options{Follow: true})
+	if err != nil {
+		t.Fatalf("Open follower: %v", err)
+	}
+	defer follower.Close()
+
+	iter := follower.Iter()
+	defer iter.Close()
+
+	for round := 1; round <= 10; round++ {
+		write(round)
+	}
+
+	i := 0
+	for iter.SeekFirst(); iter.Valid(); iter.Next() {
+		if want := fmt.Appendf(nil, "val%05d-0", i); !bytes.Equal(iter.Val(), want) {
+			t.Fatalf("Val(%s) = %q, want %q", iter.Key(), iter.Val(), want)
+		}
+		i++
+	}
+	if err = iter.Error(); err != nil {
+		t.Fatalf("Iter: %v", err)
+	}
+	if i != n {
+		t.Fatalf("iterated %d keys, want %d", i, n)
+	}
+
+	if _, err = follower.Refresh(); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if got, _ := follower.Get([]byte("key00000")); !bytes.Equal(got, []byte("val00000-10")) {
+		t.Fatalf("Get after Refresh = %q", got)
+	}
+
+	t.Log("✓ Follower snapshot intact across rewrites")
+}
@@ -0,0 +1,61 @@
+package kv
+
+import (
+	"bytes"
+
+	"github.com/dacapoday/smol/bptree"
+)
+
+// Split returns up to n-1 ascending keys dividing the snapshot of the
+// iterator into at most n ranges of about equal size, for scanning them
+// in parallel with Partitions. The keys are derived from the separators
+// of branch pages near the root, in few block reads; a small snapshot
+// yields fewer keys. Keys outside the bounds of the iterator are dropped.
+func (iter Iter[F]) Split(n int) (keys [][]byte, err error) {
+	if iter.ator.ckpt == nil {
+		return nil, ErrClosed
+	}
+	reader := &iter.ator.Reader
+	splits, err := bptree.Split(reader.Block(), reader.Root(), reader.KeyInlineSize(), n)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range splits {
+		if (iter.ator.start == nil || bytes.Compare(key, iter.ator.start) > 0) &&
+			(iter.ator.end == nil || bytes.Compare(key, iter.ator.end) < 0) {
+			keys = append(keys, key)
+		}
+	}
+	return
+}
+
+// Partitions returns an iterator per range between consecutive ascending
+// split keys within the bounds of the iterator, the first range from its
+// start and the last to its end, such as the keys returned by Split.
+// Each is bounded to its range and reads the snapshot of the iterator,
+// sharing its checkpoint: partitions are independent of each other and
+// of the iterator, and can be used by separate goroutines.
+//
+// Important: Caller must call Close on each partition.
+func (iter Iter[F]) Partitions(splits [][]byte) []Iter[F] {
+	parts := make([]Iter[F], 0, len(splits)+1)
+	start := iter.ator.start
+	for i := 0; i <= len(splits); i++ {
+		end := iter.ator.end
+		if i < len(splits) {
+			end = splits[i]
+		}
+		parts = append(parts, iter.bounded(start, end))
+		start = end
+	}
+	return parts
+}
+
+// bounded returns a clone of the iterator bounded to [start, end), left
+// unpositioned if its position is outside them.
+func (iter Iter[F]) bounded(start, end []byte) Iter[F] {
+	part := iter.Clone()
+	part.ator.start, part.ator.end = start, end
+	part.within(part.ator.Reader.Valid())
+	return part
+}
@@ -0,0 +1,95 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/dacapoday/smol/mem"
+)
+
+// TestPartitions tests that partitions of a snapshot, scanned by separate
+// goroutines, cover its keys once each in balanced ranges, and stay within
+// their bounds.
+func TestPartitions(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	const total = 20000
+	kv.Batch(func(yield func([]byte, []byte) bool) {
+		for i := range total {
+			yield(fmt.Appendf(nil, "key-%05d", i), []byte("value"))
+		}
+	})
+
+	iter := kv.Iter()
+	defer iter.Close()
+	// writes after the snapshot are not seen by its partitions
+	kv.Set([]byte("key-00000x"), []byte("new"))
+
+	splits, err := iter.Split(4)
+	if err != nil || len(splits) != 3 || !slices.IsSortedFunc(splits, bytes.Compare) {
+		t.Fatalf("Split(4) = %q, %v", splits, err)
+	}
+	parts := iter.Partitions(splits)
+	keys := make([][]string, len(parts))
+	var wg sync.WaitGroup
+	for i, part := range parts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer part.Close()
+			for ok := part.SeekFirst(); ok; ok = part.Next() {
+				keys[i] = append(keys[i], string(part.Key()))
+			}
+		}()
+	}
+	wg.Wait()
+
+	all := slices.Concat(keys...)
+	if len(all) != total || !slices.IsSorted(all) || slices.Contains(all, "key-00000x") {
+		t.Fatalf("partitions hold %d keys, want %d in order", len(all), total)
+	}
+	for i, part := range keys {
+		if len(part) < total/len(parts)/2 {
+			t.Fatalf("partition %d holds %d keys", i, len(part))
+		}
+	}
+
+	// a partition is bounded in both directions
+	parts = iter.Partitions([][]byte{[]byte("key-00100"), []byte("key-00200")})
+	part := parts[1]
+	for _, p := range parts {
+		defer p.Close()
+	}
+	if !part.SeekLast() || string(part.Key()) != "key-00199" || part.Next() || part.Valid() || part.Key() != nil {
+		t.Fatalf("SeekLast = %q", part.Key())
+	}
+	if !part.Seek([]byte("key-00050")) || string(part.Key()) != "key-00100" || part.Prev() {
+		t.Fatalf("Seek before start = %q", part.Key())
+	}
+	if !part.SeekLE([]byte("key-00500")) || string(part.Key()) != "key-00199" {
+		t.Fatalf("SeekLE after end = %q", part.Key())
+	}
+	if part.Seek([]byte("key-00300")) || part.Error() != nil {
+		t.Fatalf("Seek after end = %q, %v", part.Key(), part.Error())
+	}
+	n := 0
+	for ok := parts[0].SeekLast(); ok; ok = parts[0].Prev() {
+		n++
+	}
+	if n != 100 {
+		t.Fatalf("first partition holds %d keys backward", n)
+	}
+	if splits, _ := parts[2].Split(4); len(splits) == 0 || bytes.Compare(splits[0], []byte("key-00200")) <= 0 {
+		t.Fatalf("Split of a partition = %q", splits)
+	}
+
+	t.Logf("✓ %d partitions scanned in parallel", len(keys))
+}
@@ -0,0 +1,159 @@
+package kv
+
+import (
+	"fmt"
+
+	"github.com/dacapoday/smol/iterator"
+)
+
+// Table is a typed view of the keys of a store starting with Prefix,
+// encoded by the Key codec after the prefix, and of their values, encoded
+// by the Val codec. Tables with distinct prefixes, none a prefix of
+// another, share a store. Keys iterate in the order of K.
+//
+// Methods take the store to work on: a KV, or Tx.Store to work in the
+// transaction. Table has no state of its own and is safe to copy.
+type Table[K, V any] struct {
+	Prefix []byte
+	Key    KeyCodec[K]
+	Val    ValueCodec[V]
+}
+
+// Store is a store a Table reads and writes: a KV, or a Tx through
+// Tx.Store. Set with a nil val deletes key.
+type Store interface {
+	Get(key []byte) ([]byte, error)
+	Set(key, val []byte) error
+}
+
+// EncodeKey returns the key of the store for key.
+func (table Table[K, V]) EncodeKey(key K) []byte {
+	buf := make([]byte, 0, len(table.Prefix)+16)
+	return table.Key.AppendKey(append(buf, table.Prefix...), key)
+}
+
+// Encode returns the key and value of the store for key and val,
+// as yielded to KV.Batch.
+func (table Table[K, V]) Encode(key K, val V) (k, v []byte, err error) {
+	if v, err = table.Val.Marshal(val); err != nil {
+		return
+	}
+	if v == nil {
+		v = []byte{}
+	}
+	return table.EncodeKey(key), v, nil
+}
+
+// Get returns the value of key, reporting whether it exists.
+func (table Table[K, V]) Get(store Store, key K) (val V, ok bool, err error) {
+	data, err := store.Get(table.EncodeKey(key))
+	if err != nil || data == nil {
+		return
+	}
+	if val, err = table.Val.Unmarshal(data); err != nil {
+		err = &KeyError{Op: "kv.Table.Get", Key: table.EncodeKey(key), Err: err}
+		return
+	}
+	return val, true, nil
+}
+
+// Set sets key to val.
+func (table Table[K, V]) Set(store Store, key K, val V) error {
+	k, v, err := table.Encode(key, val)
+	if err != nil {
+		return fmt.Errorf("kv.Table.Set: %w", err)
+	}
+	return store.Set(k, v)
+}
+
+// Delete deletes key.
+func (table Table[K, V]) Delete(store Store, key K) error {
+	return store.Set(table.EncodeKey(key), nil)
+}
+
+// Iter returns a typed iterator over the keys of the table in iter,
+// an iterator of a KV or a Tx, which the caller still closes.
+func (table Table[K, V]) Iter(iter iterator.Iterator) *TableIter[K, V] {
+	view := &TableIter[K, V]{table: table}
+	view.view.Load(iter, table.Prefix, true)
+	return view
+}
+
+// TableIter is a typed iterator over the keys of a Table.
+// Keys or values that fail to decode stop it, with the error kept
+// in Error.
+type TableIter[K, V any] struct {
+	table Table[K, V]
+	view  iterator.Prefix[iterator.Iterator]
+	err   error
+}
+
+// Valid returns true if positioned at a valid item.
+func (iter *TableIter[K, V]) Valid() bool {
+	return iter.err == nil && iter.view.Valid()
+}
+
+// Error returns any error encountered during iteration or decoding.
+func (iter *TableIter[K, V]) Error() error {
+	if iter.err != nil {
+		return iter.err
+	}
+	return iter.view.Error()
+}
+
+// Key returns the current key, or the zero K if invalid.
+func (iter *TableIter[K, V]) Key() (key K) {
+	if !iter.Valid() {
+		return
+	}
+	key, rest, err := iter.table.Key.DecodeKey(iter.view.Key())
+	if err == nil && len(rest) != 0 {
+		err = fmt.Errorf("%w key: %d trailing bytes", ErrBadEncoding, len(rest))
+	}
+	if err != nil {
+		iter.err = &KeyError{Op: "kv.TableIter.Key", Key: iter.view.Inner().Key(), Err: err}
+	}
+	return
+}
+
+// Val returns the current value, or the zero V if invalid.
+func (iter *TableIter[K, V]) Val() (val V) {
+	if !iter.Valid() {
+		return
+	}
+	val, err := iter.table.Val.Unmarshal(iter.view.Val())
+	if err != nil {
+		iter.err = &KeyError{Op: "kv.TableIter.Val", Key: iter.view.Inner().Key(), Err: err}
+	}
+	return
+}
+
+// Next advances to the next item.
+func (iter *TableIter[K, V]) Next() bool {
+	return iter.err == nil && iter.view.Next()
+}
+
+// Prev moves to the previous item.
+func (iter *TableIter[K, V]) Prev() bool {
+	return iter.err == nil && iter.view.Prev()
+}
+
+// SeekFirst positions at the first key.
+func (iter *TableIter[K, V]) SeekFirst() bool {
+	return iter.err == nil && iter.view.SeekFirst()
+}
+
+// SeekLast positions at the last key.
+func (iter *TableIter[K, V]) SeekLast() bool {
+	return iter.err == nil && iter.view.SeekLast()
+}
+
+// Seek positions at the first key >= the given key.
+func (iter *TableIter[K, V]) Seek(key K) bool {
+	return iter.err == nil && iter.view.Seek(iter.table.Key.AppendKey(nil, key))
+}
+
+// SeekLE positions at the last key <= the given key.
+func (iter *TableIter[K, V]) SeekLE(key K) bool {
+	return iter.err == nil && iter.view.SeekLE(iter.table.Key.AppendKey(nil, key))
+}
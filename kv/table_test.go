@@ -0,0 +1,208 @@
+package kv
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dacapoday/smol/mem"
+	"github.com/dacapoday/smol/tuple"
+)
+
+// checkKeyOrder checks that keys, sorted by compare, encode in the same
+// order and decode back from their encodings.
+func checkKeyOrder[K any](t *testing.T, codec KeyCodec[K], keys []K, compare func(a, b K) int, equal func(a, b K) bool) {
+	t.Helper()
+	slices.SortFunc(keys, compare)
+	var prev []byte
+	for i, key := range keys {
+		enc := codec.AppendKey([]byte("x"), key)[1:]
+		if i > 0 && bytes.Compare(prev, enc) > 0 {
+			t.Fatalf("%v encodes before %v", key, keys[i-1])
+		}
+		got, rest, err := codec.DecodeKey(append(enc, "tail"...))
+		if err != nil || string(rest) != "tail" || !equal(got, key) {
+			t.Fatalf("DecodeKey(%v) = %v, %q, %v", key, got, rest, err)
+		}
+		prev = enc
+	}
+}
+
+// TestKeyCodecs tests that key codecs preserve order and round-trip.
+func TestKeyCodecs(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+
+	ints := []int64{math.MinInt64, -1, 0, 1, math.MaxInt64}
+	uints := []uint64{0, 1, 255, 256, math.MaxUint64}
+	for range 200 {
+		ints = append(ints, int64(r.Uint64()))
+		uints = append(uints, r.Uint64()>>r.IntN(64))
+	}
+	checkKeyOrder(t, Int64Key, ints, cmp.Compare, func(a, b int64) bool { return a == b })
+	checkKeyOrder(t, Uint64Key, uints, cmp.Compare, func(a, b uint64) bool { return a == b })
+
+	strs := []string{"", "\x00", "\x00\x00", "\x00\x01", "\x00\xff", "a", "a\x00", "a\x00b", "ab", "\xff"}
+	for range 200 {
+		b := make([]byte, r.IntN(6))
+		for i := range b {
+			b[i] = []byte{0, 1, 'a', 0xff}[r.IntN(4)]
+		}
+		strs = append(strs, string(b))
+	}
+	checkKeyOrder(t, StringKey, strs, func(a, b string) int { return bytes.Compare([]byte(a), []byte(b)) }, func(a, b string) bool { return a == b })
+
+	times := []time.Time{time.Unix(0, 0), time.Unix(-1, 0), time.Date(2026, 10, 18, 12, 0, 0, 1, time.UTC)}
+	for range 100 {
+		times = append(times, time.Unix(0, int64(r.Uint64())))
+	}
+	checkKeyOrder(t, TimeKey, times, func(a, b time.Time) int { return a.Compare(b) }, time.Time.Equal)
+
+	// a string part is delimited, so pairs order by first, then second
+	pairs := PairKey(StringKey, Int64Key)
+	var keys []Pair[string, int64]
+	for _, s := range strs[:10] {
+		for _, n := range ints[:5] {
+			keys = append(keys, Pair[string, int64]{s, n})
+		}
+	}
+	checkKeyOrder(t, pairs, keys, func(a, b Pair[string, int64]) int {
+		if c := bytes.Compare([]byte(a.First), []byte(b.First)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Second, b.Second)
+	}, func(a, b Pair[string, int64]) bool { return a == b })
+
+	// keys encode as the tuples of their parts
+	at := time.Unix(5, 0)
+	nested := PairKey(PairKey(BytesKey, Uint64Key), TimeKey)
+	key := nested.AppendKey(nil, Pair[Pair[[]byte, uint64], time.Time]{Pair[[]byte, uint64]{[]byte("b"), 7}, at})
+	if want := (tuple.Tuple{[]byte("b"), uint64(7), at.UnixNano()}).Pack(); !bytes.Equal(key, want) {
+		t.Fatalf("pair key %x, want tuple %x", key, want)
+	}
+	if _, _, err := Int64Key.DecodeKey(tuple.Tuple{"7"}.Pack()); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("string as int64: %v", err)
+	}
+
+	if _, _, err := Uint64Key.DecodeKey([]byte{1, 2}); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("short key: %v", err)
+	}
+	if _, _, err := StringKey.DecodeKey([]byte("ab")); !errors.Is(err, ErrBadEncoding) {
+		t.Fatalf("unterminated key: %v", err)
+	}
+
+	t.Log("✓ Key codecs preserve order and round-trip")
+}
+
+type account struct {
+	Owner   string
+	Balance int64
+}
+
+// TestValueCodecs tests that value codecs round-trip.
+func TestValueCodecs(t *testing.T) {
+	want := account{Owner: "ann", Balance: -42}
+	for name, codec := range map[string]ValueCodec[account]{"json": JSON[account](), "gob": Gob[account]()} {
+		data, err := codec.Marshal(want)
+		if err != nil {
+			t.Fatalf("%s Marshal: %v", name, err)
+		}
+		if got, err := codec.Unmarshal(data); err != nil || got != want {
+			t.Fatalf("%s Unmarshal = %+v, %v", name, got, err)
+		}
+	}
+
+	type point struct{ X, Y int32 }
+	bin := Binary[point]()
+	data, err := bin.Marshal(point{1, -2})
+	if err != nil || len(data) != 8 {
+		t.Fatalf("binary Marshal = %x, %v", data, err)
+	}
+	if got, err := bin.Unmarshal(data); err != nil || got != (point{1, -2}) {
+		t.Fatalf("binary Unmarshal = %+v, %v", got, err)
+	}
+	if _, err := bin.Unmarshal(append(data, 0)); err == nil {
+		t.Fatal("expected error for trailing bytes")
+	}
+
+	t.Log("✓ JSON, gob and binary values round-trip")
+}
+
+// TestTable tests typed access to a KV and a Tx, and typed iteration in
+// key order within the table's prefix.
+func TestTable(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	accounts := Table[int64, account]{Prefix: []byte("acct/"), Key: Int64Key, Val: JSON[account]()}
+	names := Table[string, string]{Prefix: []byte("name/"), Key: StringKey, Val: StringValue}
+
+	for _, id := range []int64{3, -7, 100, 0} {
+		if err := accounts.Set(&kv, id, account{Owner: "o", Balance: id * 10}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	names.Set(&kv, "zed", "last")
+
+	got, ok, err := accounts.Get(&kv, -7)
+	if err != nil || !ok || got.Balance != -70 {
+		t.Fatalf("Get(-7) = %+v, %v, %v", got, ok, err)
+	}
+	if _, ok, err = accounts.Get(&kv, 5); ok || err != nil {
+		t.Fatalf("Get(5) = %v, %v", ok, err)
+	}
+
+	// writes in a transaction are seen by its reads and iterators
+	tx := kv.Begin()
+	accounts.Set(tx.Store(), 50, account{Owner: "tx"})
+	accounts.Delete(tx.Store(), 3)
+	if got, ok, _ := accounts.Get(tx.Store(), 50); !ok || got.Owner != "tx" {
+		t.Fatalf("Get(50) in tx = %+v, %v", got, ok)
+	}
+	txIter := tx.Iter()
+	iter := accounts.Iter(txIter)
+	var ids []int64
+	for iter.SeekFirst(); iter.Valid(); iter.Next() {
+		ids = append(ids, iter.Key())
+	}
+	txIter.Close()
+	if err := iter.Error(); err != nil || !slices.Equal(ids, []int64{-7, 0, 50, 100}) {
+		t.Fatalf("ids in tx = %v, %v", ids, err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	kvIter := kv.Iter()
+	defer kvIter.Close()
+	iter = accounts.Iter(kvIter)
+	if !iter.Seek(1) || iter.Key() != 50 || iter.Val().Owner != "tx" {
+		t.Fatalf("Seek(1) = %v", iter.Key())
+	}
+	if !iter.SeekLast() || iter.Key() != 100 || iter.Next() {
+		t.Fatalf("SeekLast = %v", iter.Key())
+	}
+
+	// a key that does not decode stops the iterator with an error
+	kv.Set([]byte("acct/bad"), []byte("{}"))
+	badIter := kv.Iter()
+	defer badIter.Close()
+	iter = accounts.Iter(badIter)
+	for iter.SeekFirst(); iter.Valid(); iter.Next() {
+		iter.Key()
+	}
+	var keyErr *KeyError
+	if !errors.As(iter.Error(), &keyErr) || string(keyErr.Key) != "acct/bad" || !errors.Is(iter.Error(), ErrBadEncoding) {
+		t.Fatalf("Error = %v", iter.Error())
+	}
+
+	t.Log("✓ Typed Get, Set, Delete and iteration over KV and Tx")
+}
@@ -0,0 +1,365 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"slices"
+	"time"
+
+	"github.com/dacapoday/smol/block"
+	"github.com/dacapoday/smol/bptree"
+	"github.com/dacapoday/smol/btree"
+)
+
+// The kv entry is the root page of the kv tree, followed by the root page
+// of the expiry index when any key has an expiry time. The index is a
+// second B+ tree written in the same commit, mapping
+//
+//	'k' + key            -> expiry
+//	'e' + expiry + key   -> 0x00
+//
+// so a key's expiry is found by key, and expired keys in expiry order.
+// Expiry is a big-endian u64 of unix nanoseconds with the sign bit flipped.
+// The index also holds the entries of secondary indexes, see Index.
+
+const (
+	expiryByKey  = 'k'
+	expiryByTime = 'e'
+)
+
+// splitEntry splits a kv entry into the root page and the expiry index root.
+func splitEntry(entry bptree.Page) (root, index bptree.Page) {
+	size := entry.Size()
+	if size >= len(entry) {
+		return entry, nil
+	}
+	return entry[:size], entry[size:]
+}
+
+// joinEntry builds a kv entry from the root page and the expiry index root.
+// While the two do not fit in a page, the larger is pushed down a level.
+func (kv *KV[F]) joinEntry(root, index bptree.Page) (entry bptree.Page, err error) {
+	if len(index) == 0 {
+		return root, nil
+	}
+	for len(root)+len(index) > kv.block.PageSize() {
+		if len(root) >= len(index) {
+			root, err = bptree.Push(&kv.block, root, kv.klen)
+		} else {
+			index, err = bptree.Push(&kv.block, index, kv.klen)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return slices.Concat(root, index), nil
+}
+
+func expiryTime(t time.Time) uint64 {
+	return uint64(t.UnixNano()) ^ 1<<63
+}
+
+func expiryKey(at uint64, key []byte) []byte {
+	buf := make([]byte, 0, 9+len(key))
+	buf = append(buf, expiryByTime)
+	buf = binary.BigEndian.AppendUint64(buf, at)
+	return append(buf, key...)
+}
+
+// expiring carries the expiry side of a commit: expiry times of the keys
+// set with one, whether written keys, or the merged ones, keep their
+// expiry unless it is due by now, the time to reap keys expired by, and
+// a secondary index to rebuild.
+type expiring struct {
+	at      map[string]uint64
+	keep    bool
+	merged  merging
+	now     uint64
+	reap    uint64
+	reaped  int
+	rebuild *Index
+}
+
+func (exp *expiring) kept(key []byte) bool {
+	_, merged := exp.merged[string(key)]
+	return exp.keep || merged
+}
+
+// SetExpiry inserts or updates a key-value pair that expires at expireAt.
+// Expired keys are hidden from Get and iterators, and deleted by Reap.
+// Pass nil value to delete a key.
+//
+// Set, Batch and Tx.Commit clear the expiry of the keys they write.
+func (kv *KV[F]) SetExpiry(key, val []byte, expireAt time.Time) error {
+	exp := &expiring{at: map[string]uint64{string(key): expiryTime(expireAt)}}
+	return kv.commit(context.Background(), fixed(single(key, val)), exp)
+}
+
+// SetTTL inserts or updates a key-value pair that expires after ttl.
+func (kv *KV[F]) SetTTL(key, val []byte, ttl time.Duration) error {
+	return kv.SetExpiry(key, val, time.Now().Add(ttl))
+}
+
+// Expiry returns the expiry time of a key, and false if the key does not
+// exist, has expired, or has no expiry.
+func (kv *KV[F]) Expiry(key []byte) (expireAt time.Time, ok bool, err error) {
+	entry, ckpt := kv.atom.Acquire()
+	if ckpt == nil {
+		err = ErrClosed
+		return
+	}
+	defer ckpt.Release()
+
+	root, index := splitEntry(entry)
+	at, ok, err := kv.expiresAt(index, key)
+	if err != nil || !ok || at <= expiryTime(time.Now()) {
+		ok = false
+		return
+	}
+	val, err := bptree.Get(&kv.block, root, kv.klen, kv.vlen, 0, nil, key)
+	if err != nil || val == nil {
+		ok = false
+		return
+	}
+	expireAt = time.Unix(0, int64(at^1<<63))
+	return
+}
+
+// Reap deletes the keys expired at now, in one commit, and returns how
+// many were deleted. Expired keys are found through the expiry index,
+// without scanning the store. Deletes are delivered to subscribers.
+func (kv *KV[F]) Reap(now time.Time) (n int, err error) {
+	return kv.ReapContext(context.Background(), now)
+}
+
+// ReapContext is Reap, deleting nothing and returning the context's
+// error once ctx is done.
+func (kv *KV[F]) ReapContext(ctx context.Context, now time.Time) (n int, err error) {
+	exp := &expiring{reap: expiryTime(now)}
+	err = kv.commit(ctx, func(_, index bptree.Page) (sortedChanges func(func([]byte, []byte) bool), err error) {
+		if sortedChanges, err = kv.expired(index, exp); err == nil && exp.reaped == 0 {
+			err = errUnchanged{}
+		}
+		return
+	}, exp)
+	if err == nil {
+		n = exp.reaped
+	}
+	return
+}
+
+// startReaper reaps expired keys every interval until Close, which
+// also aborts a reap in progress. Errors are dropped; the next tick retries.
+func (kv *KV[F]) startReaper(interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	kv.reaper = func() {
+		cancel()
+		<-done
+	}
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				kv.ReapContext(ctx, now)
+			}
+		}
+	}()
+}
+
+// expiresAt looks up the expiry of a key in the expiry index.
+func (kv *KV[F]) expiresAt(index bptree.Page, key []byte) (at uint64, ok bool, err error) {
+	if len(index) == 0 {
+		return
+	}
+	val, err := bptree.Get(&kv.block, index, kv.klen, kv.vlen, 0, nil, append([]byte{expiryByKey}, key...))
+	if err != nil || len(val) != 8 {
+		return
+	}
+	return binary.BigEndian.Uint64(val), true, nil
+}
+
+// expired returns deletes of the keys in the expiry index that expired by exp.reap.
+func (kv *KV[F]) expired(index bptree.Page, exp *expiring) (sortedChanges func(func([]byte, []byte) bool), err error) {
+	var deletes btree.BTree
+	var reader bptree.Reader[*block.Heap[F]]
+	reader.Load(&kv.block, index, kv.klen, kv.vlen, 0)
+	defer reader.Close()
+	for ok := reader.Seek([]byte{expiryByTime}); ok; ok = reader.Next() {
+		key := reader.Key()
+		if len(key) < 9 || key[0] != expiryByTime || binary.BigEndian.Uint64(key[1:]) > exp.reap {
+			break
+		}
+		deletes.Set(bytes.Clone(key[9:]), nil)
+		exp.reaped++
+	}
+	if err = reader.Error(); err != nil {
+		exp.reaped = 0
+	}
+	return deletes.Items, err
+}
+
+// expiryTracker collects the expiry index changes for the changes it
+// passes through: the old expiry of each key written is dropped unless
+// kept and not yet due, and keys set with an expiry time get a new one.
+// The secondary index entries of the old value of each key, read from
+// root, are replaced by those of the new one.
+type expiryTracker[F File] struct {
+	kv      *KV[F]
+	root    bptree.Page
+	index   bptree.Page
+	exp     *expiring
+	changes btree.BTree
+	buf     []byte
+	err     error
+}
+
+func (tracker *expiryTracker[F]) track(sortedChanges func(func([]byte, []byte) bool)) func(func([]byte, []byte) bool) {
+	return func(yield func([]byte, []byte) bool) {
+		for key, val := range sortedChanges {
+			if tracker.err = tracker.change(key, val); tracker.err != nil {
+				return
+			}
+			if !yield(key, val) {
+				return
+			}
+		}
+	}
+}
+
+func (tracker *expiryTracker[F]) change(key, val []byte) (err error) {
+	kv := tracker.kv
+	if len(kv.indexes) != 0 {
+		var old []byte
+		if old, err = bptree.Get(&kv.block, tracker.root, kv.klen, kv.vlen, 0, nil, key); err != nil {
+			return
+		}
+		tracker.reindex(key, old, val)
+	}
+	byKey := append([]byte{expiryByKey}, key...)
+	if len(tracker.index) != 0 {
+		tracker.buf, err = bptree.Get(&kv.block, tracker.index, kv.klen, kv.vlen, 0, tracker.buf[:0], byKey)
+		if err != nil {
+			return
+		}
+		if len(tracker.buf) == 8 {
+			at := binary.BigEndian.Uint64(tracker.buf)
+			if exp := tracker.exp; val != nil && exp != nil && at > exp.now && exp.kept(key) {
+				return
+			}
+			tracker.changes.Set(byKey, nil)
+			tracker.changes.Set(expiryKey(at, key), nil)
+		}
+	}
+	if val == nil || tracker.exp == nil {
+		return
+	}
+	if at, ok := tracker.exp.at[string(key)]; ok {
+		tracker.changes.Set(byKey, binary.BigEndian.AppendUint64(nil, at))
+		tracker.changes.Set(expiryKey(at, key), []byte{0})
+	}
+	return
+}
+
+// write writes the collected changes to the expiry index, dropping the
+// index once the kv tree is empty.
+func (tracker *expiryTracker[F]) write(ctx context.Context, root bptree.Page) (index bptree.Page, err error) {
+	kv := tracker.kv
+	index = tracker.index
+	if len(root) == 0 {
+		if len(index) != 0 {
+			err = bptree.RecycleContext(ctx, &kv.block, index, kv.klen, kv.vlen)
+		}
+		return nil, err
+	}
+	if exp := tracker.exp; exp != nil && exp.rebuild != nil {
+		if err = tracker.rebuild(ctx, root, exp.rebuild); err != nil {
+			return
+		}
+	}
+	if tracker.changes.Empty() {
+		return
+	}
+	_, index, err = bptree.WriteSortedChangesContext(ctx, &kv.block,
+		index, kv.klen, kv.vlen, 0, kv.write, tracker.changes.Items)
+	return
+}
+
+// expiry hides the keys of an iterator snapshot that had expired when the
+// iterator was created. When no key has an expiry time, it costs one seek
+// of the expiry index at load, which may hold secondary index entries only.
+type expiry[F File] struct {
+	index bptree.Reader[*block.Heap[F]]
+	now   uint64
+	key   []byte
+	err   error
+}
+
+func (e *expiry[F]) load(kv *KV[F], index bptree.Page) {
+	if len(index) == 0 {
+		return
+	}
+	e.index.Load(&kv.block, index, kv.klen, kv.vlen, 0)
+	// expiry entries by key sort last, after any secondary index entries
+	if !e.index.Seek([]byte{expiryByKey}) || e.index.Key()[0] != expiryByKey {
+		e.err = e.index.Error()
+		e.index.Close()
+		return
+	}
+	e.now = expiryTime(time.Now())
+}
+
+func (e *expiry[F]) loadFrom(src *expiry[F]) {
+	if src.now == 0 {
+		return
+	}
+	e.index.LoadFrom(&src.index)
+	e.now = src.now
+	e.err = src.err
+}
+
+func (e *expiry[F]) close() {
+	if e.now != 0 {
+		e.index.Close()
+		e.now = 0
+	}
+}
+
+// expired reports whether key has expired. Lookup errors are kept in err.
+func (e *expiry[F]) expired(key []byte) bool {
+	if e.now == 0 || e.err != nil {
+		return false
+	}
+	e.key = append(append(e.key[:0], expiryByKey), key...)
+	if !e.index.Seek(e.key) || !e.index.Equal(e.key) {
+		e.err = e.index.Error()
+		return false
+	}
+	val := e.index.Val()
+	if e.err = e.index.Error(); e.err != nil || len(val) != 8 {
+		return false
+	}
+	return binary.BigEndian.Uint64(val) <= e.now
+}
+
+// forward moves reader forward past expired keys.
+func (e *expiry[F]) forward(reader *bptree.Reader[*block.Heap[F]], ok bool) bool {
+	for ok && e.expired(reader.Key()) {
+		ok = reader.Next()
+	}
+	return ok && e.err == nil
+}
+
+// backward moves reader backward past expired keys.
+func (e *expiry[F]) backward(reader *bptree.Reader[*block.Heap[F]], ok bool) bool {
+	for ok && e.expired(reader.Key()) {
+		ok = reader.Prev()
+	}
+	return ok && e.err == nil
+}
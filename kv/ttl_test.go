@@ -0,0 +1,258 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dacapoday/smol/mem"
+)
+
+// TestExpiry tests that expired keys are hidden from Get and iterators,
+// that Set clears an expiry, and that expiries survive a reopen.
+func TestExpiry(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	kv.Set([]byte("a"), []byte("plain"))
+	if err := kv.SetExpiry([]byte("b"), []byte("gone"), past); err != nil {
+		t.Fatalf("SetExpiry: %v", err)
+	}
+	if err := kv.SetTTL([]byte("c"), []byte("live"), time.Hour); err != nil {
+		t.Fatalf("SetTTL: %v", err)
+	}
+	kv.SetExpiry([]byte("d"), []byte("gone"), past)
+	kv.SetExpiry([]byte("e"), []byte("reset"), past)
+	kv.Set([]byte("e"), []byte("kept"))
+
+	check := func(kv *KV[*mem.File]) {
+		t.Helper()
+		for key, want := range map[string]string{"a": "plain", "b": "", "c": "live", "d": "", "e": "kept"} {
+			val, err := kv.Get([]byte(key))
+			if err != nil {
+				t.Fatalf("Get(%q): %v", key, err)
+			}
+			if string(val) != want {
+				t.Fatalf("Get(%q) = %q, want %q", key, val, want)
+			}
+		}
+
+		iter := kv.Iter()
+		defer iter.Close()
+		var keys []byte
+		for iter.SeekFirst(); iter.Valid(); iter.Next() {
+			keys = append(keys, iter.Key()...)
+		}
+		if string(keys) != "ace" {
+			t.Fatalf("iterated %q, want \"ace\"", keys)
+		}
+		keys = keys[:0]
+		for iter.SeekLast(); iter.Valid(); iter.Prev() {
+			keys = append(keys, iter.Key()...)
+		}
+		if string(keys) != "eca" {
+			t.Fatalf("iterated backward %q, want \"eca\"", keys)
+		}
+		if !iter.Seek([]byte("b")) || string(iter.Key()) != "c" {
+			t.Fatalf("Seek(b) lands on %q, want c", iter.Key())
+		}
+		if err := iter.Error(); err != nil {
+			t.Fatalf("Iter error: %v", err)
+		}
+
+		at, ok, err := kv.Expiry([]byte("c"))
+		if err != nil || !ok || at.Sub(future).Abs() > time.Minute {
+			t.Fatalf("Expiry(c) = %v, %v, %v", at, ok, err)
+		}
+		for _, key := range []string{"a", "b", "e"} {
+			if _, ok, _ := kv.Expiry([]byte(key)); ok {
+				t.Fatalf("Expiry(%q) reported", key)
+			}
+		}
+	}
+	check(&kv)
+
+	var buf bytes.Buffer
+	if _, err := file.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	var file2 mem.File
+	if _, err := file2.ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	var reopened KV[*mem.File]
+	if err := reopened.Load(&file2); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	defer reopened.Close()
+	check(&reopened)
+
+	t.Log("✓ Expired keys hidden, expiries persisted")
+}
+
+// TestReap tests that Reap deletes exactly the expired keys,
+// in expiry order, and notifies subscribers.
+func TestReap(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	base := time.Now().Add(time.Hour)
+	count := 3000
+	for batch := 0; batch < count; batch += 100 {
+		for i := batch; i < batch+100; i++ {
+			key := fmt.Appendf(nil, "session-%05d", (i*7919)%count)
+			if err := kv.SetExpiry(key, []byte("token"), base.Add(time.Duration(i)*time.Second)); err != nil {
+				t.Fatalf("SetExpiry: %v", err)
+			}
+		}
+	}
+	kv.Set([]byte("config"), []byte("on"))
+
+	sub, err := kv.Subscribe(&SubscribeOptions{Buffer: 4})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Close()
+
+	n, err := kv.Reap(base.Add(999 * time.Second))
+	if err != nil {
+		t.Fatalf("Reap: %v", err)
+	}
+	if n != 1000 {
+		t.Fatalf("Reap deleted %d keys, want 1000", n)
+	}
+	changeset := <-sub.C
+	if len(changeset.Changes) != n {
+		t.Fatalf("changeset holds %d changes, want %d", len(changeset.Changes), n)
+	}
+
+	if n, err = kv.Reap(base.Add(999 * time.Second)); err != nil || n != 0 {
+		t.Fatalf("Reap again = %d, %v", n, err)
+	}
+
+	for i := range count {
+		key := fmt.Appendf(nil, "session-%05d", (i*7919)%count)
+		val, err := kv.Get(key)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if (i < 1000) != (val == nil) {
+			t.Fatalf("Get(%q) = %q after reaping", key, val)
+		}
+	}
+
+	n, err = kv.Reap(base.Add(time.Duration(count) * time.Second))
+	if err != nil || n != count-1000 {
+		t.Fatalf("Reap rest = %d, %v", n, err)
+	}
+	iter := kv.Iter()
+	defer iter.Close()
+	var keys [][]byte
+	for iter.SeekFirst(); iter.Valid(); iter.Next() {
+		keys = append(keys, bytes.Clone(iter.Key()))
+	}
+	if len(keys) != 1 || string(keys[0]) != "config" {
+		t.Fatalf("left %q, want config", keys)
+	}
+
+	t.Log("✓ Expired keys reaped via the expiry index")
+}
+
+// TestReapInterval tests the background reaper.
+func TestReapInterval(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.LoadFile(&file, &Options{ReapInterval: 5 * time.Millisecond}); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	kv.SetTTL([]byte("a"), []byte("1"), 10*time.Millisecond)
+	kv.Set([]byte("b"), []byte("2"))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		entry, ckpt := kv.atom.Acquire()
+		_, index := splitEntry(entry)
+		ckpt.Release()
+		if len(index) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired key not reaped")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if val, _ := kv.Get([]byte("b")); string(val) != "2" {
+		t.Fatalf("Get(b) = %q", val)
+	}
+
+	t.Log("✓ Background reaper deletes expired keys")
+}
+
+// TestExpiryLargeRoots tests root pages that do not fit in one entry
+// together, which are pushed down a level.
+func TestExpiryLargeRoots(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	long := func(i int) []byte {
+		return append(fmt.Appendf(nil, "%02d", i), bytes.Repeat([]byte("x"), 2000)...)
+	}
+	kv.Batch(func(yield func([]byte, []byte) bool) {
+		for i := range 7 {
+			yield(long(i), []byte("value"))
+		}
+	})
+	for i := 7; i < 10; i++ {
+		if err := kv.SetTTL(long(i), []byte("session"), time.Duration(i-7)*time.Hour); err != nil {
+			t.Fatalf("SetTTL: %v", err)
+		}
+	}
+
+	entry, ckpt := kv.atom.Acquire()
+	root, index := splitEntry(entry)
+	ckpt.Release()
+	if len(index) == 0 || root.IsLeaf() && index.IsLeaf() {
+		t.Fatalf("entry of %d+%d bytes, want a pushed root", len(root), len(index))
+	}
+
+	for i := range 10 {
+		val, err := kv.Get(long(i))
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if (i == 7) != (val == nil) {
+			t.Fatalf("Get(%d) = %q", i, val)
+		}
+	}
+	if n, err := kv.Reap(time.Now().Add(90 * time.Minute)); err != nil || n != 2 {
+		t.Fatalf("Reap = %d, %v", n, err)
+	}
+	iter := kv.Iter()
+	defer iter.Close()
+	n := 0
+	for iter.SeekFirst(); iter.Valid(); iter.Next() {
+		n++
+	}
+	if n != 8 {
+		t.Fatalf("iterated %d keys, want 8", n)
+	}
+
+	t.Log("✓ Large roots pushed into the entry")
+}
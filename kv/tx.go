@@ -2,6 +2,10 @@ package kv
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
 
 	"github.com/dacapoday/smol/btree"
 )
@@ -12,21 +16,43 @@ import (
 // Writes are serialized. Uncommitted changes are isolated until Commit.
 func (kv *KV[F]) Begin() (tx *Tx[Iter[F]]) {
 	tx = new(Tx[Iter[F]])
-	tx.Begin(kv.Iter(), kv.commitSortedChanges)
+	tx.BeginContext(kv.Iter(), kv.commitSortedChanges)
+	tx.commitMerging = kv.commitMerging
 	return
 }
 
 // Tx represents a transaction with Read Committed isolation.
 // Buffers changes in memory until Commit.
 type Tx[Iter Iterator[Iter]] struct {
-	commit   Commit
-	snapshot Iter
-	pending  btree.BTree
+	commit        Commit
+	commitContext CommitContext
+	commitMerging func(ctx context.Context, sortedChanges func(yield func([]byte, []byte) bool), merges merging) error
+	snapshot      Iter
+	pending       btree.BTree
+	merges        merging // operands of pending keys written by Merge
+	savepoints    []*savepoint
+	seq           uint64
+}
+
+// savepoint holds the changes made since a savepoint was taken.
+type savepoint struct {
+	seq     uint64
+	pending btree.BTree
+	merges  merging
+}
+
+// Savepoint marks a point in a transaction to roll back to.
+type Savepoint struct {
+	depth int
+	seq   uint64
 }
 
 // Commit is a function type for committing sorted changes.
 type Commit = func(sortedChanges func(yield func([]byte, []byte) bool)) error
 
+// CommitContext is a Commit that aborts, committing nothing, once ctx is done.
+type CommitContext = func(ctx context.Context, sortedChanges func(yield func([]byte, []byte) bool)) error
+
 // Begin initializes the transaction with a snapshot and commit function.
 func (tx *Tx[Iter]) Begin(snapshot Iter, commit Commit) {
 	if tx.commit != nil {
@@ -36,12 +62,25 @@ func (tx *Tx[Iter]) Begin(snapshot Iter, commit Commit) {
 	tx.snapshot = snapshot
 }
 
+// BeginContext is Begin with a commit function that honors the
+// context passed to CommitContext.
+func (tx *Tx[Iter]) BeginContext(snapshot Iter, commit CommitContext) {
+	tx.Begin(snapshot, func(sortedChanges func(yield func([]byte, []byte) bool)) error {
+		return commit(context.Background(), sortedChanges)
+	})
+	tx.commitContext = commit
+}
+
 func (tx *Tx[Iter]) close() {
 	tx.commit = nil
+	tx.commitContext = nil
+	tx.commitMerging = nil
 	tx.snapshot.Close()
 	var nilSnapshot Iter
 	tx.snapshot = nilSnapshot
 	tx.pending.Reset()
+	tx.merges = nil
+	tx.savepoints = nil
 }
 
 // Rollback discards all pending changes and closes the transaction.
@@ -57,22 +96,150 @@ func (tx *Tx[Iter]) Rollback() {
 // Returns immediately if no changes were made.
 // Transaction is closed after commit (successful or not).
 func (tx *Tx[Iter]) Commit() (err error) {
+	return tx.CommitContext(context.Background())
+}
+
+// CommitContext is Commit, aborting once ctx is done: nothing is written
+// and the context's error is returned. A transaction begun with Begin
+// rather than BeginContext only checks ctx before committing.
+func (tx *Tx[Iter]) CommitContext(ctx context.Context) (err error) {
 	if tx.commit == nil {
 		err = ErrClosed
 		return
 	}
-	if tx.pending.Empty() {
+	pending, merges := tx.flatten()
+	if pending.Empty() {
 		return
 	}
-	err = tx.commit(tx.pending.Items)
+	switch {
+	case tx.commitMerging != nil:
+		err = tx.commitMerging(ctx, merges.without(pending.Items), merges)
+	case tx.commitContext != nil:
+		err = tx.commitContext(ctx, pending.Items)
+	default:
+		if err = ctx.Err(); err == nil {
+			err = tx.commit(pending.Items)
+		}
+	}
 	tx.close()
 	return
 }
 
+// Savepoint marks the current state of the transaction.
+// RollbackTo discards the changes made since.
+func (tx *Tx[Iter]) Savepoint() Savepoint {
+	tx.seq++
+	tx.savepoints = append(tx.savepoints, &savepoint{seq: tx.seq})
+	return Savepoint{len(tx.savepoints), tx.seq}
+}
+
+// RollbackTo discards the changes made since sp, and the savepoints taken
+// since. sp itself remains and can be rolled back to again.
+// Returns ErrOutOfRange if sp was discarded by an earlier RollbackTo.
+func (tx *Tx[Iter]) RollbackTo(sp Savepoint) error {
+	if tx.commit == nil {
+		return ErrClosed
+	}
+	if sp.depth < 1 || sp.depth > len(tx.savepoints) || tx.savepoints[sp.depth-1].seq != sp.seq {
+		return fmt.Errorf("kv.Tx.RollbackTo: %w: savepoint discarded", ErrOutOfRange)
+	}
+	// reset rather than drop, so iterators reading the layers see it
+	for _, discarded := range tx.savepoints[sp.depth-1:] {
+		discarded.pending.Reset()
+		discarded.merges = nil
+	}
+	tx.savepoints = tx.savepoints[:sp.depth]
+	return nil
+}
+
+// layers returns iterators over the pending changes and those made since
+// each savepoint, latest first.
+func (tx *Tx[Iter]) layers() []btree.Iter {
+	layers := make([]btree.Iter, 0, len(tx.savepoints)+1)
+	for i := len(tx.savepoints) - 1; i >= 0; i-- {
+		layers = append(layers, tx.savepoints[i].pending.Iter())
+	}
+	return append(layers, tx.pending.Iter())
+}
+
+// flatten returns the pending changes with those made since each
+// savepoint applied over them, for Commit, and the operands of the keys
+// written by Merge since they were last set. Those keys have the value
+// of the transaction's view in the changes.
+func (tx *Tx[Iter]) flatten() (*btree.BTree, merging) {
+	if len(tx.savepoints) == 0 {
+		return &tx.pending, tx.merges
+	}
+	flat := new(btree.BTree)
+	var merges merging
+	apply := func(pending *btree.BTree, layer merging) {
+		for key, val := range pending.Items {
+			flat.Set(key, val)
+			if operands, ok := layer[string(key)]; ok {
+				for _, operand := range operands {
+					merges = merges.add(key, operand.op, operand.val)
+				}
+			} else {
+				delete(merges, string(key))
+			}
+		}
+	}
+	apply(&tx.pending, tx.merges)
+	for _, sp := range tx.savepoints {
+		apply(&sp.pending, sp.merges)
+	}
+	return flat, merges
+}
+
+// BeginNested starts a transaction nested in tx. Its changes layer over
+// those of tx, and its Commit applies them to tx rather than the store.
+// Rolling it back leaves tx unchanged. Nested transactions nest further.
+//
+// A function rather than a method, since a method of Tx[Iter] returning
+// Tx[TxIter[Iter]] would instantiate Tx without end.
+//
+// Important: Caller must Commit or Rollback the nested transaction.
+func BeginNested[Iter Iterator[Iter]](tx *Tx[Iter]) (nested *Tx[TxIter[Iter]]) {
+	nested = new(Tx[TxIter[Iter]])
+	nested.BeginContext(tx.Iter(), func(ctx context.Context, sortedChanges func(yield func([]byte, []byte) bool)) error {
+		return tx.apply(ctx, sortedChanges, nil)
+	})
+	nested.commitMerging = tx.apply
+	return
+}
+
+// apply sets sorted changes committed by a nested transaction, and
+// merges the operands of its keys written by Merge. Those merge as they
+// did in its view, which was the view of tx.
+func (tx *Tx[Iter]) apply(ctx context.Context, sortedChanges func(yield func([]byte, []byte) bool), merges merging) error {
+	if tx.commit == nil {
+		return ErrClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for key, val := range sortedChanges {
+		tx.Set(key, val)
+	}
+	for _, key := range slices.Sorted(maps.Keys(merges)) {
+		for _, operand := range merges[key] {
+			if err := tx.Merge(operand.op, []byte(key), operand.val); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // Get retrieves a value within the transaction's view.
 // Checks pending changes first, then the snapshot.
 // Returns nil if key does not exist.
 func (tx *Tx[Iter]) Get(key []byte) (val []byte, err error) {
+	for i := len(tx.savepoints) - 1; i >= 0; i-- {
+		if val, found := tx.savepoints[i].pending.Get(key); found {
+			return val, nil
+		}
+	}
 	val, found := tx.pending.Get(key)
 	if found {
 		return
@@ -99,5 +266,79 @@ func (tx *Tx[Iter]) Get(key []byte) (val []byte, err error) {
 //
 // Warning: Caller must not modify key or val after calling Set.
 func (tx *Tx[Iter]) Set(key, val []byte) {
-	tx.pending.Set(key, val)
+	pending, merges := tx.top()
+	pending.Set(key, val)
+	delete(*merges, string(key))
+}
+
+// Store returns tx as a Store, for a Table to work in the transaction.
+// Its Set reports no error; Commit does.
+func (tx *Tx[Iter]) Store() Store {
+	return txStore[Iter]{tx}
+}
+
+type txStore[Iter Iterator[Iter]] struct {
+	tx *Tx[Iter]
+}
+
+func (store txStore[Iter]) Get(key []byte) ([]byte, error) {
+	return store.tx.Get(key)
+}
+
+func (store txStore[Iter]) Set(key, val []byte) error {
+	store.tx.Set(key, val)
+	return nil
+}
+
+// Merge merges operand into the value of key with op. The transaction's
+// view has the merged value right away. Commit merges operand again,
+// into the value key has in the store then, unless key was set in the
+// transaction before. Transactions begun with Begin or BeginContext
+// commit the value of their view instead.
+//
+// Warning: Caller must not modify key or operand after calling Merge.
+func (tx *Tx[Iter]) Merge(op MergeOperator, key, operand []byte) error {
+	if tx.commit == nil {
+		return ErrClosed
+	}
+	val, err := tx.Get(key)
+	if err == nil {
+		val, err = op.Merge(key, val, operand)
+	}
+	if err != nil {
+		return err
+	}
+	if written, merged := tx.written(key); written && !merged {
+		tx.Set(key, val)
+		return nil
+	}
+	pending, merges := tx.top()
+	pending.Set(key, val)
+	*merges = merges.add(key, op, operand)
+	return nil
+}
+
+// top returns the layer of changes written to: those since the last
+// savepoint, or the pending ones.
+func (tx *Tx[Iter]) top() (*btree.BTree, *merging) {
+	if n := len(tx.savepoints); n != 0 {
+		return &tx.savepoints[n-1].pending, &tx.savepoints[n-1].merges
+	}
+	return &tx.pending, &tx.merges
+}
+
+// written reports whether key was written in the transaction, and if so
+// whether last by Merge.
+func (tx *Tx[Iter]) written(key []byte) (written, merged bool) {
+	for i := len(tx.savepoints) - 1; i >= 0; i-- {
+		if _, found := tx.savepoints[i].pending.Get(key); found {
+			_, merged = tx.savepoints[i].merges[string(key)]
+			return true, merged
+		}
+	}
+	if _, found := tx.pending.Get(key); found {
+		_, merged = tx.merges[string(key)]
+		return true, merged
+	}
+	return
 }
@@ -2,6 +2,8 @@ package kv
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"testing"
 
 	"github.com/dacapoday/smol/mem"
@@ -141,3 +143,236 @@ func TestTxCommit(t *testing.T) {
 
 	t.Log("✓ Commit makes changes visible")
 }
+
+// TestTxSavepoint tests rolling back to savepoints within a transaction.
+func TestTxSavepoint(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	kv.Set([]byte("a"), []byte("1"))
+
+	tx := kv.Begin()
+	tx.Set([]byte("b"), []byte("2"))
+	sp1 := tx.Savepoint()
+	tx.Set([]byte("a"), nil)
+	tx.Set([]byte("c"), []byte("3"))
+	sp2 := tx.Savepoint()
+	tx.Set([]byte("d"), []byte("4"))
+
+	view := func(want string) {
+		t.Helper()
+		iter := tx.Iter()
+		defer iter.Close()
+		var got []byte
+		for iter.SeekFirst(); iter.Valid(); iter.Next() {
+			got = append(got, iter.Key()...)
+		}
+		if string(got) != want {
+			t.Fatalf("tx view %q, want %q", got, want)
+		}
+	}
+	view("bcd")
+
+	if err := tx.RollbackTo(sp1); err != nil {
+		t.Fatalf("RollbackTo: %v", err)
+	}
+	view("ab")
+	if val, _ := tx.Get([]byte("a")); string(val) != "1" {
+		t.Fatalf("tx.Get(a) = %q after rollback", val)
+	}
+	if err := tx.RollbackTo(sp2); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("RollbackTo discarded savepoint: %v, want ErrOutOfRange", err)
+	}
+
+	// sp1 remains after rolling back to it
+	tx.Set([]byte("e"), []byte("5"))
+	if err := tx.RollbackTo(sp1); err != nil {
+		t.Fatalf("RollbackTo again: %v", err)
+	}
+	tx.Set([]byte("f"), []byte("6"))
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	iter := kv.Iter()
+	defer iter.Close()
+	var got []byte
+	for iter.SeekFirst(); iter.Valid(); iter.Next() {
+		got = append(got, iter.Key()...)
+	}
+	if string(got) != "abf" {
+		t.Fatalf("committed %q, want \"abf\"", got)
+	}
+
+	t.Log("✓ Savepoints roll back the changes made since")
+}
+
+// TestTxIterSavepoints tests iterating a transaction's view across
+// savepoints while writing to it.
+func TestTxIterSavepoints(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	kv.Set([]byte("a"), []byte("1"))
+	kv.Set([]byte("e"), []byte("5"))
+	tx := kv.Begin()
+	defer tx.Rollback()
+	tx.Set([]byte("b"), []byte("2"))
+	sp := tx.Savepoint()
+	tx.Set([]byte("a"), nil)
+
+	iter := tx.Iter()
+	defer iter.Close()
+	keys := func() string {
+		t.Helper()
+		var got []byte
+		for iter.SeekFirst(); iter.Valid(); iter.Next() {
+			got = append(got, iter.Key()...)
+		}
+		if err := iter.Error(); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		return string(got)
+	}
+	if got := keys(); got != "be" {
+		t.Fatalf("view %q, want \"be\"", got)
+	}
+
+	// writes to the layers of the iterator are seen, below and above the savepoint
+	tx.Set([]byte("c"), []byte("3"))
+	if got := keys(); got != "bce" {
+		t.Fatalf("view after write %q, want \"bce\"", got)
+	}
+	var seen []byte
+	for iter.SeekFirst(); iter.Valid(); iter.Next() {
+		seen = append(seen, iter.Key()...)
+		if string(iter.Key()) == "b" {
+			tx.Set([]byte("d"), []byte("4"))
+		}
+	}
+	if string(seen) != "bcde" {
+		t.Fatalf("view while writing %q, want \"bcde\"", seen)
+	}
+
+	if err := tx.RollbackTo(sp); err != nil {
+		t.Fatalf("RollbackTo: %v", err)
+	}
+	if got := keys(); got != "abe" {
+		t.Fatalf("view after RollbackTo %q, want \"abe\"", got)
+	}
+
+	clone := iter.Clone()
+	defer clone.Close()
+	if !clone.Seek([]byte("b")) || string(clone.Val()) != "2" {
+		t.Fatalf("clone Seek(b) = %q", clone.Key())
+	}
+
+	t.Log("✓ Iterators read the savepoint layers as they change")
+}
+
+// TestTxNested tests nested transactions layered over the outer one.
+func TestTxNested(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	kv.Set([]byte("a"), []byte("1"))
+	tx := kv.Begin()
+	tx.Set([]byte("b"), []byte("2"))
+
+	inner := BeginNested(tx)
+	if val, _ := inner.Get([]byte("b")); string(val) != "2" {
+		t.Fatalf("inner.Get(b) = %q, want outer's write", val)
+	}
+	inner.Set([]byte("a"), nil)
+	inner.Set([]byte("c"), []byte("3"))
+	if val, _ := tx.Get([]byte("c")); val != nil {
+		t.Fatalf("outer sees inner write c = %q", val)
+	}
+	inner.Rollback()
+	if val, _ := tx.Get([]byte("a")); string(val) != "1" {
+		t.Fatalf("tx.Get(a) = %q after inner rollback", val)
+	}
+
+	inner = BeginNested(tx)
+	inner.Set([]byte("a"), nil)
+	innermost := BeginNested(inner)
+	innermost.Set([]byte("d"), []byte("4"))
+	if err := innermost.Commit(); err != nil {
+		t.Fatalf("innermost Commit: %v", err)
+	}
+	if err := inner.Commit(); err != nil {
+		t.Fatalf("inner Commit: %v", err)
+	}
+	if val, _ := kv.Get([]byte("d")); val != nil {
+		t.Fatalf("nested commit reached the store: d = %q", val)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	for key, want := range map[string]string{"a": "", "b": "2", "d": "4"} {
+		if val, _ := kv.Get([]byte(key)); string(val) != want {
+			t.Fatalf("Get(%q) = %q, want %q", key, val, want)
+		}
+	}
+
+	t.Log("✓ Nested transactions commit into the outer one")
+}
+
+// TestTxCommitContext tests that a cancelled commit writes nothing and
+// closes the transaction, in the store and in a parent transaction.
+func TestTxCommitContext(t *testing.T) {
+	var file mem.File
+	var kv KV[*mem.File]
+	if err := kv.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer kv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tx := kv.Begin()
+	tx.Set([]byte("a"), []byte("1"))
+	nested := BeginNested(tx)
+	nested.Set([]byte("b"), []byte("2"))
+	if err := nested.CommitContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("nested CommitContext: %v, want context.Canceled", err)
+	}
+	if err := nested.Commit(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Commit after cancel: %v, want ErrClosed", err)
+	}
+	if val, _ := tx.Get([]byte("b")); val != nil {
+		t.Fatalf("cancelled nested change applied: %q", val)
+	}
+
+	if err := tx.CommitContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("CommitContext: %v, want context.Canceled", err)
+	}
+	if val, _ := kv.Get([]byte("a")); val != nil {
+		t.Fatalf("cancelled change committed: %q", val)
+	}
+
+	tx = kv.Begin()
+	tx.Set([]byte("a"), []byte("1"))
+	if err := tx.CommitContext(context.Background()); err != nil {
+		t.Fatalf("CommitContext: %v", err)
+	}
+	if val, _ := kv.Get([]byte("a")); string(val) != "1" {
+		t.Fatalf("Get(a) = %q", val)
+	}
+
+	t.Log("✓ Cancelled commits write nothing")
+}
@@ -0,0 +1,46 @@
+package smol
+
+import "time"
+
+// Observer receives events for metrics and tracing.
+//
+// Callbacks run synchronously on the goroutine doing the work, some while
+// writers are serialized: they must be quick, safe for concurrent use,
+// and must not call back into the store. Embed NopObserver to implement
+// only some of them.
+type Observer interface {
+	// BlockRead reports a read of n bytes from a block, taking d.
+	BlockRead(blockID BlockID, n int, d time.Duration, err error)
+
+	// BlockWrite reports a write of n bytes to a block, taking d.
+	BlockWrite(blockID BlockID, n int, d time.Duration, err error)
+
+	// Commit reports a commit, successful or not.
+	Commit(info CommitInfo)
+
+	// IterOpen reports an iterator created, or cloned.
+	IterOpen()
+
+	// IterClose reports an iterator closed after being open for lifetime.
+	IterClose(lifetime time.Duration)
+}
+
+// CommitInfo describes a commit.
+type CommitInfo struct {
+	Ckp       uint32        // commit number, 0 if the commit failed
+	Duration  time.Duration // time spent committing
+	Sync      time.Duration // time spent in fsync
+	Allocated int           // blocks allocated since the previous commit
+	Recycled  int           // blocks recycled since the previous commit
+	Retained  int           // earlier checkpoints still acquired, holding their recycled blocks back from reuse
+	Err       error
+}
+
+// NopObserver ignores all events.
+type NopObserver struct{}
+
+func (NopObserver) BlockRead(BlockID, int, time.Duration, error)  {}
+func (NopObserver) BlockWrite(BlockID, int, time.Duration, error) {}
+func (NopObserver) Commit(CommitInfo)                             {}
+func (NopObserver) IterOpen()                                     {}
+func (NopObserver) IterClose(time.Duration)                       {}
@@ -11,22 +11,27 @@ var (
 	ErrAllocateFailed = smol.ErrAllocateFailed
 )
 
-func errAllocateFailed[B ReadWrite](b B) error {
+type BlockError = smol.BlockError
+
+// errAllocateFailed wraps ErrAllocateFailed, and the error of the block
+// if any, in a BlockError with the invalid BlockID AllocateBlock returned.
+func errAllocateFailed[B ReadWrite](op string, b B, blockID BlockID) error {
+	err := ErrAllocateFailed
 	if block, ok := any(b).(interface{ Error() error }); ok {
-		if err := block.Error(); err != nil {
-			return fmt.Errorf("%w: %w", ErrAllocateFailed, err)
+		if blockErr := block.Error(); blockErr != nil {
+			err = fmt.Errorf("%w: %w", ErrAllocateFailed, blockErr)
 		}
 	}
-	return ErrAllocateFailed
+	return &BlockError{Op: op, BlockID: blockID, Err: err}
 }
 
-func errOverflow(overflowSize int) error {
+func errOverflow(op string, blockID BlockID, overflowSize int) error {
 	if overflowSize < 0 {
-		return fmt.Errorf("%w: %d bytes over", ErrBadOverflow, -overflowSize)
+		return &BlockError{Op: op, BlockID: blockID, Err: fmt.Errorf("%w: %d bytes over", ErrBadOverflow, -overflowSize)}
 	}
-	return fmt.Errorf("%w: %d bytes remaining", ErrBadOverflow, overflowSize)
+	return &BlockError{Op: op, BlockID: blockID, Err: fmt.Errorf("%w: %d bytes remaining", ErrBadOverflow, overflowSize)}
 }
 
-func errNextID(nextID BlockID) error {
-	return fmt.Errorf("%w: invalid nextID %d", ErrBadOverflow, nextID)
+func errNextID(op string, blockID, nextID BlockID) error {
+	return &BlockError{Op: op, BlockID: blockID, Err: fmt.Errorf("%w: invalid nextID %d", ErrBadOverflow, nextID)}
 }
@@ -71,12 +71,14 @@ func Compare[B ReadOnly](block B, key, head []byte, overflowSize int, overflowID
 
 // Iter returns an iterator over each page's data in the overflow chain.
 // Data is only valid during the yield call.
-// Reports ErrBadOverflow if data size doesn't match overflowSize.
+// Reports ErrBadOverflow, in a BlockError naming the page where the chain
+// went wrong, if data size doesn't match overflowSize.
 func Iter[B ReadOnly](block B, overflowSize int, overflowID BlockID) func(yield func([]byte, error) bool) {
 	return func(yield func([]byte, error) bool) {
 		buffer := block.AllocateBuffer()
 		defer block.RecycleBuffer(buffer)
 
+		var blockID BlockID
 		read := func(buffer []byte) {
 			page := Page(buffer)
 			var data []byte
@@ -89,7 +91,7 @@ func Iter[B ReadOnly](block B, overflowSize int, overflowID BlockID) func(yield
 			}
 			overflowSize -= len(data)
 			if overflowSize < 0 {
-				yield(nil, errOverflow(overflowSize))
+				yield(nil, errOverflow("overflow.Iter", blockID, overflowSize))
 				overflowID = 0
 				overflowSize = 0
 				return
@@ -101,14 +103,15 @@ func Iter[B ReadOnly](block B, overflowSize int, overflowID BlockID) func(yield
 		}
 
 		for overflowID > 1 {
+			blockID = overflowID
 			if err := block.ReadBlock(overflowID, buffer, read); err != nil {
-				yield(nil, err)
+				yield(nil, &BlockError{Op: "overflow.Iter", BlockID: blockID, Err: err})
 				return
 			}
 		}
 
 		if overflowSize != 0 {
-			yield(nil, errOverflow(overflowSize))
+			yield(nil, errOverflow("overflow.Iter", blockID, overflowSize))
 		}
 	}
 }
@@ -128,13 +131,13 @@ func Recycle[B ReadWrite](block B, overflowID BlockID) (err error) {
 
 		nextID = page.OverflowID()
 		if nextID < 2 {
-			err = errNextID(nextID)
+			err = errNextID("overflow.Recycle", overflowID, nextID)
 		}
 	}
 
 	for overflowID > 1 {
 		if e := block.ReadBlock(overflowID, buffer, recycle); e != nil {
-			err = e
+			err = &BlockError{Op: "overflow.Recycle", BlockID: overflowID, Err: e}
 			return
 		}
 		block.RecycleBlock(overflowID)
@@ -167,7 +170,7 @@ func Write[B ReadWrite](block B, body []byte, inlineSize int) (head []byte, over
 
 	overflowID = block.AllocateBlock()
 	if overflowID < 2 {
-		err = errAllocateFailed(block)
+		err = errAllocateFailed("overflow.Write", block, overflowID)
 		return
 	}
 
@@ -182,7 +185,7 @@ func Write[B ReadWrite](block B, body []byte, inlineSize int) (head []byte, over
 
 		overflowID = block.AllocateBlock()
 		if overflowID < 2 {
-			err = errAllocateFailed(block)
+			err = errAllocateFailed("overflow.Write", block, overflowID)
 			return
 		}
 
@@ -2,6 +2,7 @@ package overflow
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 
 	"github.com/dacapoday/smol/block"
@@ -394,3 +395,65 @@ func TestCompareEdgeCases(t *testing.T) {
 		}
 	}
 }
+
+func TestIterBadOverflow(t *testing.T) {
+	var f mem.File
+	var b block.Heap[*mem.File]
+
+	_, ckpt, err := b.Load(&f, option{})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer ckpt.Release()
+	defer b.Close()
+
+	data := bytes.Repeat([]byte("x"), 2000)
+	head, overflowSize, overflowID, err := Write(&b, data, 10)
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	_, err = Read(&b, nil, head, overflowSize+1, overflowID)
+	var blockErr *BlockError
+	if !errors.As(err, &blockErr) || blockErr.BlockID < 2 {
+		t.Fatalf("Expected BlockError, got %v", err)
+	}
+	if !errors.Is(err, ErrBadOverflow) {
+		t.Errorf("Expected ErrBadOverflow, got %v", err)
+	}
+
+	_, err = Read(&b, nil, head, overflowSize-1, overflowID)
+	if !errors.As(err, &blockErr) || !errors.Is(err, ErrBadOverflow) {
+		t.Errorf("Expected BlockError wrapping ErrBadOverflow, got %v", err)
+	}
+}
+
+// noSpace is a block store failing every allocation.
+type noSpace struct {
+	*block.Heap[*mem.File]
+}
+
+func (noSpace) AllocateBlock() BlockID {
+	return 0
+}
+
+func TestWriteAllocateFailed(t *testing.T) {
+	var f mem.File
+	var b block.Heap[*mem.File]
+
+	_, ckpt, err := b.Load(&f, option{})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer ckpt.Release()
+	defer b.Close()
+
+	_, _, _, err = Write(noSpace{&b}, bytes.Repeat([]byte("x"), 2000), 10)
+	var blockErr *BlockError
+	if !errors.As(err, &blockErr) || blockErr.Op != "overflow.Write" {
+		t.Fatalf("Expected overflow.Write BlockError, got %v", err)
+	}
+	if !errors.Is(err, ErrAllocateFailed) {
+		t.Errorf("Expected ErrAllocateFailed, got %v", err)
+	}
+}
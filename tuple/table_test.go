@@ -0,0 +1,60 @@
+package tuple_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dacapoday/smol/iterator"
+	"github.com/dacapoday/smol/kv"
+	"github.com/dacapoday/smol/mem"
+	"github.com/dacapoday/smol/tuple"
+)
+
+// TestTable tests Codec as the key codec of a kv.Table, scanning the
+// records of one tenant with Range.
+func TestTable(t *testing.T) {
+	var file mem.File
+	var store kv.KV[*mem.File]
+	if err := store.Load(&file); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer store.Close()
+
+	events := kv.Table[tuple.Tuple, string]{Prefix: []byte("ev/"), Key: tuple.Codec{}, Val: kv.StringValue}
+	for _, key := range []tuple.Tuple{
+		{"a", int64(2), "x"},
+		{"b", int64(-5), "y"},
+		{"b", int64(10), "z"},
+		{"b", int64(3), nil},
+		{"c", int64(0), "w"},
+	} {
+		if err := events.Set(&store, key, "v"); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+
+	begin, end := tuple.Tuple{"b"}.Range()
+	iter := store.Iter()
+	defer iter.Close()
+	var view iterator.Range[kv.Iter[*mem.File]]
+	view.Load(iter, append([]byte("ev/"), begin...), append([]byte("ev/"), end...))
+	var got []tuple.Tuple
+	for ok := view.SeekFirst(); ok; ok = view.Next() {
+		key, err := tuple.Unpack(view.Key()[len("ev/"):])
+		if err != nil {
+			t.Fatalf("Unpack: %v", err)
+		}
+		got = append(got, key)
+	}
+	want := []tuple.Tuple{{"b", int64(-5), "y"}, {"b", int64(3), nil}, {"b", int64(10), "z"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("scan = %v, want %v", got, want)
+	}
+
+	table := events.Iter(iter)
+	if !table.Seek(tuple.Tuple{"b", int64(4)}) || !reflect.DeepEqual(table.Key(), tuple.Tuple{"b", int64(10), "z"}) {
+		t.Fatalf("Seek = %v, %v", table.Key(), table.Error())
+	}
+
+	t.Log("✓ Tuple keys scanned by prefix through kv.Table")
+}
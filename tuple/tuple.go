@@ -0,0 +1,345 @@
+// Package tuple encodes tuples of values into keys whose lexicographic
+// order is the order of the tuples, element by element, for composite
+// keys of a B+ tree such as (tenant, timestamp, id).
+//
+// The encoding is that of the FoundationDB tuple layer. Each element
+// starts with a type code, so elements of different types order by type:
+//
+//	0x00        nil
+//	0x01        []byte, with 0x00 escaped as 0x00 0xFF, ending with 0x00
+//	0x02        string, escaped as []byte
+//	0x05        nested Tuple, its nil elements as 0x00 0xFF, ending with 0x00
+//	0x0C..0x1C  integer: 0x14 for zero, 0x14±n for n big-endian bytes of
+//	            a positive number or the one's complement of a negative one
+//	0x20        float32, big-endian with the sign bit flipped, or all bits
+//	            of a negative number
+//	0x21        float64, as float32
+//	0x26, 0x27  false, true
+//
+// A tuple sorts before the tuples it is a prefix of, so the keys of all
+// tuples starting with t are those in t.Range.
+package tuple
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+
+	"github.com/dacapoday/smol"
+	"github.com/dacapoday/smol/iterator"
+)
+
+// Tuple is a list of elements: nil, []byte, string, bool, float32,
+// float64, Tuple, or any signed or unsigned integer type.
+// Integers decode as int64, or as uint64 beyond math.MaxInt64.
+type Tuple []any
+
+// ErrFormat is returned when a key is not an encoded tuple.
+// It matches smol.ErrBadEncoding.
+var ErrFormat = fmt.Errorf("tuple: %w", smol.ErrBadEncoding)
+
+const (
+	codeNil     = 0x00
+	codeBytes   = 0x01
+	codeString  = 0x02
+	codeNested  = 0x05
+	codeIntZero = 0x14
+	codeFloat   = 0x20
+	codeDouble  = 0x21
+	codeFalse   = 0x26
+	codeTrue    = 0x27
+)
+
+// Pack returns the encoding of the tuple.
+// It panics on an element of an unsupported type.
+func (t Tuple) Pack() []byte {
+	return t.Append(nil)
+}
+
+// Append appends the encoding of the tuple to buf.
+// It panics on an element of an unsupported type.
+func (t Tuple) Append(buf []byte) []byte {
+	for _, elem := range t {
+		buf = appendElem(buf, elem, false)
+	}
+	return buf
+}
+
+func appendElem(buf []byte, elem any, nested bool) []byte {
+	switch v := elem.(type) {
+	case nil:
+		if nested {
+			return append(buf, codeNil, 0xFF)
+		}
+		return append(buf, codeNil)
+	case []byte:
+		return AppendBytes(buf, v)
+	case string:
+		return AppendString(buf, v)
+	case bool:
+		if v {
+			return append(buf, codeTrue)
+		}
+		return append(buf, codeFalse)
+	case float32:
+		u := math.Float32bits(v)
+		if u&(1<<31) != 0 {
+			u = ^u
+		} else {
+			u ^= 1 << 31
+		}
+		return binary.BigEndian.AppendUint32(append(buf, codeFloat), u)
+	case float64:
+		u := math.Float64bits(v)
+		if u&(1<<63) != 0 {
+			u = ^u
+		} else {
+			u ^= 1 << 63
+		}
+		return binary.BigEndian.AppendUint64(append(buf, codeDouble), u)
+	case Tuple:
+		buf = append(buf, codeNested)
+		for _, elem := range v {
+			buf = appendElem(buf, elem, true)
+		}
+		return append(buf, codeNil)
+	case int:
+		return AppendInt(buf, int64(v))
+	case int8:
+		return AppendInt(buf, int64(v))
+	case int16:
+		return AppendInt(buf, int64(v))
+	case int32:
+		return AppendInt(buf, int64(v))
+	case int64:
+		return AppendInt(buf, v)
+	case uint:
+		return AppendUint(buf, uint64(v))
+	case uint8:
+		return AppendUint(buf, uint64(v))
+	case uint16:
+		return AppendUint(buf, uint64(v))
+	case uint32:
+		return AppendUint(buf, uint64(v))
+	case uint64:
+		return AppendUint(buf, v)
+	}
+	panic(fmt.Errorf("tuple: unsupported element type %T", elem))
+}
+
+// The element encoders below append one element, as Tuple.Append does.
+// They serve keys made of elements of known types, such as kv.KeyCodec.
+
+// AppendBytes appends the encoding of a []byte element to buf.
+func AppendBytes(buf, b []byte) []byte {
+	return appendBytes(buf, codeBytes, b)
+}
+
+// AppendString appends the encoding of a string element to buf.
+func AppendString(buf []byte, s string) []byte {
+	return appendBytes(buf, codeString, []byte(s))
+}
+
+func appendBytes(buf []byte, code byte, b []byte) []byte {
+	buf = append(buf, code)
+	for _, c := range b {
+		if buf = append(buf, c); c == 0 {
+			buf = append(buf, 0xFF)
+		}
+	}
+	return append(buf, 0)
+}
+
+// AppendUint appends the encoding of an integer element to buf.
+func AppendUint(buf []byte, u uint64) []byte {
+	n := (bits.Len64(u) + 7) / 8
+	buf = append(buf, codeIntZero+byte(n))
+	for i := n - 1; i >= 0; i-- {
+		buf = append(buf, byte(u>>(8*i)))
+	}
+	return buf
+}
+
+// AppendInt appends the encoding of an integer element to buf.
+func AppendInt(buf []byte, v int64) []byte {
+	if v >= 0 {
+		return AppendUint(buf, uint64(v))
+	}
+	u := uint64(-v) // the magnitude, also of math.MinInt64
+	n := (bits.Len64(u) + 7) / 8
+	u = ^u // one's complement, of which the low n bytes are written
+	buf = append(buf, codeIntZero-byte(n))
+	for i := n - 1; i >= 0; i-- {
+		buf = append(buf, byte(u>>(8*i)))
+	}
+	return buf
+}
+
+// Unpack decodes a tuple from its encoding.
+func Unpack(key []byte) (t Tuple, err error) {
+	t = Tuple{}
+	for len(key) != 0 {
+		var elem any
+		if elem, key, err = decodeElem(key, false); err != nil {
+			return nil, err
+		}
+		t = append(t, elem)
+	}
+	return
+}
+
+// Decode decodes the element at the front of data, as Unpack does, and
+// returns the rest of data.
+func Decode(data []byte) (elem any, rest []byte, err error) {
+	return decodeElem(data, false)
+}
+
+// DecodeBytes decodes the []byte element at the front of data into
+// buf[:0], and returns the rest of data.
+func DecodeBytes(data, buf []byte) (b, rest []byte, err error) {
+	if len(data) == 0 || data[0] != codeBytes {
+		return nil, nil, fmt.Errorf("%w: not bytes", ErrFormat)
+	}
+	return decodeBytes(data[1:], buf)
+}
+
+func decodeElem(key []byte, nested bool) (elem any, rest []byte, err error) {
+	if len(key) == 0 {
+		return nil, nil, fmt.Errorf("%w: no element", ErrFormat)
+	}
+	code := key[0]
+	key = key[1:]
+	switch {
+	case code == codeNil:
+		if nested {
+			// an escaped nil, the terminator is handled by the caller
+			return nil, key[1:], nil
+		}
+		return nil, key, nil
+	case code == codeBytes || code == codeString:
+		b, rest, err := decodeBytes(key, []byte{})
+		if err != nil || code == codeBytes {
+			return b, rest, err
+		}
+		return string(b), rest, nil
+	case code == codeNested:
+		t := Tuple{}
+		for {
+			if len(key) == 0 {
+				return nil, nil, fmt.Errorf("%w: unterminated tuple", ErrFormat)
+			}
+			if key[0] == codeNil && (len(key) == 1 || key[1] != 0xFF) {
+				return t, key[1:], nil
+			}
+			var elem any
+			if elem, key, err = decodeElem(key, true); err != nil {
+				return nil, nil, err
+			}
+			t = append(t, elem)
+		}
+	case code >= codeIntZero-8 && code <= codeIntZero+8:
+		n := int(code) - codeIntZero
+		neg := n < 0
+		if neg {
+			n = -n
+		}
+		if len(key) < n {
+			return nil, nil, fmt.Errorf("%w: short integer", ErrFormat)
+		}
+		var u uint64
+		for _, c := range key[:n] {
+			u = u<<8 | uint64(c)
+		}
+		if !neg {
+			if u > math.MaxInt64 {
+				return u, key[n:], nil
+			}
+			return int64(u), key[n:], nil
+		}
+		// u is the one's complement of the magnitude in n bytes
+		m := ^u
+		if n < 8 {
+			m &= 1<<(8*n) - 1
+		}
+		if m > 1<<63 {
+			return nil, nil, fmt.Errorf("%w: integer overflow", ErrFormat)
+		}
+		return -int64(m), key[n:], nil
+	case code == codeFloat:
+		if len(key) < 4 {
+			return nil, nil, fmt.Errorf("%w: short float", ErrFormat)
+		}
+		u := binary.BigEndian.Uint32(key)
+		if u&(1<<31) != 0 {
+			u ^= 1 << 31
+		} else {
+			u = ^u
+		}
+		return math.Float32frombits(u), key[4:], nil
+	case code == codeDouble:
+		if len(key) < 8 {
+			return nil, nil, fmt.Errorf("%w: short float", ErrFormat)
+		}
+		u := binary.BigEndian.Uint64(key)
+		if u&(1<<63) != 0 {
+			u ^= 1 << 63
+		} else {
+			u = ^u
+		}
+		return math.Float64frombits(u), key[8:], nil
+	case code == codeFalse:
+		return false, key, nil
+	case code == codeTrue:
+		return true, key, nil
+	}
+	return nil, nil, fmt.Errorf("%w: type code %#x", ErrFormat, code)
+}
+
+func decodeBytes(key, buf []byte) (b, rest []byte, err error) {
+	b = buf[:0]
+	for i := 0; i < len(key); i++ {
+		if key[i] != 0 {
+			b = append(b, key[i])
+			continue
+		}
+		if i+1 < len(key) && key[i+1] == 0xFF {
+			b = append(b, 0)
+			i++
+			continue
+		}
+		return b, key[i+1:], nil
+	}
+	return nil, nil, fmt.Errorf("%w: unterminated bytes", ErrFormat)
+}
+
+// Range returns the range [begin, end) of the keys of the tuples that
+// start with t and are longer, for Seek and range scans, such as with
+// iterator.Range.
+func (t Tuple) Range() (begin, end []byte) {
+	key := t.Pack()
+	return append(key, 0x00), append(key[:len(key):len(key)], 0xFF)
+}
+
+// PrefixEnd returns the smallest key greater than the keys of t and of
+// the tuples starting with t, an upper bound for scans from t.Pack().
+// It is nil if there is none.
+func (t Tuple) PrefixEnd() []byte {
+	return iterator.PrefixEnd(t.Pack())
+}
+
+// Codec encodes Tuple keys, as a kv.KeyCodec. A tuple takes all of the
+// key it is decoded from, so it can be the last part of a kv.PairKey
+// only; nest tuples instead.
+type Codec struct{}
+
+// AppendKey appends the encoding of key to buf.
+func (Codec) AppendKey(buf []byte, key Tuple) []byte {
+	return key.Append(buf)
+}
+
+// DecodeKey decodes a tuple from data.
+func (Codec) DecodeKey(data []byte) (key Tuple, rest []byte, err error) {
+	key, err = Unpack(data)
+	return
+}
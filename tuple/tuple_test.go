@@ -0,0 +1,243 @@
+package tuple
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/dacapoday/smol"
+)
+
+// compare is the order of tuples the encoding must preserve: element by
+// element, elements of different types by type code, a prefix first.
+func compare(a, b Tuple) int {
+	for i := range min(len(a), len(b)) {
+		if c := compareElem(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func typeOrder(elem any) int {
+	switch v := elem.(type) {
+	case nil:
+		return 0
+	case []byte:
+		return 1
+	case string:
+		return 2
+	case Tuple:
+		return 5
+	case int64, uint64:
+		return 20
+	case float32:
+		return 32
+	case float64:
+		return 33
+	case bool:
+		if v {
+			return 39
+		}
+		return 38
+	}
+	panic("unexpected type")
+}
+
+func compareElem(a, b any) int {
+	if c := cmp.Compare(typeOrder(a), typeOrder(b)); c != 0 {
+		return c
+	}
+	switch a := a.(type) {
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	case string:
+		return cmp.Compare(a, b.(string))
+	case Tuple:
+		return compare(a, b.(Tuple))
+	case int64:
+		if b, ok := b.(int64); ok {
+			return cmp.Compare(a, b)
+		}
+		return -1
+	case uint64:
+		if b, ok := b.(uint64); ok {
+			return cmp.Compare(a, b)
+		}
+		return 1
+	case float32:
+		return cmp.Compare(a, b.(float32))
+	case float64:
+		return cmp.Compare(a, b.(float64))
+	}
+	return 0
+}
+
+func randomElem(r *rand.Rand, depth int) any {
+	switch r.IntN(9) {
+	case 0:
+		return nil
+	case 1:
+		b := make([]byte, r.IntN(4))
+		for i := range b {
+			b[i] = []byte{0, 1, 'a', 0xFF}[r.IntN(4)]
+		}
+		return b
+	case 2:
+		return string([]byte{'a', 0, 'b'}[:r.IntN(4)%3+1])
+	case 3:
+		if depth < 2 {
+			return randomTuple(r, depth+1)
+		}
+		return nil
+	case 4:
+		return int64(r.Uint64()) >> r.IntN(64)
+	case 5:
+		if r.IntN(2) == 0 {
+			return uint64(math.MaxInt64) + 1 + r.Uint64N(math.MaxInt64)
+		}
+		return []int64{0, -1, 1, 255, -255, 256, -256, math.MinInt64, math.MaxInt64}[r.IntN(9)]
+	case 6:
+		return float32(r.NormFloat64())
+	case 7:
+		return []float64{math.Inf(-1), -1.5, -0.0001, 0, 0.0001, 1.5, math.Inf(1)}[r.IntN(7)]
+	}
+	return r.IntN(2) == 0
+}
+
+func randomTuple(r *rand.Rand, depth int) Tuple {
+	t := Tuple{}
+	for range r.IntN(4) {
+		t = append(t, randomElem(r, depth))
+	}
+	return t
+}
+
+// TestOrder tests that encodings of random tuples sort as the tuples
+// and decode back to them.
+func TestOrder(t *testing.T) {
+	r := rand.New(rand.NewPCG(4, 7))
+	tuples := make([]Tuple, 2000)
+	for i := range tuples {
+		tuples[i] = randomTuple(r, 0)
+	}
+	slices.SortFunc(tuples, compare)
+
+	var prev []byte
+	for i, tup := range tuples {
+		key := tup.Pack()
+		if i > 0 {
+			if c := bytes.Compare(prev, key); c > 0 || c == 0 && compare(tuples[i-1], tup) != 0 {
+				t.Fatalf("%#v encodes before %#v", tup, tuples[i-1])
+			}
+		}
+		got, err := Unpack(key)
+		if err != nil {
+			t.Fatalf("Unpack(%#v): %v", tup, err)
+		}
+		if !reflect.DeepEqual(got, tup) {
+			t.Fatalf("Unpack = %#v, want %#v", got, tup)
+		}
+		prev = key
+	}
+
+	t.Logf("✓ %d tuples sorted and round-tripped", len(tuples))
+}
+
+// TestIntegers tests the integer encoding at its byte length boundaries
+// and that every integer type decodes as int64 or uint64.
+func TestIntegers(t *testing.T) {
+	var ints []int64
+	for shift := range 63 {
+		n := int64(1) << shift
+		ints = append(ints, n-1, n, -n, -n+1, -n-1)
+	}
+	ints = append(ints, math.MinInt64, math.MaxInt64)
+	slices.Sort(ints)
+	ints = slices.Compact(ints)
+	var prev []byte
+	for _, n := range ints {
+		key := Tuple{n}.Pack()
+		if bytes.Compare(prev, key) >= 0 {
+			t.Fatalf("%d does not encode after the previous integer", n)
+		}
+		if got, err := Unpack(key); err != nil || got[0] != n {
+			t.Fatalf("Unpack(%d) = %v, %v", n, got, err)
+		}
+		prev = key
+	}
+	if !bytes.Equal(Tuple{0}.Pack(), []byte{0x14}) || !bytes.Equal(Tuple{-1}.Pack(), []byte{0x13, 0xFE}) {
+		t.Fatalf("unexpected encodings %x %x", Tuple{0}.Pack(), Tuple{-1}.Pack())
+	}
+
+	got, err := Unpack(Tuple{int8(-3), uint16(7), uint(9), int32(5), uint64(math.MaxUint64)}.Pack())
+	if err != nil || !reflect.DeepEqual(got, Tuple{int64(-3), int64(7), int64(9), int64(5), uint64(math.MaxUint64)}) {
+		t.Fatalf("Unpack = %#v, %v", got, err)
+	}
+
+	t.Logf("✓ %d integers at length boundaries in order", len(ints))
+}
+
+// TestRange tests that Range and PrefixEnd bound the keys of the tuples
+// starting with a tuple.
+func TestRange(t *testing.T) {
+	prefix := Tuple{"tenant", int64(7)}
+	begin, end := prefix.Range()
+	inside := []Tuple{
+		{"tenant", int64(7), nil},
+		{"tenant", int64(7), int64(math.MinInt64)},
+		{"tenant", int64(7), "\xff\xff"},
+		{"tenant", int64(7), true, Tuple{nil}},
+	}
+	outside := []Tuple{
+		{"tenant", int64(6), "z"},
+		{"tenant", int64(7)},
+		{"tenant", int64(8)},
+		{"tenant\x00", int64(7), "a"},
+	}
+	for _, tup := range inside {
+		key := tup.Pack()
+		if bytes.Compare(key, begin) < 0 || bytes.Compare(key, end) >= 0 {
+			t.Fatalf("%#v outside Range", tup)
+		}
+	}
+	for _, tup := range outside {
+		key := tup.Pack()
+		if bytes.Compare(key, begin) >= 0 && bytes.Compare(key, end) < 0 {
+			t.Fatalf("%#v inside Range", tup)
+		}
+	}
+	if bytes.Compare(prefix.Pack(), prefix.PrefixEnd()) >= 0 || bytes.Compare(end, prefix.PrefixEnd()) > 0 {
+		t.Fatal("PrefixEnd does not bound the tuple and its extensions")
+	}
+
+	t.Log("✓ Range holds the extensions of a tuple only")
+}
+
+// TestUnpackError tests that malformed keys are rejected.
+func TestUnpackError(t *testing.T) {
+	for _, key := range [][]byte{
+		{0x01, 'a'},
+		{0x05, 0x14},
+		{0x16, 0x01},
+		{0x20, 0, 0},
+		{0x21, 0, 0, 0, 0},
+		{0x30},
+	} {
+		if _, err := Unpack(key); !errors.Is(err, ErrFormat) || !errors.Is(err, smol.ErrBadEncoding) {
+			t.Fatalf("Unpack(%x) = %v, want ErrFormat", key, err)
+		}
+	}
+	if _, _, err := Decode(nil); !errors.Is(err, ErrFormat) {
+		t.Fatalf("Decode(nil) = %v, want ErrFormat", err)
+	}
+	if _, _, err := DecodeBytes(Tuple{"a"}.Pack(), nil); !errors.Is(err, ErrFormat) {
+		t.Fatalf("DecodeBytes(string) = %v, want ErrFormat", err)
+	}
+	t.Log("✓ Malformed keys rejected")
+}